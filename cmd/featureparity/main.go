@@ -0,0 +1,446 @@
+// Command featureparity checks that ComputeFeatures reproduces the features
+// the model was trained on.
+//
+// It reads raw login events and reference feature values (CSV with a header
+// row, or JSONL), recomputes the features of every reference row and reports
+// per-feature differences and the rows that are out of tolerance. The exit
+// status is 1 when any row fails, so it can gate a release.
+//
+//	featureparity -events logins.csv -reference features.csv
+//
+// Events need user_id, when, phone_model and os; device_id, country, city,
+// latitude and longitude are optional. Reference rows need user_id and
+// transdate (row_id, amount and direction are optional); every other column
+// named after a feature computed from logins is compared, unless -features
+// is given. Transfer and recipient features are not computed by the tool.
+//
+// With -golden, the reference rows are written to a directory as golden
+// files of ComputeFeatures (see internal/testdata/features) instead:
+//
+//	featureparity -events logins.csv -reference features.csv -golden internal/testdata/features
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"antifraud-demo-backend/internal"
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTime accepts RFC 3339 and the formats pandas writes; times without
+// a zone are UTC.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+// readRecords returns the rows of a CSV or JSONL file as column -> value.
+func readRecords(path string) ([]map[string]any, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make([]map[string]any, 0)
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		line := 0
+		for sc.Scan() {
+			line++
+			if strings.TrimSpace(sc.Text()) == "" {
+				continue
+			}
+			rec := make(map[string]any)
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			out = append(out, rec)
+		}
+		return out, sc.Err()
+	}
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read header: %w", path, err)
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rec := make(map[string]any, len(header))
+		for i, col := range header {
+			rec[col] = row[i]
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func str(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// num converts a feature value to a float; ok is false for categorical
+// values.
+func num(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if v == "" {
+			return 0, true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func optionalFloat(v any) (*float64, error) {
+	s := str(v)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func readEvents(path string) (map[string][]*internal.LoginSession, error) {
+	recs, err := readRecords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]*internal.LoginSession)
+	for i, rec := range recs {
+		when, err := parseTime(str(rec["when"]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: event %d: %w", path, i+1, err)
+		}
+		s := &internal.LoginSession{
+			UserID:     str(rec["user_id"]),
+			When:       when,
+			PhoneModel: str(rec["phone_model"]),
+			OS:         str(rec["os"]),
+			DeviceID:   str(rec["device_id"]),
+		}
+		s.Country = str(rec["country"])
+		s.City = str(rec["city"])
+		if s.Latitude, err = optionalFloat(rec["latitude"]); err != nil {
+			return nil, fmt.Errorf("%s: event %d: latitude: %w", path, i+1, err)
+		}
+		if s.Longitude, err = optionalFloat(rec["longitude"]); err != nil {
+			return nil, fmt.Errorf("%s: event %d: longitude: %w", path, i+1, err)
+		}
+		out[s.UserID] = append(out[s.UserID], s)
+	}
+	return out, nil
+}
+
+type featureStats struct {
+	name      string
+	rows      int
+	failures  int
+	maxAbs    float64
+	sumAbs    float64
+	maxRel    float64
+	numerical bool
+}
+
+type failure struct {
+	row       string
+	feature   string
+	got, want any
+}
+
+func main() {
+	failedRows, err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) || errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+	if failedRows > 0 {
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage")
+
+// run checks parity as told by the command line args, writes the report to
+// out and returns the number of rows out of tolerance.
+func run(args []string, out io.Writer) (int, error) {
+	fs := flag.NewFlagSet("featureparity", flag.ContinueOnError)
+	eventsPath := fs.String("events", "", "login events, .csv or .jsonl")
+	referencePath := fs.String("reference", "", "reference feature values, .csv or .jsonl")
+	featureList := fs.String("features", "", "comma-separated login features to compare (default: all reference columns that are login features)")
+	atol := fs.Float64("atol", 1e-6, "absolute tolerance")
+	rtol := fs.Float64("rtol", 1e-6, "relative tolerance")
+	maxFailures := fs.Int("max-failures", 20, "failing values to print")
+	goldenDir := fs.String("golden", "", "write the reference rows as ComputeFeatures golden files to this directory instead of comparing")
+	if err := fs.Parse(args); err != nil {
+		return 0, err
+	}
+	if *eventsPath == "" || *referencePath == "" {
+		fs.Usage()
+		return 0, errUsage
+	}
+
+	events, err := readEvents(*eventsPath)
+	if err != nil {
+		return 0, err
+	}
+	reference, err := readRecords(*referencePath)
+	if err != nil {
+		return 0, err
+	}
+	if len(reference) == 0 {
+		return 0, fmt.Errorf("%s: no reference rows", *referencePath)
+	}
+
+	computed := make(map[string]bool, len(internal.LoginFeatures))
+	for _, name := range internal.LoginFeatures {
+		computed[name] = true
+	}
+	var names []string
+	if *featureList != "" {
+		names = strings.Split(*featureList, ",")
+	} else {
+		cols := make(map[string]struct{})
+		for _, rec := range reference {
+			for col := range rec {
+				cols[col] = struct{}{}
+			}
+		}
+		for col := range cols {
+			if computed[col] {
+				names = append(names, col)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	defs := make([]internal.FeatureDef, len(names))
+	stats := make([]*featureStats, len(names))
+	for i, name := range names {
+		def, err := internal.FeatureByName(strings.TrimSpace(name))
+		if err != nil {
+			return 0, err
+		}
+		if !computed[def.Name] {
+			return 0, fmt.Errorf("%s is not computed from logins", def.Name)
+		}
+		defs[i] = def
+		stats[i] = &featureStats{name: def.Name, numerical: true}
+	}
+	if len(defs) == 0 {
+		return 0, fmt.Errorf("%s: no feature columns to compare", *referencePath)
+	}
+
+	if *goldenDir != "" {
+		return 0, writeGolden(*goldenDir, *referencePath, reference, events, defs)
+	}
+
+	failures := make([]failure, 0)
+	failedRows := 0
+	for i, rec := range reference {
+		rowID := str(rec["row_id"])
+		if rowID == "" {
+			rowID = strconv.Itoa(i + 1)
+		}
+
+		transdate, err := parseTime(str(rec["transdate"]))
+		if err != nil {
+			return 0, fmt.Errorf("%s: row %s: %v", *referencePath, rowID, err)
+		}
+		feats := &internal.ModelFeatures{Direction: str(rec["direction"])}
+		feats.Amount, _ = num(rec["amount"])
+		internal.ComputeFeatures(feats, events[str(rec["user_id"])], transdate)
+
+		rowFailed := false
+		for j, def := range defs {
+			want, present := rec[def.Name]
+			if !present {
+				continue
+			}
+			got := def.Extract(feats)
+			st := stats[j]
+			st.rows++
+
+			gn, gok := num(got)
+			wn, wok := num(want)
+			ok := false
+			if gok && wok {
+				abs := math.Abs(gn - wn)
+				rel := 0.0
+				if wn != 0 {
+					rel = abs / math.Abs(wn)
+				}
+				st.sumAbs += abs
+				st.maxAbs = math.Max(st.maxAbs, abs)
+				st.maxRel = math.Max(st.maxRel, rel)
+				ok = abs <= *atol+*rtol*math.Abs(wn)
+			} else {
+				st.numerical = false
+				ok = str(got) == str(want)
+			}
+
+			if !ok {
+				st.failures++
+				rowFailed = true
+				failures = append(failures, failure{row: rowID, feature: def.Name, got: got, want: want})
+			}
+		}
+		if rowFailed {
+			failedRows++
+		}
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "feature\trows\tfailed\tmax abs diff\tmean abs diff\tmax rel diff\t")
+	for _, st := range stats {
+		if !st.numerical {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t-\t-\t-\t\n", st.name, st.rows, st.failures)
+			continue
+		}
+		mean := 0.0
+		if st.rows > 0 {
+			mean = st.sumAbs / float64(st.rows)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3g\t%.3g\t%.3g\t\n", st.name, st.rows, st.failures, st.maxAbs, mean, st.maxRel)
+	}
+	tw.Flush()
+
+	if len(failures) > 0 {
+		fmt.Fprintf(out, "\nfailing values (first %d of %d):\n", min(*maxFailures, len(failures)), len(failures))
+		for _, f := range failures[:min(*maxFailures, len(failures))] {
+			fmt.Fprintf(out, "  row %s: %s = %v, reference %v\n", f.row, f.feature, f.got, f.want)
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d of %d rows out of tolerance (atol=%g, rtol=%g)\n", failedRows, len(reference), *atol, *rtol)
+	return failedRows, nil
+}
+
+// goldenFile is the format of the ComputeFeatures golden files. Features
+// lists the features the reference has; only those are compared.
+type goldenFile struct {
+	Description string                   `json:"description"`
+	Transdate   time.Time                `json:"transdate"`
+	Features    []string                 `json:"features"`
+	Sessions    []*internal.LoginSession `json:"sessions"`
+	Expected    map[string]any           `json:"expected"`
+}
+
+// writeGolden writes each reference row to dir as <reference>_<row_id>.json,
+// with the row's user's events as sessions and its values of defs as
+// expected.
+func writeGolden(dir, referencePath string, reference []map[string]any, events map[string][]*internal.LoginSession, defs []internal.FeatureDef) error {
+	prefix := strings.TrimSuffix(filepath.Base(referencePath), filepath.Ext(referencePath))
+	for i, rec := range reference {
+		rowID := str(rec["row_id"])
+		if rowID == "" {
+			rowID = strconv.Itoa(i + 1)
+		}
+		transdate, err := parseTime(str(rec["transdate"]))
+		if err != nil {
+			return fmt.Errorf("%s: row %s: %v", referencePath, rowID, err)
+		}
+
+		g := goldenFile{
+			Description: fmt.Sprintf("row %s of %s", rowID, filepath.Base(referencePath)),
+			Transdate:   transdate,
+			Features:    make([]string, 0, len(defs)),
+			Sessions:    events[str(rec["user_id"])],
+			Expected:    make(map[string]any, len(defs)),
+		}
+		if g.Sessions == nil {
+			g.Sessions = make([]*internal.LoginSession, 0)
+		}
+		for _, def := range defs {
+			want, present := rec[def.Name]
+			if !present {
+				continue
+			}
+			// typed like the feature, as ModelFeatures reads it back
+			switch def.Extract(&internal.ModelFeatures{}).(type) {
+			case string:
+				g.Expected[def.Name] = str(want)
+			case int:
+				n, ok := num(want)
+				if !ok {
+					return fmt.Errorf("%s: row %s: %s: %q is not a number", referencePath, rowID, def.Name, str(want))
+				}
+				g.Expected[def.Name] = int(math.Round(n))
+			default:
+				n, ok := num(want)
+				if !ok {
+					return fmt.Errorf("%s: row %s: %s: %q is not a number", referencePath, rowID, def.Name, str(want))
+				}
+				g.Expected[def.Name] = n
+			}
+			g.Features = append(g.Features, def.Name)
+		}
+
+		b, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, prefix+"_"+rowID+".json"), append(b, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
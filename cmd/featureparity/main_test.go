@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunMatchingReference(t *testing.T) {
+	var out bytes.Buffer
+	failed, err := run([]string{"-events", "testdata/logins.csv", "-reference", "testdata/reference.csv"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 {
+		t.Fatalf("%d rows failed:\n%s", failed, out.String())
+	}
+	// transfer and recipient columns of the reference are not compared
+	for _, name := range []string{"transfers_last_1h", "recipient_inbound_24h", "amount_to_balance_ratio"} {
+		if strings.Contains(out.String(), name) {
+			t.Errorf("%s compared:\n%s", name, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "avg_login_interval_30d") {
+		t.Errorf("login features not compared:\n%s", out.String())
+	}
+}
+
+func TestRunFeatureList(t *testing.T) {
+	var out bytes.Buffer
+	failed, err := run([]string{"-events", "testdata/logins.csv", "-reference", "testdata/reference.csv",
+		"-features", "logins_last_7_days,monthly_os_changes"}, &out)
+	if err != nil || failed != 0 {
+		t.Fatalf("got %d failed, %v", failed, err)
+	}
+
+	if _, err := run([]string{"-events", "testdata/logins.csv", "-reference", "testdata/reference.csv", "-features", "transfers_last_1h"}, &out); err == nil {
+		t.Error("compared a feature the tool does not compute")
+	}
+}
+
+func TestRunOutOfTolerance(t *testing.T) {
+	ref := filepath.Join(t.TempDir(), "reference.jsonl")
+	rows := `{"row_id": "r1", "user_id": "u1", "transdate": "2025-11-24T12:00:00Z", "logins_last_7_days": 3, "avg_login_interval_30d": 151200.5}
+{"row_id": "r2", "user_id": "u2", "transdate": "2025-11-24T12:00:00Z", "logins_last_7_days": 0, "avg_login_interval_30d": 0}
+`
+	if err := os.WriteFile(ref, []byte(rows), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	failed, err := run([]string{"-events", "testdata/logins.csv", "-reference", ref}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 1 || !strings.Contains(out.String(), "row r1: avg_login_interval_30d") {
+		t.Errorf("got %d failed:\n%s", failed, out.String())
+	}
+}
+
+func TestRunGolden(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if _, err := run([]string{"-events", "testdata/logins.csv", "-reference", "testdata/reference.csv", "-golden", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "reference_r1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g goldenFile
+	if err := json.Unmarshal(b, &g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Sessions) != 4 || g.Expected["logins_last_7_days"] != 3.0 || g.Expected["last_os_categorical"] != "iOS 18" {
+		t.Errorf("got %s", b)
+	}
+	if _, ok := g.Expected["transfers_last_1h"]; ok {
+		t.Error("transfer feature written")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reference_r2.json")); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"antifraud-demo-backend/internal"
 	auth "antifraud-demo-backend/internal/auth"
+	"antifraud-demo-backend/internal/geo"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,8 +21,27 @@ var (
 	port int
 
 	dsn string
+
+	geoDBPath string
+
+	featureSetsPath   string
+	featureSetVersion int
+	trialFeatureSet   int
+	modelsPath        string
+
+	otpSenderKind string
+	otpFilePath   string
+
+	eventSinkKind string
+	eventFilePath string
+
+	caseAttachmentsDir string
 )
 
+// shutdownTimeout bounds the wait for open requests and running transfer
+// jobs on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func init() {
 	viper.AutomaticEnv()
 
@@ -24,6 +50,24 @@ func init() {
 
 	port = viper.GetInt("PORT")
 	dsn = viper.GetString("DATABASE_URL")
+	geoDBPath = viper.GetString("GEOIP_DB_PATH")
+
+	viper.SetDefault("FEATURE_SET_VERSION", 1)
+	viper.SetDefault("FEATURE_SET_TRIAL_VERSION", 2)
+	featureSetsPath = viper.GetString("FEATURE_SETS_PATH")
+	featureSetVersion = viper.GetInt("FEATURE_SET_VERSION")
+	trialFeatureSet = viper.GetInt("FEATURE_SET_TRIAL_VERSION")
+	modelsPath = viper.GetString("ANTIFRAUD_MODELS_PATH")
+
+	viper.SetDefault("OTP_SENDER", "log")
+	otpSenderKind = viper.GetString("OTP_SENDER")
+	otpFilePath = viper.GetString("OTP_FILE_PATH")
+
+	eventSinkKind = viper.GetString("EVENT_SINK")
+	eventFilePath = viper.GetString("EVENT_FILE_PATH")
+
+	viper.SetDefault("CASE_ATTACHMENTS_DIR", "attachments")
+	caseAttachmentsDir = viper.GetString("CASE_ATTACHMENTS_DIR")
 }
 
 func main() {
@@ -37,6 +81,51 @@ func main() {
 
 	internal.SetDB(db)
 
+	locator, err := geo.Open(geoDBPath)
+	if err != nil {
+		log.Fatalf("failed to open geo database: %v", err)
+	}
+	internal.SetGeoLocator(locator)
+
+	if err := internal.ConfigureFeatureSets(featureSetsPath, featureSetVersion, trialFeatureSet); err != nil {
+		log.Fatalf("failed to configure feature sets: %v", err)
+	}
+	if err := internal.ConfigureModels(modelsPath); err != nil {
+		log.Fatalf("failed to configure antifraud model: %v", err)
+	}
+
+	otpSender, err := internal.NewOTPSender(otpSenderKind, otpFilePath)
+	if err != nil {
+		log.Fatalf("failed to configure OTP sender: %v", err)
+	}
+	internal.SetOTPSender(otpSender)
+
+	attachments, err := internal.NewLocalAttachmentStore(caseAttachmentsDir)
+	if err != nil {
+		log.Fatalf("failed to open case attachments directory: %v", err)
+	}
+	internal.SetAttachmentStore(attachments)
+
+	// cancelled on shutdown, stopping the background workers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	transferWorkersDone := internal.StartTransferWorkers(ctx)
+	internal.StartWebhookWorkers(ctx)
+	internal.StartChallengeSweeper(ctx)
+	if err := internal.StartEventListener(ctx, dsn); err != nil {
+		log.Fatalf("failed to listen for events: %v", err)
+	}
+
+	// without a sink, events wait in the outbox
+	if eventSinkKind != "" {
+		sink, err := internal.NewEventSink(eventSinkKind, eventFilePath)
+		if err != nil {
+			log.Fatalf("failed to configure event sink: %v", err)
+		}
+		internal.StartOutboxRelay(ctx, sink)
+	}
+
 	mux := http.NewServeMux()
 
 	// User login endpoints
@@ -48,7 +137,13 @@ func main() {
 	mux.Handle("GET /cards", auth.AuthMiddleware(http.HandlerFunc(internal.ListCardsHandler)))
 	mux.Handle("GET /cards/lookup", auth.AuthMiddleware(http.HandlerFunc(internal.GetCardByNumberHandler)))
 	mux.Handle("POST /transfer", auth.AuthMiddleware(http.HandlerFunc(internal.DoTransferHandler)))
+	mux.Handle("POST /transfer/{id}/confirm", auth.AuthMiddleware(http.HandlerFunc(internal.ConfirmTransferHandler)))
 	mux.Handle("GET /transfers", auth.AuthMiddleware(http.HandlerFunc(internal.ListTransfersHandler)))
+	mux.Handle("GET /transfers/{id}", auth.AuthMiddleware(http.HandlerFunc(internal.GetUserTransferHandler)))
+	mux.Handle("GET /devices", auth.AuthMiddleware(http.HandlerFunc(internal.ListDevicesHandler)))
+	mux.Handle("PATCH /devices/{id}", auth.AuthMiddleware(http.HandlerFunc(internal.UpdateDeviceHandler)))
+	mux.Handle("DELETE /devices/{id}", auth.AuthMiddleware(http.HandlerFunc(internal.RevokeDeviceHandler)))
+	mux.Handle("GET /notifications/stream", auth.AuthMiddleware(http.HandlerFunc(internal.NotificationStreamHandler)))
 
 	// Superuser endpoints
 	mux.Handle("GET /admin/users", auth.AuthMiddleware(
@@ -66,17 +161,243 @@ func main() {
 			http.HandlerFunc(internal.ListTransfersByUserHandler),
 		),
 	))
+	mux.Handle("GET /admin/users/devices", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListDevicesByUserHandler),
+		),
+	))
+	mux.Handle("GET /admin/users/feature-store/check", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.FeatureStoreCheckHandler),
+		),
+	))
+	mux.Handle("POST /admin/users/feature-store/rebuild", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.FeatureStoreRebuildHandler),
+		),
+	))
+	mux.Handle("PUT /admin/cards/{id}/status", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.SetCardStatusHandler),
+		),
+	))
+	mux.Handle("GET /admin/cards/mule-report", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.MuleReportHandler),
+		),
+	))
+	mux.Handle("GET /admin/transfers/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetTransferHandler),
+		),
+	))
 	mux.Handle("GET /admin/analytics/transfers", auth.AuthMiddleware(
 		auth.RequireSuperuserMiddleware(
 			http.HandlerFunc(internal.AnalyticsTransfersHandler),
 		),
 	))
+	mux.Handle("GET /admin/analytics/models", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.AnalyticsModelsHandler),
+		),
+	))
+
+	mux.Handle("POST /admin/webhooks", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.CreateWebhookHandler),
+		),
+	))
+	mux.Handle("GET /admin/webhooks", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListWebhooksHandler),
+		),
+	))
+	mux.Handle("GET /admin/webhooks/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetWebhookHandler),
+		),
+	))
+	mux.Handle("PATCH /admin/webhooks/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.UpdateWebhookHandler),
+		),
+	))
+	mux.Handle("DELETE /admin/webhooks/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DeleteWebhookHandler),
+		),
+	))
+	mux.Handle("GET /admin/webhooks/{id}/deliveries", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListWebhookDeliveriesHandler),
+		),
+	))
+	mux.Handle("POST /admin/webhook-deliveries/{id}/replay", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ReplayWebhookDeliveryHandler),
+		),
+	))
+	mux.Handle("GET /admin/limits", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListLimitOverridesHandler),
+		),
+	))
+	mux.Handle("GET /admin/limits/{scope}/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetLimitOverrideHandler),
+		),
+	))
+	mux.Handle("PUT /admin/limits/{scope}/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.SetLimitOverrideHandler),
+		),
+	))
+	mux.Handle("DELETE /admin/limits/{scope}/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DeleteLimitOverrideHandler),
+		),
+	))
+	mux.Handle("POST /admin/list-entries", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.CreateListEntryHandler),
+		),
+	))
+	mux.Handle("GET /admin/list-entries", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListListEntriesHandler),
+		),
+	))
+	mux.Handle("GET /admin/list-entries/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetListEntryHandler),
+		),
+	))
+	mux.Handle("DELETE /admin/list-entries/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DeleteListEntryHandler),
+		),
+	))
+	mux.Handle("GET /admin/rules", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListRulesHandler),
+		),
+	))
+	mux.Handle("POST /admin/rules", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.CreateRuleHandler),
+		),
+	))
+	mux.Handle("GET /admin/rules/variables", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListRuleVariablesHandler),
+		),
+	))
+	mux.Handle("POST /admin/rules/dry-run", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DryRunRuleHandler),
+		),
+	))
+	mux.Handle("GET /admin/rules/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetRuleHandler),
+		),
+	))
+	mux.Handle("PUT /admin/rules/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.UpdateRuleHandler),
+		),
+	))
+	mux.Handle("PUT /admin/transfers/{id}/label", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.SetTransferLabelHandler),
+		),
+	))
+	mux.Handle("DELETE /admin/transfers/{id}/label", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DeleteTransferLabelHandler),
+		),
+	))
+	mux.Handle("POST /admin/simulations", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.CreateSimulationHandler),
+		),
+	))
+	mux.Handle("GET /admin/simulations", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListSimulationsHandler),
+		),
+	))
+	mux.Handle("GET /admin/simulations/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetSimulationHandler),
+		),
+	))
+	mux.Handle("GET /admin/users/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetUserHandler),
+		),
+	))
+	mux.Handle("PATCH /admin/users/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.UpdateUserHandler),
+		),
+	))
+	mux.Handle("POST /admin/cases", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.CreateCaseHandler),
+		),
+	))
+	mux.Handle("GET /admin/cases", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.ListCasesHandler),
+		),
+	))
+	mux.Handle("GET /admin/cases/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.GetCaseHandler),
+		),
+	))
+	mux.Handle("PATCH /admin/cases/{id}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.UpdateCaseHandler),
+		),
+	))
+	mux.Handle("POST /admin/cases/{id}/entities", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.AddCaseEntityHandler),
+		),
+	))
+	mux.Handle("DELETE /admin/cases/{id}/entities/{kind}/{entityId}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.RemoveCaseEntityHandler),
+		),
+	))
+	mux.Handle("POST /admin/cases/{id}/notes", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.AddCaseNoteHandler),
+		),
+	))
+	mux.Handle("POST /admin/cases/{id}/attachments", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.UploadCaseAttachmentHandler),
+		),
+	))
+	mux.Handle("GET /admin/cases/{id}/attachments/{attachmentId}", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.DownloadCaseAttachmentHandler),
+		),
+	))
+	mux.Handle("GET /admin/stream/transfers", auth.AuthMiddleware(
+		auth.RequireSuperuserMiddleware(
+			http.HandlerFunc(internal.TransferStreamHandler),
+		),
+	))
 
 	corsHandler := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 
 			// Handle preflight requests
@@ -91,6 +412,25 @@ func main() {
 
 	host := fmt.Sprintf("0.0.0.0:%d", port)
 
-	log.Printf("Serving %s", host)
-	log.Fatal(http.ListenAndServe(host, corsHandler(mux)))
+	srv := &http.Server{Addr: host, Handler: corsHandler(mux)}
+	go func() {
+		log.Printf("Serving %s", host)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down server: %v", err)
+	}
+	// jobs still running past the timeout are resumed once their lease
+	// runs out
+	select {
+	case <-transferWorkersDone:
+	case <-shutdownCtx.Done():
+	}
 }
@@ -1,31 +1,89 @@
 package internal
 
 import (
-	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 var (
-	client http.Client
-
 	antifraud_model_url string
+
+	modelTimeout          time.Duration
+	modelAttemptTimeout   time.Duration
+	modelMaxAttempts      int
+	modelBreakerThreshold int
+	modelBreakerCooldown  time.Duration
+	modelGRPCConns        int
+
+	// name the champion model's scores are stored under
+	antifraud_model_name string
+
+	// Optional second model scored in shadow with its own feature set, to
+	// trial a new model on live traffic. Superseded by ANTIFRAUD_MODELS_PATH.
+	trial_model_url string
+
+	liveFeatureSet  *FeatureSet
+	trialFeatureSet *FeatureSet
 )
 
 func init() {
-	client = http.Client{Timeout: 5 * time.Second}
-
 	viper.AutomaticEnv()
 	antifraud_model_url = viper.GetString("ANTIFRAUD_MODEL_URL")
+	viper.SetDefault("ANTIFRAUD_MODEL_NAME", "champion")
+	antifraud_model_name = viper.GetString("ANTIFRAUD_MODEL_NAME")
+
+	viper.SetDefault("ANTIFRAUD_MODEL_TIMEOUT", 5*time.Second)
+	viper.SetDefault("ANTIFRAUD_MODEL_ATTEMPT_TIMEOUT", 2*time.Second)
+	viper.SetDefault("ANTIFRAUD_MODEL_MAX_ATTEMPTS", 3)
+	viper.SetDefault("ANTIFRAUD_MODEL_BREAKER_THRESHOLD", 10)
+	viper.SetDefault("ANTIFRAUD_MODEL_BREAKER_COOLDOWN", 30*time.Second)
+	modelTimeout = viper.GetDuration("ANTIFRAUD_MODEL_TIMEOUT")
+	modelAttemptTimeout = viper.GetDuration("ANTIFRAUD_MODEL_ATTEMPT_TIMEOUT")
+	modelMaxAttempts = viper.GetInt("ANTIFRAUD_MODEL_MAX_ATTEMPTS")
+	modelBreakerThreshold = viper.GetInt("ANTIFRAUD_MODEL_BREAKER_THRESHOLD")
+	modelBreakerCooldown = viper.GetDuration("ANTIFRAUD_MODEL_BREAKER_COOLDOWN")
+	viper.SetDefault("ANTIFRAUD_MODEL_GRPC_CONNS", 4)
+	modelGRPCConns = viper.GetInt("ANTIFRAUD_MODEL_GRPC_CONNS")
+
+	trial_model_url = viper.GetString("ANTIFRAUD_TRIAL_MODEL_URL")
 }
 
+// ConfigureFeatureSets loads additional feature set manifests from path (if
+// set) and selects the live and trial versions.
+func ConfigureFeatureSets(path string, liveVersion, trialVersion int) error {
+	if path != "" {
+		if err := LoadFeatureSets(path); err != nil {
+			return err
+		}
+	}
+
+	live, err := GetFeatureSet(liveVersion)
+	if err != nil {
+		return err
+	}
+	liveFeatureSet = live
+
+	trialFeatureSet = nil
+	if trial_model_url != "" {
+		trial, err := GetFeatureSet(trialVersion)
+		if err != nil {
+			return err
+		}
+		trialFeatureSet = trial
+	}
+	return nil
+}
+
+// ModelFeatures holds every computed feature. What is actually sent to a
+// model is selected by its FeatureSet; the JSON tags are the registered
+// feature names.
 type ModelFeatures struct {
 	// CstDimID                  string  `json:"cst_dim_id"`
-	Amount					  float64 `json:"amount"`
+	Amount                    float64 `json:"amount"`
 	MonthlyOSChanges          int     `json:"monthly_os_changes"`
 	MonthlyPhoneModelChanges  int     `json:"monthly_phone_model_changes"`
 	LastPhoneModelCategorical string  `json:"last_phone_model_categorical"`
@@ -44,28 +102,61 @@ type ModelFeatures struct {
 	BurstinessLoginInterval   float64 `json:"burstiness_login_interval"`
 	FanoFactorLoginInterval   float64 `json:"fano_factor_login_interval"`
 	ZscoreAvgLoginInterval7d  float64 `json:"zscore_avg_login_interval_7d"`
+
+	IsNewDevice int `json:"is_new_device"`
+
+	LastLoginDistanceKm  float64 `json:"last_login_distance_km"`
+	LastLoginSpeedKmh    float64 `json:"last_login_speed_kmh"`
+	IsNewCountry30d      int     `json:"is_new_country_30d"`
+	IsNewCity30d         int     `json:"is_new_city_30d"`
+	DistinctLocations30d int     `json:"distinct_locations_30d"`
+
+	AmountZscore30d          float64 `json:"amount_zscore_30d"`
+	TransfersLast1h          int     `json:"transfers_last_1h"`
+	TransfersLast24h         int     `json:"transfers_last_24h"`
+	TransfersLast7d          int     `json:"transfers_last_7d"`
+	AmountSumLast1h          float64 `json:"amount_sum_last_1h"`
+	AmountSumLast24h         float64 `json:"amount_sum_last_24h"`
+	AmountSumLast7d          float64 `json:"amount_sum_last_7d"`
+	IsNewDestination         int     `json:"is_new_destination"`
+	SecondsSinceLastTransfer float64 `json:"seconds_since_last_transfer"`
+	AmountToBalanceRatio     float64 `json:"amount_to_balance_ratio"`
+
+	FailedChallengeAttempts30d int `json:"failed_challenge_attempts_30d"`
+
+	RecipientFeatures
 }
 
-type PredictResponse struct {
-	FraudProbability float64 `json:"fraud_probability"`
-	BlockTransaction bool    `json:"block_transaction"`
+// The features a transfer was scored on are stored with it as JSON, so that
+// rules can be tried on past transfers as they were seen.
+func (f ModelFeatures) Value() (driver.Value, error) {
+	return json.Marshal(f)
 }
 
-func PredictFraud(feats *ModelFeatures) (*PredictResponse, error) {
-	b, err := json.Marshal(feats)
-	if err != nil {
-		return nil, err
+func (f *ModelFeatures) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
 	}
+	return fmt.Errorf("ModelFeatures: unsupported type %T", src)
+}
 
-	resp, err := client.Post(fmt.Sprintf("%s/predict", antifraud_model_url), "application/json", bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+type RecipientFeatures struct {
+	RecipientDistinctSenders24h     int     `json:"recipient_distinct_senders_24h"`
+	RecipientDistinctSenders7d      int     `json:"recipient_distinct_senders_7d"`
+	RecipientInbound1h              int     `json:"recipient_inbound_1h"`
+	RecipientInbound24h             int     `json:"recipient_inbound_24h"`
+	RecipientInboundSum24h          float64 `json:"recipient_inbound_sum_24h"`
+	RecipientBlockedInboundShare30d float64 `json:"recipient_blocked_inbound_share_30d"`
+	RecipientAccountAgeDays         float64 `json:"recipient_account_age_days"`
+	RecipientQuickForwardShare7d    float64 `json:"recipient_quick_forward_share_7d"`
+}
 
-	var pr PredictResponse
-	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
-		return nil, err
-	}
-	return &pr, nil
+type PredictResponse struct {
+	FraudProbability float64 `json:"fraud_probability"`
+	BlockTransaction bool    `json:"block_transaction"`
+	// Optional: the features that contributed most to the score.
+	TopFeatures []FeatureContribution `json:"top_features,omitempty"`
 }
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// AttachmentStore keeps the content of case attachments under opaque keys.
+type AttachmentStore interface {
+	// Put stores r under key and returns the number of bytes written.
+	Put(key string, r io.Reader) (int64, error)
+	// Open returns the content stored under key, fs.ErrNotExist if none.
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// LocalAttachmentStore keeps attachments as files in a directory.
+type LocalAttachmentStore struct {
+	Dir string
+}
+
+// NewLocalAttachmentStore returns a store in dir, creating it if needed.
+func NewLocalAttachmentStore(dir string) (*LocalAttachmentStore, error) {
+	if dir == "" {
+		return nil, errors.New("attachment store needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalAttachmentStore{Dir: dir}, nil
+}
+
+func (s *LocalAttachmentStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid attachment key %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+// Put writes to a temporary file renamed into place, so that a failed
+// upload never leaves a partial attachment behind.
+func (s *LocalAttachmentStore) Put(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+func (s *LocalAttachmentStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalAttachmentStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+var attachmentStore AttachmentStore
+
+func SetAttachmentStore(s AttachmentStore) { attachmentStore = s }
+
+// caseAttachmentMaxBytes bounds the size of an uploaded case attachment.
+var caseAttachmentMaxBytes int64
+
+func init() {
+	viper.AutomaticEnv()
+	viper.SetDefault("CASE_ATTACHMENT_MAX_BYTES", 10<<20)
+	caseAttachmentMaxBytes = viper.GetInt64("CASE_ATTACHMENT_MAX_BYTES")
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocalAttachmentStore(t *testing.T) {
+	s, err := NewLocalAttachmentStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Put("a1", strings.NewReader("evidence"))
+	if err != nil || n != 8 {
+		t.Fatalf("put: %d, %v", n, err)
+	}
+	f, err := s.Open("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "evidence" {
+		t.Errorf("got %q", b)
+	}
+
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(s.Dir)
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want 1", len(entries))
+	}
+
+	if err := s.Delete("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("a1"); err != nil {
+		t.Errorf("deleting twice: %v", err)
+	}
+	if _, err := s.Open("a1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestLocalAttachmentStoreKeys(t *testing.T) {
+	s, err := NewLocalAttachmentStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", ".", "..", "../x", "a/b"} {
+		if _, err := s.Put(key, strings.NewReader("x")); err == nil {
+			t.Errorf("put %q accepted", key)
+		}
+		if _, err := s.Open(key); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open %q: got %v", key, err)
+		}
+	}
+}
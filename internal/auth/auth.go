@@ -23,7 +23,7 @@ func init() {
 	signingKey = []byte(viper.GetString("SECRET_KEY"))
 }
 
-func GenerateToken(uid string, phoneModel, osStr string) (string, error) {
+func GenerateToken(uid string, phoneModel, osStr, deviceID string) (string, error) {
 	now := time.Now()
 	claims := &JwtClaims{
 		UserId:      uid,
@@ -34,6 +34,7 @@ func GenerateToken(uid string, phoneModel, osStr string) (string, error) {
 		Subject:     uid,
 		PhoneModel:  phoneModel,
 		OS:          osStr,
+		DeviceID:    deviceID,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -19,6 +19,7 @@ type JwtClaims struct {
 
 	PhoneModel string `json:"phone_model,omitempty"`
 	OS         string `json:"os,omitempty"`
+	DeviceID   string `json:"device_id,omitempty"`
 }
 
 func (c *JwtClaims) GetExpirationTime() (*jwt.NumericDate, error) {
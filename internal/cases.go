@@ -0,0 +1,578 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Case statuses. Closing a case as fraud or legit labels its transfers
+// accordingly; a closed case can be reopened for investigation.
+const (
+	CaseOpen          = "open"
+	CaseInvestigating = "investigating"
+	CaseClosedFraud   = "closed_fraud"
+	CaseClosedLegit   = "closed_legit"
+)
+
+var caseTransitions = map[string][]string{
+	CaseOpen:          {CaseInvestigating, CaseClosedFraud, CaseClosedLegit},
+	CaseInvestigating: {CaseOpen, CaseClosedFraud, CaseClosedLegit},
+	CaseClosedFraud:   {CaseInvestigating},
+	CaseClosedLegit:   {CaseInvestigating},
+}
+
+func caseClosed(status string) bool {
+	return status == CaseClosedFraud || status == CaseClosedLegit
+}
+
+// Kinds of entities linked to a case.
+const (
+	CaseEntityUser     = "user"
+	CaseEntityCard     = "card"
+	CaseEntityTransfer = "transfer"
+)
+
+// Types of case timeline events.
+const (
+	CaseEventCreated         = "created"
+	CaseEventUpdated         = "updated"
+	CaseEventStatusChanged   = "status_changed"
+	CaseEventAssigned        = "assigned"
+	CaseEventEntityAdded     = "entity_added"
+	CaseEventEntityRemoved   = "entity_removed"
+	CaseEventNoteAdded       = "note_added"
+	CaseEventAttachmentAdded = "attachment_added"
+)
+
+var (
+	ErrCaseTransition    = errors.New("status change not allowed")
+	ErrUnknownCaseEntity = errors.New("linked entity not found")
+	ErrUnknownSuperuser  = errors.New("assignee is not a superuser")
+	ErrCaseLabelConflict = errors.New("transfers already labeled otherwise")
+
+	errInvalidCaseUpdate = errors.New("invalid case update")
+)
+
+// CaseEventData holds the details of a timeline event, stored as JSON.
+type CaseEventData map[string]string
+
+func (d CaseEventData) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *CaseEventData) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	}
+	return fmt.Errorf("CaseEventData: unsupported type %T", src)
+}
+
+// CaseUpdate holds the fields of a case to change; nil fields are kept.
+type CaseUpdate struct {
+	Title       *string
+	Description *string
+	Status      *string
+	// "" unassigns the case
+	Assignee *string
+}
+
+// applyCaseUpdate applies u to c as of now and returns the timeline events
+// it makes.
+func applyCaseUpdate(c *Case, u CaseUpdate, now time.Time) ([]CaseEventData, []string, error) {
+	var types []string
+	var events []CaseEventData
+
+	if u.Title != nil || u.Description != nil {
+		data := CaseEventData{}
+		if u.Title != nil {
+			title := strings.TrimSpace(*u.Title)
+			if title == "" {
+				return nil, nil, fmt.Errorf("%w: missing title", errInvalidCaseUpdate)
+			}
+			if title != c.Title {
+				data["title"] = title
+				c.Title = title
+			}
+		}
+		if u.Description != nil && *u.Description != c.Description {
+			data["description"] = *u.Description
+			c.Description = *u.Description
+		}
+		if len(data) > 0 {
+			types = append(types, CaseEventUpdated)
+			events = append(events, data)
+		}
+	}
+
+	if u.Status != nil && *u.Status != c.Status {
+		if _, ok := caseTransitions[*u.Status]; !ok {
+			return nil, nil, fmt.Errorf("%w: status must be open, investigating, closed_fraud or closed_legit", errInvalidCaseUpdate)
+		}
+		if !slices.Contains(caseTransitions[c.Status], *u.Status) {
+			return nil, nil, fmt.Errorf("%w: %s to %s", ErrCaseTransition, c.Status, *u.Status)
+		}
+		types = append(types, CaseEventStatusChanged)
+		events = append(events, CaseEventData{"from": c.Status, "to": *u.Status})
+		c.Status = *u.Status
+		c.ClosedAt = nil
+		if caseClosed(c.Status) {
+			c.ClosedAt = &now
+		}
+	}
+
+	if u.Assignee != nil && *u.Assignee != c.Assignee {
+		types = append(types, CaseEventAssigned)
+		events = append(events, CaseEventData{"from": c.Assignee, "to": *u.Assignee})
+		c.Assignee = *u.Assignee
+	}
+	return events, types, nil
+}
+
+// normalizeCaseEntity validates the kind of e and puts its ID in canonical
+// form.
+func normalizeCaseEntity(e *CaseEntity) error {
+	e.EntityID = strings.TrimSpace(e.EntityID)
+	switch e.Kind {
+	case CaseEntityUser:
+		if e.EntityID == "" {
+			return errors.New("missing entity id")
+		}
+	case CaseEntityCard, CaseEntityTransfer:
+		id, err := uuid.Parse(e.EntityID)
+		if err != nil {
+			return errors.New("invalid entity id")
+		}
+		e.EntityID = id.String()
+	default:
+		return errors.New("kind must be user, card or transfer")
+	}
+	return nil
+}
+
+// caseLabel is the fraud label a case closed with status gives its
+// transfers.
+func caseLabel(status string) string {
+	if status == CaseClosedFraud {
+		return LabelFraud
+	}
+	return LabelLegit
+}
+
+const caseColumns = `id, title, description, status, COALESCE(assignee::text, '') AS assignee, created_by, created_at, updated_at, closed_at`
+
+func addCaseEvent(tx *sqlx.Tx, caseID uuid.UUID, typ, actor string, data CaseEventData) error {
+	if data == nil {
+		data = CaseEventData{}
+	}
+	if _, err := tx.Exec(`INSERT INTO case_events (case_id, type, actor, data) VALUES ($1,$2,$3,$4)`, caseID, typ, actor, data); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE cases SET updated_at = now() WHERE id = $1`, caseID)
+	return err
+}
+
+func checkCaseEntity(tx *sqlx.Tx, e *CaseEntity) error {
+	var query string
+	switch e.Kind {
+	case CaseEntityUser:
+		query = `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`
+	case CaseEntityCard:
+		query = `SELECT EXISTS (SELECT 1 FROM cards WHERE id = $1::uuid)`
+	case CaseEntityTransfer:
+		query = `SELECT EXISTS (SELECT 1 FROM transfers WHERE id = $1::uuid)`
+	}
+	var exists bool
+	if err := tx.Get(&exists, query, e.EntityID); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s %s", ErrUnknownCaseEntity, e.Kind, e.EntityID)
+	}
+	return nil
+}
+
+func checkSuperuser(tx *sqlx.Tx, id string) error {
+	if id == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrUnknownSuperuser
+	}
+	var exists bool
+	if err := tx.Get(&exists, `SELECT EXISTS (SELECT 1 FROM superusers WHERE id = $1::uuid)`, id); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSuperuser
+	}
+	return nil
+}
+
+// addCaseEntity links e to its case; linking an entity twice does nothing.
+func addCaseEntity(tx *sqlx.Tx, e *CaseEntity) error {
+	if err := checkCaseEntity(tx, e); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT INTO case_entities (case_id, kind, entity_id, added_by) VALUES ($1,$2,$3,$4)
+		ON CONFLICT DO NOTHING`, e.CaseID, e.Kind, e.EntityID, e.AddedBy)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	return addCaseEvent(tx, e.CaseID, CaseEventEntityAdded, e.AddedBy, CaseEventData{"kind": e.Kind, "entity_id": e.EntityID})
+}
+
+// CreateCase stores c with its linked entities and writes the stored row
+// back into c.
+func (db *DB) CreateCase(c *Case, entities []*CaseEntity) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkSuperuser(tx, c.Assignee); err != nil {
+		return err
+	}
+	err = tx.Get(c, `INSERT INTO cases (title, description, status, assignee, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5)
+		RETURNING `+caseColumns, c.Title, c.Description, CaseOpen, c.Assignee, c.CreatedBy)
+	if err != nil {
+		return err
+	}
+	if err := addCaseEvent(tx, c.ID, CaseEventCreated, c.CreatedBy, CaseEventData{"title": c.Title}); err != nil {
+		return err
+	}
+	if c.Assignee != "" {
+		if err := addCaseEvent(tx, c.ID, CaseEventAssigned, c.CreatedBy, CaseEventData{"from": "", "to": c.Assignee}); err != nil {
+			return err
+		}
+	}
+	for _, e := range entities {
+		e.CaseID = c.ID
+		e.AddedBy = c.CreatedBy
+		if err := addCaseEntity(tx, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetCase(id uuid.UUID) (*Case, error) {
+	var c Case
+	err := db.conn.Get(&c, `SELECT `+caseColumns+` FROM cases WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListCases lists the cases with status and assignee, any if empty, most
+// recently updated first.
+func (db *DB) ListCases(status, assignee string) ([]*Case, error) {
+	out := make([]*Case, 0)
+	err := db.conn.Select(&out, `SELECT `+caseColumns+` FROM cases
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR assignee::text = $2)
+		ORDER BY updated_at DESC`, status, assignee)
+	return out, err
+}
+
+// UpdateCase applies u to a case on behalf of actor and returns it. Closing
+// the case labels its transfers and reopening it takes its labels back. It
+// returns sql.ErrNoRows if there is no such case, ErrCaseTransition if the
+// status change is not allowed, ErrCaseLabelConflict if closing it would
+// change labels set by analysts or other cases.
+func (db *DB) UpdateCase(id uuid.UUID, u CaseUpdate, actor string) (*Case, error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var c Case
+	if err := tx.Get(&c, `SELECT `+caseColumns+` FROM cases WHERE id=$1 FOR UPDATE`, id); err != nil {
+		return nil, err
+	}
+	events, types, err := applyCaseUpdate(&c, u, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if u.Assignee != nil {
+		if err := checkSuperuser(tx, c.Assignee); err != nil {
+			return nil, err
+		}
+	}
+
+	if caseClosed(c.Status) && slices.Contains(types, CaseEventStatusChanged) {
+		if err := checkCaseLabels(tx, c.ID, caseLabel(c.Status), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Get(&c, `UPDATE cases
+		SET title = $2, description = $3, status = $4, assignee = NULLIF($5, '')::uuid, closed_at = $6,
+			closed_by = CASE WHEN $6::timestamptz IS NULL THEN NULL WHEN closed_at IS NULL THEN $7 ELSE closed_by END,
+			updated_at = now()
+		WHERE id = $1
+		RETURNING `+caseColumns, c.ID, c.Title, c.Description, c.Status, c.Assignee, c.ClosedAt, actor)
+	if err != nil {
+		return nil, err
+	}
+	for i, data := range events {
+		if types[i] == CaseEventStatusChanged && (caseClosed(data["from"]) || caseClosed(data["to"])) {
+			var transfers []string
+			if err := tx.Select(&transfers, `SELECT entity_id FROM case_entities WHERE case_id = $1 AND kind = 'transfer'`, c.ID); err != nil {
+				return nil, err
+			}
+			removed, err := syncCaseLabels(tx, transfers)
+			if err != nil {
+				return nil, err
+			}
+			if caseClosed(c.Status) {
+				data["labeled_transfers"] = fmt.Sprint(len(transfers))
+			} else {
+				data["unlabeled_transfers"] = fmt.Sprint(removed)
+			}
+		}
+		if err := addCaseEvent(tx, c.ID, types[i], actor, data); err != nil {
+			return nil, err
+		}
+	}
+	return &c, tx.Commit()
+}
+
+// checkCaseLabels returns ErrCaseLabelConflict if any of the transfers of
+// the case, or of transfers if not nil, has a label other than label set by
+// an analyst or another case.
+func checkCaseLabels(tx *sqlx.Tx, caseID uuid.UUID, label string, transfers []string) error {
+	var n int
+	err := tx.Get(&n, `SELECT COUNT(*) FROM case_entities e
+		JOIN transfer_labels l ON l.transfer_id = e.entity_id::uuid
+		WHERE e.case_id = $1 AND e.kind = 'transfer' AND ($3::text[] IS NULL OR e.entity_id = ANY($3))
+			AND l.label <> $2 AND l.case_id IS DISTINCT FROM $1`, caseID, label, pq.Array(transfers))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: %d transfers", ErrCaseLabelConflict, n)
+	}
+	return nil
+}
+
+// syncCaseLabels brings the labels of transfers set by cases in line with
+// the closed cases they are linked to, and returns how many labels it
+// removed. A label whose case is no longer closed or no longer links the
+// transfer passes to another closed case linking it, the first closed, or
+// is removed. Labels set by analysts are left alone.
+func syncCaseLabels(tx *sqlx.Tx, transfers []string) (int64, error) {
+	if len(transfers) == 0 {
+		return 0, nil
+	}
+	const closedCases = `FROM case_entities e JOIN cases c ON c.id = e.case_id
+		WHERE e.kind = 'transfer' AND c.status IN ('closed_fraud', 'closed_legit')`
+
+	var deleted, added []string
+	err := tx.Select(&deleted, `DELETE FROM transfer_labels l
+		WHERE l.transfer_id::text = ANY($1) AND l.case_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 `+closedCases+` AND e.entity_id = l.transfer_id::text AND c.id = l.case_id)
+		RETURNING l.transfer_id::text`, pq.Array(transfers))
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Select(&added, `INSERT INTO transfer_labels (transfer_id, label, note, labeled_by, case_id)
+		SELECT DISTINCT ON (e.entity_id) e.entity_id::uuid,
+			CASE c.status WHEN 'closed_fraud' THEN 'fraud' ELSE 'legit' END,
+			'case ' || c.id || ': ' || c.title, COALESCE(c.closed_by, c.created_by), c.id
+		`+closedCases+` AND e.entity_id = ANY($1)
+		ORDER BY e.entity_id, c.closed_at, c.id
+		ON CONFLICT (transfer_id) DO NOTHING
+		RETURNING transfer_id::text`, pq.Array(transfers))
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, id := range deleted {
+		// else the label passed to another case
+		if !slices.Contains(added, id) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// AddCaseEntity links e to its case, labeling a transfer linked to a closed
+// case. It returns ErrUnknownCaseEntity if the entity does not exist,
+// ErrCaseLabelConflict if the transfer is labeled otherwise.
+func (db *DB) AddCaseEntity(e *CaseEntity) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addCaseEntity(tx, e); err != nil {
+		return err
+	}
+	if e.Kind == CaseEntityTransfer {
+		// a transfer linked to a closed case takes its label
+		var status string
+		if err := tx.Get(&status, `SELECT status FROM cases WHERE id=$1`, e.CaseID); err != nil {
+			return err
+		}
+		if caseClosed(status) {
+			if err := checkCaseLabels(tx, e.CaseID, caseLabel(status), []string{e.EntityID}); err != nil {
+				return err
+			}
+			if _, err := syncCaseLabels(tx, []string{e.EntityID}); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveCaseEntity unlinks an entity from a case on behalf of actor,
+// removing the label the case gave a transfer. It returns sql.ErrNoRows if
+// the entity was not linked.
+func (db *DB) RemoveCaseEntity(caseID uuid.UUID, kind, entityID, actor string) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var removed string
+	err = tx.Get(&removed, `DELETE FROM case_entities WHERE case_id=$1 AND kind=$2 AND entity_id=$3 RETURNING entity_id`, caseID, kind, entityID)
+	if err != nil {
+		return err
+	}
+	if err := addCaseEvent(tx, caseID, CaseEventEntityRemoved, actor, CaseEventData{"kind": kind, "entity_id": entityID}); err != nil {
+		return err
+	}
+	if kind == CaseEntityTransfer {
+		if _, err := syncCaseLabels(tx, []string{entityID}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) ListCaseEntities(caseID uuid.UUID) ([]*CaseEntity, error) {
+	out := make([]*CaseEntity, 0)
+	err := db.conn.Select(&out, `SELECT case_id, kind, entity_id, added_by, added_at FROM case_entities
+		WHERE case_id=$1 ORDER BY added_at`, caseID)
+	return out, err
+}
+
+// AddCaseNote stores n and writes the stored row back into it.
+func (db *DB) AddCaseNote(n *CaseNote) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(n, `INSERT INTO case_notes (case_id, body, author) VALUES ($1,$2,$3)
+		RETURNING id, case_id, body, author, created_at`, n.CaseID, n.Body, n.Author)
+	if err != nil {
+		return err
+	}
+	if err := addCaseEvent(tx, n.CaseID, CaseEventNoteAdded, n.Author, CaseEventData{"note_id": n.ID.String()}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) ListCaseNotes(caseID uuid.UUID) ([]*CaseNote, error) {
+	out := make([]*CaseNote, 0)
+	err := db.conn.Select(&out, `SELECT id, case_id, body, author, created_at FROM case_notes
+		WHERE case_id=$1 ORDER BY created_at`, caseID)
+	return out, err
+}
+
+const caseAttachmentColumns = `id, case_id, filename, content_type, size, storage_key, uploaded_by, created_at`
+
+// AddCaseAttachment records a, already in the attachment store, and writes
+// the stored row back into it.
+func (db *DB) AddCaseAttachment(a *CaseAttachment) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(a, `INSERT INTO case_attachments (id, case_id, filename, content_type, size, storage_key, uploaded_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		RETURNING `+caseAttachmentColumns, a.ID, a.CaseID, a.Filename, a.ContentType, a.Size, a.StorageKey, a.UploadedBy)
+	if err != nil {
+		return err
+	}
+	err = addCaseEvent(tx, a.CaseID, CaseEventAttachmentAdded, a.UploadedBy, CaseEventData{"attachment_id": a.ID.String(), "filename": a.Filename})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetCaseAttachment(caseID, id uuid.UUID) (*CaseAttachment, error) {
+	var a CaseAttachment
+	err := db.conn.Get(&a, `SELECT `+caseAttachmentColumns+` FROM case_attachments WHERE case_id=$1 AND id=$2`, caseID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (db *DB) ListCaseAttachments(caseID uuid.UUID) ([]*CaseAttachment, error) {
+	out := make([]*CaseAttachment, 0)
+	err := db.conn.Select(&out, `SELECT `+caseAttachmentColumns+` FROM case_attachments
+		WHERE case_id=$1 ORDER BY created_at`, caseID)
+	return out, err
+}
+
+// ListCaseEvents returns the timeline of a case, oldest first.
+func (db *DB) ListCaseEvents(caseID uuid.UUID) ([]*CaseEvent, error) {
+	out := make([]*CaseEvent, 0)
+	err := db.conn.Select(&out, `SELECT id, case_id, type, actor, data, created_at FROM case_events
+		WHERE case_id=$1 ORDER BY id`, caseID)
+	return out, err
+}
+
+// ListCasesForTransfer lists the cases a transfer is linked to.
+func (db *DB) ListCasesForTransfer(transferID uuid.UUID) ([]*Case, error) {
+	out := make([]*Case, 0)
+	err := db.conn.Select(&out, `SELECT `+caseColumns+` FROM cases
+		WHERE id IN (SELECT case_id FROM case_entities WHERE kind = 'transfer' AND entity_id = $1)
+		ORDER BY updated_at DESC`, transferID.String())
+	return out, err
+}
+
+// ListCasesForUser lists the cases a user is linked to, directly or through
+// one of their cards or the transfers they sent.
+func (db *DB) ListCasesForUser(userID string) ([]*Case, error) {
+	out := make([]*Case, 0)
+	err := db.conn.Select(&out, `SELECT `+caseColumns+` FROM cases
+		WHERE id IN (
+			SELECT case_id FROM case_entities WHERE kind = 'user' AND entity_id = $1
+			UNION SELECT e.case_id FROM case_entities e JOIN cards c ON e.kind = 'card' AND e.entity_id = c.id::text
+				WHERE c.user_id = $1
+			UNION SELECT e.case_id FROM case_entities e JOIN transfers t ON e.kind = 'transfer' AND e.entity_id = t.id::text
+				WHERE t.from_user_id = $1
+		)
+		ORDER BY updated_at DESC`, userID)
+	return out, err
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestApplyCaseUpdateStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{CaseOpen, CaseInvestigating, false},
+		{CaseOpen, CaseClosedFraud, false},
+		{CaseInvestigating, CaseClosedLegit, false},
+		{CaseInvestigating, CaseOpen, false},
+		{CaseClosedFraud, CaseInvestigating, false},
+		{CaseClosedFraud, CaseOpen, true},
+		{CaseClosedLegit, CaseClosedFraud, true},
+		{CaseOpen, "closed", true},
+	}
+	for i, c := range cases {
+		cs := &Case{Title: "t", Status: c.from}
+		to := c.to
+		events, types, err := applyCaseUpdate(cs, CaseUpdate{Status: &to}, now)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("case %d: %s to %s allowed", i, c.from, c.to)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if cs.Status != c.to || len(types) != 1 || types[0] != CaseEventStatusChanged {
+			t.Errorf("case %d: got status %s, events %v", i, cs.Status, types)
+		}
+		if events[0]["from"] != c.from || events[0]["to"] != c.to {
+			t.Errorf("case %d: got event %v", i, events[0])
+		}
+		if closed := cs.ClosedAt != nil; closed != caseClosed(c.to) {
+			t.Errorf("case %d: closed_at %v for status %s", i, cs.ClosedAt, c.to)
+		}
+	}
+
+	cs := &Case{Status: CaseClosedFraud}
+	open := CaseOpen
+	if _, _, err := applyCaseUpdate(cs, CaseUpdate{Status: &open}, now); !errors.Is(err, ErrCaseTransition) {
+		t.Errorf("got %v, want ErrCaseTransition", err)
+	}
+}
+
+func TestApplyCaseUpdateFields(t *testing.T) {
+	cs := &Case{Title: "old", Description: "d", Status: CaseOpen, Assignee: "a"}
+	title, desc, assignee := "  new ", "d", ""
+	events, types, err := applyCaseUpdate(cs, CaseUpdate{Title: &title, Description: &desc, Assignee: &assignee}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Title != "new" || cs.Assignee != "" {
+		t.Errorf("got %+v", cs)
+	}
+	// the unchanged description is not recorded
+	if len(types) != 2 || types[0] != CaseEventUpdated || types[1] != CaseEventAssigned {
+		t.Fatalf("got events %v", types)
+	}
+	if _, ok := events[0]["description"]; ok || events[0]["title"] != "new" {
+		t.Errorf("got update event %v", events[0])
+	}
+
+	// nothing changed, nothing recorded
+	if _, types, _ := applyCaseUpdate(cs, CaseUpdate{Title: &cs.Title}, time.Now()); len(types) != 0 {
+		t.Errorf("got events %v", types)
+	}
+
+	blank := " "
+	if _, _, err := applyCaseUpdate(cs, CaseUpdate{Title: &blank}, time.Now()); !errors.Is(err, errInvalidCaseUpdate) {
+		t.Errorf("got %v, want errInvalidCaseUpdate", err)
+	}
+}
+
+func TestNormalizeCaseEntity(t *testing.T) {
+	id := uuid.New()
+	e := &CaseEntity{Kind: CaseEntityTransfer, EntityID: " " + id.String() + " "}
+	if err := normalizeCaseEntity(e); err != nil || e.EntityID != id.String() {
+		t.Errorf("got %q, %v", e.EntityID, err)
+	}
+	for _, e := range []*CaseEntity{
+		{Kind: CaseEntityCard, EntityID: "123"},
+		{Kind: CaseEntityUser, EntityID: ""},
+		{Kind: "device", EntityID: id.String()},
+	} {
+		if err := normalizeCaseEntity(e); err == nil {
+			t.Errorf("%s %q accepted", e.Kind, e.EntityID)
+		}
+	}
+}
+
+func TestCaseEventDataScan(t *testing.T) {
+	var d CaseEventData
+	if err := d.Scan([]byte(`{"from":"open","to":"investigating"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if d["from"] != "open" || d["to"] != "investigating" {
+		t.Errorf("got %v", d)
+	}
+	if err := d.Scan(42); err == nil {
+		t.Error("scanned an int")
+	}
+}
+
+func TestCaseLabelsCloseReopen(t *testing.T) {
+	db := openTestDB(t)
+	t1 := insertTestTransfer(t, db)
+	t2 := insertTestTransfer(t, db)
+	if err := db.SetTransferLabel(&TransferLabel{TransferID: t2, Label: LabelLegit, LabeledBy: "analyst"}); err != nil {
+		t.Fatal(err)
+	}
+
+	newCase := func(title string, transfers ...uuid.UUID) *Case {
+		c := &Case{Title: title, CreatedBy: "admin"}
+		entities := make([]*CaseEntity, len(transfers))
+		for i, id := range transfers {
+			entities[i] = &CaseEntity{Kind: CaseEntityTransfer, EntityID: id.String()}
+		}
+		if err := db.CreateCase(c, entities); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	setStatus := func(c *Case, status string) error {
+		_, err := db.UpdateCase(c.ID, CaseUpdate{Status: &status}, "admin")
+		return err
+	}
+	// label returns the label of id and the case that set it, "" if none
+	label := func(id uuid.UUID) (string, *uuid.UUID) {
+		t.Helper()
+		l, err := db.GetTransferLabel(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l.Label, l.CaseID
+	}
+	wantLabel := func(step string, id uuid.UUID, want string, by *Case) {
+		t.Helper()
+		got, caseID := label(id)
+		if got != want || (by == nil) != (caseID == nil) || (by != nil && *caseID != by.ID) {
+			t.Errorf("%s: got label %q by case %v, want %q by %v", step, got, caseID, want, by)
+		}
+	}
+
+	a := newCase("a", t1)
+	if err := setStatus(a, CaseClosedFraud); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("close a", t1, LabelFraud, a)
+
+	// the analyst's label is not overwritten
+	err := db.AddCaseEntity(&CaseEntity{CaseID: a.ID, Kind: CaseEntityTransfer, EntityID: t2.String(), AddedBy: "admin"})
+	if !errors.Is(err, ErrCaseLabelConflict) {
+		t.Errorf("link labeled transfer: got %v, want ErrCaseLabelConflict", err)
+	}
+	wantLabel("link labeled transfer", t2, LabelLegit, nil)
+
+	// nor is another case's
+	b := newCase("b", t1)
+	if err := setStatus(b, CaseClosedLegit); !errors.Is(err, ErrCaseLabelConflict) {
+		t.Errorf("close b as legit: got %v, want ErrCaseLabelConflict", err)
+	}
+	if err := setStatus(b, CaseClosedFraud); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("close b", t1, LabelFraud, a)
+
+	// reopening a case hands its labels to another closed case, if any
+	if err := setStatus(a, CaseInvestigating); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("reopen a", t1, LabelFraud, b)
+	if err := setStatus(b, CaseInvestigating); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("reopen b", t1, "", nil)
+
+	if err := setStatus(a, CaseClosedLegit); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("close a again", t1, LabelLegit, a)
+
+	if err := db.RemoveCaseEntity(a.ID, CaseEntityTransfer, t1.String(), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	wantLabel("unlink from a", t1, "", nil)
+	wantLabel("end", t2, LabelLegit, nil)
+}
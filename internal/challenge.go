@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// Transfers the model allows with at least this score are only executed
+	// after the user confirms a one-time code. Set above 1 to disable.
+	stepUpMinScore float64
+
+	otpTTL         time.Duration
+	otpMaxAttempts int
+
+	challengeSweepInterval time.Duration
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	viper.SetDefault("STEP_UP_MIN_SCORE", 0.5)
+	viper.SetDefault("OTP_TTL", 5*time.Minute)
+	viper.SetDefault("OTP_MAX_ATTEMPTS", 3)
+	stepUpMinScore = viper.GetFloat64("STEP_UP_MIN_SCORE")
+	otpTTL = viper.GetDuration("OTP_TTL")
+	otpMaxAttempts = viper.GetInt("OTP_MAX_ATTEMPTS")
+
+	viper.SetDefault("CHALLENGE_SWEEP_INTERVAL", 30*time.Second)
+	challengeSweepInterval = viper.GetDuration("CHALLENGE_SWEEP_INTERVAL")
+}
+
+// transferStatus is the outcome of scoring: the model's block decision,
+// or a challenge for allowed transfers that are still risky.
+func transferStatus(pr *PredictResponse) string {
+	switch {
+	case pr.BlockTransaction:
+		return TransferBlocked
+	case pr.FraudProbability >= stepUpMinScore:
+		return TransferChallengeRequired
+	}
+	return TransferCompleted
+}
+
+// newChallenge returns a challenge for t and its code in clear.
+func newChallenge(t *Transfer, now time.Time) (*TransferChallenge, string, error) {
+	code, err := newOTPCode()
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", err
+	}
+	return &TransferChallenge{
+		ID:        uuid.New(),
+		UserID:    t.FromUserID,
+		CodeHash:  string(hash),
+		CreatedAt: now,
+		ExpiresAt: now.Add(otpTTL),
+	}, code, nil
+}
+
+type ChallengeOutcome int
+
+const (
+	ChallengeConfirmed ChallengeOutcome = iota
+	ChallengeInvalidCode
+	// too many invalid codes, the transfer is blocked
+	ChallengeFailed
+	ChallengeExpired
+	// the transfer is not awaiting confirmation (anymore)
+	ChallengeNotPending
+)
+
+// ConfirmChallenge checks code against the challenge of the user's
+// transfer and moves the transfer on: executed on the right code, blocked
+// after otpMaxAttempts wrong ones, expired after otpTTL. It returns
+// sql.ErrNoRows if the user has no such challenged transfer.
+func (db *DB) ConfirmChallenge(userId string, transferId uuid.UUID, code string, now time.Time) (*Transfer, ChallengeOutcome, error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	// the row lock serializes concurrent confirmations of the transfer
+	var c TransferChallenge
+	if err := tx.Get(&c, `SELECT id, transfer_id, user_id, code_hash, created_at, expires_at, failed_attempts, confirmed_at
+		FROM transfer_challenges WHERE transfer_id=$1 AND user_id=$2 FOR UPDATE`, transferId, userId); err != nil {
+		return nil, 0, err
+	}
+	var t Transfer
+	if err := tx.Get(&t, `SELECT `+transferColumns+` FROM transfers WHERE id=$1`, transferId); err != nil {
+		return nil, 0, err
+	}
+
+	if t.Status != TransferChallengeRequired {
+		return &t, ChallengeNotPending, nil
+	}
+
+	var outcome ChallengeOutcome
+	switch {
+	case !now.Before(c.ExpiresAt):
+		outcome = ChallengeExpired
+		t.Status = TransferChallengeExpired
+	case bcrypt.CompareHashAndPassword([]byte(c.CodeHash), []byte(code)) == nil:
+		outcome = ChallengeConfirmed
+		t.Status = TransferCompleted
+		if _, err := tx.Exec(`UPDATE transfer_challenges SET confirmed_at=$2 WHERE id=$1`, c.ID, now); err != nil {
+			return nil, 0, err
+		}
+	default:
+		outcome = ChallengeInvalidCode
+		c.FailedAttempts++
+		if _, err := tx.Exec(`UPDATE transfer_challenges SET failed_attempts=$2 WHERE id=$1`, c.ID, c.FailedAttempts); err != nil {
+			return nil, 0, err
+		}
+		if c.FailedAttempts >= otpMaxAttempts {
+			outcome = ChallengeFailed
+			t.Status = TransferChallengeFailed
+			t.IsBlocked = true
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE transfers SET status=$2, is_blocked=$3 WHERE id=$1`, t.ID, t.Status, t.IsBlocked); err != nil {
+		return nil, 0, err
+	}
+	if outcome != ChallengeInvalidCode {
+		if err := addTransferEvent(tx, &t); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return &t, outcome, nil
+}
+
+// CountFailedChallengeAttempts returns the number of wrong codes entered by
+// the user in challenges created since.
+func (db *DB) CountFailedChallengeAttempts(userId string, since time.Time) (int, error) {
+	var n int
+	err := db.conn.Get(&n, `SELECT COALESCE(SUM(failed_attempts), 0) FROM transfer_challenges WHERE user_id=$1 AND created_at >= $2`, userId, since)
+	return n, err
+}
+
+// challengeSweepBatchSize bounds the transfers expired in one transaction.
+const challengeSweepBatchSize = 100
+
+// ExpireChallenges expires up to limit transfers whose challenge ran out
+// unconfirmed at now, with a transfer.expired event each, and returns how
+// many it expired. Challenges being confirmed are skipped.
+func (db *DB) ExpireChallenges(now time.Time, limit int) (int, error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// locks the challenge first, like ConfirmChallenge
+	expired := make([]*Transfer, 0)
+	err = tx.Select(&expired, `WITH due AS (
+			SELECT c.transfer_id FROM transfer_challenges c
+			JOIN transfers t ON t.id = c.transfer_id
+			WHERE t.status = $1 AND c.expires_at <= $2
+			ORDER BY c.expires_at
+			LIMIT $3
+			FOR UPDATE OF c, t SKIP LOCKED
+		)
+		SELECT `+transferColumns+` FROM transfers WHERE id IN (SELECT transfer_id FROM due)`, TransferChallengeRequired, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range expired {
+		t.Status = TransferChallengeExpired
+		if _, err := tx.Exec(`UPDATE transfers SET status=$2 WHERE id=$1`, t.ID, t.Status); err != nil {
+			return 0, err
+		}
+		if err := addTransferEvent(tx, t); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
+// StartChallengeSweeper expires the transfers whose challenge ran out,
+// every CHALLENGE_SWEEP_INTERVAL until ctx is done.
+func StartChallengeSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(challengeSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// a full batch means there may be more
+			for ctx.Err() == nil {
+				n, err := dbClient.ExpireChallenges(time.Now().UTC(), challengeSweepBatchSize)
+				if err != nil {
+					log.Printf("failed to expire challenges: %v", err)
+					break
+				}
+				if n < challengeSweepBatchSize {
+					break
+				}
+			}
+		}
+	}()
+}
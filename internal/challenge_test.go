@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// insertTestChallenge puts a new transfer on hold with a challenge
+// expiring at expiresAt and returns the transfer, its user and the code.
+func insertTestChallenge(t *testing.T, db *DB, expiresAt time.Time) (uuid.UUID, string, string) {
+	t.Helper()
+	id := insertTestTransfer(t, db)
+	var userID string
+	if err := db.conn.Get(&userID, `UPDATE transfers SET status=$2 WHERE id=$1 RETURNING from_user_id`, id, TransferChallengeRequired); err != nil {
+		t.Fatal(err)
+	}
+	c, code, err := newChallenge(&Transfer{FromUserID: userID}, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TransferID = id
+	c.ExpiresAt = expiresAt
+	if _, err := db.conn.NamedExec(`INSERT INTO transfer_challenges (id, transfer_id, user_id, code_hash, created_at, expires_at)
+		VALUES (:id, :transfer_id, :user_id, :code_hash, :created_at, :expires_at)`, c); err != nil {
+		t.Fatal(err)
+	}
+	return id, userID, code
+}
+
+// eventTypesForKey lists the types of the outbox events of key.
+func eventTypesForKey(t *testing.T, db *DB, key string) []string {
+	t.Helper()
+	events, err := db.ListEventsForKey(key, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = e.Type
+	}
+	return out
+}
+
+func TestConfirmChallenge(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now().UTC()
+	wrong := func(code string) string {
+		if code == "000000" {
+			return "000001"
+		}
+		return "000000"
+	}
+
+	t.Run("confirmed", func(t *testing.T) {
+		id, user, code := insertTestChallenge(t, db, now.Add(time.Minute))
+		tr, outcome, err := db.ConfirmChallenge(user, id, wrong(code), now)
+		if err != nil || outcome != ChallengeInvalidCode || tr.Status != TransferChallengeRequired {
+			t.Fatalf("wrong code: got %v, %v", outcome, err)
+		}
+		tr, outcome, err = db.ConfirmChallenge(user, id, code, now)
+		if err != nil || outcome != ChallengeConfirmed || tr.Status != TransferCompleted {
+			t.Fatalf("got %v, %v", outcome, err)
+		}
+		if _, outcome, err = db.ConfirmChallenge(user, id, code, now); err != nil || outcome != ChallengeNotPending {
+			t.Errorf("confirmed again: got %v, %v", outcome, err)
+		}
+		// a wrong code has no event
+		if got := eventTypesForKey(t, db, user); len(got) != 1 || got[0] != EventTransferApproved {
+			t.Errorf("got events %v", got)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		id, user, code := insertTestChallenge(t, db, now.Add(time.Minute))
+		var outcome ChallengeOutcome
+		var tr *Transfer
+		var err error
+		for range otpMaxAttempts {
+			if tr, outcome, err = db.ConfirmChallenge(user, id, wrong(code), now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if outcome != ChallengeFailed || tr.Status != TransferChallengeFailed || !tr.IsBlocked {
+			t.Fatalf("got %v, %+v", outcome, tr)
+		}
+		if _, outcome, _ = db.ConfirmChallenge(user, id, code, now); outcome != ChallengeNotPending {
+			t.Errorf("right code after failing: got %v", outcome)
+		}
+		if got := eventTypesForKey(t, db, user); len(got) != 1 || got[0] != EventTransferFailed {
+			t.Errorf("got events %v", got)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		id, user, code := insertTestChallenge(t, db, now)
+		tr, outcome, err := db.ConfirmChallenge(user, id, code, now)
+		if err != nil || outcome != ChallengeExpired || tr.Status != TransferChallengeExpired {
+			t.Fatalf("got %v, %v", outcome, err)
+		}
+		if got := eventTypesForKey(t, db, user); len(got) != 1 || got[0] != EventTransferExpired {
+			t.Errorf("got events %v", got)
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		id, _, code := insertTestChallenge(t, db, now.Add(time.Minute))
+		if _, _, err := db.ConfirmChallenge("mallory", id, code, now); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestExpireChallenges(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now().UTC()
+	expired, expiredUser, _ := insertTestChallenge(t, db, now.Add(-time.Minute))
+	pending, pendingUser, _ := insertTestChallenge(t, db, now.Add(time.Minute))
+	confirmed, confirmedUser, code := insertTestChallenge(t, db, now.Add(-time.Second))
+	if _, outcome, err := db.ConfirmChallenge(confirmedUser, confirmed, code, now.Add(-2*time.Second)); err != nil || outcome != ChallengeConfirmed {
+		t.Fatalf("got %v, %v", outcome, err)
+	}
+
+	n, err := db.ExpireChallenges(now, 10)
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if n, err := db.ExpireChallenges(now, 10); err != nil || n != 0 {
+		t.Errorf("again: got %d, %v", n, err)
+	}
+
+	for id, want := range map[uuid.UUID]string{expired: TransferChallengeExpired, pending: TransferChallengeRequired, confirmed: TransferCompleted} {
+		tr, err := db.GetTransferByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.Status != want {
+			t.Errorf("transfer %s: got %s, want %s", id, tr.Status, want)
+		}
+	}
+	if got := eventTypesForKey(t, db, expiredUser); len(got) != 1 || got[0] != EventTransferExpired {
+		t.Errorf("got events %v", got)
+	}
+	if got := eventTypesForKey(t, db, pendingUser); len(got) != 0 {
+		t.Errorf("pending: got events %v", got)
+	}
+}
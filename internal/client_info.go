@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"antifraud-demo-backend/internal/geo"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	trustedProxies []*net.IPNet
+
+	geoLocator geo.Locator = geo.Nop
+)
+
+func init() {
+	viper.AutomaticEnv()
+	trustedProxies = parseTrustedProxies(viper.GetString("TRUSTED_PROXIES"))
+}
+
+func SetGeoLocator(l geo.Locator) { geoLocator = l }
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDRs.
+func parseTrustedProxies(v string) []*net.IPNet {
+	out := make([]*net.IPNet, 0)
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Printf("ignoring invalid trusted proxy %q: %v", p, err)
+			continue
+		}
+		out = append(out, network)
+	}
+	return out
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made the request.
+// X-Forwarded-For is only honoured when the direct peer is a trusted proxy,
+// and is walked right to left so that a client can't spoof its address by
+// prepending entries.
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// ClientContextFromRequest collects the IP, user agent and location of the
+// request's client.
+func ClientContextFromRequest(r *http.Request) ClientContext {
+	cc := ClientContext{UserAgent: r.UserAgent()}
+
+	ip := ClientIP(r)
+	if ip == nil {
+		return cc
+	}
+	cc.IP = ip.String()
+
+	if loc, ok := geoLocator.Lookup(ip); ok {
+		cc.Country = loc.Country
+		cc.City = loc.City
+		cc.Latitude = loc.Latitude
+		cc.Longitude = loc.Longitude
+	}
+	return cc
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"antifraud-demo-backend/internal/geo"
+)
+
+func setTrustedProxies(t *testing.T, v string) {
+	t.Helper()
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	trustedProxies = parseTrustedProxies(v)
+}
+
+func TestClientIP(t *testing.T) {
+	setTrustedProxies(t, "10.0.0.0/8, 192.0.2.1, 2001:db8::1, not-an-ip")
+	if len(trustedProxies) != 3 {
+		t.Fatalf("got %d trusted proxies", len(trustedProxies))
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5555", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:5555", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", "10.1.2.3:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed prefix", "10.1.2.3:443", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "192.0.2.1:443", []string{"198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:443", []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:443", []string{"10.0.0.5, 10.0.0.6"}, "10.0.0.5"},
+		{"garbage hop", "10.1.2.3:443", []string{"198.51.100.1, unknown"}, "10.1.2.3"},
+		{"ipv6 proxy", "[2001:db8::1]:443", []string{"2001:db8::42"}, "2001:db8::42"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		for _, v := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := ClientIP(r); got.String() != c.want {
+			t.Errorf("%s: got %v, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestClientContextFromRequest(t *testing.T) {
+	setTrustedProxies(t, "")
+	l, err := geo.ReadCSV(strings.NewReader("network,country,city,latitude,longitude\n5.34.0.0/16,KZ,Almaty,43.2389,76.8897\n5.36.0.0/24,KZ,,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := geoLocator
+	t.Cleanup(func() { geoLocator = saved })
+	SetGeoLocator(l)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", "test")
+	r.RemoteAddr = "5.34.1.1:1234"
+	cc := ClientContextFromRequest(r)
+	if cc.IP != "5.34.1.1" || cc.UserAgent != "test" || cc.Country != "KZ" || cc.City != "Almaty" || cc.Latitude == nil || *cc.Longitude != 76.8897 {
+		t.Errorf("got %+v", cc)
+	}
+
+	// located down to the country only
+	r.RemoteAddr = "5.36.0.1:1234"
+	cc = ClientContextFromRequest(r)
+	if cc.Country != "KZ" || cc.City != "" || cc.Latitude != nil || cc.Longitude != nil {
+		t.Errorf("got %+v", cc)
+	}
+
+	r.RemoteAddr = "198.51.100.1:1234"
+	if cc = ClientContextFromRequest(r); cc.IP != "198.51.100.1" || cc.Country != "" || cc.Latitude != nil {
+		t.Errorf("got %+v", cc)
+	}
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -25,13 +27,37 @@ func (db *DB) Close() error {
 
 func (db *DB) GetUserByID(id string) (*User, error) {
 	var user User
-	err := db.conn.Get(&user, `SELECT id, first_name, last_name, status FROM users WHERE id=$1`, id)
+	err := db.conn.Get(&user, `SELECT id, first_name, last_name, status, created_at, segment FROM users WHERE id=$1`, id)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// UpdateUser stores the status and segment of u. Blocking the user adds a
+// user.blocked event. It returns sql.ErrNoRows if there is no such user.
+func (db *DB) UpdateUser(u *User) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prev UserStatus
+	if err := tx.Get(&prev, `SELECT status FROM users WHERE id=$1 FOR UPDATE`, u.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET status=$2, segment=$3 WHERE id=$1`, u.ID, u.Status, u.Segment); err != nil {
+		return err
+	}
+	if u.Status == StatusBlocked && prev != StatusBlocked {
+		if err := addEvent(tx, EventUserBlocked, u.ID, userToDTO(u)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (db *DB) GetSuperuserByUsername(username string) (*Superuser, error) {
 	var su Superuser
 	err := db.conn.Get(&su, `SELECT id, username, password_hash FROM superusers WHERE username=$1`, username)
@@ -41,14 +67,13 @@ func (db *DB) GetSuperuserByUsername(username string) (*Superuser, error) {
 	return &su, nil
 }
 
-func (db *DB) SaveSession(s *LoginSession) error {
-	_, err := db.conn.Exec(`INSERT INTO login_sessions (user_id, when_ts, phone_model, os) VALUES ($1,$2,$3,$4)`, s.UserID, s.When, s.PhoneModel, s.OS)
-	return err
-}
+const clientContextColumns = `COALESCE(ip, '') as ip, COALESCE(user_agent, '') as user_agent, COALESCE(country, '') as country, COALESCE(city, '') as city, latitude, longitude`
+
+const sessionColumns = `id, user_id, when_ts as when, phone_model, os, COALESCE(device_id, '') as device_id, ` + clientContextColumns
 
 func (db *DB) ListSessionsForUser(userId string) ([]*LoginSession, error) {
 	out := make([]*LoginSession, 0)
-	err := db.conn.Select(&out, `SELECT id, user_id, when_ts as when, phone_model, os FROM login_sessions WHERE user_id=$1 ORDER BY when_ts ASC`, userId)
+	err := db.conn.Select(&out, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 ORDER BY when_ts ASC`, userId)
 	return out, err
 }
 
@@ -67,20 +92,135 @@ func (db *DB) GetCardByNumber(number string) (*Card, error) {
 	return &card, nil
 }
 
-func (db *DB) SaveTransfer(t *Transfer) error {
-	_, err := db.conn.Exec(`INSERT INTO transfers (from_user_id, from_card_id, to_card_id, amount, when_ts, fraud_score, is_blocked) VALUES ($1,$2,$3,$4,$5,$6,$7)`, t.FromUserID, t.FromCardID, t.ToCardID, t.Amount, t.When, t.FraudScore, t.IsBlocked)
+const transferColumns = `id, from_user_id, from_card_id, to_card_id, amount, when_ts as when, fraud_score, is_blocked, status, COALESCE(device_id, '') as device_id, COALESCE(is_new_device, false) as is_new_device, COALESCE(model, '') as model, explanation, list_hits, rule_hits, features, ` + clientContextColumns
+
+func (db *DB) GetCardByID(id uuid.UUID) (*Card, error) {
+	var card Card
+	err := db.conn.Get(&card, `SELECT id, user_id, number, balance, status FROM cards WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// SetCardStatus sets the status of c to c.Status. Blocking the card adds a
+// card.blocked event for its owner; setting the status it already has
+// changes nothing.
+func (db *DB) SetCardStatus(c *Card) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE cards SET status=$2 WHERE id=$1 AND status<>$2`, c.ID, c.Status)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if c.Status == CardBlocked {
+		if err := addEvent(tx, EventCardBlocked, c.UserID, cardToDTO(c)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// namedPreparer is a *sqlx.DB or *sqlx.Tx.
+type namedPreparer interface {
+	PrepareNamed(query string) (*sqlx.NamedStmt, error)
+}
+
+func insertTransfer(q namedPreparer, t *Transfer) error {
+	stmt, err := q.PrepareNamed(`INSERT INTO transfers (from_user_id, from_card_id, to_card_id, amount, when_ts, fraud_score, is_blocked, status, device_id, is_new_device, model, explanation, ip, user_agent, country, city, latitude, longitude)
+		VALUES (:from_user_id, :from_card_id, :to_card_id, :amount, :when, :fraud_score, :is_blocked, :status, NULLIF(:device_id,''), :is_new_device, NULLIF(:model,''), :explanation, NULLIF(:ip,''), :user_agent, NULLIF(:country,''), NULLIF(:city,''), :latitude, :longitude)
+		RETURNING id`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	return stmt.Get(&t.ID, t)
+}
+
+// SaveModelScore stores a model's score of a transfer, replacing the one of
+// an earlier attempt.
+func (db *DB) SaveModelScore(s *ModelScore) error {
+	_, err := db.conn.NamedExec(`INSERT INTO model_scores (transfer_id, model, feature_set_version, shadow, fraud_score, is_blocked, latency_ms, error)
+		VALUES (:transfer_id, :model, :feature_set_version, :shadow, :fraud_score, :is_blocked, :latency_ms, :error)
+		ON CONFLICT (transfer_id, model) DO UPDATE SET
+			feature_set_version = EXCLUDED.feature_set_version,
+			shadow = EXCLUDED.shadow,
+			fraud_score = EXCLUDED.fraud_score,
+			is_blocked = EXCLUDED.is_blocked,
+			latency_ms = EXCLUDED.latency_ms,
+			error = EXCLUDED.error,
+			created_at = now()`, s)
 	return err
 }
 
+func (db *DB) GetTransferByID(id uuid.UUID) (*Transfer, error) {
+	var t Transfer
+	err := db.conn.Get(&t, `SELECT `+transferColumns+` FROM transfers WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (db *DB) ListModelScores(transferId uuid.UUID) ([]*ModelScore, error) {
+	out := make([]*ModelScore, 0)
+	err := db.conn.Select(&out, `SELECT transfer_id, model, feature_set_version, shadow, fraud_score, is_blocked, latency_ms, error
+		FROM model_scores WHERE transfer_id=$1 ORDER BY shadow, model`, transferId)
+	return out, err
+}
+
 func (db *DB) ListTransfersForUser(userId string) ([]*Transfer, error) {
 	out := make([]*Transfer, 0)
-	err := db.conn.Select(&out, `SELECT id, from_user_id, from_card_id, to_card_id, amount, when_ts as when, fraud_score, is_blocked FROM transfers WHERE from_user_id=$1 ORDER BY when_ts DESC`, userId)
+	err := db.conn.Select(&out, `SELECT `+transferColumns+` FROM transfers WHERE from_user_id=$1 ORDER BY when_ts DESC`, userId)
 	return out, err
 }
 
+func (db *DB) ListTransfersForUserSince(userId string, since time.Time) ([]*Transfer, error) {
+	out := make([]*Transfer, 0)
+	err := db.conn.Select(&out, `SELECT `+transferColumns+` FROM transfers WHERE from_user_id=$1 AND when_ts >= $2 ORDER BY when_ts ASC`, userId, since)
+	return out, err
+}
+
+// HasTransferredTo reports whether the user has ever made an executed
+// transfer to the card.
+func (db *DB) HasTransferredTo(userId string, toCardId uuid.UUID) (bool, error) {
+	var exists bool
+	err := db.conn.Get(&exists, `SELECT EXISTS (SELECT 1 FROM transfers WHERE from_user_id=$1 AND to_card_id=$2 AND status='completed')`, userId, toCardId)
+	return exists, err
+}
+
+// GetRecipientActivity loads the inbound and outbound transfers of a card
+// made since the given time, along with its owner's account creation time.
+func (db *DB) GetRecipientActivity(card *Card, since time.Time) (*RecipientActivity, error) {
+	a := &RecipientActivity{
+		Inbound:  make([]*Transfer, 0),
+		Outbound: make([]*Transfer, 0),
+	}
+	if err := db.conn.Select(&a.Inbound, `SELECT `+transferColumns+` FROM transfers WHERE to_card_id=$1 AND when_ts >= $2 ORDER BY when_ts ASC`, card.ID, since); err != nil {
+		return nil, err
+	}
+	if err := db.conn.Select(&a.Outbound, `SELECT `+transferColumns+` FROM transfers WHERE from_card_id=$1 AND when_ts >= $2 ORDER BY when_ts ASC`, card.ID, since); err != nil {
+		return nil, err
+	}
+
+	owner, err := db.GetUserByID(card.UserID)
+	if err != nil {
+		return nil, err
+	}
+	a.AccountCreatedAt = owner.CreatedAt
+	return a, nil
+}
+
 func (db *DB) ListAllUsers() ([]*User, error) {
 	out := make([]*User, 0)
-	err := db.conn.Select(&out, `SELECT id, first_name, last_name, status FROM users`)
+	err := db.conn.Select(&out, `SELECT id, first_name, last_name, status, created_at, segment FROM users`)
 	return out, err
 }
 
@@ -92,13 +232,102 @@ func (db *DB) ListAllCardsByUser(userId string) ([]*Card, error) {
 
 func (db *DB) ListAllTransfersByUser(userId string) ([]*Transfer, error) {
 	out := make([]*Transfer, 0)
-	err := db.conn.Select(&out, `SELECT id, from_user_id, from_card_id, to_card_id, amount, when_ts as when, fraud_score, is_blocked FROM transfers WHERE from_user_id=$1`, userId)
+	err := db.conn.Select(&out, `SELECT `+transferColumns+` FROM transfers WHERE from_user_id=$1`, userId)
 	return out, err
 }
 
 func (db *DB) ListAllLoginSessionsByUser(userId string) ([]*LoginSession, error) {
 	out := make([]*LoginSession, 0)
-	err := db.conn.Select(&out, `SELECT id, user_id, when_ts as when, phone_model, os FROM login_sessions WHERE user_id=$1`, userId)
+	err := db.conn.Select(&out, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1`, userId)
+	return out, err
+}
+
+const deviceColumns = `id, user_id, device_id, name, phone_model, os, first_seen, last_seen, trusted, revoked_at`
+
+func (db *DB) GetDevice(userId, deviceId string) (*Device, error) {
+	var d Device
+	err := db.conn.Get(&d, `SELECT `+deviceColumns+` FROM devices WHERE user_id=$1 AND device_id=$2`, userId, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (db *DB) GetDeviceByID(userId string, id uuid.UUID) (*Device, error) {
+	var d Device
+	err := db.conn.Get(&d, `SELECT `+deviceColumns+` FROM devices WHERE user_id=$1 AND id=$2`, userId, id)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// UpsertDevice registers a device on first sight or refreshes its last_seen
+// and metadata otherwise. The stored row is written back into d. A device
+// seen for the first time adds a device.new_login event with c, where the
+// login came from.
+func (db *DB) UpsertDevice(d *Device, c ClientContext) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var row struct {
+		Device
+		Inserted bool `db:"inserted"`
+	}
+	err = tx.Get(&row, `INSERT INTO devices (user_id, device_id, name, phone_model, os, first_seen, last_seen)
+		VALUES ($1,$2,$3,$4,$5,$6,$6)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET
+			last_seen = EXCLUDED.last_seen,
+			phone_model = EXCLUDED.phone_model,
+			os = EXCLUDED.os,
+			name = CASE WHEN devices.name = '' THEN EXCLUDED.name ELSE devices.name END
+		RETURNING `+deviceColumns+`, xmax = 0 AS inserted`, d.UserID, d.DeviceID, d.Name, d.PhoneModel, d.OS, d.LastSeen)
+	if err != nil {
+		return err
+	}
+	*d = row.Device
+
+	if row.Inserted {
+		if err := addEvent(tx, EventDeviceNewLogin, d.UserID, deviceEventToDTO(d, &c)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) UpdateDevice(d *Device) error {
+	_, err := db.conn.Exec(`UPDATE devices SET name=$1, trusted=$2, revoked_at=$3 WHERE id=$4`, d.Name, d.Trusted, d.RevokedAt, d.ID)
+	return err
+}
+
+// RevokeDevice revokes d at d.RevokedAt and adds a device.revoked event.
+// Revoking a device already revoked changes nothing.
+func (db *DB) RevokeDevice(d *Device) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE devices SET trusted=false, revoked_at=$2 WHERE id=$1 AND revoked_at IS NULL`, d.ID, d.RevokedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := addEvent(tx, EventDeviceRevoked, d.UserID, deviceEventToDTO(d, nil)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) ListDevicesForUser(userId string) ([]*Device, error) {
+	out := make([]*Device, 0)
+	err := db.conn.Select(&out, `SELECT `+deviceColumns+` FROM devices WHERE user_id=$1 ORDER BY last_seen DESC`, userId)
 	return out, err
 }
 
@@ -167,3 +396,55 @@ func (db *DB) GetTransferAnalytics(start, end *time.Time) (*TransferAnalytics, e
 		DailyStats:       dailyStats,
 	}, nil
 }
+
+// ModelComparison summarizes one model's scores of the transfers in a
+// period. Shadow scores are compared with the decision enforced for the
+// same transfer.
+type ModelComparison struct {
+	Model            string  `db:"model"`
+	Scored           int     `db:"scored"`
+	Decided          int     `db:"decided"`
+	Errors           int     `db:"errors"`
+	Blocked          int     `db:"blocked"`
+	MeanScore        float64 `db:"mean_score"`
+	MeanLatencyMs    float64 `db:"mean_latency_ms"`
+	Compared         int     `db:"compared"`
+	Agreed           int     `db:"agreed"`
+	WouldBlock       int     `db:"would_block"`
+	WouldAllow       int     `db:"would_allow"`
+	MeanAbsScoreDiff float64 `db:"mean_abs_score_diff"`
+}
+
+func (db *DB) GetModelComparison(start, end *time.Time) ([]*ModelComparison, error) {
+	var args []interface{}
+	where := "WHERE TRUE"
+	if start != nil {
+		args = append(args, *start)
+		where += fmt.Sprintf(" AND t.when_ts >= $%d", len(args))
+	}
+	if end != nil {
+		args = append(args, *end)
+		where += fmt.Sprintf(" AND t.when_ts <= $%d", len(args))
+	}
+
+	out := make([]*ModelComparison, 0)
+	err := db.conn.Select(&out, `SELECT s.model,
+			COUNT(*) as scored,
+			COUNT(*) FILTER (WHERE NOT s.shadow) as decided,
+			COUNT(*) FILTER (WHERE s.fraud_score IS NULL) as errors,
+			COUNT(*) FILTER (WHERE s.is_blocked) as blocked,
+			COALESCE(AVG(s.fraud_score), 0) as mean_score,
+			COALESCE(AVG(s.latency_ms), 0) as mean_latency_ms,
+			COUNT(d.fraud_score) FILTER (WHERE s.fraud_score IS NOT NULL) as compared,
+			COUNT(*) FILTER (WHERE s.is_blocked = d.is_blocked) as agreed,
+			COUNT(*) FILTER (WHERE s.is_blocked AND NOT d.is_blocked) as would_block,
+			COUNT(*) FILTER (WHERE NOT s.is_blocked AND d.is_blocked) as would_allow,
+			COALESCE(AVG(ABS(s.fraud_score - d.fraud_score)), 0) as mean_abs_score_diff
+		FROM model_scores s
+		JOIN transfers t ON t.id = s.transfer_id
+		LEFT JOIN model_scores d ON d.transfer_id = s.transfer_id AND NOT d.shadow AND s.shadow
+		`+where+`
+		GROUP BY s.model
+		ORDER BY s.model`, args...)
+	return out, err
+}
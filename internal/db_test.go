@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openTestDB returns a database migrated in a schema of its own, dropped
+// when the test ends. Tests using it are skipped unless TEST_DATABASE_URL
+// points to a Postgres database they may create schemas in.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := NewDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.conn.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.conn.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := NewDB(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// the migrations only have Up sections, applied in order
+	files, err := filepath.Glob("../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := func(path string) int {
+		n, _ := strconv.Atoi(strings.SplitN(filepath.Base(path), "_", 2)[0])
+		return n
+	}
+	sort.Slice(files, func(i, j int) bool { return version(files[i]) < version(files[j]) })
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.conn.Exec(string(b)); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+	}
+	return db
+}
+
+// insertTestTransfer inserts a pending transfer between two new cards of a
+// new user, with its scoring job.
+func insertTestTransfer(t *testing.T, db *DB) uuid.UUID {
+	t.Helper()
+	userID := uuid.NewString()
+	if _, err := db.conn.Exec(`INSERT INTO users (id, first_name, last_name, status) VALUES ($1, 'Test', 'User', 'active')`, userID); err != nil {
+		t.Fatal(err)
+	}
+	var cards [2]uuid.UUID
+	for i := range cards {
+		err := db.conn.Get(&cards[i], `INSERT INTO cards (user_id, number, balance, status) VALUES ($1, $2, 1000, 'active') RETURNING id`,
+			userID, fmt.Sprintf("4000%012d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var id uuid.UUID
+	err := db.conn.Get(&id, `INSERT INTO transfers (from_user_id, from_card_id, to_card_id, amount, when_ts, status)
+		VALUES ($1, $2, $3, 10, now(), 'pending') RETURNING id`, userID, cards[0], cards[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.conn.Exec(`INSERT INTO transfer_jobs (transfer_id) VALUES ($1)`, id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestClaimTransferJobLease(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestTransfer(t, db)
+
+	j, err := db.ClaimTransferJob(50 * time.Millisecond)
+	if err != nil || j == nil || j.TransferID != id || j.Attempts != 1 {
+		t.Fatalf("got %+v, %v", j, err)
+	}
+	if again, err := db.ClaimTransferJob(time.Minute); err != nil || again != nil {
+		t.Fatalf("leased job claimed again: %+v, %v", again, err)
+	}
+
+	// the worker died: the job is runnable again once the lease runs out
+	time.Sleep(100 * time.Millisecond)
+	j2, err := db.ClaimTransferJob(time.Minute)
+	if err != nil || j2 == nil || j2.TransferID != id || j2.Attempts != 2 {
+		t.Fatalf("got %+v, %v", j2, err)
+	}
+
+	settled := &Transfer{ID: id, Status: TransferCompleted}
+	if ok, err := db.SettleTransfer(j, settled, nil); err != nil || ok {
+		t.Fatalf("stale claim settled the transfer: %v, %v", ok, err)
+	}
+	if ok, err := db.SettleTransfer(j2, settled, nil); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	got, err := db.GetTransferByID(id)
+	if err != nil || got.Status != TransferCompleted {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	if j3, err := db.ClaimTransferJob(time.Minute); err != nil || j3 != nil {
+		t.Fatalf("settled job claimed: %+v, %v", j3, err)
+	}
+}
+
+func TestRetryAndFailTransferJob(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestTransfer(t, db)
+
+	j, err := db.ClaimTransferJob(time.Minute)
+	if err != nil || j == nil {
+		t.Fatalf("got %+v, %v", j, err)
+	}
+	if err := db.RetryTransferJob(j, 50*time.Millisecond, "model unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	// released, but not before its backoff
+	if again, err := db.ClaimTransferJob(time.Minute); err != nil || again != nil {
+		t.Fatalf("job claimed during its backoff: %+v, %v", again, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	j2, err := db.ClaimTransferJob(time.Minute)
+	if err != nil || j2 == nil || j2.Attempts != 2 || j2.LastError != "model unavailable" {
+		t.Fatalf("got %+v, %v", j2, err)
+	}
+
+	// a stale claim can neither reschedule nor fail the job
+	if err := db.FailTransferJob(j, "stale"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTransferByID(id); got.Status != TransferPending {
+		t.Fatalf("stale claim failed the transfer: %s", got.Status)
+	}
+
+	if err := db.FailTransferJob(j2, "model unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTransferByID(id); got.Status != TransferFailed {
+		t.Fatalf("got status %s, want failed", got.Status)
+	}
+}
+
+func TestSaveModelScoreReplacesEarlierAttempt(t *testing.T) {
+	db := openTestDB(t)
+	id := insertTestTransfer(t, db)
+
+	if err := db.SaveModelScore(&ModelScore{TransferID: id, Model: "champion", FeatureSetVersion: 1, Error: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	score, blocked := 0.4, false
+	if err := db.SaveModelScore(&ModelScore{TransferID: id, Model: "champion", FeatureSetVersion: 1, FraudScore: &score, IsBlocked: &blocked}); err != nil {
+		t.Fatal(err)
+	}
+	scores, err := db.ListModelScores(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 1 || scores[0].FraudScore == nil || *scores[0].FraudScore != score || scores[0].Error != "" {
+		t.Errorf("got %+v", scores)
+	}
+}
@@ -5,6 +5,25 @@ type UserDTO struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Status    string `json:"status"`
+	Segment   string `json:"segment"`
+	// Only returned for the user themselves.
+	Limits *UserLimitsDTO `json:"limits,omitempty"`
+}
+
+// UserLimitsDTO gives the spending limits of a user and what is left of
+// them. Limits that are not set are null.
+type UserLimitsDTO struct {
+	MaxAmount          *float64  `json:"max_amount"`
+	DailyAmount        *LimitDTO `json:"daily_amount"`
+	MonthlyAmount      *LimitDTO `json:"monthly_amount"`
+	HourlyTransfers    *LimitDTO `json:"hourly_transfers"`
+	DailyNewRecipients *LimitDTO `json:"daily_new_recipients"`
+}
+
+type LimitDTO struct {
+	Limit     float64 `json:"limit"`
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
 }
 
 type CardDTO struct {
@@ -24,6 +43,60 @@ type TransferDTO struct {
 	When       string  `json:"when"`
 	FraudScore float64 `json:"fraud_score"`
 	IsBlocked  bool    `json:"is_blocked"`
+	Status     string  `json:"status"`
+
+	IsNewDevice bool `json:"is_new_device"`
+
+	// Why the transfer was blocked or challenged, see Reason* for the values.
+	ReasonCodes []string `json:"reason_codes,omitempty"`
+
+	// Set when the transfer was just challenged.
+	ChallengeID        string `json:"challenge_id,omitempty"`
+	ChallengeExpiresAt string `json:"challenge_expires_at,omitempty"`
+}
+
+type DeviceDTO struct {
+	ID         string `json:"id"`
+	DeviceID   string `json:"device_id"`
+	Name       string `json:"name"`
+	PhoneModel string `json:"phone_model"`
+	OS         string `json:"os"`
+	FirstSeen  string `json:"first_seen"`
+	LastSeen   string `json:"last_seen"`
+	Status     string `json:"status"`
+}
+
+type DeviceEventDTO struct {
+	Device  DeviceDTO `json:"device"`
+	IP      string    `json:"ip,omitempty"`
+	Country string    `json:"country,omitempty"`
+	City    string    `json:"city,omitempty"`
+}
+
+// NotificationCloseDTO is the data of the last event of a notification
+// stream closed by the server.
+type NotificationCloseDTO struct {
+	Reason string `json:"reason"`
+}
+
+type DeviceListResponse struct {
+	Devices []DeviceDTO `json:"devices"`
+}
+
+// UpdateUserRequest holds the fields of a user to change; fields that are
+// not set are kept.
+type UpdateUserRequest struct {
+	Status  *string `json:"status"`
+	Segment *string `json:"segment"`
+}
+
+type SetCardStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type UpdateDeviceRequest struct {
+	Name    *string `json:"name"`
+	Trusted *bool   `json:"trusted"`
 }
 
 type LoginRequest struct {
@@ -46,6 +119,10 @@ type TransferRequest struct {
 	Amount     float64 `json:"amount"`
 }
 
+type ConfirmTransferRequest struct {
+	Code string `json:"code"`
+}
+
 type UserListResponse struct {
 	Users []UserDTO `json:"users"`
 }
@@ -77,6 +154,391 @@ type TransferAnalyticsResponse struct {
 	DailyStats       []TransferAnalyticsDayStatsDTO `json:"daily_stats"`
 }
 
+type ModelScoreDTO struct {
+	Model             string   `json:"model"`
+	FeatureSetVersion int      `json:"feature_set_version"`
+	Shadow            bool     `json:"shadow"`
+	FraudScore        *float64 `json:"fraud_score"`
+	IsBlocked         *bool    `json:"is_blocked"`
+	LatencyMs         int64    `json:"latency_ms"`
+	Error             string   `json:"error,omitempty"`
+}
+
+type AdminTransferResponse struct {
+	Transfer    TransferDTO  `json:"transfer"`
+	Model       string       `json:"model"`
+	Explanation *Explanation `json:"explanation"`
+	ListHits    []ListHit    `json:"list_hits"`
+	RuleHits    []RuleHit    `json:"rule_hits"`
+	// nil until the transfer is labeled
+	Label       *TransferLabelDTO `json:"label"`
+	ModelScores []ModelScoreDTO   `json:"model_scores"`
+	// cases the transfer is linked to
+	Cases []CaseRefDTO `json:"cases"`
+}
+
+type ModelComparisonDTO struct {
+	Model            string  `json:"model"`
+	Scored           int     `json:"scored"`
+	Decided          int     `json:"decided"`
+	Errors           int     `json:"errors"`
+	Blocked          int     `json:"blocked"`
+	MeanScore        float64 `json:"mean_score"`
+	MeanLatencyMs    float64 `json:"mean_latency_ms"`
+	Compared         int     `json:"compared"`
+	Agreed           int     `json:"agreed"`
+	WouldBlock       int     `json:"would_block"`
+	WouldAllow       int     `json:"would_allow"`
+	MeanAbsScoreDiff float64 `json:"mean_abs_score_diff"`
+}
+
+type ModelComparisonResponse struct {
+	Models []ModelComparisonDTO `json:"models"`
+}
+
+type MuleReportSenderDTO struct {
+	UserID    string  `json:"user_id"`
+	Transfers int     `json:"transfers"`
+	Blocked   int     `json:"blocked"`
+	Amount    float64 `json:"amount"`
+}
+
+type MuleReportResponse struct {
+	Card     CardDTO               `json:"card"`
+	Features RecipientFeatures     `json:"features"`
+	Senders  []MuleReportSenderDTO `json:"senders_30d"`
+}
+
+type FeatureDiffDTO struct {
+	Feature string `json:"feature"`
+	Store   any    `json:"store"`
+	Full    any    `json:"full"`
+}
+
+type FeatureStoreCheckResponse struct {
+	UserID     string           `json:"user_id"`
+	Consistent bool             `json:"consistent"`
+	Mismatches []FeatureDiffDTO `json:"mismatches"`
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Generated if empty.
+	Secret string `json:"secret"`
+}
+
+type UpdateWebhookRequest struct {
+	URL        *string   `json:"url"`
+	EventTypes *[]string `json:"event_types"`
+	Secret     *string   `json:"secret"`
+	Active     *bool     `json:"active"`
+}
+
+type WebhookDTO struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+	CreatedAt  string   `json:"created_at"`
+	UpdatedAt  string   `json:"updated_at"`
+	// Only returned when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []WebhookDTO `json:"webhooks"`
+}
+
+type WebhookAttemptDTO struct {
+	AttemptedAt string `json:"attempted_at"`
+	StatusCode  *int   `json:"status_code"`
+	Error       string `json:"error,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+}
+
+type WebhookDeliveryDTO struct {
+	ID             int64               `json:"id"`
+	SubscriptionID string              `json:"subscription_id"`
+	EventID        string              `json:"event_id"`
+	EventType      string              `json:"event_type"`
+	Status         string              `json:"status"`
+	Attempts       int                 `json:"attempts"`
+	NextAttemptAt  *string             `json:"next_attempt_at"`
+	LastStatusCode *int                `json:"last_status_code"`
+	LastError      string              `json:"last_error,omitempty"`
+	CreatedAt      string              `json:"created_at"`
+	DeliveredAt    *string             `json:"delivered_at"`
+	Log            []WebhookAttemptDTO `json:"log"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []WebhookDeliveryDTO `json:"deliveries"`
+}
+
+// SetLimitsRequest sets the limits of an override; null inherits, 0 lifts
+// the limit.
+type SetLimitsRequest struct {
+	MaxAmount          *float64 `json:"max_amount"`
+	DailyAmount        *float64 `json:"daily_amount"`
+	MonthlyAmount      *float64 `json:"monthly_amount"`
+	HourlyTransfers    *int     `json:"hourly_transfers"`
+	DailyNewRecipients *int     `json:"daily_new_recipients"`
+}
+
+type LimitOverrideDTO struct {
+	Scope              string   `json:"scope"`
+	ScopeID            string   `json:"scope_id"`
+	MaxAmount          *float64 `json:"max_amount"`
+	DailyAmount        *float64 `json:"daily_amount"`
+	MonthlyAmount      *float64 `json:"monthly_amount"`
+	HourlyTransfers    *int     `json:"hourly_transfers"`
+	DailyNewRecipients *int     `json:"daily_new_recipients"`
+	UpdatedAt          string   `json:"updated_at"`
+}
+
+type LimitOverrideListResponse struct {
+	Limits []LimitOverrideDTO `json:"limits"`
+}
+
+type CreateListEntryRequest struct {
+	List   string `json:"list"`
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+	// RFC 3339; never expires if empty.
+	ExpiresAt string `json:"expires_at"`
+}
+
+type ListEntryDTO struct {
+	ID        string  `json:"id"`
+	List      string  `json:"list"`
+	Kind      string  `json:"kind"`
+	Value     string  `json:"value"`
+	Reason    string  `json:"reason"`
+	ExpiresAt *string `json:"expires_at"`
+	Expired   bool    `json:"expired"`
+	CreatedBy string  `json:"created_by"`
+	CreatedAt string  `json:"created_at"`
+	UpdatedBy string  `json:"updated_by,omitempty"`
+	UpdatedAt *string `json:"updated_at,omitempty"`
+}
+
+type ListEntryListResponse struct {
+	Entries []ListEntryDTO `json:"entries"`
+}
+
+type CreateRuleRequest struct {
+	Name        string `json:"name"`
+	Expression  string `json:"expression"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	// true if omitted
+	Enabled *bool `json:"enabled"`
+}
+
+type UpdateRuleRequest struct {
+	Expression  *string `json:"expression"`
+	Action      *string `json:"action"`
+	Description *string `json:"description"`
+	Enabled     *bool   `json:"enabled"`
+}
+
+type RuleVersionDTO struct {
+	Version     int    `json:"version"`
+	Expression  string `json:"expression"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	CreatedBy   string `json:"created_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type RuleDTO struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Version     int    `json:"version"`
+	Expression  string `json:"expression"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	CreatedBy   string `json:"created_by"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	// Only set on GET /admin/rules/{id}, newest first.
+	Versions []RuleVersionDTO `json:"versions,omitempty"`
+}
+
+type RuleListResponse struct {
+	Rules []RuleDTO `json:"rules"`
+}
+
+type RuleVariableListResponse struct {
+	Variables []RuleVariable `json:"variables"`
+}
+
+// RuleDryRunRequest tries an expression, or a stored rule at its current
+// or a given version, on the transfers of a period.
+type RuleDryRunRequest struct {
+	Expression string `json:"expression"`
+	Action     string `json:"action"`
+	RuleID     string `json:"rule_id"`
+	Version    int    `json:"version"`
+	// YYYY-MM-DD, both included; the last 30 days if empty.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Limit int    `json:"limit"`
+}
+
+type RuleDryRunSampleDTO struct {
+	Transfer TransferDTO `json:"transfer"`
+	Status   string      `json:"status"`
+	WouldBe  string      `json:"would_be"`
+}
+
+type RuleDryRunResponse struct {
+	Expression    string                `json:"expression"`
+	Action        string                `json:"action"`
+	Start         string                `json:"start"`
+	End           string                `json:"end"`
+	Evaluated     int                   `json:"evaluated"`
+	Skipped       int                   `json:"skipped"`
+	Truncated     bool                  `json:"truncated"`
+	Matched       int                   `json:"matched"`
+	MatchedAmount float64               `json:"matched_amount"`
+	Changed       int                   `json:"changed"`
+	Errors        int                   `json:"errors"`
+	Samples       []RuleDryRunSampleDTO `json:"samples"`
+}
+
+type SetTransferLabelRequest struct {
+	Label string `json:"label"`
+	Note  string `json:"note"`
+}
+
+type TransferLabelDTO struct {
+	Label     string `json:"label"`
+	Note      string `json:"note"`
+	LabeledBy string `json:"labeled_by"`
+	LabeledAt string `json:"labeled_at"`
+	// Only set for labels set by closing a case.
+	CaseID string `json:"case_id,omitempty"`
+}
+
+type CreateSimulationRequest struct {
+	// YYYY-MM-DD, both included; the last 30 days if empty.
+	Start  string           `json:"start"`
+	End    string           `json:"end"`
+	Limit  int              `json:"limit"`
+	Policy SimulationPolicy `json:"policy"`
+}
+
+type SimulationDTO struct {
+	ID        string            `json:"id"`
+	Start     string            `json:"start"`
+	End       string            `json:"end"`
+	Policy    SimulationPolicy  `json:"policy"`
+	Report    *SimulationReport `json:"report"`
+	CreatedBy string            `json:"created_by"`
+	CreatedAt string            `json:"created_at"`
+}
+
+type SimulationListResponse struct {
+	Simulations []SimulationDTO `json:"simulations"`
+}
+
+// AdminUserResponse is a user as seen by admins, with the cases they are
+// linked to directly or through their cards and transfers.
+type AdminUserResponse struct {
+	User  UserDTO      `json:"user"`
+	Cases []CaseRefDTO `json:"cases"`
+}
+
+// CaseRefDTO links to a case from the views of its entities.
+type CaseRefDTO struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status"`
+	Assignee string `json:"assignee"`
+}
+
+type CaseEntityRequest struct {
+	Kind     string `json:"kind"`
+	EntityID string `json:"entity_id"`
+}
+
+type CreateCaseRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// superuser ID, unassigned if empty
+	Assignee string              `json:"assignee"`
+	Entities []CaseEntityRequest `json:"entities"`
+}
+
+// UpdateCaseRequest changes the fields that are set; an empty assignee
+// unassigns the case.
+type UpdateCaseRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+	Assignee    *string `json:"assignee"`
+}
+
+type AddCaseNoteRequest struct {
+	Body string `json:"body"`
+}
+
+type CaseEntityDTO struct {
+	Kind     string `json:"kind"`
+	EntityID string `json:"entity_id"`
+	AddedBy  string `json:"added_by"`
+	AddedAt  string `json:"added_at"`
+}
+
+type CaseNoteDTO struct {
+	ID        string `json:"id"`
+	Body      string `json:"body"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"created_at"`
+}
+
+type CaseAttachmentDTO struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	UploadedBy  string `json:"uploaded_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type CaseEventDTO struct {
+	Type      string            `json:"type"`
+	Actor     string            `json:"actor"`
+	Data      map[string]string `json:"data"`
+	CreatedAt string            `json:"created_at"`
+}
+
+type CaseDTO struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	Assignee    string  `json:"assignee"`
+	CreatedBy   string  `json:"created_by"`
+	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at"`
+	ClosedAt    *string `json:"closed_at"`
+	// Only returned for a single case.
+	Entities    []CaseEntityDTO     `json:"entities,omitempty"`
+	Notes       []CaseNoteDTO       `json:"notes,omitempty"`
+	Attachments []CaseAttachmentDTO `json:"attachments,omitempty"`
+	Timeline    []CaseEventDTO      `json:"timeline,omitempty"`
+}
+
+type CaseListResponse struct {
+	Cases []CaseDTO `json:"cases"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
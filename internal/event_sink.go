@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is a domain event as published by the outbox relay. Delivery is at
+// least once: consumers deduplicate on ID.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Ordering key, the user the event is about.
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventSink publishes events to a broker. A Kafka sink would produce to a
+// topic keyed by Key, a NATS sink to a subject per Type. Publish returns
+// once the broker has the event; events of one key are published one at a
+// time, in order.
+type EventSink interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// FileEventSink appends events as JSON lines to a file, for local
+// development.
+type FileEventSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileEventSink) Publish(_ context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// MemoryEventSink keeps published events in memory, for tests. Fail, if
+// set, is called before an event is kept and fails its publication.
+type MemoryEventSink struct {
+	Fail func(e Event) error
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *MemoryEventSink) Publish(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Fail != nil {
+		if err := s.Fail(e); err != nil {
+			return err
+		}
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Events returns the events published so far.
+func (s *MemoryEventSink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// NewEventSink returns the sink of the given kind: "file" (writing to path)
+// or "memory".
+func NewEventSink(kind, path string) (EventSink, error) {
+	switch kind {
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file event sink needs a path")
+		}
+		return &FileEventSink{Path: path}, nil
+	case "memory":
+		return &MemoryEventSink{}, nil
+	}
+	return nil, fmt.Errorf("unknown event sink %q", kind)
+}
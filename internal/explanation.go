@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// FeatureContribution is how much a feature pushed a score up (positive) or
+// down (negative).
+type FeatureContribution struct {
+	Feature      string  `json:"feature"`
+	Contribution float64 `json:"contribution"`
+}
+
+// Explanation sources: the top features returned by the model, or, for
+// models that don't explain themselves, the local policy checks. Decisions
+// taken on a list hit have no features; the hits are on the transfer.
+const (
+	ExplanationModel  = "model"
+	ExplanationPolicy = "policy"
+	ExplanationList   = "list"
+)
+
+// Explanation is stored with the transfer as JSON. It is for admins only;
+// users get ReasonCodes.
+type Explanation struct {
+	Source   string                `json:"source"`
+	Features []FeatureContribution `json:"features"`
+}
+
+func (e Explanation) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+func (e *Explanation) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	}
+	return fmt.Errorf("Explanation: unsupported type %T", src)
+}
+
+// maxExplanationFeatures bounds what is stored of a model's top features.
+const maxExplanationFeatures = 10
+
+// policyCheck flags a feature that is risky on its own: at least (or, if
+// strict, above) threshold. Flags (0 or 1) have a threshold of 1.
+type policyCheck struct {
+	feature   string
+	threshold float64
+	strict    bool
+}
+
+func (c policyCheck) hit(f *ModelFeatures) bool {
+	def, err := FeatureByName(c.feature)
+	if err != nil {
+		return false
+	}
+	var v float64
+	switch x := def.Extract(f).(type) {
+	case int:
+		v = float64(x)
+	case float64:
+		v = x
+	default:
+		return false
+	}
+	if c.strict {
+		return v > c.threshold
+	}
+	return v >= c.threshold
+}
+
+// policyChecks only explain a decision made by a model that returned no top
+// features; they never change it. The thresholds are set with
+// POLICY_<FEATURE>, e.g. POLICY_AMOUNT_ZSCORE_30D=3.
+var policyChecks = []policyCheck{
+	{feature: "is_new_device", threshold: 1},
+	{feature: "amount_zscore_30d", threshold: 3, strict: true},
+	{feature: "amount_to_balance_ratio", threshold: 0.9, strict: true},
+	{feature: "is_new_destination", threshold: 1},
+	{feature: "recipient_blocked_inbound_share_30d", threshold: 0.2, strict: true},
+	{feature: "recipient_distinct_senders_24h", threshold: 5},
+	{feature: "transfers_last_1h", threshold: 5},
+	{feature: "last_login_speed_kmh", threshold: 900, strict: true},
+	{feature: "is_new_country_30d", threshold: 1},
+	{feature: "monthly_phone_model_changes", threshold: 3},
+	{feature: "failed_challenge_attempts_30d", threshold: 3},
+}
+
+func init() {
+	viper.AutomaticEnv()
+	configurePolicyChecks()
+}
+
+func configurePolicyChecks() {
+	for i := range policyChecks {
+		c := &policyChecks[i]
+		key := "POLICY_" + strings.ToUpper(c.feature)
+		viper.SetDefault(key, c.threshold)
+		c.threshold = viper.GetFloat64(key)
+	}
+}
+
+// Explain returns the explanation of pr: the model's top features, strongest
+// first, or the policy checks feats fails.
+func Explain(feats *ModelFeatures, pr *PredictResponse) *Explanation {
+	if len(pr.TopFeatures) > 0 {
+		top := append([]FeatureContribution(nil), pr.TopFeatures...)
+		sort.SliceStable(top, func(i, j int) bool {
+			return math.Abs(top[i].Contribution) > math.Abs(top[j].Contribution)
+		})
+		return &Explanation{Source: ExplanationModel, Features: top[:min(len(top), maxExplanationFeatures)]}
+	}
+
+	e := &Explanation{Source: ExplanationPolicy, Features: make([]FeatureContribution, 0)}
+	for _, c := range policyChecks {
+		if c.hit(feats) {
+			e.Features = append(e.Features, FeatureContribution{Feature: c.feature, Contribution: 1})
+		}
+	}
+	return e
+}
+
+// User-facing reason codes. They name a kind of risk, never a feature value
+// or threshold.
+const (
+	ReasonNewDevice              = "new_device"
+	ReasonUnusualAmount          = "unusual_amount"
+	ReasonNewRecipient           = "new_recipient"
+	ReasonRiskyRecipient         = "risky_recipient"
+	ReasonHighActivity           = "high_activity"
+	ReasonUnusualLocation        = "unusual_location"
+	ReasonUnusualAccountActivity = "unusual_account_activity"
+)
+
+var featureReasons = map[string]string{
+	"is_new_device":               ReasonNewDevice,
+	"monthly_os_changes":          ReasonUnusualAccountActivity,
+	"monthly_phone_model_changes": ReasonUnusualAccountActivity,
+
+	"amount":                  ReasonUnusualAmount,
+	"amount_zscore_30d":       ReasonUnusualAmount,
+	"amount_to_balance_ratio": ReasonUnusualAmount,
+
+	"is_new_destination":                  ReasonNewRecipient,
+	"recipient_distinct_senders_24h":      ReasonRiskyRecipient,
+	"recipient_distinct_senders_7d":       ReasonRiskyRecipient,
+	"recipient_inbound_1h":                ReasonRiskyRecipient,
+	"recipient_inbound_24h":               ReasonRiskyRecipient,
+	"recipient_inbound_sum_24h":           ReasonRiskyRecipient,
+	"recipient_blocked_inbound_share_30d": ReasonRiskyRecipient,
+	"recipient_account_age_days":          ReasonRiskyRecipient,
+	"recipient_quick_forward_share_7d":    ReasonRiskyRecipient,
+
+	"transfers_last_1h":           ReasonHighActivity,
+	"transfers_last_24h":          ReasonHighActivity,
+	"transfers_last_7d":           ReasonHighActivity,
+	"amount_sum_last_1h":          ReasonHighActivity,
+	"amount_sum_last_24h":         ReasonHighActivity,
+	"amount_sum_last_7d":          ReasonHighActivity,
+	"seconds_since_last_transfer": ReasonHighActivity,
+
+	"last_login_distance_km": ReasonUnusualLocation,
+	"last_login_speed_kmh":   ReasonUnusualLocation,
+	"is_new_country_30d":     ReasonUnusualLocation,
+	"is_new_city_30d":        ReasonUnusualLocation,
+	"distinct_locations_30d": ReasonUnusualLocation,
+
+	"logins_last_7_days":           ReasonUnusualAccountActivity,
+	"logins_last_30_days":          ReasonUnusualAccountActivity,
+	"login_frequency_7d":           ReasonUnusualAccountActivity,
+	"login_frequency_30d":          ReasonUnusualAccountActivity,
+	"freq_change_7d_vs_mean":       ReasonUnusualAccountActivity,
+	"logins_7d_over_30d_ratio":     ReasonUnusualAccountActivity,
+	"avg_login_interval_30d":       ReasonUnusualAccountActivity,
+	"std_login_interval_30d":       ReasonUnusualAccountActivity,
+	"var_login_interval_30d":       ReasonUnusualAccountActivity,
+	"ewm_login_interval_7d":        ReasonUnusualAccountActivity,
+	"burstiness_login_interval":    ReasonUnusualAccountActivity,
+	"fano_factor_login_interval":   ReasonUnusualAccountActivity,
+	"zscore_avg_login_interval_7d": ReasonUnusualAccountActivity,
+
+	"failed_challenge_attempts_30d": ReasonUnusualAccountActivity,
+}
+
+// maxReasonCodes is how many reasons a user is shown.
+const maxReasonCodes = 3
+
+// ReasonCodes maps the features that raised the score, strongest first, to
+// distinct reason codes. Features without a safe description are skipped.
+func (e *Explanation) ReasonCodes() []string {
+	if e == nil {
+		return nil
+	}
+
+	out := make([]string, 0, maxReasonCodes)
+	seen := make(map[string]bool)
+	for _, c := range e.Features {
+		code, ok := featureReasons[c.Feature]
+		if !ok || c.Contribution <= 0 || seen[code] {
+			continue
+		}
+		seen[code] = true
+		out = append(out, code)
+		if len(out) == maxReasonCodes {
+			break
+		}
+	}
+	return out
+}
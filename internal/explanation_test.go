@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestExplainModelFeatures(t *testing.T) {
+	pr := &PredictResponse{TopFeatures: []FeatureContribution{{"amount", 0.2}, {"is_new_device", -0.9}, {"transfers_last_1h", 0.5}}}
+	for i := range 10 {
+		pr.TopFeatures = append(pr.TopFeatures, FeatureContribution{fmt.Sprintf("f%d", i), 0.01})
+	}
+
+	e := Explain(&ModelFeatures{IsNewDevice: 1}, pr)
+	if e.Source != ExplanationModel || len(e.Features) != maxExplanationFeatures {
+		t.Fatalf("got %s with %d features", e.Source, len(e.Features))
+	}
+	// strongest first, whichever the direction
+	if e.Features[0].Feature != "is_new_device" || e.Features[1].Feature != "transfers_last_1h" || e.Features[2].Feature != "amount" {
+		t.Errorf("got %v", e.Features[:3])
+	}
+	if pr.TopFeatures[0].Feature != "amount" {
+		t.Error("model response reordered")
+	}
+}
+
+func TestExplainPolicyChecks(t *testing.T) {
+	cases := []struct {
+		feats ModelFeatures
+		want  []string
+	}{
+		{ModelFeatures{}, []string{}},
+		// thresholds: strictly above for scores and ratios, at least for counts
+		{ModelFeatures{AmountZscore30d: 3, AmountToBalanceRatio: 0.9, TransfersLast1h: 4, FailedChallengeAttempts30d: 2}, []string{}},
+		{ModelFeatures{AmountZscore30d: 3.1, AmountToBalanceRatio: 0.95, TransfersLast1h: 5, FailedChallengeAttempts30d: 3},
+			[]string{"amount_zscore_30d", "amount_to_balance_ratio", "transfers_last_1h", "failed_challenge_attempts_30d"}},
+		{ModelFeatures{IsNewDevice: 1, IsNewDestination: 1, IsNewCountry30d: 1, LastLoginSpeedKmh: 1200},
+			[]string{"is_new_device", "is_new_destination", "last_login_speed_kmh", "is_new_country_30d"}},
+	}
+	for i, c := range cases {
+		e := Explain(&c.feats, &PredictResponse{FraudProbability: 0.9})
+		if e.Source != ExplanationPolicy || e.Features == nil {
+			t.Fatalf("case %d: got %+v", i, e)
+		}
+		got := make([]string, 0)
+		for _, f := range e.Features {
+			if f.Contribution != 1 {
+				t.Errorf("case %d: %s contributes %v", i, f.Feature, f.Contribution)
+			}
+			got = append(got, f.Feature)
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestPolicyChecksConfig(t *testing.T) {
+	for _, c := range policyChecks {
+		if _, err := FeatureByName(c.feature); err != nil {
+			t.Errorf("policy check on %s: %v", c.feature, err)
+		}
+	}
+
+	saved := slices.Clone(policyChecks)
+	t.Cleanup(func() { policyChecks = saved })
+	t.Setenv("POLICY_FAILED_CHALLENGE_ATTEMPTS_30D", "5")
+	configurePolicyChecks()
+
+	feats := &ModelFeatures{FailedChallengeAttempts30d: 4}
+	if e := Explain(feats, &PredictResponse{}); len(e.Features) != 0 {
+		t.Errorf("4 failed attempts flagged at threshold 5: %v", e.Features)
+	}
+	feats.FailedChallengeAttempts30d = 5
+	if e := Explain(feats, &PredictResponse{}); len(e.Features) != 1 {
+		t.Errorf("5 failed attempts not flagged: %v", e.Features)
+	}
+}
+
+func TestReasonCodes(t *testing.T) {
+	if codes := (*Explanation)(nil).ReasonCodes(); codes != nil {
+		t.Errorf("nil explanation: got %v", codes)
+	}
+
+	e := &Explanation{Source: ExplanationModel, Features: []FeatureContribution{
+		{"amount", -0.8},               // lowered the score
+		{"some_internal_score", 0.7},   // no safe description
+		{"transfers_last_1h", 0.6},     // high_activity
+		{"amount_sum_last_24h", 0.5},   // high_activity again
+		{"is_new_device", 0.4},         // new_device
+		{"is_new_country_30d", 0.3},    // unusual_location
+		{"recipient_inbound_24h", 0.2}, // over the limit
+	}}
+	want := []string{ReasonHighActivity, ReasonNewDevice, ReasonUnusualLocation}
+	if got := e.ReasonCodes(); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := (&Explanation{Source: ExplanationList, Features: []FeatureContribution{}}).ReasonCodes(); got == nil || len(got) != 0 {
+		t.Errorf("list decision: got %#v", got)
+	}
+}
+
+func TestReasonCodesCoverRegisteredFeatures(t *testing.T) {
+	for name := range featureReasons {
+		if _, err := FeatureByName(name); err != nil {
+			t.Errorf("reason for %s: %v", name, err)
+		}
+	}
+}
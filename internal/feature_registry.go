@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FeatureDef is a named feature extractor. A feature whose meaning changes
+// gets a new version rather than being edited in place, so that feature sets
+// pinned to the old version keep sending what their model was trained on.
+type FeatureDef struct {
+	Name    string
+	Version int
+	Extract func(f *ModelFeatures) any
+}
+
+var featureRegistry = make(map[string]map[int]FeatureDef)
+
+func RegisterFeature(name string, version int, extract func(f *ModelFeatures) any) {
+	versions, ok := featureRegistry[name]
+	if !ok {
+		versions = make(map[int]FeatureDef)
+		featureRegistry[name] = versions
+	}
+	if _, dup := versions[version]; dup {
+		panic(fmt.Sprintf("feature %s@%d registered twice", name, version))
+	}
+	versions[version] = FeatureDef{Name: name, Version: version, Extract: extract}
+}
+
+// FeatureByName resolves a feature reference, "name" (version 1) or
+// "name@version".
+func FeatureByName(ref string) (FeatureDef, error) {
+	name, version := ref, 1
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		v, err := strconv.Atoi(ref[i+1:])
+		if err != nil {
+			return FeatureDef{}, fmt.Errorf("invalid feature reference %q", ref)
+		}
+		name, version = ref[:i], v
+	}
+
+	def, ok := featureRegistry[name][version]
+	if !ok {
+		return FeatureDef{}, fmt.Errorf("unknown feature %s@%d", name, version)
+	}
+	return def, nil
+}
+
+// FeatureSet is the list of features sent to a model. Every set but
+// version 1, the original contract, also sends its version as
+// feature_set_version.
+type FeatureSet struct {
+	Version  int
+	Features []FeatureDef
+}
+
+func (fs *FeatureSet) Payload(f *ModelFeatures) map[string]any {
+	out := make(map[string]any, len(fs.Features)+1)
+	for _, def := range fs.Features {
+		out[def.Name] = def.Extract(f)
+	}
+	if fs.Version != 1 {
+		out["feature_set_version"] = fs.Version
+	}
+	return out
+}
+
+// FeatureSetManifest is the declarative form of a feature set. Features are
+// referenced as "name" (version 1) or "name@version".
+type FeatureSetManifest struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+}
+
+var featureSets = make(map[int]*FeatureSet)
+
+func RegisterFeatureSet(m FeatureSetManifest) error {
+	if _, dup := featureSets[m.Version]; dup {
+		return fmt.Errorf("feature set v%d already defined", m.Version)
+	}
+
+	fs := &FeatureSet{Version: m.Version}
+	seen := make(map[string]struct{})
+	for _, ref := range m.Features {
+		def, err := FeatureByName(ref)
+		if err != nil {
+			return fmt.Errorf("feature set v%d: %w", m.Version, err)
+		}
+		if _, dup := seen[def.Name]; dup {
+			return fmt.Errorf("feature set v%d: feature %s listed twice", m.Version, def.Name)
+		}
+		seen[def.Name] = struct{}{}
+		fs.Features = append(fs.Features, def)
+	}
+
+	featureSets[m.Version] = fs
+	return nil
+}
+
+func GetFeatureSet(version int) (*FeatureSet, error) {
+	fs, ok := featureSets[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown feature set v%d", version)
+	}
+	return fs, nil
+}
+
+func FeatureSetVersions() []int {
+	out := make([]int, 0, len(featureSets))
+	for v := range featureSets {
+		out = append(out, v)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// LoadFeatureSets registers the feature sets of a JSON file holding a list
+// of FeatureSetManifest.
+func LoadFeatureSets(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var manifests []FeatureSetManifest
+	if err := json.Unmarshal(b, &manifests); err != nil {
+		return fmt.Errorf("feature sets %s: %w", path, err)
+	}
+	for _, m := range manifests {
+		if err := RegisterFeatureSet(m); err != nil {
+			return fmt.Errorf("feature sets %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterFeature("amount", 1, func(f *ModelFeatures) any { return f.Amount })
+	RegisterFeature("monthly_os_changes", 1, func(f *ModelFeatures) any { return f.MonthlyOSChanges })
+	RegisterFeature("monthly_phone_model_changes", 1, func(f *ModelFeatures) any { return f.MonthlyPhoneModelChanges })
+	RegisterFeature("last_phone_model_categorical", 1, func(f *ModelFeatures) any { return f.LastPhoneModelCategorical })
+	RegisterFeature("last_os_categorical", 1, func(f *ModelFeatures) any { return f.LastOSCategorical })
+	RegisterFeature("direction", 1, func(f *ModelFeatures) any { return f.Direction })
+	RegisterFeature("logins_last_7_days", 1, func(f *ModelFeatures) any { return f.LoginsLast7Days })
+	RegisterFeature("logins_last_30_days", 1, func(f *ModelFeatures) any { return f.LoginsLast30Days })
+	RegisterFeature("login_frequency_7d", 1, func(f *ModelFeatures) any { return f.LoginFrequency7d })
+	RegisterFeature("login_frequency_30d", 1, func(f *ModelFeatures) any { return f.LoginFrequency30d })
+	RegisterFeature("freq_change_7d_vs_mean", 1, func(f *ModelFeatures) any { return f.FreqChange7dVsMean })
+	RegisterFeature("logins_7d_over_30d_ratio", 1, func(f *ModelFeatures) any { return f.Logins7dOver30dRatio })
+	RegisterFeature("avg_login_interval_30d", 1, func(f *ModelFeatures) any { return f.AvgLoginInterval30d })
+	RegisterFeature("std_login_interval_30d", 1, func(f *ModelFeatures) any { return f.StdLoginInterval30d })
+	RegisterFeature("var_login_interval_30d", 1, func(f *ModelFeatures) any { return f.VarLoginInterval30d })
+	RegisterFeature("ewm_login_interval_7d", 1, func(f *ModelFeatures) any { return f.EwmLoginInterval7d })
+	RegisterFeature("burstiness_login_interval", 1, func(f *ModelFeatures) any { return f.BurstinessLoginInterval })
+	RegisterFeature("fano_factor_login_interval", 1, func(f *ModelFeatures) any { return f.FanoFactorLoginInterval })
+	RegisterFeature("zscore_avg_login_interval_7d", 1, func(f *ModelFeatures) any { return f.ZscoreAvgLoginInterval7d })
+
+	RegisterFeature("is_new_device", 1, func(f *ModelFeatures) any { return f.IsNewDevice })
+
+	RegisterFeature("last_login_distance_km", 1, func(f *ModelFeatures) any { return f.LastLoginDistanceKm })
+	RegisterFeature("last_login_speed_kmh", 1, func(f *ModelFeatures) any { return f.LastLoginSpeedKmh })
+	RegisterFeature("is_new_country_30d", 1, func(f *ModelFeatures) any { return f.IsNewCountry30d })
+	RegisterFeature("is_new_city_30d", 1, func(f *ModelFeatures) any { return f.IsNewCity30d })
+	RegisterFeature("distinct_locations_30d", 1, func(f *ModelFeatures) any { return f.DistinctLocations30d })
+
+	RegisterFeature("amount_zscore_30d", 1, func(f *ModelFeatures) any { return f.AmountZscore30d })
+	RegisterFeature("transfers_last_1h", 1, func(f *ModelFeatures) any { return f.TransfersLast1h })
+	RegisterFeature("transfers_last_24h", 1, func(f *ModelFeatures) any { return f.TransfersLast24h })
+	RegisterFeature("transfers_last_7d", 1, func(f *ModelFeatures) any { return f.TransfersLast7d })
+	RegisterFeature("amount_sum_last_1h", 1, func(f *ModelFeatures) any { return f.AmountSumLast1h })
+	RegisterFeature("amount_sum_last_24h", 1, func(f *ModelFeatures) any { return f.AmountSumLast24h })
+	RegisterFeature("amount_sum_last_7d", 1, func(f *ModelFeatures) any { return f.AmountSumLast7d })
+	RegisterFeature("is_new_destination", 1, func(f *ModelFeatures) any { return f.IsNewDestination })
+	RegisterFeature("seconds_since_last_transfer", 1, func(f *ModelFeatures) any { return f.SecondsSinceLastTransfer })
+	RegisterFeature("amount_to_balance_ratio", 1, func(f *ModelFeatures) any { return f.AmountToBalanceRatio })
+
+	RegisterFeature("recipient_distinct_senders_24h", 1, func(f *ModelFeatures) any { return f.RecipientDistinctSenders24h })
+	RegisterFeature("recipient_distinct_senders_7d", 1, func(f *ModelFeatures) any { return f.RecipientDistinctSenders7d })
+	RegisterFeature("recipient_inbound_1h", 1, func(f *ModelFeatures) any { return f.RecipientInbound1h })
+	RegisterFeature("recipient_inbound_24h", 1, func(f *ModelFeatures) any { return f.RecipientInbound24h })
+	RegisterFeature("recipient_inbound_sum_24h", 1, func(f *ModelFeatures) any { return f.RecipientInboundSum24h })
+	RegisterFeature("recipient_blocked_inbound_share_30d", 1, func(f *ModelFeatures) any { return f.RecipientBlockedInboundShare30d })
+	RegisterFeature("recipient_account_age_days", 1, func(f *ModelFeatures) any { return f.RecipientAccountAgeDays })
+	RegisterFeature("recipient_quick_forward_share_7d", 1, func(f *ModelFeatures) any { return f.RecipientQuickForwardShare7d })
+	RegisterFeature("failed_challenge_attempts_30d", 1, func(f *ModelFeatures) any { return f.FailedChallengeAttempts30d })
+
+	v1 := []string{
+		"amount",
+		"monthly_os_changes",
+		"monthly_phone_model_changes",
+		"last_phone_model_categorical",
+		"last_os_categorical",
+		"direction",
+		"logins_last_7_days",
+		"logins_last_30_days",
+		"login_frequency_7d",
+		"login_frequency_30d",
+		"freq_change_7d_vs_mean",
+		"logins_7d_over_30d_ratio",
+		"avg_login_interval_30d",
+		"std_login_interval_30d",
+		"var_login_interval_30d",
+		"ewm_login_interval_7d",
+		"burstiness_login_interval",
+		"fano_factor_login_interval",
+		"zscore_avg_login_interval_7d",
+	}
+	v2 := append(append([]string{}, v1...),
+		"is_new_device",
+		"last_login_distance_km",
+		"last_login_speed_kmh",
+		"is_new_country_30d",
+		"is_new_city_30d",
+		"distinct_locations_30d",
+	)
+
+	v3 := append(append([]string{}, v2...),
+		"amount_zscore_30d",
+		"transfers_last_1h",
+		"transfers_last_24h",
+		"transfers_last_7d",
+		"amount_sum_last_1h",
+		"amount_sum_last_24h",
+		"amount_sum_last_7d",
+		"is_new_destination",
+		"seconds_since_last_transfer",
+		"amount_to_balance_ratio",
+	)
+
+	v4 := append(append([]string{}, v3...),
+		"recipient_distinct_senders_24h",
+		"recipient_distinct_senders_7d",
+		"recipient_inbound_1h",
+		"recipient_inbound_24h",
+		"recipient_inbound_sum_24h",
+		"recipient_blocked_inbound_share_30d",
+		"recipient_account_age_days",
+		"recipient_quick_forward_share_7d",
+	)
+
+	v5 := append(append([]string{}, v4...),
+		"failed_challenge_attempts_30d",
+	)
+
+	for _, m := range []FeatureSetManifest{
+		{Version: 1, Features: v1},
+		{Version: 2, Features: v2},
+		{Version: 3, Features: v3},
+		{Version: 4, Features: v4},
+		{Version: 5, Features: v5},
+	} {
+		if err := RegisterFeatureSet(m); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func featureSetNames(t *testing.T, version int) []string {
+	t.Helper()
+	set, err := GetFeatureSet(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(set.Features))
+	for i, def := range set.Features {
+		names[i] = def.Name
+	}
+	return names
+}
+
+// A published feature set is a contract models opt into: new features go
+// into a new version.
+func TestPublishedFeatureSets(t *testing.T) {
+	v1 := featureSetNames(t, 1)
+	if len(v1) != 19 || v1[0] != "amount" || v1[18] != "zscore_avg_login_interval_7d" {
+		t.Errorf("v1 changed: %v", v1)
+	}
+	v2 := featureSetNames(t, 2)
+	want := append(slices.Clone(v1), "is_new_device", "last_login_distance_km", "last_login_speed_kmh", "is_new_country_30d", "is_new_city_30d", "distinct_locations_30d")
+	if !slices.Equal(v2, want) {
+		t.Errorf("v2 changed: %v", v2)
+	}
+
+	versions := FeatureSetVersions()
+	for i := 1; i < len(versions); i++ {
+		prev, cur := featureSetNames(t, versions[i-1]), featureSetNames(t, versions[i])
+		if len(cur) <= len(prev) || !slices.Equal(cur[:len(prev)], prev) {
+			t.Errorf("v%d does not extend v%d", versions[i], versions[i-1])
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package internal
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// The login feature store keeps daily aggregates of each user's logins
+// (counts, interval sums and sums of squares, distinct device and location
+// sets) up to date on every login, so that transfer scoring only reads the
+// buckets of the last 30 days plus the raw logins of the last 7 days instead
+// of the whole history.
+//
+// Exponentially weighted and 7 day statistics depend on the exact sequence
+// of recent intervals, so they are still computed from the raw tail.
+// CheckLoginFeatures compares the store against a full ComputeFeatures run.
+
+// countSet counts occurrences of string values, stored as a JSON object.
+type countSet map[string]int
+
+func (c countSet) distinct() int {
+	n := 0
+	for _, v := range c {
+		if v > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (c countSet) add(o countSet) {
+	for k, v := range o {
+		c[k] += v
+	}
+}
+
+func (c countSet) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(c)
+}
+
+func (c *countSet) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*c = make(countSet)
+		return nil
+	default:
+		return fmt.Errorf("countSet: unsupported type %T", src)
+	}
+	out := make(countSet)
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	*c = out
+	return nil
+}
+
+type loginBucket struct {
+	UserID        string   `db:"user_id"`
+	Day           string   `db:"day"`
+	Logins        int      `db:"logins"`
+	Intervals     int      `db:"intervals"`
+	IntervalSum   float64  `db:"interval_sum"`
+	IntervalSqSum float64  `db:"interval_sq_sum"`
+	PhoneModels   countSet `db:"phone_models"`
+	OSes          countSet `db:"oses"`
+	Countries     countSet `db:"countries"`
+	Places        countSet `db:"places"`
+}
+
+func newLoginBucket(userId, day string) *loginBucket {
+	return &loginBucket{
+		UserID:      userId,
+		Day:         day,
+		PhoneModels: make(countSet),
+		OSes:        make(countSet),
+		Countries:   make(countSet),
+		Places:      make(countSet),
+	}
+}
+
+func (b *loginBucket) addLogin(s *LoginSession) {
+	b.Logins++
+	b.PhoneModels[s.PhoneModel]++
+	b.OSes[s.OS]++
+	if s.Country != "" {
+		b.Countries[s.Country]++
+		b.Places[placeKey(s.Country, s.City)]++
+	}
+}
+
+func (b *loginBucket) addInterval(seconds float64) {
+	b.Intervals++
+	b.IntervalSum += seconds
+	b.IntervalSqSum += seconds * seconds
+}
+
+func (b *loginBucket) merge(o *loginBucket) {
+	b.Logins += o.Logins
+	b.Intervals += o.Intervals
+	b.IntervalSum += o.IntervalSum
+	b.IntervalSqSum += o.IntervalSqSum
+	b.PhoneModels.add(o.PhoneModels)
+	b.OSes.add(o.OSes)
+	b.Countries.add(o.Countries)
+	b.Places.add(o.Places)
+}
+
+func dayKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+type loginFeatureState struct {
+	UserID          string        `db:"user_id"`
+	LastSessionID   uuid.UUID     `db:"last_session_id"`
+	PrevSessionID   uuid.NullUUID `db:"prev_session_id"`
+	LastDeviceIsNew bool          `db:"last_device_is_new"`
+}
+
+const bucketColumns = `user_id, to_char(day, 'YYYY-MM-DD') as day, logins, intervals, interval_sum, interval_sq_sum, phone_models, oses, countries, places`
+
+func getLoginFeatureState(q sqlx.Queryer, userId string) (*loginFeatureState, error) {
+	var st loginFeatureState
+	err := sqlx.Get(q, &st, `SELECT user_id, last_session_id, prev_session_id, last_device_is_new FROM login_feature_state WHERE user_id=$1`, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func getSession(q sqlx.Queryer, id uuid.UUID) (*LoginSession, error) {
+	var s LoginSession
+	if err := sqlx.Get(q, &s, `SELECT `+sessionColumns+` FROM login_sessions WHERE id=$1`, id); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func getBucketForUpdate(tx *sqlx.Tx, userId, day string) (*loginBucket, error) {
+	b := newLoginBucket(userId, day)
+	err := tx.Get(b, `SELECT `+bucketColumns+` FROM login_feature_buckets WHERE user_id=$1 AND day=$2::date FOR UPDATE`, userId, day)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return b, nil
+}
+
+func saveBucket(tx *sqlx.Tx, b *loginBucket) error {
+	_, err := tx.NamedExec(`INSERT INTO login_feature_buckets (user_id, day, logins, intervals, interval_sum, interval_sq_sum, phone_models, oses, countries, places)
+		VALUES (:user_id, CAST(:day AS date), :logins, :intervals, :interval_sum, :interval_sq_sum, :phone_models, :oses, :countries, :places)
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			logins = EXCLUDED.logins,
+			intervals = EXCLUDED.intervals,
+			interval_sum = EXCLUDED.interval_sum,
+			interval_sq_sum = EXCLUDED.interval_sq_sum,
+			phone_models = EXCLUDED.phone_models,
+			oses = EXCLUDED.oses,
+			countries = EXCLUDED.countries,
+			places = EXCLUDED.places`, b)
+	return err
+}
+
+func saveLoginFeatureState(tx *sqlx.Tx, st *loginFeatureState) error {
+	_, err := tx.NamedExec(`INSERT INTO login_feature_state (user_id, last_session_id, prev_session_id, last_device_is_new)
+		VALUES (:user_id, :last_session_id, :prev_session_id, :last_device_is_new)
+		ON CONFLICT (user_id) DO UPDATE SET
+			last_session_id = EXCLUDED.last_session_id,
+			prev_session_id = EXCLUDED.prev_session_id,
+			last_device_is_new = EXCLUDED.last_device_is_new`, st)
+	return err
+}
+
+func insertSession(tx *sqlx.Tx, s *LoginSession) error {
+	stmt, err := tx.PrepareNamed(`INSERT INTO login_sessions (user_id, when_ts, phone_model, os, device_id, ip, user_agent, country, city, latitude, longitude)
+		VALUES (:user_id, :when, :phone_model, :os, NULLIF(:device_id,''), NULLIF(:ip,''), :user_agent, NULLIF(:country,''), NULLIF(:city,''), :latitude, :longitude)
+		RETURNING id`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	return stmt.Get(&s.ID, s)
+}
+
+// lockUserLogins serializes feature store updates of one user until the end
+// of the transaction. A row lock isn't enough since the user may have no
+// state row yet.
+func lockUserLogins(tx *sqlx.Tx, userId string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('login_features:' || $1))`, userId)
+	return err
+}
+
+// RecordLogin saves the session and folds it into the user's login
+// aggregates in one transaction.
+func (db *DB) RecordLogin(s *LoginSession) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserLogins(tx, s.UserID); err != nil {
+		return err
+	}
+
+	st, err := getLoginFeatureState(tx, s.UserID)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		// first login since the store was introduced (or ever): fold the
+		// existing history in together with this session
+		if err := insertSession(tx, s); err != nil {
+			return err
+		}
+		if err := rebuildLoginFeatures(tx, s.UserID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	last, err := getSession(tx, st.LastSessionID)
+	if err != nil {
+		return err
+	}
+	if s.When.Before(last.When) {
+		// out of order session, intervals of other logins change as well
+		if err := insertSession(tx, s); err != nil {
+			return err
+		}
+		if err := rebuildLoginFeatures(tx, s.UserID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	deviceSeen := false
+	if s.DeviceID != "" {
+		if err := tx.Get(&deviceSeen, `SELECT EXISTS (SELECT 1 FROM login_sessions WHERE user_id=$1 AND device_id=$2)`, s.UserID, s.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	if err := insertSession(tx, s); err != nil {
+		return err
+	}
+
+	ib, err := getBucketForUpdate(tx, s.UserID, dayKey(last.When))
+	if err != nil {
+		return err
+	}
+	ib.addInterval(s.When.Sub(last.When).Seconds())
+	if err := saveBucket(tx, ib); err != nil {
+		return err
+	}
+
+	lb, err := getBucketForUpdate(tx, s.UserID, dayKey(s.When))
+	if err != nil {
+		return err
+	}
+	lb.addLogin(s)
+	if err := saveBucket(tx, lb); err != nil {
+		return err
+	}
+
+	err = saveLoginFeatureState(tx, &loginFeatureState{
+		UserID:          s.UserID,
+		LastSessionID:   s.ID,
+		PrevSessionID:   uuid.NullUUID{UUID: last.ID, Valid: true},
+		LastDeviceIsNew: s.DeviceID != "" && !deviceSeen,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RebuildLoginFeatures recomputes a user's login aggregates from the full
+// session history.
+func (db *DB) RebuildLoginFeatures(userId string) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockUserLogins(tx, userId); err != nil {
+		return err
+	}
+	if err := rebuildLoginFeatures(tx, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func rebuildLoginFeatures(tx *sqlx.Tx, userId string) error {
+	if _, err := tx.Exec(`DELETE FROM login_feature_state WHERE user_id=$1`, userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM login_feature_buckets WHERE user_id=$1`, userId); err != nil {
+		return err
+	}
+
+	sessions := make([]*LoginSession, 0)
+	if err := tx.Select(&sessions, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 ORDER BY when_ts ASC, id ASC`, userId); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	buckets := make(map[string]*loginBucket)
+	bucket := func(t time.Time) *loginBucket {
+		day := dayKey(t)
+		b, ok := buckets[day]
+		if !ok {
+			b = newLoginBucket(userId, day)
+			buckets[day] = b
+		}
+		return b
+	}
+
+	devices := make(map[string]struct{})
+	st := &loginFeatureState{UserID: userId}
+	for i, s := range sessions {
+		if i > 0 {
+			prev := sessions[i-1]
+			bucket(prev.When).addInterval(s.When.Sub(prev.When).Seconds())
+			st.PrevSessionID = uuid.NullUUID{UUID: prev.ID, Valid: true}
+		}
+		bucket(s.When).addLogin(s)
+
+		_, seen := devices[s.DeviceID]
+		st.LastSessionID = s.ID
+		st.LastDeviceIsNew = s.DeviceID != "" && !seen
+		devices[s.DeviceID] = struct{}{}
+	}
+
+	for _, b := range buckets {
+		if err := saveBucket(tx, b); err != nil {
+			return err
+		}
+	}
+	return saveLoginFeatureState(tx, st)
+}
+
+// LoginFeatures fills the login-based part of feats like ComputeFeatures
+// does with the user's full session history, but from the feature store.
+// transdate is expected to be the current time; for points in the past it
+// falls back to a full recompute.
+//
+// deviceId is the device the transfer is made from. The device is new if
+// it logged in no more than once up to transdate; without one (old
+// clients), the last login's device is taken like in ComputeFeatures.
+func (db *DB) LoginFeatures(feats *ModelFeatures, userId, deviceId string, transdate time.Time) error {
+	if err := db.loginFeatures(feats, userId, transdate); err != nil {
+		return err
+	}
+	if deviceId == "" {
+		return nil
+	}
+	var logins int
+	if err := db.conn.Get(&logins, `SELECT count(*) FROM login_sessions WHERE user_id=$1 AND device_id=$2 AND when_ts <= $3`, userId, deviceId, transdate); err != nil {
+		return err
+	}
+	feats.IsNewDevice = 0
+	if logins <= 1 {
+		feats.IsNewDevice = 1
+	}
+	return nil
+}
+
+func (db *DB) loginFeatures(feats *ModelFeatures, userId string, transdate time.Time) error {
+	st, err := getLoginFeatureState(db.conn, userId)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if err := db.RebuildLoginFeatures(userId); err != nil {
+			return err
+		}
+		if st, err = getLoginFeatureState(db.conn, userId); err != nil || st == nil {
+			// no logins at all
+			return err
+		}
+	}
+
+	last, err := getSession(db.conn, st.LastSessionID)
+	if err != nil {
+		return err
+	}
+	if last.When.After(transdate) {
+		sessions, err := db.ListSessionsForUser(userId)
+		if err != nil {
+			return err
+		}
+		ComputeFeatures(feats, sessions, transdate)
+		return nil
+	}
+
+	feats.LastPhoneModelCategorical = last.PhoneModel
+	feats.LastOSCategorical = last.OS
+	if st.LastDeviceIsNew {
+		feats.IsNewDevice = 1
+	}
+
+	if st.PrevSessionID.Valid {
+		before, err := getSession(db.conn, st.PrevSessionID.UUID)
+		if err != nil {
+			return err
+		}
+		setTravelFeatures(feats, before, last)
+	}
+
+	cutoff7 := transdate.Add(-7 * 24 * time.Hour)
+	cutoff30 := transdate.Add(-30 * 24 * time.Hour)
+
+	agg, err := db.loginAggregates30d(userId, cutoff30)
+	if err != nil {
+		return err
+	}
+
+	// logins of the last 7 days and the one right before them, for the
+	// interval-sequence features
+	tail := make([]*LoginSession, 0)
+	if err := db.conn.Select(&tail, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 AND when_ts >= $2 ORDER BY when_ts ASC`, userId, cutoff7); err != nil {
+		return err
+	}
+	logins7 := len(tail)
+	before7 := make([]*LoginSession, 0)
+	if err := db.conn.Select(&before7, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 AND when_ts < $2 AND when_ts >= $3 ORDER BY when_ts DESC LIMIT 1`, userId, cutoff7, cutoff30); err != nil {
+		return err
+	}
+	times := make([]time.Time, 0, len(tail)+1)
+	for _, s := range before7 {
+		times = append(times, s.When)
+	}
+	for _, s := range tail {
+		times = append(times, s.When)
+	}
+
+	feats.MonthlyPhoneModelChanges = agg.PhoneModels.distinct()
+	feats.MonthlyOSChanges = agg.OSes.distinct()
+	setLoginCountFeatures(feats, logins7, agg.Logins)
+	setPlaceFeatures(feats, last, !last.When.Before(cutoff30), agg.Countries, agg.Places)
+
+	if agg.Intervals > 0 {
+		n := float64(agg.Intervals)
+		mean := agg.IntervalSum / n
+		// rounding can push an all-equal sample slightly below zero
+		variance := math.Max(0, agg.IntervalSqSum/n-mean*mean)
+		setIntervalFeatures(feats, mean, variance)
+		setRecentIntervalFeatures(feats, times, cutoff7, mean, math.Sqrt(variance))
+	}
+
+	return nil
+}
+
+// loginAggregates30d sums the buckets of the days after cutoff's day. The
+// cutoff day itself is only partially inside the window and is aggregated
+// from raw sessions.
+func (db *DB) loginAggregates30d(userId string, cutoff time.Time) (*loginBucket, error) {
+	agg := newLoginBucket(userId, "")
+
+	buckets := make([]*loginBucket, 0)
+	if err := db.conn.Select(&buckets, `SELECT `+bucketColumns+` FROM login_feature_buckets WHERE user_id=$1 AND day > $2::date`, userId, dayKey(cutoff)); err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		agg.merge(b)
+	}
+
+	nextDay := cutoff.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	edge := make([]*LoginSession, 0)
+	if err := db.conn.Select(&edge, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 AND when_ts >= $2 AND when_ts < $3 ORDER BY when_ts ASC`, userId, cutoff, nextDay); err != nil {
+		return nil, err
+	}
+	if len(edge) == 0 {
+		return agg, nil
+	}
+
+	after := make([]*LoginSession, 0)
+	if err := db.conn.Select(&after, `SELECT `+sessionColumns+` FROM login_sessions WHERE user_id=$1 AND when_ts >= $2 ORDER BY when_ts ASC LIMIT 1`, userId, nextDay); err != nil {
+		return nil, err
+	}
+	edge = append(edge, after...)
+
+	for i, s := range edge {
+		if i > 0 {
+			agg.addInterval(s.When.Sub(edge[i-1].When).Seconds())
+		}
+		if s.When.Before(nextDay) {
+			agg.addLogin(s)
+		}
+	}
+	return agg, nil
+}
+
+type FeatureDiff struct {
+	Feature string
+	Got     any
+	Want    any
+}
+
+// CheckLoginFeatures computes the login features of a transfer made at
+// transdate both from the feature store and from the full session history
+// and returns the features that differ (Got from the store, Want from the
+// full recompute).
+func (db *DB) CheckLoginFeatures(userId string, transdate time.Time) ([]FeatureDiff, error) {
+	fromStore := &ModelFeatures{}
+	if err := db.LoginFeatures(fromStore, userId, "", transdate); err != nil {
+		return nil, err
+	}
+
+	sessions, err := db.ListSessionsForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	full := ComputeFeatures(&ModelFeatures{}, sessions, transdate)
+
+	return DiffFeatures(fromStore, full, 1e-6)
+}
+
+// DiffFeatures compares two feature vectors field by field, as serialized
+// to the model. Numbers are equal if they are within tol, relative to the
+// larger of the two (or absolute below 1).
+func DiffFeatures(a, b *ModelFeatures, tol float64) ([]FeatureDiff, error) {
+	am, err := featureMap(a)
+	if err != nil {
+		return nil, err
+	}
+	bm, err := featureMap(b)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]FeatureDiff, 0)
+	for _, name := range sortedKeys(am, bm) {
+		av, bv := am[name], bm[name]
+		an, aok := av.(float64)
+		bn, bok := bv.(float64)
+		if aok && bok {
+			if math.Abs(an-bn) <= tol*math.Max(1, math.Max(math.Abs(an), math.Abs(bn))) {
+				continue
+			}
+		} else if av == bv {
+			continue
+		}
+		diffs = append(diffs, FeatureDiff{Feature: name, Got: av, Want: bv})
+	}
+	return diffs, nil
+}
+
+// featureMap returns all computed features by their field's JSON name.
+func featureMap(f *ModelFeatures) (map[string]any, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any)
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func sortedKeys(maps ...map[string]any) []string {
+	seen := make(map[string]struct{})
+	keys := make([]string, 0)
+	for _, m := range maps {
+		for k := range m {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
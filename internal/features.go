@@ -6,6 +6,35 @@ import (
 	"time"
 )
 
+// LoginFeatures are the features ComputeFeatures derives from login
+// sessions. The others come from the transfer, its sender's history and its
+// recipient.
+var LoginFeatures = []string{
+	"monthly_os_changes",
+	"monthly_phone_model_changes",
+	"last_phone_model_categorical",
+	"last_os_categorical",
+	"logins_last_7_days",
+	"logins_last_30_days",
+	"login_frequency_7d",
+	"login_frequency_30d",
+	"freq_change_7d_vs_mean",
+	"logins_7d_over_30d_ratio",
+	"avg_login_interval_30d",
+	"std_login_interval_30d",
+	"var_login_interval_30d",
+	"ewm_login_interval_7d",
+	"burstiness_login_interval",
+	"fano_factor_login_interval",
+	"zscore_avg_login_interval_7d",
+	"is_new_device",
+	"last_login_distance_km",
+	"last_login_speed_kmh",
+	"is_new_country_30d",
+	"is_new_city_30d",
+	"distinct_locations_30d",
+}
+
 func ComputeFeatures(feats *ModelFeatures, sessions []*LoginSession, transdate time.Time) *ModelFeatures {
 	prev := make([]*LoginSession, 0)
 	for _, s := range sessions {
@@ -23,11 +52,30 @@ func ComputeFeatures(feats *ModelFeatures, sessions []*LoginSession, transdate t
 	feats.LastPhoneModelCategorical = last.PhoneModel
 	feats.LastOSCategorical = last.OS
 
+	// The last login's device is new if no earlier session was made from it.
+	// Sessions without a device ID (old clients) are never flagged.
+	if last.DeviceID != "" {
+		feats.IsNewDevice = 1
+		for _, s := range prev[:len(prev)-1] {
+			if s.DeviceID == last.DeviceID {
+				feats.IsNewDevice = 0
+				break
+			}
+		}
+	}
+
 	cutoff7 := transdate.Add(-7 * 24 * time.Hour)
 	cutoff30 := transdate.Add(-30 * 24 * time.Hour)
+
+	if len(prev) >= 2 {
+		setTravelFeatures(feats, prev[len(prev)-2], last)
+	}
+
 	var logins7, logins30 int
 	phoneModels30 := make(map[string]struct{})
 	os30 := make(map[string]struct{})
+	countries30 := make(countSet)
+	places30 := make(countSet)
 
 	times30 := make([]time.Time, 0)
 	for _, s := range prev {
@@ -36,6 +84,10 @@ func ComputeFeatures(feats *ModelFeatures, sessions []*LoginSession, transdate t
 			phoneModels30[s.PhoneModel] = struct{}{}
 			os30[s.OS] = struct{}{}
 			times30 = append(times30, s.When)
+			if s.Country != "" {
+				countries30[s.Country]++
+				places30[placeKey(s.Country, s.City)]++
+			}
 		}
 		if s.When.After(cutoff7) || s.When.Equal(cutoff7) {
 			logins7++
@@ -44,6 +96,36 @@ func ComputeFeatures(feats *ModelFeatures, sessions []*LoginSession, transdate t
 
 	feats.MonthlyPhoneModelChanges = len(phoneModels30)
 	feats.MonthlyOSChanges = len(os30)
+	setLoginCountFeatures(feats, logins7, logins30)
+	setPlaceFeatures(feats, last, !last.When.Before(cutoff30), countries30, places30)
+
+	if len(times30) >= 2 {
+		sort.Slice(times30, func(i, j int) bool { return times30[i].Before(times30[j]) })
+		intervals := make([]float64, 0, len(times30)-1)
+		for i := 1; i < len(times30); i++ {
+			intervals = append(intervals, times30[i].Sub(times30[i-1]).Seconds())
+		}
+
+		sum := 0.0
+		for _, v := range intervals {
+			sum += v
+		}
+		mean := sum / float64(len(intervals))
+
+		varSum := 0.0
+		for _, v := range intervals {
+			varSum += (v - mean) * (v - mean)
+		}
+		variance := varSum / float64(len(intervals))
+
+		setIntervalFeatures(feats, mean, variance)
+		setRecentIntervalFeatures(feats, times30, cutoff7, mean, math.Sqrt(variance))
+	}
+
+	return feats
+}
+
+func setLoginCountFeatures(feats *ModelFeatures, logins7, logins30 int) {
 	feats.LoginsLast7Days = logins7
 	feats.LoginsLast30Days = logins30
 
@@ -61,77 +143,122 @@ func ComputeFeatures(feats *ModelFeatures, sessions []*LoginSession, transdate t
 	if logins30 != 0 {
 		feats.Logins7dOver30dRatio = float64(logins7) / float64(logins30)
 	}
+}
 
-	if len(times30) >= 2 {
-		sort.Slice(times30, func(i, j int) bool { return times30[i].Before(times30[j]) })
-		intervals := make([]float64, 0, len(times30)-1)
-		for i := 1; i < len(times30); i++ {
-			intervals = append(intervals, times30[i].Sub(times30[i-1]).Seconds())
-		}
+// setIntervalFeatures fills the statistics of the login intervals of the
+// last 30 days, given their mean and (population) variance.
+func setIntervalFeatures(feats *ModelFeatures, mean, variance float64) {
+	std := math.Sqrt(variance)
+	feats.AvgLoginInterval30d = mean
+	feats.StdLoginInterval30d = std
+	feats.VarLoginInterval30d = variance
 
-		sum := 0.0
-		for _, v := range intervals {
-			sum += v
-		}
-		mean := sum / float64(len(intervals))
+	if mean+std != 0 {
+		feats.BurstinessLoginInterval = (std - mean) / (std + mean)
+	}
 
-		varSum := 0.0
-		for _, v := range intervals {
-			varSum += (v - mean) * (v - mean)
-		}
-		variance := varSum / float64(len(intervals))
-		std := math.Sqrt(variance)
-		feats.AvgLoginInterval30d = mean
-		feats.StdLoginInterval30d = std
-		feats.VarLoginInterval30d = variance
-
-		var ewm float64
-		alpha := 0.3
-		count := 0
-		for i := 1; i < len(times30); i++ {
-			if times30[i].After(cutoff7) || times30[i].Equal(cutoff7) {
-				iv := times30[i].Sub(times30[i-1]).Seconds()
-				if count == 0 {
-					ewm = iv
-				} else {
-					ewm = alpha*iv + (1-alpha)*ewm
-				}
-				count++
+	if mean != 0 {
+		feats.FanoFactorLoginInterval = variance / mean
+	}
+}
+
+// setRecentIntervalFeatures fills the features of the last 7 days' login
+// intervals. times must be sorted and lie within the 30 day window; only
+// the logins after cutoff7 and the one right before them are used.
+func setRecentIntervalFeatures(feats *ModelFeatures, times []time.Time, cutoff7 time.Time, mean, std float64) {
+	var ewm float64
+	alpha := 0.3
+	count := 0
+	for i := 1; i < len(times); i++ {
+		if times[i].After(cutoff7) || times[i].Equal(cutoff7) {
+			iv := times[i].Sub(times[i-1]).Seconds()
+			if count == 0 {
+				ewm = iv
+			} else {
+				ewm = alpha*iv + (1-alpha)*ewm
 			}
+			count++
 		}
-		if count > 0 {
-			feats.EwmLoginInterval7d = ewm
-		}
+	}
+	if count > 0 {
+		feats.EwmLoginInterval7d = ewm
+	}
 
-		if mean+std != 0 {
-			feats.BurstinessLoginInterval = (std - mean) / (std + mean)
+	times7 := make([]time.Time, 0)
+	for _, t := range times {
+		if t.After(cutoff7) || t.Equal(cutoff7) {
+			times7 = append(times7, t)
 		}
-
-		if mean != 0 {
-			feats.FanoFactorLoginInterval = variance / mean
+	}
+	if len(times7) >= 2 {
+		ints7 := make([]float64, 0)
+		for i := 1; i < len(times7); i++ {
+			ints7 = append(ints7, times7[i].Sub(times7[i-1]).Seconds())
 		}
-
-		times7 := make([]time.Time, 0)
-		for _, t := range times30 {
-			if t.After(transdate.Add(-7*24*time.Hour)) || t.Equal(transdate.Add(-7*24*time.Hour)) {
-				times7 = append(times7, t)
-			}
+		sum7 := 0.0
+		for _, v := range ints7 {
+			sum7 += v
 		}
-		if len(times7) >= 2 {
-			ints7 := make([]float64, 0)
-			for i := 1; i < len(times7); i++ {
-				ints7 = append(ints7, times7[i].Sub(times7[i-1]).Seconds())
-			}
-			sum7 := 0.0
-			for _, v := range ints7 {
-				sum7 += v
-			}
-			mean7 := sum7 / float64(len(ints7))
-			if std != 0 {
-				feats.ZscoreAvgLoginInterval7d = (mean7 - mean) / std
-			}
+		mean7 := sum7 / float64(len(ints7))
+		if std != 0 {
+			feats.ZscoreAvgLoginInterval7d = (mean7 - mean) / std
 		}
 	}
+}
 
-	return feats
+const earthRadiusKm = 6371.0
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// minTravelInterval bounds the implied speed of two logins made at (almost)
+// the same moment from different places.
+const minTravelInterval = time.Minute
+
+// setTravelFeatures fills the distance and implied speed between the last
+// two logins, when both were geolocated.
+func setTravelFeatures(feats *ModelFeatures, before, last *LoginSession) {
+	if last.Latitude == nil || last.Longitude == nil || before.Latitude == nil || before.Longitude == nil {
+		return
+	}
+
+	dist := haversineKm(*before.Latitude, *before.Longitude, *last.Latitude, *last.Longitude)
+	dt := last.When.Sub(before.When)
+	if dt < minTravelInterval {
+		dt = minTravelInterval
+	}
+	feats.LastLoginDistanceKm = dist
+	feats.LastLoginSpeedKmh = dist / dt.Hours()
+}
+
+func placeKey(country, city string) string {
+	return country + "|" + city
+}
+
+// setPlaceFeatures fills the location novelty features of the last login.
+// countries and places count the geolocated logins of the last 30 days,
+// including the last one when lastInWindow is set.
+func setPlaceFeatures(feats *ModelFeatures, last *LoginSession, lastInWindow bool, countries, places countSet) {
+	feats.DistinctLocations30d = places.distinct()
+	if last.Country == "" {
+		return
+	}
+
+	self := 0
+	if lastInWindow {
+		self = 1
+	}
+	if countries[last.Country]-self <= 0 {
+		feats.IsNewCountry30d = 1
+	}
+	if last.City != "" && places[placeKey(last.Country, last.City)]-self <= 0 {
+		feats.IsNewCity30d = 1
+	}
 }
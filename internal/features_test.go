@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// featureFixture is a golden file under testdata/features. Only non-zero
+// features are listed in Expected, unless Features is set: fixtures
+// generated by featureparity from a reference export only compare the
+// features it has, listed in Features.
+type featureFixture struct {
+	Description string          `json:"description"`
+	Transdate   time.Time       `json:"transdate"`
+	Features    []string        `json:"features"`
+	Sessions    []*LoginSession `json:"sessions"`
+	Expected    json.RawMessage `json:"expected"`
+}
+
+func TestComputeFeaturesGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "features", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fx featureFixture
+			if err := json.Unmarshal(b, &fx); err != nil {
+				t.Fatal(err)
+			}
+
+			got := ComputeFeatures(&ModelFeatures{}, fx.Sessions, fx.Transdate)
+			want := &ModelFeatures{}
+			if fx.Expected != nil {
+				if err := json.Unmarshal(fx.Expected, want); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			diffs, err := DiffFeatures(got, want, 1e-9)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range diffs {
+				if fx.Features != nil && !slices.Contains(fx.Features, d.Feature) {
+					continue
+				}
+				t.Errorf("%s: got %v, want %v", d.Feature, d.Got, d.Want)
+			}
+		})
+	}
+}
+
+var (
+	testPhoneModels = []string{"iPhone 14 Pro", "Pixel 7", "Galaxy S23", ""}
+	testOSes        = []string{"iOS 17.1", "Android 13", "Android 14", ""}
+	testDevices     = []string{"", "dev-a", "dev-b", "dev-c"}
+	testPlaces      = []struct {
+		country, city string
+		lat, lon      float64
+	}{
+		{"KZ", "Almaty", 43.2389, 76.8897},
+		{"KZ", "Astana", 51.1694, 71.4491},
+		{"RU", "Moscow", 55.7558, 37.6173},
+		{"KZ", "", 48.0196, 66.9237},
+	}
+)
+
+// randomSessions generates logins spread over the 45 days before transdate
+// with distinct timestamps. Ties are avoided on purpose: the "last" login
+// of two simultaneous ones is not defined.
+func randomSessions(rng *rand.Rand, transdate time.Time) []*LoginSession {
+	n := rng.Intn(40)
+	used := make(map[int64]struct{})
+	out := make([]*LoginSession, 0, n)
+	for len(out) < n {
+		var offset time.Duration
+		switch rng.Intn(4) {
+		case 0:
+			// bursts of logins seconds apart
+			offset = time.Duration(rng.Int63n(600)) * time.Second
+		case 1:
+			// around the 7 and 30 day cutoffs
+			edge := []time.Duration{7 * 24 * time.Hour, 30 * 24 * time.Hour}[rng.Intn(2)]
+			offset = edge + time.Duration(rng.Int63n(5)-2)*time.Second
+		default:
+			offset = time.Duration(rng.Int63n(int64(45*24*time.Hour/time.Second))) * time.Second
+		}
+		when := transdate.Add(-offset)
+		if _, dup := used[when.Unix()]; dup {
+			continue
+		}
+		used[when.Unix()] = struct{}{}
+
+		s := &LoginSession{
+			When:       when,
+			PhoneModel: testPhoneModels[rng.Intn(len(testPhoneModels))],
+			OS:         testOSes[rng.Intn(len(testOSes))],
+			DeviceID:   testDevices[rng.Intn(len(testDevices))],
+		}
+		if rng.Intn(3) > 0 {
+			p := testPlaces[rng.Intn(len(testPlaces))]
+			lat, lon := p.lat, p.lon
+			s.Country, s.City = p.country, p.city
+			s.Latitude, s.Longitude = &lat, &lon
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+const propertyRuns = 500
+
+func TestComputeFeaturesOrderInvariant(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	transdate := time.Date(2025, 11, 24, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < propertyRuns; i++ {
+		sessions := randomSessions(rng, transdate)
+		want := ComputeFeatures(&ModelFeatures{}, sessions, transdate)
+
+		shuffled := append([]*LoginSession{}, sessions...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		got := ComputeFeatures(&ModelFeatures{}, shuffled, transdate)
+
+		diffs, err := DiffFeatures(got, want, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) > 0 {
+			t.Fatalf("run %d: shuffling %d sessions changed features: %+v", i, len(sessions), diffs)
+		}
+	}
+}
+
+func TestComputeFeaturesIgnoresFutureSessions(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	transdate := time.Date(2025, 11, 24, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < propertyRuns; i++ {
+		sessions := randomSessions(rng, transdate)
+		want := ComputeFeatures(&ModelFeatures{}, sessions, transdate)
+
+		withFuture := append([]*LoginSession{}, sessions...)
+		for j := rng.Intn(5) + 1; j > 0; j-- {
+			withFuture = append(withFuture, &LoginSession{
+				When:       transdate.Add(time.Duration(rng.Int63n(int64(48*time.Hour))) + time.Nanosecond),
+				PhoneModel: "Future Phone",
+				OS:         "FutureOS",
+				DeviceID:   "dev-future",
+			})
+		}
+		got := ComputeFeatures(&ModelFeatures{}, withFuture, transdate)
+
+		diffs, err := DiffFeatures(got, want, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) > 0 {
+			t.Fatalf("run %d: sessions after transdate changed features: %+v", i, diffs)
+		}
+	}
+}
+
+func TestComputeFeaturesFinite(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	transdate := time.Date(2025, 11, 24, 12, 0, 0, 0, time.UTC)
+
+	check := func(t *testing.T, sessions []*LoginSession) {
+		t.Helper()
+		m, err := featureMap(ComputeFeatures(&ModelFeatures{}, sessions, transdate))
+		if err != nil {
+			// encoding/json refuses NaN and Inf
+			t.Fatal(err)
+		}
+		for k, v := range m {
+			if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+				t.Fatalf("%s = %v", k, f)
+			}
+		}
+	}
+
+	for i := 0; i < propertyRuns; i++ {
+		check(t, randomSessions(rng, transdate))
+	}
+
+	// degenerate histories: simultaneous logins and a login at transdate
+	same := make([]*LoginSession, 0)
+	for i := 0; i < 5; i++ {
+		same = append(same, &LoginSession{When: transdate.Add(-time.Hour), PhoneModel: "Pixel 7", OS: "Android 13"})
+	}
+	check(t, same)
+	check(t, append(same, &LoginSession{When: transdate}))
+}
+
+func TestLoginFeaturesRequestDevice(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.conn.Exec(`INSERT INTO users (id, first_name, last_name, status) VALUES ('trent', 'T', 'R', 'active')`); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, device := range []string{"phone", "phone", "tablet"} {
+		s := &LoginSession{UserID: "trent", When: now.Add(time.Duration(i-3) * time.Hour), PhoneModel: "Pixel", OS: "Android", DeviceID: device}
+		if err := db.RecordLogin(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the last login is from the tablet, the transfer from the phone
+	for device, want := range map[string]int{"phone": 0, "tablet": 1, "": 1} {
+		feats := &ModelFeatures{}
+		if err := db.LoginFeatures(feats, "trent", device, now); err != nil {
+			t.Fatal(err)
+		}
+		if feats.IsNewDevice != want {
+			t.Errorf("device %q: got is_new_device %d, want %d", device, feats.IsNewDevice, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+)
+
+type ipRange struct {
+	start, end net.IP
+	loc        Location
+}
+
+type CSVLocator struct {
+	ranges []ipRange
+}
+
+// OpenCSV loads a CSV file with the header
+//
+//	network,country,city,latitude,longitude
+//
+// where network is a CIDR (e.g. 5.34.0.0/16). Networks must not overlap.
+// The city and coordinates may be empty.
+func OpenCSV(path string) (*CSVLocator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCSV(f)
+}
+
+func ReadCSV(r io.Reader) (*CSVLocator, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 5
+
+	if _, err := cr.Read(); err != nil {
+		return nil, fmt.Errorf("geo csv: read header: %w", err)
+	}
+
+	l := &CSVLocator{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geo csv: %w", err)
+		}
+
+		_, network, err := net.ParseCIDR(rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("geo csv: %w", err)
+		}
+		loc := Location{Country: rec[1], City: rec[2]}
+		if rec[3] != "" || rec[4] != "" {
+			lat, err := strconv.ParseFloat(rec[3], 64)
+			if err != nil {
+				return nil, fmt.Errorf("geo csv: latitude of %s: %w", rec[0], err)
+			}
+			lon, err := strconv.ParseFloat(rec[4], 64)
+			if err != nil {
+				return nil, fmt.Errorf("geo csv: longitude of %s: %w", rec[0], err)
+			}
+			loc.Latitude, loc.Longitude = &lat, &lon
+		}
+
+		start := network.IP.To16()
+		end := make(net.IP, len(start))
+		mask := network.Mask
+		if len(mask) == net.IPv4len {
+			// match the ::ffff:a.b.c.d form returned by To16
+			mask = append(net.IPMask(bytes.Repeat([]byte{0xff}, net.IPv6len-net.IPv4len)), mask...)
+		}
+		for i := range start {
+			end[i] = start[i] | ^mask[i]
+		}
+
+		l.ranges = append(l.ranges, ipRange{
+			start: start,
+			end:   end,
+			loc:   loc,
+		})
+	}
+
+	sort.Slice(l.ranges, func(i, j int) bool { return bytes.Compare(l.ranges[i].start, l.ranges[j].start) < 0 })
+	return l, nil
+}
+
+func (l *CSVLocator) Lookup(ip net.IP) (*Location, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return nil, false
+	}
+
+	// first range starting after ip, the candidate is the one before it
+	i := sort.Search(len(l.ranges), func(i int) bool { return bytes.Compare(l.ranges[i].start, ip) > 0 })
+	if i == 0 {
+		return nil, false
+	}
+	r := l.ranges[i-1]
+	if bytes.Compare(ip, r.end) > 0 {
+		return nil, false
+	}
+	loc := r.loc
+	return &loc, true
+}
@@ -0,0 +1,75 @@
+package geo
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testCSV = `network,country,city,latitude,longitude
+5.34.0.0/16,KZ,Almaty,43.2389,76.8897
+5.36.0.0/24,KZ,,,
+2a00:1450::/32,US,Mountain View,37.386,-122.0838
+91.0.0.0/8,RU,Moscow,55.7558,37.6173
+`
+
+func TestCSVLocator(t *testing.T) {
+	l, err := ReadCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip      string
+		country string
+		city    string
+		coords  bool
+	}{
+		{"5.34.0.0", "KZ", "Almaty", true},
+		{"5.34.255.255", "KZ", "Almaty", true},
+		{"::ffff:5.34.1.2", "KZ", "Almaty", true},
+		{"5.36.0.7", "KZ", "", false},
+		{"91.200.1.1", "RU", "Moscow", true},
+		{"2a00:1450:4001::1", "US", "Mountain View", true},
+		// gaps, and before the first and after the last network
+		{"5.35.0.1", "", "", false},
+		{"1.1.1.1", "", "", false},
+		{"203.0.113.9", "", "", false},
+		{"2a01::1", "", "", false},
+	}
+	for _, c := range cases {
+		loc, ok := l.Lookup(net.ParseIP(c.ip))
+		if ok != (c.country != "") {
+			t.Errorf("%s: found %t", c.ip, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if loc.Country != c.country || loc.City != c.city || (loc.Latitude != nil) != c.coords || (loc.Longitude != nil) != c.coords {
+			t.Errorf("%s: got %+v", c.ip, loc)
+		}
+	}
+
+	loc, _ := l.Lookup(net.ParseIP("5.34.1.1"))
+	if *loc.Latitude != 43.2389 || *loc.Longitude != 76.8897 {
+		t.Errorf("got %v, %v", *loc.Latitude, *loc.Longitude)
+	}
+	if _, ok := l.Lookup(nil); ok {
+		t.Error("nil IP found")
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for name, csv := range map[string]string{
+		"empty":     ``,
+		"network":   "network,country,city,latitude,longitude\n5.34.0.0,KZ,Almaty,43.2,76.8\n",
+		"latitude":  "network,country,city,latitude,longitude\n5.34.0.0/16,KZ,Almaty,north,76.8\n",
+		"longitude": "network,country,city,latitude,longitude\n5.34.0.0/16,KZ,Almaty,43.2,\n",
+		"columns":   "network,country,city,latitude,longitude\n5.34.0.0/16,KZ,Almaty\n",
+	} {
+		if _, err := ReadCSV(strings.NewReader(csv)); err == nil {
+			t.Errorf("%s: read", name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package geo
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// Location is what is known of where an IP is: the country, city and
+// coordinates may each be missing (empty or nil).
+type Location struct {
+	Country   string
+	City      string
+	Latitude  *float64
+	Longitude *float64
+}
+
+// Locator resolves an IP address to an approximate location. Lookups are
+// offline, so implementations must be safe for concurrent use.
+type Locator interface {
+	Lookup(ip net.IP) (*Location, bool)
+}
+
+type nopLocator struct{}
+
+func (nopLocator) Lookup(net.IP) (*Location, bool) { return nil, false }
+
+// Nop is used when no geo database is configured.
+var Nop Locator = nopLocator{}
+
+// Open picks an implementation by file extension: .mmdb files are read as
+// MaxMind databases, everything else as CSV (see OpenCSV).
+func Open(path string) (Locator, error) {
+	if path == "" {
+		return Nop, nil
+	}
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		return OpenMMDB(path)
+	}
+	return OpenCSV(path)
+}
@@ -0,0 +1,58 @@
+package geo
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type MMDBLocator struct {
+	reader *maxminddb.Reader
+}
+
+// OpenMMDB opens a MaxMind City database (GeoLite2-City or compatible).
+func OpenMMDB(path string) (*MMDBLocator, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MMDBLocator{reader: r}, nil
+}
+
+func (l *MMDBLocator) Close() error {
+	return l.reader.Close()
+}
+
+type mmdbCity struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+func (l *MMDBLocator) Lookup(ip net.IP) (*Location, bool) {
+	var rec mmdbCity
+	if err := l.reader.Lookup(ip, &rec); err != nil {
+		return nil, false
+	}
+	return rec.location()
+}
+
+// location keeps whatever the record has: many networks are only known
+// down to the country, some without coordinates.
+func (rec *mmdbCity) location() (*Location, bool) {
+	loc := &Location{Country: rec.Country.ISOCode, City: rec.City.Names["en"]}
+	if rec.Location.Latitude != nil && rec.Location.Longitude != nil {
+		loc.Latitude, loc.Longitude = rec.Location.Latitude, rec.Location.Longitude
+	}
+	if loc.Country == "" && loc.Latitude == nil {
+		return nil, false
+	}
+	return loc, true
+}
@@ -0,0 +1,26 @@
+package geo
+
+import "testing"
+
+func TestMMDBLocation(t *testing.T) {
+	lat, lon := 48.8566, 2.3522
+	var full, countryOnly, coordsOnly, empty mmdbCity
+	full.Country.ISOCode = "FR"
+	full.City.Names = map[string]string{"en": "Paris", "fr": "Paris"}
+	full.Location.Latitude, full.Location.Longitude = &lat, &lon
+	countryOnly.Country.ISOCode = "FR"
+	coordsOnly.Location.Latitude, coordsOnly.Location.Longitude = &lat, &lon
+
+	if loc, ok := full.location(); !ok || loc.Country != "FR" || loc.City != "Paris" || *loc.Latitude != lat || *loc.Longitude != lon {
+		t.Errorf("full: got %+v", loc)
+	}
+	if loc, ok := countryOnly.location(); !ok || loc.Country != "FR" || loc.City != "" || loc.Latitude != nil || loc.Longitude != nil {
+		t.Errorf("country only: got %+v, %t", loc, ok)
+	}
+	if loc, ok := coordsOnly.location(); !ok || loc.Country != "" || *loc.Latitude != lat {
+		t.Errorf("coordinates only: got %+v, %t", loc, ok)
+	}
+	if _, ok := empty.location(); ok {
+		t.Error("empty record found")
+	}
+}
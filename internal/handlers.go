@@ -1,8 +1,14 @@
 package internal
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"antifraud-demo-backend/internal/auth"
@@ -42,20 +48,54 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	pm := r.Header.Get("X-Phone-Model")
 	os := r.Header.Get("X-OS")
+	deviceID := r.Header.Get("X-Device-ID")
+	from := ClientContextFromRequest(r)
+	now := time.Now().UTC()
+
+	if deviceID != "" {
+		dev, err := dbClient.GetDevice(user.ID, deviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get device"})
+			return
+		}
+		if dev != nil && dev.Status() == DeviceRevoked {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "device revoked"})
+			return
+		}
+
+		dev = &Device{
+			UserID:     user.ID,
+			DeviceID:   deviceID,
+			Name:       r.Header.Get("X-Device-Name"),
+			PhoneModel: pm,
+			OS:         os,
+			LastSeen:   now,
+		}
+		if err := dbClient.UpsertDevice(dev, from); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to save device"})
+			return
+		}
+	}
 
 	sess := &LoginSession{
 		UserID:     user.ID,
-		When:       time.Now().UTC(),
+		When:       now,
 		PhoneModel: pm,
 		OS:         os,
+		DeviceID:   deviceID,
+
+		ClientContext: from,
 	}
-	if err := dbClient.SaveSession(sess); err != nil {
+	if err := dbClient.RecordLogin(sess); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to save session"})
 		return
 	}
 
-	tok, _ := auth.GenerateToken(user.ID, pm, os)
+	tok, _ := auth.GenerateToken(user.ID, pm, os, deviceID)
 	_ = json.NewEncoder(w).Encode(LoginResponse{
 		Token:       tok,
 		IsSuperuser: false,
@@ -113,12 +153,46 @@ func GetUsersMeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_ = json.NewEncoder(w).Encode(UserDTO{
-		ID:        user.ID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Status:    string(user.Status),
-	})
+	limits, usage, err := dbClient.GetUserLimits(user.ID, time.Now().UTC())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get limits"})
+		return
+	}
+	limitsDTO := userLimitsToDTO(limits, usage)
+
+	dto := userToDTO(user)
+	dto.Limits = &limitsDTO
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+func userToDTO(u *User) UserDTO {
+	return UserDTO{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Status:    string(u.Status),
+		Segment:   u.Segment,
+	}
+}
+
+func userLimitsToDTO(l Limits, u LimitUsage) UserLimitsDTO {
+	limit := func(limit, used float64) *LimitDTO {
+		if limit == 0 {
+			return nil
+		}
+		return &LimitDTO{Limit: limit, Used: used, Remaining: max(limit-used, 0)}
+	}
+
+	var dto UserLimitsDTO
+	if l.MaxAmount > 0 {
+		dto.MaxAmount = &l.MaxAmount
+	}
+	dto.DailyAmount = limit(l.DailyAmount, u.DailyAmount)
+	dto.MonthlyAmount = limit(l.MonthlyAmount, u.MonthlyAmount)
+	dto.HourlyTransfers = limit(float64(l.HourlyTransfers), float64(u.HourlyTransfers))
+	dto.DailyNewRecipients = limit(float64(l.DailyNewRecipients), float64(u.DailyNewRecipients))
+	return dto
 }
 
 func ListCardsHandler(w http.ResponseWriter, r *http.Request) {
@@ -140,18 +214,22 @@ func ListCardsHandler(w http.ResponseWriter, r *http.Request) {
 
 	cardDTOs := make([]CardDTO, len(cards))
 	for i, card := range cards {
-		cardDTOs[i] = CardDTO{
-			ID:      card.ID.String(),
-			UserID:  card.UserID,
-			Number:  card.Number,
-			Balance: card.Balance,
-			Status:  string(card.Status),
-		}
+		cardDTOs[i] = cardToDTO(card)
 	}
 
 	_ = json.NewEncoder(w).Encode(CardListResponse{Cards: cardDTOs})
 }
 
+func cardToDTO(c *Card) CardDTO {
+	return CardDTO{
+		ID:      c.ID.String(),
+		UserID:  c.UserID,
+		Number:  c.Number,
+		Balance: c.Balance,
+		Status:  string(c.Status),
+	}
+}
+
 func GetCardByNumberHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -192,58 +270,344 @@ func DoTransferHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
 		return
 	}
-
-	sessions, err := dbClient.ListSessionsForUser(claims.UserId)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get sessions"})
+	if req.Amount <= 0 || math.IsNaN(req.Amount) || math.IsInf(req.Amount, 0) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "amount must be positive"})
 		return
 	}
 
-	feats := &ModelFeatures{
-		// CstDimID: claims.UserId,
-		Amount:   req.Amount,
-		// TODO: it's not encrypted
-		Direction: req.ToCardID,
+	fromCardID, err := uuid.Parse(req.FromCardID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid from_card_id"})
+		return
 	}
-	ComputeFeatures(feats, sessions, time.Now().UTC())
-
-	pr, err := PredictFraud(feats)
+	toCardID, err := uuid.Parse(req.ToCardID)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to call predictor"})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid to_card_id"})
 		return
 	}
 
-	fromCardID, err := uuid.Parse(req.FromCardID)
-	if err != nil {
+	fromCard, err := dbClient.GetCardByID(fromCardID)
+	if err != nil || fromCard.UserID != claims.UserId {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid from_card_id"})
 		return
 	}
-	toCardID, err := uuid.Parse(req.ToCardID)
+	toCard, err := dbClient.GetCardByID(toCardID)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid to_card_id"})
 		return
 	}
 
+	if claims.DeviceID != "" {
+		dev, err := dbClient.GetDevice(claims.UserId, claims.DeviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get device"})
+			return
+		}
+		if dev != nil && dev.Status() == DeviceRevoked {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "device revoked"})
+			return
+		}
+	}
+
+	// scored and settled by a transfer worker, see processTransferJob
 	t := &Transfer{
 		FromUserID: claims.UserId,
 		FromCardID: fromCardID,
-		ToCardID:   toCardID,
+		ToCardID:   toCard.ID,
 		Amount:     req.Amount,
 		When:       time.Now().UTC(),
-		FraudScore: pr.FraudProbability,
-		IsBlocked:  pr.BlockTransaction,
+		Status:     TransferPending,
+
+		DeviceID: claims.DeviceID,
+
+		ClientContext: ClientContextFromRequest(r),
 	}
-	if err := dbClient.SaveTransfer(t); err != nil {
+	if err := dbClient.EnqueueTransfer(t); err != nil {
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: limitErr.Error()})
+			return
+		}
+		if errors.Is(err, ErrInvalidAmount) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to persist transfer"})
 		return
 	}
+	notifyTransferWorkers()
+
+	w.Header().Set("Location", "/transfers/"+t.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(transferToDTO(t))
+}
+
+// GetUserTransferHandler returns one of the user's transfers, for polling a
+// pending transfer until it is settled.
+func GetUserTransferHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid transfer id"})
+		return
+	}
+
+	t, err := dbClient.GetTransferForUser(claims.UserId, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "transfer not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get transfer"})
+		return
+	}
+
+	dto := transferToDTO(t)
+	if t.Status == TransferChallengeRequired {
+		c, err := dbClient.GetChallengeForTransfer(t.ID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get transfer"})
+			return
+		}
+		dto.ChallengeID = c.ID.String()
+		dto.ChallengeExpiresAt = c.ExpiresAt.Format(time.RFC3339)
+	}
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+func ConfirmTransferHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid transfer id"})
+		return
+	}
+
+	var req ConfirmTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	t, outcome, err := dbClient.ConfirmChallenge(claims.UserId, id, req.Code, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "transfer not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to confirm transfer"})
+		return
+	}
+
+	switch outcome {
+	case ChallengeInvalidCode:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid code"})
+		return
+	case ChallengeFailed:
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "too many failed attempts"})
+		return
+	case ChallengeExpired:
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "challenge expired"})
+		return
+	case ChallengeNotPending:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "transfer is not awaiting confirmation"})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(transferToDTO(t))
+}
+
+func ListTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	list, err := dbClient.ListTransfersForUser(claims.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list transfers"})
+		return
+	}
 
-	_ = json.NewEncoder(w).Encode(TransferDTO{
+	transferDTOs := make([]TransferDTO, len(list))
+	for i, t := range list {
+		transferDTOs[i] = transferToDTO(t)
+	}
+
+	_ = json.NewEncoder(w).Encode(TransferListResponse{Transfers: transferDTOs})
+}
+
+// NotificationStreamHandler streams the user's events as server-sent events:
+// status changes of their transfers and security events. A client
+// reconnecting with Last-Event-ID gets the events it missed. The stream is
+// closed, after a "close" event, when the token expires or its device is
+// revoked.
+func NotificationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	streamNotifications(w, r, dbClient)
+}
+
+func streamNotifications(w http.ResponseWriter, r *http.Request, store userEventStore) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	if claims.DeviceID != "" {
+		dev, err := store.GetDevice(claims.UserId, claims.DeviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get device"})
+			return
+		}
+		if dev != nil && dev.Status() == DeviceRevoked {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "device revoked"})
+			return
+		}
+	}
+
+	// watch before reading the last id, so that nothing falls in between
+	wakeup, stop := userEvents.Watch(claims.UserId)
+	defer stop()
+
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid Last-Event-ID"})
+			return
+		}
+		lastID = id
+	} else {
+		id, err := store.LastEventID(claims.UserId)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get events"})
+			return
+		}
+		lastID = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	closeStream := func(reason string) {
+		b, _ := json.Marshal(NotificationCloseDTO{Reason: reason})
+		if writeSSE(w, "", "close", b) == nil {
+			rc.Flush()
+		}
+	}
+
+	expires := time.NewTimer(time.Until(time.Unix(claims.ExpiresAt, 0)))
+	defer expires.Stop()
+	poll := time.NewTicker(notificationPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(notificationHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for {
+			events, err := store.ListEventsForKey(claims.UserId, lastID, notificationBatchSize)
+			if err != nil {
+				// the next poll tries again
+				log.Printf("notifications for %s: failed to list events: %v", claims.UserId, err)
+				break
+			}
+			for _, e := range events {
+				lastID = e.ID
+				if !userEventTypes[e.Type] {
+					continue
+				}
+				if err := writeSSE(w, strconv.FormatInt(e.ID, 10), e.Type, e.Payload); err != nil {
+					return
+				}
+				if e.Type == EventDeviceRevoked && claims.DeviceID != "" {
+					var dev DeviceEventDTO
+					if json.Unmarshal(e.Payload, &dev) == nil && dev.Device.DeviceID == claims.DeviceID {
+						closeStream("device_revoked")
+						return
+					}
+				}
+			}
+			if len(events) < notificationBatchSize {
+				break
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expires.C:
+			closeStream("token_expired")
+			return
+		case <-wakeup:
+		case <-poll.C:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// transferToDTO converts t for its sender. Reason codes are only given for
+// blocked and challenged transfers.
+func transferToDTO(t *Transfer) TransferDTO {
+	dto := TransferDTO{
 		ID:         t.ID.String(),
 		FromUserID: t.FromUserID,
 		FromCardID: t.FromCardID.String(),
@@ -252,10 +616,42 @@ func DoTransferHandler(w http.ResponseWriter, r *http.Request) {
 		When:       t.When.Format(time.RFC3339),
 		FraudScore: t.FraudScore,
 		IsBlocked:  t.IsBlocked,
-	})
+		Status:     t.Status,
+
+		IsNewDevice: t.IsNewDevice,
+	}
+	if t.IsBlocked || t.Status == TransferChallengeRequired {
+		dto.ReasonCodes = t.Explanation.ReasonCodes()
+	}
+	return dto
 }
 
-func ListTransfersHandler(w http.ResponseWriter, r *http.Request) {
+func deviceToDTO(d *Device) DeviceDTO {
+	return DeviceDTO{
+		ID:         d.ID.String(),
+		DeviceID:   d.DeviceID,
+		Name:       d.Name,
+		PhoneModel: d.PhoneModel,
+		OS:         d.OS,
+		FirstSeen:  d.FirstSeen.Format(time.RFC3339),
+		LastSeen:   d.LastSeen.Format(time.RFC3339),
+		Status:     string(d.Status()),
+	}
+}
+
+// deviceEventToDTO is the payload of a device event. c is where the login
+// came from, if the event is a login.
+func deviceEventToDTO(d *Device, c *ClientContext) DeviceEventDTO {
+	e := DeviceEventDTO{Device: deviceToDTO(d)}
+	if c != nil {
+		e.IP = c.IP
+		e.Country = c.Country
+		e.City = c.City
+	}
+	return e
+}
+
+func ListDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	claims, ok := auth.JwtClaimsFromContext(r)
@@ -265,26 +661,114 @@ func ListTransfersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	list, err := dbClient.ListTransfersForUser(claims.UserId)
+	devices, err := dbClient.ListDevicesForUser(claims.UserId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list transfers"})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list devices"})
 		return
 	}
 
-	transferDTOs := make([]TransferDTO, len(list))
-	for i, t := range list {
-		transferDTOs[i] = TransferDTO{
-			ID:         t.ID.String(),
-			FromUserID: t.FromUserID,
-			FromCardID: t.FromCardID.String(),
-			ToCardID:   t.ToCardID.String(),
-			Amount:     t.Amount,
-			When:       t.When.Format(time.RFC3339),
-			FraudScore: t.FraudScore,
-			IsBlocked:  t.IsBlocked,
+	deviceDTOs := make([]DeviceDTO, len(devices))
+	for i, d := range devices {
+		deviceDTOs[i] = deviceToDTO(d)
+	}
+
+	_ = json.NewEncoder(w).Encode(DeviceListResponse{Devices: deviceDTOs})
+}
+
+func UpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid device id"})
+		return
+	}
+
+	var req UpdateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	dev, err := dbClient.GetDeviceByID(claims.UserId, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "device not found"})
+		return
+	}
+	if dev.Status() == DeviceRevoked {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "device revoked"})
+		return
+	}
+
+	if req.Name != nil {
+		dev.Name = *req.Name
+	}
+	if req.Trusted != nil {
+		// A device cannot vouch for itself, otherwise a freshly stolen
+		// session could mark its own device as trusted.
+		if *req.Trusted && dev.DeviceID == claims.DeviceID {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "cannot trust the current device"})
+			return
 		}
+		dev.Trusted = *req.Trusted
 	}
 
-	_ = json.NewEncoder(w).Encode(TransferListResponse{Transfers: transferDTOs})
+	if err := dbClient.UpdateDevice(dev); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update device"})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(deviceToDTO(dev))
+}
+
+func RevokeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid device id"})
+		return
+	}
+
+	dev, err := dbClient.GetDeviceByID(claims.UserId, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "device not found"})
+		return
+	}
+
+	if dev.RevokedAt == nil {
+		now := time.Now().UTC()
+		dev.RevokedAt = &now
+		dev.Trusted = false
+		if err := dbClient.RevokeDevice(dev); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to revoke device"})
+			return
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(deviceToDTO(dev))
 }
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"antifraud-demo-backend/internal/auth"
+)
+
+func TestDoTransferHandlerInvalidAmount(t *testing.T) {
+	for _, amount := range []string{"0", "-100", "-0.01", "1e400"} {
+		body := `{"from_card_id": "00000000-0000-0000-0000-000000000001", "to_card_id": "00000000-0000-0000-0000-000000000002", "amount": ` + amount + `}`
+		r := httptest.NewRequest(http.MethodPost, "/transfers", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), auth.CtxKeyClaims, &auth.JwtClaims{UserId: "alice"}))
+		w := httptest.NewRecorder()
+
+		// rejected before any card is loaded
+		DoTransferHandler(w, r)
+		var resp ErrorResponse
+		if w.Code != http.StatusBadRequest || json.NewDecoder(w.Body).Decode(&resp) != nil || resp.Error == "" {
+			t.Errorf("%s: got %d %+v", amount, w.Code, resp)
+		}
+	}
+}
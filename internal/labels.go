@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Fraud labels: the outcome of a transfer as established after the fact,
+// by an analyst or a chargeback. Simulations measure policies against them.
+const (
+	LabelFraud = "fraud"
+	LabelLegit = "legit"
+)
+
+func validateTransferLabel(l *TransferLabel) error {
+	if l.Label != LabelFraud && l.Label != LabelLegit {
+		return errors.New("label must be fraud or legit")
+	}
+	return nil
+}
+
+const transferLabelColumns = `transfer_id, label, note, labeled_by, labeled_at, case_id`
+
+// SetTransferLabel labels a transfer, replacing its label if it has one,
+// and writes the stored row back into l. A label set by a case becomes the
+// analyst's, so the case no longer changes it.
+func (db *DB) SetTransferLabel(l *TransferLabel) error {
+	return db.conn.Get(l, `INSERT INTO transfer_labels (transfer_id, label, note, labeled_by)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (transfer_id) DO UPDATE SET
+			label = EXCLUDED.label,
+			note = EXCLUDED.note,
+			labeled_by = EXCLUDED.labeled_by,
+			labeled_at = now(),
+			case_id = NULL
+		RETURNING `+transferLabelColumns, l.TransferID, l.Label, l.Note, l.LabeledBy)
+}
+
+func (db *DB) GetTransferLabel(transferID uuid.UUID) (*TransferLabel, error) {
+	var l TransferLabel
+	err := db.conn.Get(&l, `SELECT `+transferLabelColumns+` FROM transfer_labels WHERE transfer_id=$1`, transferID)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// DeleteTransferLabel removes the label of a transfer, returning
+// sql.ErrNoRows if it has none.
+func (db *DB) DeleteTransferLabel(transferID uuid.UUID) error {
+	var deleted uuid.UUID
+	return db.conn.Get(&deleted, `DELETE FROM transfer_labels WHERE transfer_id=$1 RETURNING transfer_id`, transferID)
+}
@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// Limits are the spending limits of a user or a card. Zero means no limit.
+// Amounts are summed over rolling windows: the last 24 hours and the last
+// 30 days.
+type Limits struct {
+	MaxAmount          float64
+	DailyAmount        float64
+	MonthlyAmount      float64
+	HourlyTransfers    int
+	DailyNewRecipients int
+}
+
+// defaultLimits apply to every user unless overridden for their segment or
+// for them.
+var defaultLimits Limits
+
+func init() {
+	viper.AutomaticEnv()
+
+	defaultLimits = Limits{
+		MaxAmount:          viper.GetFloat64("LIMIT_MAX_AMOUNT"),
+		DailyAmount:        viper.GetFloat64("LIMIT_DAILY_AMOUNT"),
+		MonthlyAmount:      viper.GetFloat64("LIMIT_MONTHLY_AMOUNT"),
+		HourlyTransfers:    viper.GetInt("LIMIT_HOURLY_TRANSFERS"),
+		DailyNewRecipients: viper.GetInt("LIMIT_DAILY_NEW_RECIPIENTS"),
+	}
+}
+
+// Limit names, as reported when one is exceeded.
+const (
+	LimitMaxAmount          = "max_amount"
+	LimitDailyAmount        = "daily_amount"
+	LimitMonthlyAmount      = "monthly_amount"
+	LimitHourlyTransfers    = "hourly_transfers"
+	LimitDailyNewRecipients = "daily_new_recipients"
+)
+
+// ErrInvalidAmount is returned for a transfer amount that is not a positive
+// number, which would otherwise lower the usage it is added to.
+var ErrInvalidAmount = errors.New("amount must be positive")
+
+// LimitError is returned when a transfer would exceed a limit.
+type LimitError struct {
+	Limit string
+	// the card's own limit rather than the user's
+	Card bool
+}
+
+func (e *LimitError) Error() string {
+	if e.Card {
+		return "card limit exceeded: " + e.Limit
+	}
+	return "limit exceeded: " + e.Limit
+}
+
+// With returns l with the limits set in o replacing its own.
+func (l Limits) With(o *LimitOverride) Limits {
+	if o == nil {
+		return l
+	}
+	if o.MaxAmount != nil {
+		l.MaxAmount = *o.MaxAmount
+	}
+	if o.DailyAmount != nil {
+		l.DailyAmount = *o.DailyAmount
+	}
+	if o.MonthlyAmount != nil {
+		l.MonthlyAmount = *o.MonthlyAmount
+	}
+	if o.HourlyTransfers != nil {
+		l.HourlyTransfers = *o.HourlyTransfers
+	}
+	if o.DailyNewRecipients != nil {
+		l.DailyNewRecipients = *o.DailyNewRecipients
+	}
+	return l
+}
+
+// LimitUsage is what counts against the limits so far: transfers that went
+// through or may still go through.
+type LimitUsage struct {
+	DailyAmount        float64 `db:"daily_amount"`
+	MonthlyAmount      float64 `db:"monthly_amount"`
+	HourlyTransfers    int     `db:"hourly_transfers"`
+	DailyNewRecipients int     `db:"daily_new_recipients"`
+}
+
+// Check returns a *LimitError if a transfer of amount, to a recipient new
+// or not, does not fit in l given u, and ErrInvalidAmount if amount is not
+// positive.
+func (l Limits) Check(u LimitUsage, amount float64, newRecipient bool) error {
+	switch {
+	case !(amount > 0) || math.IsInf(amount, 0):
+		return ErrInvalidAmount
+	case l.MaxAmount > 0 && amount > l.MaxAmount:
+		return &LimitError{Limit: LimitMaxAmount}
+	case l.DailyAmount > 0 && u.DailyAmount+amount > l.DailyAmount:
+		return &LimitError{Limit: LimitDailyAmount}
+	case l.MonthlyAmount > 0 && u.MonthlyAmount+amount > l.MonthlyAmount:
+		return &LimitError{Limit: LimitMonthlyAmount}
+	case l.HourlyTransfers > 0 && u.HourlyTransfers+1 > l.HourlyTransfers:
+		return &LimitError{Limit: LimitHourlyTransfers}
+	case l.DailyNewRecipients > 0 && newRecipient && u.DailyNewRecipients+1 > l.DailyNewRecipients:
+		return &LimitError{Limit: LimitDailyNewRecipients}
+	}
+	return nil
+}
+
+// limitStatuses are the statuses of the transfers counted against limits.
+var limitStatuses = pq.Array([]string{TransferPending, TransferChallengeRequired, TransferCompleted})
+
+// limitsLock is the advisory lock class (two-key form) serializing the
+// transfers of a user while their limits are checked.
+const limitsLock = 0x6c696d
+
+// checkTransferLimits checks t against the limits of its sender and its
+// card, within tx. The caller holds the sender's limits lock.
+func checkTransferLimits(tx *sqlx.Tx, t *Transfer) error {
+	userLimits, cardLimits, err := loadLimits(tx, t.FromUserID, t.FromCardID)
+	if err != nil {
+		return err
+	}
+
+	scopes := []struct {
+		column string
+		id     any
+		limits Limits
+		card   bool
+	}{
+		{"from_user_id", t.FromUserID, userLimits, false},
+		{"from_card_id", t.FromCardID, cardLimits, true},
+	}
+	for _, s := range scopes {
+		if s.limits == (Limits{}) {
+			continue
+		}
+		u, err := limitUsage(tx, s.column, s.id, t.When)
+		if err != nil {
+			return err
+		}
+		var known bool
+		err = tx.Get(&known, `SELECT EXISTS (SELECT 1 FROM transfers WHERE `+s.column+`=$1 AND to_card_id=$2 AND status = ANY($3))`,
+			s.id, t.ToCardID, limitStatuses)
+		if err != nil {
+			return err
+		}
+		err = s.limits.Check(u, t.Amount, !known)
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			limitErr.Card = s.card
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLimits resolves the limits of a user (defaults, then their segment,
+// then their own) and of a card (its own only).
+func loadLimits(q sqlx.Queryer, userId string, cardId uuid.UUID) (Limits, Limits, error) {
+	overrides := make([]*LimitOverride, 0)
+	err := sqlx.Select(q, &overrides, `SELECT `+limitOverrideColumns+` FROM spending_limits
+		WHERE (scope = 'segment' AND scope_id = (SELECT segment FROM users WHERE id = $1))
+			OR (scope = 'user' AND scope_id = $1)
+			OR (scope = 'card' AND scope_id = $2)`, userId, cardId.String())
+	if err != nil {
+		return Limits{}, Limits{}, err
+	}
+
+	var segment, user, card *LimitOverride
+	for _, o := range overrides {
+		switch o.Scope {
+		case LimitScopeSegment:
+			segment = o
+		case LimitScopeUser:
+			user = o
+		case LimitScopeCard:
+			card = o
+		}
+	}
+	return defaultLimits.With(segment).With(user), Limits{}.With(card), nil
+}
+
+// limitUsage sums the transfers where column is id, as of now.
+func limitUsage(q sqlx.Queryer, column string, id any, now time.Time) (LimitUsage, error) {
+	var u LimitUsage
+	err := sqlx.Get(q, &u, `SELECT
+			COALESCE(SUM(amount) FILTER (WHERE when_ts > $2), 0) AS daily_amount,
+			COALESCE(SUM(amount), 0) AS monthly_amount,
+			COUNT(*) FILTER (WHERE when_ts > $3) AS hourly_transfers,
+			(SELECT COUNT(*) FROM (
+				SELECT to_card_id FROM transfers WHERE `+column+`=$1 AND status = ANY($5)
+				GROUP BY to_card_id HAVING MIN(when_ts) > $2
+			) r) AS daily_new_recipients
+		FROM transfers WHERE `+column+`=$1 AND when_ts > $4 AND status = ANY($5)`,
+		id, now.Add(-24*time.Hour), now.Add(-time.Hour), now.Add(-30*24*time.Hour), limitStatuses)
+	return u, err
+}
+
+// GetUserLimits returns the limits of a user and their usage as of now.
+func (db *DB) GetUserLimits(userId string, now time.Time) (Limits, LimitUsage, error) {
+	limits, _, err := loadLimits(db.conn, userId, uuid.Nil)
+	if err != nil {
+		return Limits{}, LimitUsage{}, err
+	}
+	u, err := limitUsage(db.conn, "from_user_id", userId, now)
+	return limits, u, err
+}
+
+const limitOverrideColumns = `scope, scope_id, max_amount, daily_amount, monthly_amount, hourly_transfers, daily_new_recipients, updated_at`
+
+func (db *DB) GetLimitOverride(scope, scopeId string) (*LimitOverride, error) {
+	var o LimitOverride
+	err := db.conn.Get(&o, `SELECT `+limitOverrideColumns+` FROM spending_limits WHERE scope=$1 AND scope_id=$2`, scope, scopeId)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ListLimitOverrides lists the overrides of scope, or of every scope if it
+// is empty.
+func (db *DB) ListLimitOverrides(scope string) ([]*LimitOverride, error) {
+	out := make([]*LimitOverride, 0)
+	err := db.conn.Select(&out, `SELECT `+limitOverrideColumns+` FROM spending_limits
+		WHERE $1 = '' OR scope = $1 ORDER BY scope, scope_id`, scope)
+	return out, err
+}
+
+// SetLimitOverride creates or replaces an override. The stored row is
+// written back into o.
+func (db *DB) SetLimitOverride(o *LimitOverride) error {
+	return db.conn.Get(o, `INSERT INTO spending_limits (scope, scope_id, max_amount, daily_amount, monthly_amount, hourly_transfers, daily_new_recipients)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (scope, scope_id) DO UPDATE SET
+			max_amount = EXCLUDED.max_amount,
+			daily_amount = EXCLUDED.daily_amount,
+			monthly_amount = EXCLUDED.monthly_amount,
+			hourly_transfers = EXCLUDED.hourly_transfers,
+			daily_new_recipients = EXCLUDED.daily_new_recipients,
+			updated_at = now()
+		RETURNING `+limitOverrideColumns,
+		o.Scope, o.ScopeID, o.MaxAmount, o.DailyAmount, o.MonthlyAmount, o.HourlyTransfers, o.DailyNewRecipients)
+}
+
+// DeleteLimitOverride removes an override, returning sql.ErrNoRows if there
+// is none.
+func (db *DB) DeleteLimitOverride(scope, scopeId string) error {
+	var deleted string
+	return db.conn.Get(&deleted, `DELETE FROM spending_limits WHERE scope=$1 AND scope_id=$2 RETURNING scope_id`, scope, scopeId)
+}
+
+// validateLimitOverride checks the limits set in o.
+func validateLimitOverride(o *LimitOverride) error {
+	for _, v := range []*float64{o.MaxAmount, o.DailyAmount, o.MonthlyAmount} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("amounts must not be negative")
+		}
+	}
+	for _, v := range []*int{o.HourlyTransfers, o.DailyNewRecipients} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("counts must not be negative")
+		}
+	}
+	return nil
+}
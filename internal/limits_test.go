@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	l := Limits{MaxAmount: 1000, DailyAmount: 2000, MonthlyAmount: 5000, HourlyTransfers: 3, DailyNewRecipients: 2}
+	u := LimitUsage{DailyAmount: 1500, MonthlyAmount: 4000, HourlyTransfers: 1, DailyNewRecipients: 1}
+
+	cases := []struct {
+		name         string
+		usage        LimitUsage
+		amount       float64
+		newRecipient bool
+		want         string
+	}{
+		{"fits", u, 500, true, ""},
+		{"single amount", LimitUsage{}, 1000.01, false, LimitMaxAmount},
+		{"daily", u, 501, false, LimitDailyAmount},
+		{"monthly", LimitUsage{MonthlyAmount: 4900}, 101, false, LimitMonthlyAmount},
+		{"hourly", LimitUsage{HourlyTransfers: 3}, 1, false, LimitHourlyTransfers},
+		{"new recipient", LimitUsage{DailyNewRecipients: 2}, 1, true, LimitDailyNewRecipients},
+		{"known recipient", LimitUsage{DailyNewRecipients: 2}, 1, false, ""},
+	}
+	for _, c := range cases {
+		err := l.Check(c.usage, c.amount, c.newRecipient)
+		got := ""
+		if err != nil {
+			got = err.(*LimitError).Limit
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	if err := (Limits{}).Check(LimitUsage{DailyAmount: 1e9, HourlyTransfers: 1e6}, 1e9, true); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+
+	// a negative amount would lower the daily and monthly usage
+	for _, amount := range []float64{0, -600, math.NaN(), math.Inf(1)} {
+		if err := l.Check(u, amount, false); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("%v: got %v", amount, err)
+		}
+	}
+}
+
+func TestLimitsWith(t *testing.T) {
+	amount, zero, count := 300.0, 0.0, 5
+	base := Limits{MaxAmount: 1000, DailyAmount: 2000, HourlyTransfers: 10}
+
+	got := base.With(&LimitOverride{MaxAmount: &amount, DailyAmount: &zero}).With(&LimitOverride{HourlyTransfers: &count}).With(nil)
+	want := Limits{MaxAmount: 300, DailyAmount: 0, HourlyTransfers: 5}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUserLimitsToDTO(t *testing.T) {
+	dto := userLimitsToDTO(Limits{DailyAmount: 1000, HourlyTransfers: 5}, LimitUsage{DailyAmount: 1200, HourlyTransfers: 2})
+	if dto.MaxAmount != nil || dto.MonthlyAmount != nil || dto.DailyNewRecipients != nil {
+		t.Errorf("unset limits reported: %+v", dto)
+	}
+	if dto.DailyAmount == nil || dto.DailyAmount.Remaining != 0 || dto.DailyAmount.Used != 1200 {
+		t.Errorf("daily: %+v", dto.DailyAmount)
+	}
+	if dto.HourlyTransfers == nil || dto.HourlyTransfers.Remaining != 3 {
+		t.Errorf("hourly: %+v", dto.HourlyTransfers)
+	}
+}
+
+func TestUpdateUserSegmentLimits(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.conn.Exec(`INSERT INTO users (id, first_name, last_name, status) VALUES ('peggy', 'P', 'C', 'active')`); err != nil {
+		t.Fatal(err)
+	}
+	maxAmount := 50000.0
+	if err := db.SetLimitOverride(&LimitOverride{Scope: LimitScopeSegment, ScopeID: "premium", MaxAmount: &maxAmount}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := db.GetUserByID("peggy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Segment = "premium"
+	if err := db.UpdateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	limits, _, err := loadLimits(db.conn, "peggy", uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.MaxAmount != maxAmount {
+		t.Errorf("got max amount %v", limits.MaxAmount)
+	}
+	if u, err := db.GetUserByID("peggy"); err != nil || u.Segment != "premium" || u.Status != StatusActive {
+		t.Errorf("got %+v, %v", u, err)
+	}
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Lists. A transfer hitting the block list is blocked and one whose sender
+// or source card is on the allow list is approved, both without calling the
+// model. Other allow list hits are recorded but the model still decides.
+const (
+	ListBlock = "block"
+	ListAllow = "allow"
+)
+
+// Kinds of list entries. A card entry holds a card ID or number, an ip
+// entry an address or a range in CIDR notation.
+const (
+	ListKindCard   = "card"
+	ListKindUser   = "user"
+	ListKindDevice = "device"
+	ListKindIP     = "ip"
+)
+
+// The side of a transfer a list entry was hit by.
+const (
+	ListPartySender        = "sender"
+	ListPartyRecipient     = "recipient"
+	ListPartySenderCard    = "sender_card"
+	ListPartyRecipientCard = "recipient_card"
+	ListPartyDevice        = "device"
+	ListPartyIP            = "ip"
+)
+
+// ListHit is a list entry a transfer matched, as it was at the time, so
+// that it stays meaningful once the entry is removed.
+type ListHit struct {
+	EntryID uuid.UUID `json:"entry_id"`
+	List    string    `json:"list"`
+	Kind    string    `json:"kind"`
+	Value   string    `json:"value"`
+	Party   string    `json:"party"`
+	Reason  string    `json:"reason"`
+}
+
+// ListHits are stored with the transfer as JSON; NULL if there are none.
+type ListHits []ListHit
+
+func (h ListHits) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(h)
+}
+
+func (h *ListHits) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	}
+	return fmt.Errorf("ListHits: unsupported type %T", src)
+}
+
+// Decision returns the list decision of a transfer with these hits: block
+// if any is on the block list, allow if the sender or its card is on the
+// allow list, and "" otherwise. An allowed recipient, device or IP address
+// does not vouch for the sender.
+func (h ListHits) Decision() string {
+	decision := ""
+	for _, hit := range h {
+		switch {
+		case hit.List == ListBlock:
+			return ListBlock
+		case hit.Party == ListPartySender || hit.Party == ListPartySenderCard:
+			decision = ListAllow
+		}
+	}
+	return decision
+}
+
+// normalizeListEntry validates e and puts its value in the form it is
+// matched in.
+func normalizeListEntry(e *ListEntry) error {
+	if e.List != ListBlock && e.List != ListAllow {
+		return errors.New("list must be block or allow")
+	}
+	if strings.TrimSpace(e.Reason) == "" {
+		return errors.New("missing reason")
+	}
+
+	value := strings.TrimSpace(e.Value)
+	switch e.Kind {
+	case ListKindCard:
+		if id, err := uuid.Parse(value); err == nil {
+			value = id.String()
+		} else {
+			value = strings.Join(strings.Fields(value), "")
+		}
+	case ListKindUser, ListKindDevice:
+	case ListKindIP:
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return errors.New("invalid ip address or range")
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		value = prefix.Masked().String()
+	default:
+		return errors.New("kind must be card, user, device or ip")
+	}
+	if value == "" {
+		return errors.New("missing value")
+	}
+	e.Value = value
+	return nil
+}
+
+const listEntryColumns = `id, list, kind, value, reason, expires_at, created_by, created_at, COALESCE(updated_by, '') AS updated_by, updated_at`
+
+// AddListEntry adds e, or renews the entry with the same list, kind and
+// value with e's reason and expiry, as updated by e.CreatedBy. The stored
+// row is written back into e.
+func (db *DB) AddListEntry(e *ListEntry) error {
+	var ipRange *string
+	if e.Kind == ListKindIP {
+		ipRange = &e.Value
+	}
+	return db.conn.Get(e, `INSERT INTO list_entries (list, kind, value, ip_range, reason, expires_at, created_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (list, kind, value) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			expires_at = EXCLUDED.expires_at,
+			updated_by = EXCLUDED.created_by,
+			updated_at = now()
+		RETURNING `+listEntryColumns, e.List, e.Kind, e.Value, ipRange, e.Reason, e.ExpiresAt, e.CreatedBy)
+}
+
+func (db *DB) GetListEntry(id uuid.UUID) (*ListEntry, error) {
+	var e ListEntry
+	err := db.conn.Get(&e, `SELECT `+listEntryColumns+` FROM list_entries WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListListEntries lists the entries of list and kind, any if empty, newest
+// first. Expired entries are only listed with includeExpired.
+func (db *DB) ListListEntries(list, kind string, includeExpired bool) ([]*ListEntry, error) {
+	out := make([]*ListEntry, 0)
+	err := db.conn.Select(&out, `SELECT `+listEntryColumns+` FROM list_entries
+		WHERE ($1 = '' OR list = $1) AND ($2 = '' OR kind = $2)
+			AND ($3 OR expires_at IS NULL OR expires_at > now())
+		ORDER BY created_at DESC`, list, kind, includeExpired)
+	return out, err
+}
+
+// DeleteListEntry removes an entry, returning sql.ErrNoRows if there is
+// none.
+func (db *DB) DeleteListEntry(id uuid.UUID) error {
+	var deleted uuid.UUID
+	return db.conn.Get(&deleted, `DELETE FROM list_entries WHERE id=$1 RETURNING id`, id)
+}
+
+// MatchLists returns the unexpired list entries t matches, on either side:
+// its sender and source card, the recipient card and its owner, its device
+// and its IP address.
+func (db *DB) MatchLists(t *Transfer, fromCard, toCard *Card, now time.Time) (ListHits, error) {
+	var ip *string
+	if addr, err := netip.ParseAddr(t.IP); err == nil {
+		s := addr.String()
+		ip = &s
+	}
+
+	entries := make([]*ListEntry, 0)
+	err := db.conn.Select(&entries, `SELECT `+listEntryColumns+` FROM list_entries
+		WHERE (expires_at IS NULL OR expires_at > $1) AND (
+			(kind = 'card' AND value = ANY($2))
+			OR (kind = 'user' AND value = ANY($3))
+			OR (kind = 'device' AND value = $4)
+			OR (kind = 'ip' AND ip_range >>= $5::inet)
+		)`, now,
+		pq.Array([]string{fromCard.ID.String(), fromCard.Number, toCard.ID.String(), toCard.Number}),
+		pq.Array([]string{t.FromUserID, toCard.UserID}), t.DeviceID, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(ListHits, 0)
+	for _, e := range entries {
+		for _, party := range listEntryParties(e, t, fromCard, toCard) {
+			hits = append(hits, ListHit{EntryID: e.ID, List: e.List, Kind: e.Kind, Value: e.Value, Party: party, Reason: e.Reason})
+		}
+	}
+	return hits, nil
+}
+
+// listEntryParties returns the sides of t that e matches.
+func listEntryParties(e *ListEntry, t *Transfer, fromCard, toCard *Card) []string {
+	var out []string
+	switch e.Kind {
+	case ListKindCard:
+		if e.Value == fromCard.ID.String() || e.Value == fromCard.Number {
+			out = append(out, ListPartySenderCard)
+		}
+		if e.Value == toCard.ID.String() || e.Value == toCard.Number {
+			out = append(out, ListPartyRecipientCard)
+		}
+	case ListKindUser:
+		if e.Value == t.FromUserID {
+			out = append(out, ListPartySender)
+		}
+		if e.Value == toCard.UserID {
+			out = append(out, ListPartyRecipient)
+		}
+	case ListKindDevice:
+		out = append(out, ListPartyDevice)
+	case ListKindIP:
+		out = append(out, ListPartyIP)
+	}
+	return out
+}
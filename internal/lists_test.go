@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNormalizeListEntry(t *testing.T) {
+	cardID := uuid.New()
+	valid := map[string]struct {
+		kind, value, want string
+	}{
+		"card id":     {ListKindCard, " " + cardID.String() + " ", cardID.String()},
+		"card number": {ListKindCard, "4111 1111 1111 1111", "4111111111111111"},
+		"user":        {ListKindUser, "user-1", "user-1"},
+		"ip":          {ListKindIP, "203.0.113.7", "203.0.113.7/32"},
+		"ip range":    {ListKindIP, "203.0.113.77/24", "203.0.113.0/24"},
+		"ipv6 range":  {ListKindIP, "2001:db8::1/32", "2001:db8::/32"},
+	}
+	for name, c := range valid {
+		e := &ListEntry{List: ListBlock, Kind: c.kind, Value: c.value, Reason: "mule"}
+		if err := normalizeListEntry(e); err != nil || e.Value != c.want {
+			t.Errorf("%s: got %q, %v", name, e.Value, err)
+		}
+	}
+
+	invalid := map[string]ListEntry{
+		"list":   {List: "grey", Kind: ListKindUser, Value: "u", Reason: "r"},
+		"kind":   {List: ListBlock, Kind: "email", Value: "u", Reason: "r"},
+		"value":  {List: ListBlock, Kind: ListKindDevice, Value: " ", Reason: "r"},
+		"reason": {List: ListAllow, Kind: ListKindUser, Value: "u"},
+		"ip":     {List: ListBlock, Kind: ListKindIP, Value: "203.0.113", Reason: "r"},
+	}
+	for name, e := range invalid {
+		if err := normalizeListEntry(&e); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestListHitsDecision(t *testing.T) {
+	allow := func(party string) ListHit { return ListHit{List: ListAllow, Party: party} }
+	block := ListHit{List: ListBlock, Party: ListPartyRecipientCard}
+	cases := []struct {
+		hits ListHits
+		want string
+	}{
+		{nil, ""},
+		{ListHits{allow(ListPartySender)}, ListAllow},
+		{ListHits{allow(ListPartyRecipient), allow(ListPartySenderCard)}, ListAllow},
+		{ListHits{allow(ListPartySender), block, allow(ListPartySenderCard)}, ListBlock},
+		// only the sender's side vouches for the transfer
+		{ListHits{allow(ListPartyRecipient), allow(ListPartyRecipientCard), allow(ListPartyDevice), allow(ListPartyIP)}, ""},
+	}
+	for _, c := range cases {
+		if got := c.hits.Decision(); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.hits, got, c.want)
+		}
+	}
+}
+
+func TestListEntryParties(t *testing.T) {
+	from := &Card{ID: uuid.New(), UserID: "alice", Number: "4111111111111111"}
+	to := &Card{ID: uuid.New(), UserID: "bob", Number: "4222222222222222"}
+	tr := &Transfer{FromUserID: "alice", FromCardID: from.ID, ToCardID: to.ID}
+
+	cases := []struct {
+		entry ListEntry
+		want  []string
+	}{
+		{ListEntry{Kind: ListKindCard, Value: to.Number}, []string{ListPartyRecipientCard}},
+		{ListEntry{Kind: ListKindCard, Value: from.ID.String()}, []string{ListPartySenderCard}},
+		{ListEntry{Kind: ListKindUser, Value: "bob"}, []string{ListPartyRecipient}},
+		{ListEntry{Kind: ListKindUser, Value: "alice"}, []string{ListPartySender}},
+	}
+	for _, c := range cases {
+		got := listEntryParties(&c.entry, tr, from, to)
+		if len(got) != len(c.want) || got[0] != c.want[0] {
+			t.Errorf("%s %s: got %v, want %v", c.entry.Kind, c.entry.Value, got, c.want)
+		}
+	}
+
+	// a transfer between the cards of one user hits its entry twice
+	own := &Card{ID: uuid.New(), UserID: "alice"}
+	got := listEntryParties(&ListEntry{Kind: ListKindUser, Value: "alice"}, tr, from, own)
+	if len(got) != 2 {
+		t.Errorf("own cards: got %v", got)
+	}
+}
+
+func TestListHitsValue(t *testing.T) {
+	if v, err := (ListHits{}).Value(); v != nil || err != nil {
+		t.Errorf("no hits stored as %v, %v", v, err)
+	}
+
+	hits := ListHits{{EntryID: uuid.New(), List: ListBlock, Kind: ListKindCard, Value: "4111", Party: ListPartyRecipientCard, Reason: "mule"}}
+	v, err := hits.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ListHits
+	if err := got.Scan(v); err != nil || len(got) != 1 || got[0] != hits[0] {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if err := got.Scan(nil); err != nil || got != nil {
+		t.Errorf("NULL: got %v, %v", got, err)
+	}
+}
+
+func TestMatchLists(t *testing.T) {
+	db := openTestDB(t)
+	for _, u := range []string{"alice", "bob"} {
+		if _, err := db.conn.Exec(`INSERT INTO users (id, first_name, last_name, status) VALUES ($1, $1, 'Test', 'active')`, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := &Card{UserID: "alice", Number: "4111111111111111"}
+	to := &Card{UserID: "bob", Number: "4222222222222222"}
+	for _, c := range []*Card{from, to} {
+		if err := db.conn.Get(&c.ID, `INSERT INTO cards (user_id, number, balance, status) VALUES ($1, $2, 0, 'active') RETURNING id`, c.UserID, c.Number); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	add := func(list, kind, value string, expiresAt *time.Time) *ListEntry {
+		e := &ListEntry{List: list, Kind: kind, Value: value, Reason: "test", ExpiresAt: expiresAt, CreatedBy: "admin"}
+		if err := normalizeListEntry(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddListEntry(e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	recipientCard := add(ListBlock, ListKindCard, "4222 2222 2222 2222", &future)
+	sender := add(ListAllow, ListKindUser, "alice", nil)
+	device := add(ListAllow, ListKindDevice, "dev-1", nil)
+	ipRange := add(ListBlock, ListKindIP, "203.0.113.0/24", nil)
+	add(ListBlock, ListKindCard, from.ID.String(), &past)
+	add(ListBlock, ListKindUser, "carol", nil)
+	add(ListBlock, ListKindIP, "198.51.100.0/24", nil)
+	add(ListBlock, ListKindDevice, "dev-2", nil)
+
+	tr := &Transfer{FromUserID: "alice", FromCardID: from.ID, ToCardID: to.ID, DeviceID: "dev-1"}
+	tr.IP = "203.0.113.7"
+	hits, err := db.MatchLists(tr, from, to, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[uuid.UUID]string)
+	for _, h := range hits {
+		got[h.EntryID] = h.Party
+	}
+	want := map[uuid.UUID]string{
+		recipientCard.ID: ListPartyRecipientCard,
+		sender.ID:        ListPartySender,
+		device.ID:        ListPartyDevice,
+		ipRange.ID:       ListPartyIP,
+	}
+	if len(hits) != len(want) || !maps.Equal(got, want) {
+		t.Errorf("got %+v", hits)
+	}
+	if d := hits.Decision(); d != ListBlock {
+		t.Errorf("got decision %q, want block", d)
+	}
+
+	// without a known address, device or blocked recipient only the sender is allowed
+	tr.IP, tr.DeviceID = "", ""
+	hits, err = db.MatchLists(tr, from, from, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits.Decision() != ListAllow {
+		t.Errorf("own cards: got %+v", hits)
+	}
+}
+
+func TestAddListEntryRenew(t *testing.T) {
+	db := openTestDB(t)
+	e := &ListEntry{List: ListBlock, Kind: ListKindUser, Value: "mallory", Reason: "mule", CreatedBy: "alice"}
+	if err := db.AddListEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.UpdatedBy != "" || e.UpdatedAt != nil {
+		t.Errorf("new entry: got updated by %q at %v", e.UpdatedBy, e.UpdatedAt)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	renewed := &ListEntry{List: ListBlock, Kind: ListKindUser, Value: "mallory", Reason: "chargeback", ExpiresAt: &expires, CreatedBy: "bob"}
+	if err := db.AddListEntry(renewed); err != nil {
+		t.Fatal(err)
+	}
+	if renewed.ID != e.ID || renewed.Reason != "chargeback" || renewed.ExpiresAt == nil {
+		t.Errorf("got %+v", renewed)
+	}
+	if renewed.CreatedBy != "alice" || !renewed.CreatedAt.Equal(e.CreatedAt) {
+		t.Errorf("created by %q at %v, want alice at %v", renewed.CreatedBy, renewed.CreatedAt, e.CreatedAt)
+	}
+	if renewed.UpdatedBy != "bob" || renewed.UpdatedAt == nil {
+		t.Errorf("got updated by %q at %v", renewed.UpdatedBy, renewed.UpdatedAt)
+	}
+}
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// defaultLocalModelThreshold applies to model files without a threshold.
+const defaultLocalModelThreshold = 0.5
+
+// Local model types.
+const (
+	LocalModelLogistic = "logistic"
+	LocalModelGBT      = "gbt"
+)
+
+// LocalModel scores in process from an exported model, so that demos and
+// tests can run without the model service. It is loaded from a JSON file
+// (see LoadLocalModel), or imported from an XGBoost model file as saved by
+// the training pipeline (see ImportXGBoostModel), and selected with a
+// file:// model URL.
+//
+// Inputs are encoded into a vector in order, a categorical input taking one
+// column ("ordinal", the category index) or one column per category
+// ("onehot"). Unknown categories are missing (NaN) when ordinal and all
+// zeros when one-hot. Coefficients and tree splits refer to vector columns.
+type LocalModel struct {
+	Type   string            `json:"type"`
+	Inputs []LocalModelInput `json:"inputs"`
+	// Threshold on the probability from which the transfer is blocked, 0.5
+	// if not set. 0 blocks every transfer.
+	Threshold *float64 `json:"threshold"`
+
+	// logistic regression
+	Intercept    float64   `json:"intercept"`
+	Coefficients []float64 `json:"coefficients"`
+
+	// gradient boosted trees with a logistic objective: the probability is
+	// sigmoid(BaseScore + sum of the leaves reached)
+	BaseScore float64          `json:"base_score"`
+	Trees     []LocalModelTree `json:"trees"`
+
+	width int
+}
+
+type LocalModelInput struct {
+	Name       string   `json:"name"`
+	Categories []string `json:"categories"`
+	// Encoding of a categorical input, "ordinal" (default) or "onehot".
+	Encoding string `json:"encoding"`
+
+	index map[string]int
+}
+
+// LocalModelTree is a tree as a node list, the root being Nodes[0]. A node
+// with Leaf set is a leaf; otherwise x[Feature] < Threshold goes to Left and
+// anything else to Right, a missing value to Missing (Left if unset).
+type LocalModelTree struct {
+	Nodes []LocalModelNode `json:"nodes"`
+}
+
+type LocalModelNode struct {
+	Feature   int      `json:"feature"`
+	Threshold float64  `json:"threshold"`
+	Left      int      `json:"left"`
+	Right     int      `json:"right"`
+	Missing   *int     `json:"missing"`
+	Leaf      *float64 `json:"leaf"`
+}
+
+// LoadLocalModel reads and validates a model file, either in the format of
+// LocalModel or an XGBoost model.
+func LoadLocalModel(path string) (*LocalModel, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Learner json.RawMessage `json:"learner"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, fmt.Errorf("local model %s: %w", path, err)
+	}
+	if probe.Learner != nil {
+		m, err := ImportXGBoostModel(b)
+		if err != nil {
+			return nil, fmt.Errorf("local model %s: %w", path, err)
+		}
+		return m, nil
+	}
+
+	var m LocalModel
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("local model %s: %w", path, err)
+	}
+	if err := m.init(); err != nil {
+		return nil, fmt.Errorf("local model %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *LocalModel) init() error {
+	if m.Threshold == nil {
+		threshold := defaultLocalModelThreshold
+		m.Threshold = &threshold
+	}
+	if *m.Threshold < 0 || *m.Threshold > 1 {
+		return fmt.Errorf("threshold %v out of [0, 1]", *m.Threshold)
+	}
+
+	m.width = 0
+	for i := range m.Inputs {
+		in := &m.Inputs[i]
+		if _, err := FeatureByName(in.Name); err != nil {
+			return err
+		}
+		switch in.Encoding {
+		case "":
+			in.Encoding = "ordinal"
+		case "ordinal", "onehot":
+		default:
+			return fmt.Errorf("input %s: unknown encoding %q", in.Name, in.Encoding)
+		}
+		in.index = make(map[string]int, len(in.Categories))
+		for j, c := range in.Categories {
+			in.index[c] = j
+		}
+		m.width += in.columns()
+	}
+
+	switch m.Type {
+	case LocalModelLogistic:
+		if len(m.Coefficients) != m.width {
+			return fmt.Errorf("%d coefficients for %d encoded inputs", len(m.Coefficients), m.width)
+		}
+	case LocalModelGBT:
+		if len(m.Trees) == 0 {
+			return fmt.Errorf("no trees")
+		}
+		for i, t := range m.Trees {
+			if err := t.validate(m.width); err != nil {
+				return fmt.Errorf("tree %d: %w", i, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown model type %q", m.Type)
+	}
+	return nil
+}
+
+func (in *LocalModelInput) columns() int {
+	if in.Encoding == "onehot" {
+		return len(in.Categories)
+	}
+	return 1
+}
+
+func (t LocalModelTree) validate(width int) error {
+	if len(t.Nodes) == 0 {
+		return fmt.Errorf("no nodes")
+	}
+	for i, n := range t.Nodes {
+		if n.Leaf != nil {
+			continue
+		}
+		if n.Feature < 0 || n.Feature >= width {
+			return fmt.Errorf("node %d: feature %d out of %d encoded inputs", i, n.Feature, width)
+		}
+		// children must come after their parent, which also rules out cycles
+		for _, child := range []int{n.Left, n.Right, n.missing()} {
+			if child <= i || child >= len(t.Nodes) {
+				return fmt.Errorf("node %d: invalid child %d", i, child)
+			}
+		}
+	}
+	return nil
+}
+
+func (n LocalModelNode) missing() int {
+	if n.Missing != nil {
+		return *n.Missing
+	}
+	return n.Left
+}
+
+// checkFeatureSet reports inputs the feature set does not send.
+func (m *LocalModel) checkFeatureSet(fs *FeatureSet) error {
+	sent := make(map[string]bool, len(fs.Features))
+	for _, def := range fs.Features {
+		sent[def.Name] = true
+	}
+	for _, in := range m.Inputs {
+		if !sent[in.Name] {
+			return fmt.Errorf("local model input %s is not in feature set v%d", in.Name, fs.Version)
+		}
+	}
+	return nil
+}
+
+// encode turns a feature set payload into the model's input vector.
+func (m *LocalModel) encode(payload map[string]any) ([]float64, error) {
+	x := make([]float64, 0, m.width)
+	for _, in := range m.Inputs {
+		v, ok := payload[in.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing feature %s", in.Name)
+		}
+
+		if in.Categories == nil {
+			switch v := v.(type) {
+			case float64:
+				x = append(x, v)
+			case int:
+				x = append(x, float64(v))
+			default:
+				return nil, fmt.Errorf("feature %s: %T is not numeric", in.Name, v)
+			}
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("feature %s: %T is not categorical", in.Name, v)
+		}
+		j, known := in.index[s]
+		if in.Encoding == "onehot" {
+			for k := range in.Categories {
+				if known && k == j {
+					x = append(x, 1)
+				} else {
+					x = append(x, 0)
+				}
+			}
+		} else if known {
+			x = append(x, float64(j))
+		} else {
+			x = append(x, math.NaN())
+		}
+	}
+	return x, nil
+}
+
+func (m *LocalModel) margin(x []float64) float64 {
+	if m.Type == LocalModelLogistic {
+		z := m.Intercept
+		for i, c := range m.Coefficients {
+			// a missing value contributes nothing
+			if !math.IsNaN(x[i]) {
+				z += c * x[i]
+			}
+		}
+		return z
+	}
+
+	z := m.BaseScore
+	for _, t := range m.Trees {
+		z += t.leaf(x)
+	}
+	return z
+}
+
+func (t LocalModelTree) leaf(x []float64) float64 {
+	i := 0
+	for {
+		n := t.Nodes[i]
+		if n.Leaf != nil {
+			return *n.Leaf
+		}
+		switch v := x[n.Feature]; {
+		case math.IsNaN(v):
+			i = n.missing()
+		case v < n.Threshold:
+			i = n.Left
+		default:
+			i = n.Right
+		}
+	}
+}
+
+// contributions returns each input's share of a logistic regression's
+// margin, one-hot columns summed per input. Trees don't carry the node
+// statistics needed to attribute their score, so they return none.
+func (m *LocalModel) contributions(x []float64) []FeatureContribution {
+	if m.Type != LocalModelLogistic {
+		return nil
+	}
+
+	out := make([]FeatureContribution, 0, len(m.Inputs))
+	col := 0
+	for _, in := range m.Inputs {
+		c := 0.0
+		for range in.columns() {
+			if !math.IsNaN(x[col]) {
+				c += m.Coefficients[col] * x[col]
+			}
+			col++
+		}
+		if c != 0 {
+			out = append(out, FeatureContribution{Feature: in.Name, Contribution: c})
+		}
+	}
+	return out
+}
+
+// Predict scores payload. It only fails on a payload that does not fit the
+// model.
+func (m *LocalModel) Predict(_ context.Context, payload map[string]any) (*PredictResponse, error) {
+	x, err := m.encode(payload)
+	if err != nil {
+		return nil, &ModelCallError{Attempts: 1, Err: err}
+	}
+	p := 1 / (1 + math.Exp(-m.margin(x)))
+	return &PredictResponse{FraudProbability: p, BlockTransaction: p >= *m.Threshold, TopFeatures: m.contributions(x)}, nil
+}
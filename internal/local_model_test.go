@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Expected probabilities were computed by hand from the model files in
+// testdata/models, independently of LocalModel.
+func TestLocalModelPredict(t *testing.T) {
+	cases := []struct {
+		feats    ModelFeatures
+		logistic float64
+		gbt      float64
+	}{
+		{ModelFeatures{Amount: 100, LoginsLast7Days: 5, LastOSCategorical: "Android"}, 0.051661435466084836, 0.09112296101485616},
+		{ModelFeatures{Amount: 8000, IsNewDevice: 1, LastOSCategorical: "iOS"}, 0.9088770389851438, 0.6224593312018547},
+		// unknown category: no one-hot column set, missing branch in trees
+		{ModelFeatures{Amount: 8000, LoginsLast7Days: 1, LastOSCategorical: "Symbian"}, 0.6341355910108007, 0.4255574831883411},
+		{ModelFeatures{Amount: 3000, LoginsLast7Days: 1, IsNewDevice: 1, LastOSCategorical: "Android"}, 0.6570104626734988, 0.24973989440488234},
+	}
+
+	set, err := GetFeatureSet(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []string{"logistic", "gbt"} {
+		m, err := LoadLocalModel("testdata/models/" + model + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.checkFeatureSet(set); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, c := range cases {
+			want := c.logistic
+			if model == "gbt" {
+				want = c.gbt
+			}
+			pr, err := m.Predict(context.Background(), set.Payload(&c.feats))
+			if err != nil {
+				t.Fatalf("%s case %d: %v", model, i, err)
+			}
+			if math.Abs(pr.FraudProbability-want) > 1e-12 {
+				t.Errorf("%s case %d: fraud_probability = %v, want %v", model, i, pr.FraudProbability, want)
+			}
+			if pr.BlockTransaction != (want >= *m.Threshold) {
+				t.Errorf("%s case %d: block_transaction = %t at threshold %v", model, i, pr.BlockTransaction, *m.Threshold)
+			}
+		}
+	}
+}
+
+func TestLocalModelRejectsMissingInputs(t *testing.T) {
+	m, err := LoadLocalModel("testdata/models/gbt.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := GetFeatureSet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// is_new_device is not part of the original contract
+	if err := m.checkFeatureSet(v1); err == nil {
+		t.Error("feature set v1 accepted")
+	}
+	if _, err := m.Predict(context.Background(), v1.Payload(&ModelFeatures{})); err == nil {
+		t.Error("payload without is_new_device scored")
+	}
+}
+
+// testdata/models/gbt_xgboost.json is gbt.json saved by XGBoost, with the
+// OS one-hot encoded.
+func TestImportXGBoostModel(t *testing.T) {
+	m, err := LoadLocalModel("testdata/models/gbt_xgboost.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, err := LoadLocalModel("testdata/models/gbt.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Inputs) != 4 || m.Inputs[3].Encoding != "onehot" || len(m.Inputs[3].Categories) != 2 {
+		t.Fatalf("got inputs %+v", m.Inputs)
+	}
+	if *m.Threshold != 0.45 {
+		t.Errorf("got threshold %v", *m.Threshold)
+	}
+
+	set, err := GetFeatureSet(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, osName := range []string{"Android", "iOS", "Symbian"} {
+		for _, amount := range []float64{100, 8000} {
+			for _, logins := range []int{0, 5} {
+				for _, newDevice := range []int{0, 1} {
+					payload := set.Payload(&ModelFeatures{Amount: amount, LoginsLast7Days: logins, IsNewDevice: newDevice, LastOSCategorical: osName})
+					got, err := m.Predict(context.Background(), payload)
+					if err != nil {
+						t.Fatal(err)
+					}
+					want, err := native.Predict(context.Background(), payload)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if math.Abs(got.FraudProbability-want.FraudProbability) > 1e-9 {
+						t.Errorf("%v: fraud_probability = %v, want %v", payload, got.FraudProbability, want.FraudProbability)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestImportXGBoostModelRejects(t *testing.T) {
+	b, err := os.ReadFile("testdata/models/gbt_xgboost.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, edit := range map[string]func(s string) string{
+		"categorical": func(s string) string { return strings.Replace(s, `"int", "int"]`, `"int", "c"]`, 1) },
+		"apart": func(s string) string {
+			return strings.Replace(s, `"is_new_device", "last_os_categorical=Android"`, `"last_os_categorical=Android", "is_new_device"`, 1)
+		},
+		"objective": func(s string) string { return strings.Replace(s, "binary:logistic", "reg:squarederror", 1) },
+		"threshold": func(s string) string { return strings.Replace(s, `"0.45"`, `"1.5"`, 1) },
+		"feature":   func(s string) string { return strings.Replace(s, `"amount",`, `"amount_usd",`, 1) },
+	} {
+		if _, err := ImportXGBoostModel([]byte(edit(string(b)))); err == nil {
+			t.Errorf("%s: imported", name)
+		}
+	}
+}
+
+func TestLocalModelThreshold(t *testing.T) {
+	for threshold, ok := range map[string]bool{"": true, `"threshold": 0,`: true, `"threshold": -0.1,`: false} {
+		m := &LocalModel{}
+		err := json.Unmarshal([]byte(`{`+threshold+`"type": "logistic", "inputs": [{"name": "amount"}], "coefficients": [0]}`), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.init(); (err == nil) != ok {
+			t.Errorf("%q: got %v", threshold, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		pr, err := m.Predict(context.Background(), map[string]any{"amount": 1.0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the probability is 0.5
+		if !pr.BlockTransaction {
+			t.Errorf("%q: not blocked at threshold %v", threshold, *m.Threshold)
+		}
+	}
+}
+
+// TestLocalModelServiceParity scores the feature fixtures with the model
+// service at TEST_MODEL_URL and with its export at TEST_LOCAL_MODEL (as saved
+// by the training pipeline). It is skipped unless both are set.
+func TestLocalModelServiceParity(t *testing.T) {
+	serviceURL, modelPath := os.Getenv("TEST_MODEL_URL"), os.Getenv("TEST_LOCAL_MODEL")
+	if serviceURL == "" || modelPath == "" {
+		t.Skip("TEST_MODEL_URL or TEST_LOCAL_MODEL not set")
+	}
+	service, err := NewPredictor(serviceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := LoadLocalModel(modelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := FeatureSetVersions()
+	set, err := GetFeatureSet(versions[len(versions)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.checkFeatureSet(set); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob("testdata/features/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fx featureFixture
+		if err := json.Unmarshal(b, &fx); err != nil {
+			t.Fatal(err)
+		}
+		for _, amount := range []float64{50, 1500, 9000} {
+			feats := ComputeFeatures(&ModelFeatures{Amount: amount}, fx.Sessions, fx.Transdate)
+			payload := set.Payload(feats)
+			want, err := service.Predict(context.Background(), payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := local.Predict(context.Background(), payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got.FraudProbability-want.FraudProbability) > 1e-5 {
+				t.Errorf("%s, amount %v: local %v, service %v", filepath.Base(path), amount, got.FraudProbability, want.FraudProbability)
+			}
+			if got.BlockTransaction != want.BlockTransaction {
+				t.Errorf("%s, amount %v: local block_transaction %t, service %t", filepath.Base(path), amount, got.BlockTransaction, want.BlockTransaction)
+			}
+		}
+	}
+}
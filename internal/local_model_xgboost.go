@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// xgboostModel is the part of an XGBoost model saved as JSON
+// (Booster.save_model("model.json")) that LocalModel needs.
+type xgboostModel struct {
+	Learner struct {
+		Attributes   map[string]string `json:"attributes"`
+		FeatureNames []string          `json:"feature_names"`
+		FeatureTypes []string          `json:"feature_types"`
+		Booster      struct {
+			Name  string `json:"name"`
+			Model struct {
+				Trees []xgboostTree `json:"trees"`
+			} `json:"model"`
+		} `json:"gradient_booster"`
+		ModelParam struct {
+			BaseScore string `json:"base_score"`
+		} `json:"learner_model_param"`
+		Objective struct {
+			Name string `json:"name"`
+		} `json:"objective"`
+	} `json:"learner"`
+}
+
+type xgboostTree struct {
+	LeftChildren    []int             `json:"left_children"`
+	RightChildren   []int             `json:"right_children"`
+	SplitIndices    []int             `json:"split_indices"`
+	SplitConditions []float64         `json:"split_conditions"`
+	SplitType       []int             `json:"split_type"`
+	DefaultLeft     []json.RawMessage `json:"default_left"`
+}
+
+// ImportXGBoostModel converts a binary:logistic XGBoost model, as saved by
+// the training pipeline, to a LocalModel.
+//
+// Inputs are taken from the model's feature names: a feature name is a
+// numeric input, "name=category" a one-hot column of a categorical input
+// whose columns must be adjacent. Categorical splits are not supported. The
+// threshold is read from the "threshold" attribute, if set
+// (Booster.set_attr(threshold="0.6")).
+func ImportXGBoostModel(b []byte) (*LocalModel, error) {
+	var x xgboostModel
+	if err := json.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+	l := &x.Learner
+	if l.Objective.Name != "binary:logistic" {
+		return nil, fmt.Errorf("unsupported objective %q", l.Objective.Name)
+	}
+	if l.Booster.Name != "gbtree" {
+		return nil, fmt.Errorf("unsupported booster %q", l.Booster.Name)
+	}
+	if len(l.FeatureNames) == 0 {
+		return nil, fmt.Errorf("no feature names")
+	}
+	for i, typ := range l.FeatureTypes {
+		if typ == "c" {
+			return nil, fmt.Errorf("feature %s: categorical splits are not supported, one-hot encode it", l.FeatureNames[i])
+		}
+	}
+
+	m := &LocalModel{Type: LocalModelGBT}
+	inputs, err := xgboostInputs(l.FeatureNames)
+	if err != nil {
+		return nil, err
+	}
+	m.Inputs = inputs
+
+	// base_score is a probability, "5E-1" or "[5E-1]" in recent versions
+	p, err := strconv.ParseFloat(strings.Trim(l.ModelParam.BaseScore, "[]"), 64)
+	if err != nil || p <= 0 || p >= 1 {
+		return nil, fmt.Errorf("invalid base_score %q", l.ModelParam.BaseScore)
+	}
+	m.BaseScore = math.Log(p / (1 - p))
+
+	if s, ok := l.Attributes["threshold"]; ok {
+		threshold, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold attribute %q", s)
+		}
+		m.Threshold = &threshold
+	}
+
+	for i, t := range l.Booster.Model.Trees {
+		tree, err := t.convert()
+		if err != nil {
+			return nil, fmt.Errorf("tree %d: %w", i, err)
+		}
+		m.Trees = append(m.Trees, tree)
+	}
+
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// xgboostInputs groups the encoded feature names into inputs.
+func xgboostInputs(names []string) ([]LocalModelInput, error) {
+	inputs := make([]LocalModelInput, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		input, category, onehot := strings.Cut(name, "=")
+		if onehot && len(inputs) > 0 {
+			last := &inputs[len(inputs)-1]
+			if last.Name == input && last.Encoding == "onehot" {
+				last.Categories = append(last.Categories, category)
+				continue
+			}
+		}
+		if seen[input] {
+			return nil, fmt.Errorf("feature %s: columns are not adjacent", input)
+		}
+		seen[input] = true
+		in := LocalModelInput{Name: input}
+		if onehot {
+			in.Categories = []string{category}
+			in.Encoding = "onehot"
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, nil
+}
+
+// convert turns the parallel node arrays of an XGBoost tree into nodes.
+// XGBoost stores a leaf's value in its split condition.
+func (t xgboostTree) convert() (LocalModelTree, error) {
+	n := len(t.LeftChildren)
+	if len(t.RightChildren) != n || len(t.SplitIndices) != n || len(t.SplitConditions) != n || len(t.DefaultLeft) != n {
+		return LocalModelTree{}, fmt.Errorf("node arrays of different lengths")
+	}
+	nodes := make([]LocalModelNode, n)
+	for i := range nodes {
+		if t.LeftChildren[i] == -1 {
+			leaf := t.SplitConditions[i]
+			nodes[i] = LocalModelNode{Leaf: &leaf}
+			continue
+		}
+		if i < len(t.SplitType) && t.SplitType[i] != 0 {
+			return LocalModelTree{}, fmt.Errorf("node %d: categorical splits are not supported", i)
+		}
+		missing := t.RightChildren[i]
+		if s := string(t.DefaultLeft[i]); s == "1" || s == "true" {
+			missing = t.LeftChildren[i]
+		}
+		nodes[i] = LocalModelNode{
+			Feature:   t.SplitIndices[i],
+			Threshold: t.SplitConditions[i],
+			Left:      t.LeftChildren[i],
+			Right:     t.RightChildren[i],
+			Missing:   &missing,
+		}
+	}
+	return LocalModelTree{Nodes: nodes}, nil
+}
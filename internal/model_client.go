@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Errors returned by ModelClient.Predict. Failures of a single attempt are
+// reported as *ModelStatusError or *ModelResponseError (or the transport
+// error), wrapped in *ModelCallError once the client gives up.
+var (
+	ErrModelNotConfigured = errors.New("antifraud model url is not configured")
+	ErrModelCircuitOpen   = errors.New("antifraud model circuit breaker is open")
+)
+
+// ModelStatusError is a non-2xx response from the model service.
+type ModelStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ModelStatusError) Error() string {
+	return fmt.Sprintf("antifraud model responded %d: %s", e.StatusCode, e.Body)
+}
+
+// ModelResponseError is a 2xx response that does not match the
+// PredictResponse contract.
+type ModelResponseError struct {
+	Reason string
+}
+
+func (e *ModelResponseError) Error() string {
+	return "invalid antifraud model response: " + e.Reason
+}
+
+// ModelCallError is returned when every attempt failed or the deadline
+// budget ran out. Err is the error of the last attempt.
+type ModelCallError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ModelCallError) Error() string {
+	return fmt.Sprintf("antifraud model call failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *ModelCallError) Unwrap() error { return e.Err }
+
+// maxModelResponseSize bounds how much of a response body is read.
+const maxModelResponseSize = 1 << 20
+
+// Predictor scores a feature set payload.
+type Predictor interface {
+	Predict(ctx context.Context, payload map[string]any) (*PredictResponse, error)
+}
+
+// NewPredictor returns the client for a model URL: grpc://host:port for
+// ModelService over gRPC, file:///path/model.json for a LocalModel,
+// http(s):// for JSON over HTTP.
+func NewPredictor(rawURL string) (Predictor, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid antifraud model url: %w", err)
+	}
+	switch u.Scheme {
+	case "grpc":
+		return NewGRPCModelClient(u.Host)
+	case "file":
+		if u.Opaque != "" {
+			// file:relative/path.json
+			return LoadLocalModel(u.Opaque)
+		}
+		return LoadLocalModel(u.Path)
+	}
+	return NewModelClient(rawURL)
+}
+
+// ModelClient calls the /predict endpoint of a model service. Scoring is
+// idempotent, so failed attempts are retried with jittered exponential
+// backoff as long as the deadline budget allows.
+type ModelClient struct {
+	endpoint string
+	http     *http.Client
+	breaker  *circuitBreaker
+
+	// Timeout is the budget for the whole call including retries; a
+	// shorter deadline on the caller's context wins.
+	Timeout time.Duration
+	// AttemptTimeout bounds a single HTTP request.
+	AttemptTimeout time.Duration
+	MaxAttempts    int
+	BaseBackoff    time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewModelClient returns a client for the model service at baseURL using
+// the ANTIFRAUD_MODEL_* settings.
+func NewModelClient(baseURL string) (*ModelClient, error) {
+	if baseURL == "" {
+		return nil, ErrModelNotConfigured
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid antifraud model url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid antifraud model url %q: scheme must be http or https", baseURL)
+	}
+
+	return &ModelClient{
+		endpoint:       u.JoinPath("predict").String(),
+		http:           &http.Client{},
+		breaker:        &circuitBreaker{threshold: modelBreakerThreshold, cooldown: modelBreakerCooldown},
+		Timeout:        modelTimeout,
+		AttemptTimeout: modelAttemptTimeout,
+		MaxAttempts:    modelMaxAttempts,
+		BaseBackoff:    50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}, nil
+}
+
+// Predict sends payload to the model and returns its validated response.
+func (c *ModelClient) Predict(ctx context.Context, payload map[string]any) (*PredictResponse, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var lastErr error
+	attempt := 0
+	for attempt < max(c.MaxAttempts, 1) {
+		if !c.breaker.allow() {
+			if lastErr == nil {
+				return nil, ErrModelCircuitOpen
+			}
+			break
+		}
+
+		attempt++
+		pr, err := c.attempt(ctx, b)
+		if err == nil {
+			c.breaker.success()
+			return pr, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			// our budget or the caller ran out, the model is not to blame
+			c.breaker.release()
+			break
+		}
+		if !retryable(err) {
+			if countsAgainstModel(err) {
+				c.breaker.failure()
+			} else {
+				c.breaker.release()
+			}
+			break
+		}
+		c.breaker.failure()
+
+		if attempt >= c.MaxAttempts {
+			break
+		}
+		wait := c.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait+c.minAttemptTime() {
+			break
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, &ModelCallError{Attempts: attempt, Err: lastErr}
+		case <-t.C:
+		}
+	}
+	return nil, &ModelCallError{Attempts: attempt, Err: lastErr}
+}
+
+func (c *ModelClient) attempt(ctx context.Context, body []byte) (*PredictResponse, error) {
+	if c.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.AttemptTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxModelResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &ModelStatusError{StatusCode: resp.StatusCode, Body: truncate(string(data), 256)}
+	}
+	return decodePredictResponse(data)
+}
+
+// decodePredictResponse requires both fields to be present and the
+// probability to be within [0, 1]; a missing field must not silently become
+// a zero score.
+func decodePredictResponse(data []byte) (*PredictResponse, error) {
+	var raw struct {
+		FraudProbability *float64              `json:"fraud_probability"`
+		BlockTransaction *bool                 `json:"block_transaction"`
+		TopFeatures      []FeatureContribution `json:"top_features"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, &ModelResponseError{Reason: err.Error()}
+	}
+	return validatePredictResponse(raw.FraudProbability, raw.BlockTransaction, raw.TopFeatures)
+}
+
+func validatePredictResponse(probability *float64, block *bool, top []FeatureContribution) (*PredictResponse, error) {
+	if probability == nil {
+		return nil, &ModelResponseError{Reason: "missing fraud_probability"}
+	}
+	if block == nil {
+		return nil, &ModelResponseError{Reason: "missing block_transaction"}
+	}
+	p := *probability
+	if math.IsNaN(p) || p < 0 || p > 1 {
+		return nil, &ModelResponseError{Reason: fmt.Sprintf("fraud_probability %v out of [0, 1]", p)}
+	}
+	for _, c := range top {
+		if c.Feature == "" || math.IsNaN(c.Contribution) || math.IsInf(c.Contribution, 0) {
+			return nil, &ModelResponseError{Reason: fmt.Sprintf("invalid top feature %+v", c)}
+		}
+	}
+	return &PredictResponse{FraudProbability: p, BlockTransaction: *block, TopFeatures: top}, nil
+}
+
+// retryable reports whether another attempt may succeed: transport errors,
+// 5xx and 429. Other 4xx and contract violations are deterministic.
+func retryable(err error) bool {
+	var se *ModelStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+	}
+	var re *ModelResponseError
+	return !errors.As(err, &re)
+}
+
+// countsAgainstModel reports whether a non-retryable error indicates an
+// unhealthy model rather than a bad request.
+func countsAgainstModel(err error) bool {
+	var re *ModelResponseError
+	return errors.As(err, &re)
+}
+
+// backoff returns a full-jitter delay for the given (1-based) attempt.
+func (c *ModelClient) backoff(attempt int) time.Duration {
+	d := c.BaseBackoff << (attempt - 1)
+	if d <= 0 || d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d) + 1
+}
+
+// minAttemptTime is how much budget must be left for another attempt to be
+// worth making.
+func (c *ModelClient) minAttemptTime() time.Duration {
+	if c.AttemptTimeout > 0 {
+		return c.AttemptTimeout / 4
+	}
+	return 0
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after threshold consecutive failures and rejects
+// calls for cooldown. After that a single probe is let through: success
+// closes the breaker, failure opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends an attempt that says nothing about the model's health.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
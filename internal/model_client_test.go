@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// modelServer serves /predict with the responses in order, the last one
+// repeated, and counts the requests.
+type modelServer struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+type modelResponse struct {
+	status int
+	body   string
+}
+
+var okModelResponse = modelResponse{http.StatusOK, `{"fraud_probability": 0.8, "block_transaction": true}`}
+
+func newModelServer(t *testing.T, responses ...modelResponse) *modelServer {
+	t.Helper()
+	s := &modelServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/predict" || r.Method != http.MethodPost {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		i := int(s.hits.Add(1)) - 1
+		resp := responses[min(i, len(responses)-1)]
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestModelClient(t *testing.T, url string) *ModelClient {
+	t.Helper()
+	c, err := NewModelClient(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 5 * time.Second
+	c.AttemptTimeout = time.Second
+	c.MaxAttempts = 3
+	c.BaseBackoff = time.Millisecond
+	c.MaxBackoff = 5 * time.Millisecond
+	c.breaker = &circuitBreaker{threshold: 5, cooldown: time.Minute}
+	return c
+}
+
+func TestModelClientRetries(t *testing.T) {
+	cases := []struct {
+		name      string
+		responses []modelResponse
+		hits      int32
+		status    int
+	}{
+		{"recovers", []modelResponse{{http.StatusServiceUnavailable, "busy"}, {http.StatusTooManyRequests, ""}, okModelResponse}, 3, 0},
+		{"gives up", []modelResponse{{http.StatusBadGateway, ""}}, 3, http.StatusBadGateway},
+		{"bad request", []modelResponse{{http.StatusBadRequest, "unknown feature"}}, 1, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newModelServer(t, c.responses...)
+			client := newTestModelClient(t, s.URL)
+
+			pr, err := client.Predict(context.Background(), map[string]any{"amount": 100})
+			if got := s.hits.Load(); got != c.hits {
+				t.Errorf("got %d requests, want %d", got, c.hits)
+			}
+			if c.status == 0 {
+				if err != nil || pr.FraudProbability != 0.8 || !pr.BlockTransaction {
+					t.Fatalf("got %+v, %v", pr, err)
+				}
+				return
+			}
+			var ce *ModelCallError
+			var se *ModelStatusError
+			if !errors.As(err, &ce) || ce.Attempts != int(c.hits) || !errors.As(err, &se) || se.StatusCode != c.status {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestModelClientResponseValidation(t *testing.T) {
+	for name, body := range map[string]string{
+		"not json":          `<html>`,
+		"no probability":    `{"block_transaction": true}`,
+		"no decision":       `{"fraud_probability": 0.2}`,
+		"out of range":      `{"fraud_probability": 1.5, "block_transaction": true}`,
+		"negative":          `{"fraud_probability": -0.1, "block_transaction": false}`,
+		"unnamed feature":   `{"fraud_probability": 0.2, "block_transaction": false, "top_features": [{"feature": "", "contribution": 0.1}]}`,
+		"wrong probability": `{"fraud_probability": "high", "block_transaction": true}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newModelServer(t, modelResponse{http.StatusOK, body})
+			client := newTestModelClient(t, s.URL)
+
+			_, err := client.Predict(context.Background(), map[string]any{})
+			var re *ModelResponseError
+			if !errors.As(err, &re) {
+				t.Fatalf("got %v", err)
+			}
+			// a contract violation is not retried
+			if got := s.hits.Load(); got != 1 {
+				t.Errorf("got %d requests", got)
+			}
+		})
+	}
+
+	s := newModelServer(t, modelResponse{http.StatusOK, `{"fraud_probability": 0, "block_transaction": false, "top_features": [{"feature": "amount", "contribution": -0.3}]}`})
+	pr, err := newTestModelClient(t, s.URL).Predict(context.Background(), map[string]any{})
+	if err != nil || pr.FraudProbability != 0 || len(pr.TopFeatures) != 1 || pr.TopFeatures[0].Feature != "amount" {
+		t.Errorf("got %+v, %v", pr, err)
+	}
+}
+
+func TestModelClientCircuitBreaker(t *testing.T) {
+	s := newModelServer(t, modelResponse{http.StatusInternalServerError, ""}, modelResponse{http.StatusInternalServerError, ""}, modelResponse{http.StatusInternalServerError, ""}, okModelResponse)
+	client := newTestModelClient(t, s.URL)
+	client.MaxAttempts = 1
+	client.breaker = &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+	predict := func() error {
+		_, err := client.Predict(context.Background(), map[string]any{})
+		return err
+	}
+
+	// closed: two failures open it
+	for range 2 {
+		if err := predict(); err == nil || errors.Is(err, ErrModelCircuitOpen) {
+			t.Fatalf("got %v", err)
+		}
+	}
+	// open: calls are rejected without a request
+	if err := predict(); !errors.Is(err, ErrModelCircuitOpen) {
+		t.Fatalf("open: got %v", err)
+	}
+	if got := s.hits.Load(); got != 2 {
+		t.Fatalf("got %d requests", got)
+	}
+
+	// half open: the failing probe opens it again
+	time.Sleep(60 * time.Millisecond)
+	if err := predict(); err == nil || errors.Is(err, ErrModelCircuitOpen) {
+		t.Fatalf("probe: got %v", err)
+	}
+	if err := predict(); !errors.Is(err, ErrModelCircuitOpen) {
+		t.Fatalf("reopened: got %v", err)
+	}
+
+	// a successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		if err := predict(); err != nil {
+			t.Fatalf("closed: got %v", err)
+		}
+	}
+	if got := s.hits.Load(); got != 5 {
+		t.Errorf("got %d requests", got)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b := &circuitBreaker{threshold: 1, cooldown: time.Millisecond}
+	b.failure()
+	if b.allow() {
+		t.Fatal("open breaker allowed a call")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no probe after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("second probe allowed")
+	}
+	// a probe that says nothing about the model lets another one through
+	b.release()
+	if !b.allow() {
+		t.Fatal("no probe after release")
+	}
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Fatal("closed breaker rejected a call")
+	}
+}
+
+func TestModelClientDeadlineDoesNotTripBreaker(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer s.Close()
+	defer close(release)
+	client := newTestModelClient(t, s.URL)
+	client.breaker = &circuitBreaker{threshold: 1, cooldown: time.Minute}
+
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := client.Predict(ctx, map[string]any{})
+		cancel()
+		var ce *ModelCallError
+		if !errors.As(err, &ce) || ce.Attempts != 1 {
+			t.Fatalf("got %v", err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"antifraud-demo-backend/internal/modelpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// GRPCModelClient calls ModelService.Predict over a small pool of
+// connections, used round robin so that one HTTP/2 connection's stream
+// limit does not cap throughput. Retries of UNAVAILABLE calls are left to
+// gRPC's retry policy within the call deadline.
+type GRPCModelClient struct {
+	conns   []*grpc.ClientConn
+	clients []modelpb.ModelServiceClient
+	next    atomic.Uint32
+	breaker *circuitBreaker
+
+	// Timeout is the deadline of a call including retries; a shorter
+	// deadline on the caller's context wins.
+	Timeout time.Duration
+}
+
+// grpcServiceConfig retries calls that did not reach a healthy server.
+// gRPC caps maxAttempts at 5.
+const grpcServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "antifraud.model.v1.ModelService"}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": "0.05s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE", "RESOURCE_EXHAUSTED"]
+		}
+	}]
+}`
+
+// NewGRPCModelClient connects to the model service at target (host:port)
+// with ANTIFRAUD_MODEL_GRPC_CONNS connections. Connections are established
+// lazily. opts are appended to the default dial options.
+func NewGRPCModelClient(target string, opts ...grpc.DialOption) (*GRPCModelClient, error) {
+	if target == "" {
+		return nil, ErrModelNotConfigured
+	}
+
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(grpcServiceConfig, min(max(modelMaxAttempts, 2), 5))),
+	}, opts...)
+
+	c := &GRPCModelClient{
+		breaker: &circuitBreaker{threshold: modelBreakerThreshold, cooldown: modelBreakerCooldown},
+		Timeout: modelTimeout,
+	}
+	for range max(modelGRPCConns, 1) {
+		conn, err := grpc.NewClient(target, opts...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("antifraud model %s: %w", target, err)
+		}
+		c.conns = append(c.conns, conn)
+		c.clients = append(c.clients, modelpb.NewModelServiceClient(conn))
+	}
+	return c, nil
+}
+
+func (c *GRPCModelClient) Close() error {
+	var errs []error
+	for _, conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Predict sends payload to the model and returns its validated response.
+// Errors are wrapped in *ModelCallError, the gRPC status is available with
+// status.Code(errors.Unwrap(err)).
+func (c *GRPCModelClient) Predict(ctx context.Context, payload map[string]any) (*PredictResponse, error) {
+	req, err := newPredictRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.breaker.allow() {
+		return nil, ErrModelCircuitOpen
+	}
+
+	callCtx := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	client := c.clients[int(c.next.Add(1))%len(c.clients)]
+	resp, err := client.Predict(callCtx, req)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			// the caller gave up, the model is not to blame
+			c.breaker.release()
+		case status.Code(err) == codes.InvalidArgument:
+			c.breaker.release()
+		default:
+			c.breaker.failure()
+		}
+		return nil, &ModelCallError{Attempts: 1, Err: err}
+	}
+
+	var top []FeatureContribution
+	for _, fc := range resp.TopFeatures {
+		top = append(top, FeatureContribution{Feature: fc.Feature, Contribution: fc.Contribution})
+	}
+	pr, err := validatePredictResponse(resp.FraudProbability, resp.BlockTransaction, top)
+	if err != nil {
+		c.breaker.failure()
+		return nil, &ModelCallError{Attempts: 1, Err: err}
+	}
+	c.breaker.success()
+	return pr, nil
+}
+
+// newPredictRequest maps a feature set payload onto the ModelFeatures
+// message by feature name.
+func newPredictRequest(payload map[string]any) (*modelpb.PredictRequest, error) {
+	req := &modelpb.PredictRequest{FeatureSetVersion: 1, Features: &modelpb.ModelFeatures{}}
+	msg := req.Features.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+
+	for name, v := range payload {
+		if name == "feature_set_version" {
+			version, ok := v.(int)
+			if !ok {
+				return nil, fmt.Errorf("feature_set_version: unexpected type %T", v)
+			}
+			req.FeatureSetVersion = int32(version)
+			continue
+		}
+
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("feature %s is not in the gRPC model contract", name)
+		}
+
+		var value protoreflect.Value
+		switch x := v.(type) {
+		case float64:
+			if fd.Kind() == protoreflect.DoubleKind {
+				value = protoreflect.ValueOfFloat64(x)
+			}
+		case int:
+			if fd.Kind() == protoreflect.Int64Kind {
+				value = protoreflect.ValueOfInt64(int64(x))
+			}
+		case string:
+			if fd.Kind() == protoreflect.StringKind {
+				value = protoreflect.ValueOfString(x)
+			}
+		}
+		if !value.IsValid() {
+			return nil, fmt.Errorf("feature %s: %T does not fit %s", name, v, fd.Kind())
+		}
+		msg.Set(fd, value)
+	}
+	return req, nil
+}
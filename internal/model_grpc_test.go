@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"antifraud-demo-backend/internal/modelpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeModelServer is an in-process ModelService answering with predict.
+type fakeModelServer struct {
+	modelpb.UnimplementedModelServiceServer
+	predict func(context.Context, *modelpb.PredictRequest) (*modelpb.PredictResponse, error)
+}
+
+func (s *fakeModelServer) Predict(ctx context.Context, req *modelpb.PredictRequest) (*modelpb.PredictResponse, error) {
+	return s.predict(ctx, req)
+}
+
+func startFakeModel(t *testing.T, predict func(context.Context, *modelpb.PredictRequest) (*modelpb.PredictResponse, error)) *GRPCModelClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	modelpb.RegisterModelServiceServer(srv, &fakeModelServer{predict: predict})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	c, err := NewGRPCModelClient("passthrough:///bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestGRPCModelClientPredict(t *testing.T) {
+	var got *modelpb.PredictRequest
+	c := startFakeModel(t, func(_ context.Context, req *modelpb.PredictRequest) (*modelpb.PredictResponse, error) {
+		got = req
+		return &modelpb.PredictResponse{FraudProbability: proto.Float64(0.7), BlockTransaction: proto.Bool(true)}, nil
+	})
+
+	feats := &ModelFeatures{
+		Amount:                    1500,
+		LastPhoneModelCategorical: "Pixel 8",
+		LoginsLast7Days:           3,
+		IsNewDevice:               1,
+		RecipientFeatures:         RecipientFeatures{RecipientDistinctSenders24h: 4},
+	}
+	set, err := GetFeatureSet(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := c.Predict(context.Background(), set.Payload(feats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.FraudProbability != 0.7 || !pr.BlockTransaction {
+		t.Errorf("got %+v, want 0.7 and blocked", pr)
+	}
+
+	want := &modelpb.PredictRequest{
+		FeatureSetVersion: 4,
+		Features: &modelpb.ModelFeatures{
+			Amount:                       1500,
+			LastPhoneModelCategorical:    "Pixel 8",
+			LoginsLast_7Days:             3,
+			IsNewDevice:                  1,
+			RecipientDistinctSenders_24H: 4,
+		},
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("request = %v, want %v", got, want)
+	}
+}
+
+func TestGRPCModelClientEveryFeatureSetFits(t *testing.T) {
+	for _, v := range FeatureSetVersions() {
+		set, _ := GetFeatureSet(v)
+		if _, err := newPredictRequest(set.Payload(&ModelFeatures{})); err != nil {
+			t.Errorf("feature set v%d: %v", v, err)
+		}
+	}
+}
+
+func TestGRPCModelClientInvalidResponse(t *testing.T) {
+	c := startFakeModel(t, func(context.Context, *modelpb.PredictRequest) (*modelpb.PredictResponse, error) {
+		return &modelpb.PredictResponse{BlockTransaction: proto.Bool(false)}, nil
+	})
+
+	_, err := c.Predict(context.Background(), map[string]any{"amount": 1.0})
+	var re *ModelResponseError
+	if !errors.As(err, &re) {
+		t.Fatalf("err = %v, want ModelResponseError", err)
+	}
+}
+
+func TestGRPCModelClientDeadline(t *testing.T) {
+	c := startFakeModel(t, func(ctx context.Context, _ *modelpb.PredictRequest) (*modelpb.PredictResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := c.Predict(context.Background(), map[string]any{"amount": 1.0})
+	if status.Code(errors.Unwrap(err)) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("call took %v", d)
+	}
+}
+
+func TestGRPCModelClientCircuitBreaker(t *testing.T) {
+	calls := 0
+	c := startFakeModel(t, func(context.Context, *modelpb.PredictRequest) (*modelpb.PredictResponse, error) {
+		calls++
+		return nil, status.Error(codes.Internal, "model crashed")
+	})
+	c.breaker = &circuitBreaker{threshold: 3, cooldown: time.Minute}
+
+	for range 5 {
+		c.Predict(context.Background(), map[string]any{"amount": 1.0})
+	}
+	if calls != 3 {
+		t.Errorf("server called %d times, want 3", calls)
+	}
+	if _, err := c.Predict(context.Background(), map[string]any{"amount": 1.0}); !errors.Is(err, ErrModelCircuitOpen) {
+		t.Errorf("err = %v, want ErrModelCircuitOpen", err)
+	}
+}
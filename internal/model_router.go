@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Model routing modes. The champion decides unless the user falls into the
+// traffic split of a challenger; every model that does not decide is
+// called in shadow so that all of them are scored on identical traffic.
+const (
+	ModelModeChampion = "champion"
+	ModelModeShadow   = "shadow"
+	ModelModeSplit    = "split"
+)
+
+// ModelRoute is one model endpoint, as configured in ANTIFRAUD_MODELS_PATH.
+// The URL scheme selects the transport, see NewPredictor.
+type ModelRoute struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Mode string `json:"mode"`
+	// FeatureSet is the feature set version sent to the model; 0 means
+	// FEATURE_SET_VERSION.
+	FeatureSet int `json:"feature_set"`
+	// TrafficPercent is the share of users, by user ID, decided by a split
+	// challenger.
+	TrafficPercent float64 `json:"traffic_percent"`
+
+	client     Predictor
+	featureSet *FeatureSet
+}
+
+type modelRouter struct {
+	routes   []*ModelRoute
+	champion *ModelRoute
+	splits   []*ModelRoute
+}
+
+var models *modelRouter
+
+// ConfigureModels sets up the model routes from the JSON list at path or,
+// without one, from ANTIFRAUD_MODEL_URL (champion) and
+// ANTIFRAUD_TRIAL_MODEL_URL (shadow). Without any model configured the
+// server still starts, but transfers fail with ErrModelNotConfigured.
+func ConfigureModels(path string) error {
+	var routes []*ModelRoute
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &routes); err != nil {
+			return fmt.Errorf("models %s: %w", path, err)
+		}
+	} else {
+		if antifraud_model_url == "" && trial_model_url == "" {
+			models = nil
+			return nil
+		}
+		routes = append(routes, &ModelRoute{Name: antifraud_model_name, URL: antifraud_model_url, Mode: ModelModeChampion})
+		if trial_model_url != "" {
+			routes = append(routes, &ModelRoute{Name: "trial", URL: trial_model_url, Mode: ModelModeShadow, FeatureSet: trialFeatureSet.Version})
+		}
+	}
+
+	r, err := newModelRouter(routes)
+	if err != nil {
+		return err
+	}
+	models = r
+	return nil
+}
+
+func newModelRouter(routes []*ModelRoute) (*modelRouter, error) {
+	r := &modelRouter{routes: routes}
+	names := make(map[string]bool)
+	split := 0.0
+	for _, m := range routes {
+		if m.Name == "" {
+			return nil, fmt.Errorf("model %s: missing name", m.URL)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("model %s: duplicate name", m.Name)
+		}
+		names[m.Name] = true
+
+		switch m.Mode {
+		case ModelModeChampion:
+			if r.champion != nil {
+				return nil, fmt.Errorf("model %s: only one champion allowed, %s is already", m.Name, r.champion.Name)
+			}
+			r.champion = m
+		case ModelModeShadow:
+		case ModelModeSplit:
+			if m.TrafficPercent <= 0 || m.TrafficPercent > 100 {
+				return nil, fmt.Errorf("model %s: traffic_percent must be in (0, 100]", m.Name)
+			}
+			split += m.TrafficPercent
+			r.splits = append(r.splits, m)
+		default:
+			return nil, fmt.Errorf("model %s: unknown mode %q", m.Name, m.Mode)
+		}
+
+		client, err := NewPredictor(m.URL)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", m.Name, err)
+		}
+		m.client = client
+
+		if m.FeatureSet == 0 {
+			m.featureSet = liveFeatureSet
+			if m.featureSet == nil {
+				m.featureSet = featureSets[1]
+			}
+			m.FeatureSet = m.featureSet.Version
+		} else if m.featureSet, err = GetFeatureSet(m.FeatureSet); err != nil {
+			return nil, fmt.Errorf("model %s: %w", m.Name, err)
+		}
+
+		if local, ok := client.(*LocalModel); ok {
+			if err := local.checkFeatureSet(m.featureSet); err != nil {
+				return nil, fmt.Errorf("model %s: %w", m.Name, err)
+			}
+		}
+	}
+
+	if r.champion == nil {
+		return nil, fmt.Errorf("no %s model configured", ModelModeChampion)
+	}
+	if split > 100 {
+		return nil, fmt.Errorf("split traffic adds up to %v%%", split)
+	}
+	return r, nil
+}
+
+// route picks the model deciding for userID. Users are bucketed by a hash of
+// their ID, so a user always gets the same model.
+func (r *modelRouter) route(userID string) *ModelRoute {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	bucket := float64(h.Sum32()%10000) / 100
+
+	upper := 0.0
+	for _, m := range r.splits {
+		upper += m.TrafficPercent
+		if bucket < upper {
+			return m
+		}
+	}
+	return r.champion
+}
+
+func (m *ModelRoute) score(ctx context.Context, payload map[string]any, shadow bool) (*PredictResponse, *ModelScore, error) {
+	s := &ModelScore{Model: m.Name, FeatureSetVersion: m.FeatureSet, Shadow: shadow}
+	start := time.Now()
+	pr, err := m.client.Predict(ctx, payload)
+	s.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		s.Error = err.Error()
+		return nil, s, err
+	}
+	score, blocked := pr.FraudProbability, pr.BlockTransaction
+	s.FraudScore = &score
+	s.IsBlocked = &blocked
+	return pr, s, nil
+}
+
+// Prediction is the decision of the model routed to.
+type Prediction struct {
+	*PredictResponse
+	Model string
+}
+
+// modelScoreStore stores the scores of every model; the DB in production.
+type modelScoreStore interface {
+	SaveModelScore(s *ModelScore) error
+}
+
+// PredictFraud scores feats of transfer transferID with the model routed to
+// for userID and every other configured model in shadow. All the scores,
+// failed calls included, are stored in the background as they arrive, even
+// when the deciding model fails, so error rates can be compared.
+func PredictFraud(ctx context.Context, transferID uuid.UUID, userID string, feats *ModelFeatures) (*Prediction, error) {
+	if models == nil {
+		return nil, ErrModelNotConfigured
+	}
+	return models.predict(ctx, dbClient, transferID, userID, feats)
+}
+
+func (r *modelRouter) predict(ctx context.Context, store modelScoreStore, transferID uuid.UUID, userID string, feats *ModelFeatures) (*Prediction, error) {
+	decider := r.route(userID)
+	save := func(s *ModelScore) {
+		s.TransferID = transferID
+		if err := store.SaveModelScore(s); err != nil {
+			log.Printf("save %s score of transfer %s: %v", s.Model, transferID, err)
+		}
+	}
+
+	// shadows may outlive the request
+	shadowCtx := context.WithoutCancel(ctx)
+	for _, m := range r.routes {
+		if m == decider {
+			continue
+		}
+		payload := m.featureSet.Payload(feats)
+		go func() {
+			_, s, _ := m.score(shadowCtx, payload, true)
+			save(s)
+		}()
+	}
+
+	pr, s, err := decider.score(ctx, decider.featureSet.Payload(feats), false)
+	go save(s)
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %w", decider.Name, err)
+	}
+	return &Prediction{PredictResponse: pr, Model: decider.Name}, nil
+}
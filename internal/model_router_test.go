@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memModelScores is a modelScoreStore handing the scores to the test.
+type memModelScores chan *ModelScore
+
+func (c memModelScores) SaveModelScore(s *ModelScore) error {
+	c <- s
+	return nil
+}
+
+// scores returns the n scores stored, by model.
+func (c memModelScores) scores(t *testing.T, n int) map[string]*ModelScore {
+	t.Helper()
+	out := make(map[string]*ModelScore)
+	for range n {
+		select {
+		case s := <-c:
+			out[s.Model] = s
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d scores", len(out), n)
+		}
+	}
+	return out
+}
+
+func TestPredictStoresScores(t *testing.T) {
+	for name, status := range map[string]int{"champion decides": http.StatusOK, "champion fails": http.StatusBadRequest} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				w.Write([]byte(`{"fraud_probability": 0.7, "block_transaction": true}`))
+			}))
+			defer srv.Close()
+			r, err := newModelRouter([]*ModelRoute{
+				{Name: "champion", URL: srv.URL, Mode: ModelModeChampion},
+				{Name: "local", URL: "file:testdata/models/logistic.json", Mode: ModelModeShadow, FeatureSet: 2},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store := make(memModelScores, 2)
+			transferID := uuid.New()
+			pr, err := r.predict(context.Background(), store, transferID, "alice", &ModelFeatures{Amount: 100, LastOSCategorical: "iOS"})
+			if status == http.StatusOK {
+				if err != nil || pr.Model != "champion" || pr.FraudProbability != 0.7 {
+					t.Fatalf("got %+v, %v", pr, err)
+				}
+			} else if err == nil {
+				t.Fatal("failed champion decided")
+			}
+
+			scores := store.scores(t, 2)
+			champion, shadow := scores["champion"], scores["local"]
+			if champion == nil || shadow == nil {
+				t.Fatalf("got scores %v", scores)
+			}
+			if champion.TransferID != transferID || champion.Shadow || (champion.Error == "") != (status == http.StatusOK) {
+				t.Errorf("got champion score %+v", champion)
+			}
+			// the shadow score is kept when the champion fails
+			if shadow.TransferID != transferID || !shadow.Shadow || shadow.FraudScore == nil || shadow.Error != "" {
+				t.Errorf("got shadow score %+v", shadow)
+			}
+		})
+	}
+}
+
+func TestConfigureModelsTrial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"fraud_probability": 0.7, "block_transaction": true}`))
+	}))
+	defer srv.Close()
+	savedURL, savedTrialURL, savedLive, savedTrial, savedModels := antifraud_model_url, trial_model_url, liveFeatureSet, trialFeatureSet, models
+	t.Cleanup(func() {
+		antifraud_model_url, trial_model_url, liveFeatureSet, trialFeatureSet, models = savedURL, savedTrialURL, savedLive, savedTrial, savedModels
+	})
+	antifraud_model_url, trial_model_url = srv.URL, srv.URL
+
+	if err := ConfigureFeatureSets("", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureModels(""); err != nil {
+		t.Fatal(err)
+	}
+
+	store := make(memModelScores, 2)
+	pr, err := models.predict(context.Background(), store, uuid.New(), "alice", &ModelFeatures{Amount: 100})
+	if err != nil || pr.Model != antifraud_model_name {
+		t.Fatalf("got %+v, %v", pr, err)
+	}
+	// the trial is stored as a shadow score, to be compared with the champion
+	scores := store.scores(t, 2)
+	if s := scores["trial"]; s == nil || !s.Shadow || s.FeatureSetVersion != 2 || s.FraudScore == nil {
+		t.Errorf("got trial score %+v", s)
+	}
+	if s := scores[antifraud_model_name]; s == nil || s.Shadow || s.FeatureSetVersion != 1 {
+		t.Errorf("got champion score %+v", s)
+	}
+}
@@ -0,0 +1,704 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v5.29.3
+// source: model.proto
+
+// Contract of the antifraud model service over gRPC. ModelFeatures mirrors
+// the registered features; a model only reads the features of the feature
+// set named in the request, the others are left at their zero value.
+
+package modelpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PredictRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	FeatureSetVersion int32                  `protobuf:"varint,1,opt,name=feature_set_version,json=featureSetVersion,proto3" json:"feature_set_version,omitempty"`
+	Features          *ModelFeatures         `protobuf:"bytes,2,opt,name=features,proto3" json:"features,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *PredictRequest) Reset() {
+	*x = PredictRequest{}
+	mi := &file_model_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictRequest) ProtoMessage() {}
+
+func (x *PredictRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictRequest.ProtoReflect.Descriptor instead.
+func (*PredictRequest) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PredictRequest) GetFeatureSetVersion() int32 {
+	if x != nil {
+		return x.FeatureSetVersion
+	}
+	return 0
+}
+
+func (x *PredictRequest) GetFeatures() *ModelFeatures {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+// Field names are the registered feature names. Existing field numbers must
+// never be reused; a new feature version gets a new field.
+type ModelFeatures struct {
+	state                            protoimpl.MessageState `protogen:"open.v1"`
+	Amount                           float64                `protobuf:"fixed64,1,opt,name=amount,proto3" json:"amount,omitempty"`
+	MonthlyOsChanges                 int64                  `protobuf:"varint,2,opt,name=monthly_os_changes,json=monthlyOsChanges,proto3" json:"monthly_os_changes,omitempty"`
+	MonthlyPhoneModelChanges         int64                  `protobuf:"varint,3,opt,name=monthly_phone_model_changes,json=monthlyPhoneModelChanges,proto3" json:"monthly_phone_model_changes,omitempty"`
+	LastPhoneModelCategorical        string                 `protobuf:"bytes,4,opt,name=last_phone_model_categorical,json=lastPhoneModelCategorical,proto3" json:"last_phone_model_categorical,omitempty"`
+	LastOsCategorical                string                 `protobuf:"bytes,5,opt,name=last_os_categorical,json=lastOsCategorical,proto3" json:"last_os_categorical,omitempty"`
+	Direction                        string                 `protobuf:"bytes,6,opt,name=direction,proto3" json:"direction,omitempty"`
+	LoginsLast_7Days                 int64                  `protobuf:"varint,7,opt,name=logins_last_7_days,json=loginsLast7Days,proto3" json:"logins_last_7_days,omitempty"`
+	LoginsLast_30Days                int64                  `protobuf:"varint,8,opt,name=logins_last_30_days,json=loginsLast30Days,proto3" json:"logins_last_30_days,omitempty"`
+	LoginFrequency_7D                float64                `protobuf:"fixed64,9,opt,name=login_frequency_7d,json=loginFrequency7d,proto3" json:"login_frequency_7d,omitempty"`
+	LoginFrequency_30D               float64                `protobuf:"fixed64,10,opt,name=login_frequency_30d,json=loginFrequency30d,proto3" json:"login_frequency_30d,omitempty"`
+	FreqChange_7DVsMean              float64                `protobuf:"fixed64,11,opt,name=freq_change_7d_vs_mean,json=freqChange7dVsMean,proto3" json:"freq_change_7d_vs_mean,omitempty"`
+	Logins_7DOver_30DRatio           float64                `protobuf:"fixed64,12,opt,name=logins_7d_over_30d_ratio,json=logins7dOver30dRatio,proto3" json:"logins_7d_over_30d_ratio,omitempty"`
+	AvgLoginInterval_30D             float64                `protobuf:"fixed64,13,opt,name=avg_login_interval_30d,json=avgLoginInterval30d,proto3" json:"avg_login_interval_30d,omitempty"`
+	StdLoginInterval_30D             float64                `protobuf:"fixed64,14,opt,name=std_login_interval_30d,json=stdLoginInterval30d,proto3" json:"std_login_interval_30d,omitempty"`
+	VarLoginInterval_30D             float64                `protobuf:"fixed64,15,opt,name=var_login_interval_30d,json=varLoginInterval30d,proto3" json:"var_login_interval_30d,omitempty"`
+	EwmLoginInterval_7D              float64                `protobuf:"fixed64,16,opt,name=ewm_login_interval_7d,json=ewmLoginInterval7d,proto3" json:"ewm_login_interval_7d,omitempty"`
+	BurstinessLoginInterval          float64                `protobuf:"fixed64,17,opt,name=burstiness_login_interval,json=burstinessLoginInterval,proto3" json:"burstiness_login_interval,omitempty"`
+	FanoFactorLoginInterval          float64                `protobuf:"fixed64,18,opt,name=fano_factor_login_interval,json=fanoFactorLoginInterval,proto3" json:"fano_factor_login_interval,omitempty"`
+	ZscoreAvgLoginInterval_7D        float64                `protobuf:"fixed64,19,opt,name=zscore_avg_login_interval_7d,json=zscoreAvgLoginInterval7d,proto3" json:"zscore_avg_login_interval_7d,omitempty"`
+	IsNewDevice                      int64                  `protobuf:"varint,20,opt,name=is_new_device,json=isNewDevice,proto3" json:"is_new_device,omitempty"`
+	LastLoginDistanceKm              float64                `protobuf:"fixed64,21,opt,name=last_login_distance_km,json=lastLoginDistanceKm,proto3" json:"last_login_distance_km,omitempty"`
+	LastLoginSpeedKmh                float64                `protobuf:"fixed64,22,opt,name=last_login_speed_kmh,json=lastLoginSpeedKmh,proto3" json:"last_login_speed_kmh,omitempty"`
+	IsNewCountry_30D                 int64                  `protobuf:"varint,23,opt,name=is_new_country_30d,json=isNewCountry30d,proto3" json:"is_new_country_30d,omitempty"`
+	IsNewCity_30D                    int64                  `protobuf:"varint,24,opt,name=is_new_city_30d,json=isNewCity30d,proto3" json:"is_new_city_30d,omitempty"`
+	DistinctLocations_30D            int64                  `protobuf:"varint,25,opt,name=distinct_locations_30d,json=distinctLocations30d,proto3" json:"distinct_locations_30d,omitempty"`
+	AmountZscore_30D                 float64                `protobuf:"fixed64,26,opt,name=amount_zscore_30d,json=amountZscore30d,proto3" json:"amount_zscore_30d,omitempty"`
+	TransfersLast_1H                 int64                  `protobuf:"varint,27,opt,name=transfers_last_1h,json=transfersLast1h,proto3" json:"transfers_last_1h,omitempty"`
+	TransfersLast_24H                int64                  `protobuf:"varint,28,opt,name=transfers_last_24h,json=transfersLast24h,proto3" json:"transfers_last_24h,omitempty"`
+	TransfersLast_7D                 int64                  `protobuf:"varint,29,opt,name=transfers_last_7d,json=transfersLast7d,proto3" json:"transfers_last_7d,omitempty"`
+	AmountSumLast_1H                 float64                `protobuf:"fixed64,30,opt,name=amount_sum_last_1h,json=amountSumLast1h,proto3" json:"amount_sum_last_1h,omitempty"`
+	AmountSumLast_24H                float64                `protobuf:"fixed64,31,opt,name=amount_sum_last_24h,json=amountSumLast24h,proto3" json:"amount_sum_last_24h,omitempty"`
+	AmountSumLast_7D                 float64                `protobuf:"fixed64,32,opt,name=amount_sum_last_7d,json=amountSumLast7d,proto3" json:"amount_sum_last_7d,omitempty"`
+	IsNewDestination                 int64                  `protobuf:"varint,33,opt,name=is_new_destination,json=isNewDestination,proto3" json:"is_new_destination,omitempty"`
+	SecondsSinceLastTransfer         float64                `protobuf:"fixed64,34,opt,name=seconds_since_last_transfer,json=secondsSinceLastTransfer,proto3" json:"seconds_since_last_transfer,omitempty"`
+	AmountToBalanceRatio             float64                `protobuf:"fixed64,35,opt,name=amount_to_balance_ratio,json=amountToBalanceRatio,proto3" json:"amount_to_balance_ratio,omitempty"`
+	RecipientDistinctSenders_24H     int64                  `protobuf:"varint,36,opt,name=recipient_distinct_senders_24h,json=recipientDistinctSenders24h,proto3" json:"recipient_distinct_senders_24h,omitempty"`
+	RecipientDistinctSenders_7D      int64                  `protobuf:"varint,37,opt,name=recipient_distinct_senders_7d,json=recipientDistinctSenders7d,proto3" json:"recipient_distinct_senders_7d,omitempty"`
+	RecipientInbound_1H              int64                  `protobuf:"varint,38,opt,name=recipient_inbound_1h,json=recipientInbound1h,proto3" json:"recipient_inbound_1h,omitempty"`
+	RecipientInbound_24H             int64                  `protobuf:"varint,39,opt,name=recipient_inbound_24h,json=recipientInbound24h,proto3" json:"recipient_inbound_24h,omitempty"`
+	RecipientInboundSum_24H          float64                `protobuf:"fixed64,40,opt,name=recipient_inbound_sum_24h,json=recipientInboundSum24h,proto3" json:"recipient_inbound_sum_24h,omitempty"`
+	RecipientBlockedInboundShare_30D float64                `protobuf:"fixed64,41,opt,name=recipient_blocked_inbound_share_30d,json=recipientBlockedInboundShare30d,proto3" json:"recipient_blocked_inbound_share_30d,omitempty"`
+	RecipientAccountAgeDays          float64                `protobuf:"fixed64,42,opt,name=recipient_account_age_days,json=recipientAccountAgeDays,proto3" json:"recipient_account_age_days,omitempty"`
+	RecipientQuickForwardShare_7D    float64                `protobuf:"fixed64,43,opt,name=recipient_quick_forward_share_7d,json=recipientQuickForwardShare7d,proto3" json:"recipient_quick_forward_share_7d,omitempty"`
+	FailedChallengeAttempts_30D      int64                  `protobuf:"varint,44,opt,name=failed_challenge_attempts_30d,json=failedChallengeAttempts30d,proto3" json:"failed_challenge_attempts_30d,omitempty"`
+	unknownFields                    protoimpl.UnknownFields
+	sizeCache                        protoimpl.SizeCache
+}
+
+func (x *ModelFeatures) Reset() {
+	*x = ModelFeatures{}
+	mi := &file_model_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ModelFeatures) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ModelFeatures) ProtoMessage() {}
+
+func (x *ModelFeatures) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ModelFeatures.ProtoReflect.Descriptor instead.
+func (*ModelFeatures) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ModelFeatures) GetAmount() float64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetMonthlyOsChanges() int64 {
+	if x != nil {
+		return x.MonthlyOsChanges
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetMonthlyPhoneModelChanges() int64 {
+	if x != nil {
+		return x.MonthlyPhoneModelChanges
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLastPhoneModelCategorical() string {
+	if x != nil {
+		return x.LastPhoneModelCategorical
+	}
+	return ""
+}
+
+func (x *ModelFeatures) GetLastOsCategorical() string {
+	if x != nil {
+		return x.LastOsCategorical
+	}
+	return ""
+}
+
+func (x *ModelFeatures) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *ModelFeatures) GetLoginsLast_7Days() int64 {
+	if x != nil {
+		return x.LoginsLast_7Days
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLoginsLast_30Days() int64 {
+	if x != nil {
+		return x.LoginsLast_30Days
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLoginFrequency_7D() float64 {
+	if x != nil {
+		return x.LoginFrequency_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLoginFrequency_30D() float64 {
+	if x != nil {
+		return x.LoginFrequency_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetFreqChange_7DVsMean() float64 {
+	if x != nil {
+		return x.FreqChange_7DVsMean
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLogins_7DOver_30DRatio() float64 {
+	if x != nil {
+		return x.Logins_7DOver_30DRatio
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAvgLoginInterval_30D() float64 {
+	if x != nil {
+		return x.AvgLoginInterval_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetStdLoginInterval_30D() float64 {
+	if x != nil {
+		return x.StdLoginInterval_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetVarLoginInterval_30D() float64 {
+	if x != nil {
+		return x.VarLoginInterval_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetEwmLoginInterval_7D() float64 {
+	if x != nil {
+		return x.EwmLoginInterval_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetBurstinessLoginInterval() float64 {
+	if x != nil {
+		return x.BurstinessLoginInterval
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetFanoFactorLoginInterval() float64 {
+	if x != nil {
+		return x.FanoFactorLoginInterval
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetZscoreAvgLoginInterval_7D() float64 {
+	if x != nil {
+		return x.ZscoreAvgLoginInterval_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetIsNewDevice() int64 {
+	if x != nil {
+		return x.IsNewDevice
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLastLoginDistanceKm() float64 {
+	if x != nil {
+		return x.LastLoginDistanceKm
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetLastLoginSpeedKmh() float64 {
+	if x != nil {
+		return x.LastLoginSpeedKmh
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetIsNewCountry_30D() int64 {
+	if x != nil {
+		return x.IsNewCountry_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetIsNewCity_30D() int64 {
+	if x != nil {
+		return x.IsNewCity_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetDistinctLocations_30D() int64 {
+	if x != nil {
+		return x.DistinctLocations_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAmountZscore_30D() float64 {
+	if x != nil {
+		return x.AmountZscore_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetTransfersLast_1H() int64 {
+	if x != nil {
+		return x.TransfersLast_1H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetTransfersLast_24H() int64 {
+	if x != nil {
+		return x.TransfersLast_24H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetTransfersLast_7D() int64 {
+	if x != nil {
+		return x.TransfersLast_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAmountSumLast_1H() float64 {
+	if x != nil {
+		return x.AmountSumLast_1H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAmountSumLast_24H() float64 {
+	if x != nil {
+		return x.AmountSumLast_24H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAmountSumLast_7D() float64 {
+	if x != nil {
+		return x.AmountSumLast_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetIsNewDestination() int64 {
+	if x != nil {
+		return x.IsNewDestination
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetSecondsSinceLastTransfer() float64 {
+	if x != nil {
+		return x.SecondsSinceLastTransfer
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetAmountToBalanceRatio() float64 {
+	if x != nil {
+		return x.AmountToBalanceRatio
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientDistinctSenders_24H() int64 {
+	if x != nil {
+		return x.RecipientDistinctSenders_24H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientDistinctSenders_7D() int64 {
+	if x != nil {
+		return x.RecipientDistinctSenders_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientInbound_1H() int64 {
+	if x != nil {
+		return x.RecipientInbound_1H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientInbound_24H() int64 {
+	if x != nil {
+		return x.RecipientInbound_24H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientInboundSum_24H() float64 {
+	if x != nil {
+		return x.RecipientInboundSum_24H
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientBlockedInboundShare_30D() float64 {
+	if x != nil {
+		return x.RecipientBlockedInboundShare_30D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientAccountAgeDays() float64 {
+	if x != nil {
+		return x.RecipientAccountAgeDays
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetRecipientQuickForwardShare_7D() float64 {
+	if x != nil {
+		return x.RecipientQuickForwardShare_7D
+	}
+	return 0
+}
+
+func (x *ModelFeatures) GetFailedChallengeAttempts_30D() int64 {
+	if x != nil {
+		return x.FailedChallengeAttempts_30D
+	}
+	return 0
+}
+
+type PredictResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// optional, so that a response without a score is told apart from 0
+	FraudProbability *float64 `protobuf:"fixed64,1,opt,name=fraud_probability,json=fraudProbability,proto3,oneof" json:"fraud_probability,omitempty"`
+	BlockTransaction *bool    `protobuf:"varint,2,opt,name=block_transaction,json=blockTransaction,proto3,oneof" json:"block_transaction,omitempty"`
+	// optional, the features that contributed most to the score
+	TopFeatures   []*FeatureContribution `protobuf:"bytes,3,rep,name=top_features,json=topFeatures,proto3" json:"top_features,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PredictResponse) Reset() {
+	*x = PredictResponse{}
+	mi := &file_model_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictResponse) ProtoMessage() {}
+
+func (x *PredictResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictResponse.ProtoReflect.Descriptor instead.
+func (*PredictResponse) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PredictResponse) GetFraudProbability() float64 {
+	if x != nil && x.FraudProbability != nil {
+		return *x.FraudProbability
+	}
+	return 0
+}
+
+func (x *PredictResponse) GetBlockTransaction() bool {
+	if x != nil && x.BlockTransaction != nil {
+		return *x.BlockTransaction
+	}
+	return false
+}
+
+func (x *PredictResponse) GetTopFeatures() []*FeatureContribution {
+	if x != nil {
+		return x.TopFeatures
+	}
+	return nil
+}
+
+type FeatureContribution struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Feature       string                 `protobuf:"bytes,1,opt,name=feature,proto3" json:"feature,omitempty"`
+	Contribution  float64                `protobuf:"fixed64,2,opt,name=contribution,proto3" json:"contribution,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FeatureContribution) Reset() {
+	*x = FeatureContribution{}
+	mi := &file_model_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeatureContribution) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeatureContribution) ProtoMessage() {}
+
+func (x *FeatureContribution) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeatureContribution.ProtoReflect.Descriptor instead.
+func (*FeatureContribution) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FeatureContribution) GetFeature() string {
+	if x != nil {
+		return x.Feature
+	}
+	return ""
+}
+
+func (x *FeatureContribution) GetContribution() float64 {
+	if x != nil {
+		return x.Contribution
+	}
+	return 0
+}
+
+var File_model_proto protoreflect.FileDescriptor
+
+const file_model_proto_rawDesc = "" +
+	"\n" +
+	"\vmodel.proto\x12\x12antifraud.model.v1\"\x7f\n" +
+	"\x0ePredictRequest\x12.\n" +
+	"\x13feature_set_version\x18\x01 \x01(\x05R\x11featureSetVersion\x12=\n" +
+	"\bfeatures\x18\x02 \x01(\v2!.antifraud.model.v1.ModelFeaturesR\bfeatures\"\x86\x12\n" +
+	"\rModelFeatures\x12\x16\n" +
+	"\x06amount\x18\x01 \x01(\x01R\x06amount\x12,\n" +
+	"\x12monthly_os_changes\x18\x02 \x01(\x03R\x10monthlyOsChanges\x12=\n" +
+	"\x1bmonthly_phone_model_changes\x18\x03 \x01(\x03R\x18monthlyPhoneModelChanges\x12?\n" +
+	"\x1clast_phone_model_categorical\x18\x04 \x01(\tR\x19lastPhoneModelCategorical\x12.\n" +
+	"\x13last_os_categorical\x18\x05 \x01(\tR\x11lastOsCategorical\x12\x1c\n" +
+	"\tdirection\x18\x06 \x01(\tR\tdirection\x12+\n" +
+	"\x12logins_last_7_days\x18\a \x01(\x03R\x0floginsLast7Days\x12-\n" +
+	"\x13logins_last_30_days\x18\b \x01(\x03R\x10loginsLast30Days\x12,\n" +
+	"\x12login_frequency_7d\x18\t \x01(\x01R\x10loginFrequency7d\x12.\n" +
+	"\x13login_frequency_30d\x18\n" +
+	" \x01(\x01R\x11loginFrequency30d\x122\n" +
+	"\x16freq_change_7d_vs_mean\x18\v \x01(\x01R\x12freqChange7dVsMean\x126\n" +
+	"\x18logins_7d_over_30d_ratio\x18\f \x01(\x01R\x14logins7dOver30dRatio\x123\n" +
+	"\x16avg_login_interval_30d\x18\r \x01(\x01R\x13avgLoginInterval30d\x123\n" +
+	"\x16std_login_interval_30d\x18\x0e \x01(\x01R\x13stdLoginInterval30d\x123\n" +
+	"\x16var_login_interval_30d\x18\x0f \x01(\x01R\x13varLoginInterval30d\x121\n" +
+	"\x15ewm_login_interval_7d\x18\x10 \x01(\x01R\x12ewmLoginInterval7d\x12:\n" +
+	"\x19burstiness_login_interval\x18\x11 \x01(\x01R\x17burstinessLoginInterval\x12;\n" +
+	"\x1afano_factor_login_interval\x18\x12 \x01(\x01R\x17fanoFactorLoginInterval\x12>\n" +
+	"\x1czscore_avg_login_interval_7d\x18\x13 \x01(\x01R\x18zscoreAvgLoginInterval7d\x12\"\n" +
+	"\ris_new_device\x18\x14 \x01(\x03R\visNewDevice\x123\n" +
+	"\x16last_login_distance_km\x18\x15 \x01(\x01R\x13lastLoginDistanceKm\x12/\n" +
+	"\x14last_login_speed_kmh\x18\x16 \x01(\x01R\x11lastLoginSpeedKmh\x12+\n" +
+	"\x12is_new_country_30d\x18\x17 \x01(\x03R\x0fisNewCountry30d\x12%\n" +
+	"\x0fis_new_city_30d\x18\x18 \x01(\x03R\fisNewCity30d\x124\n" +
+	"\x16distinct_locations_30d\x18\x19 \x01(\x03R\x14distinctLocations30d\x12*\n" +
+	"\x11amount_zscore_30d\x18\x1a \x01(\x01R\x0famountZscore30d\x12*\n" +
+	"\x11transfers_last_1h\x18\x1b \x01(\x03R\x0ftransfersLast1h\x12,\n" +
+	"\x12transfers_last_24h\x18\x1c \x01(\x03R\x10transfersLast24h\x12*\n" +
+	"\x11transfers_last_7d\x18\x1d \x01(\x03R\x0ftransfersLast7d\x12+\n" +
+	"\x12amount_sum_last_1h\x18\x1e \x01(\x01R\x0famountSumLast1h\x12-\n" +
+	"\x13amount_sum_last_24h\x18\x1f \x01(\x01R\x10amountSumLast24h\x12+\n" +
+	"\x12amount_sum_last_7d\x18  \x01(\x01R\x0famountSumLast7d\x12,\n" +
+	"\x12is_new_destination\x18! \x01(\x03R\x10isNewDestination\x12=\n" +
+	"\x1bseconds_since_last_transfer\x18\" \x01(\x01R\x18secondsSinceLastTransfer\x125\n" +
+	"\x17amount_to_balance_ratio\x18# \x01(\x01R\x14amountToBalanceRatio\x12C\n" +
+	"\x1erecipient_distinct_senders_24h\x18$ \x01(\x03R\x1brecipientDistinctSenders24h\x12A\n" +
+	"\x1drecipient_distinct_senders_7d\x18% \x01(\x03R\x1arecipientDistinctSenders7d\x120\n" +
+	"\x14recipient_inbound_1h\x18& \x01(\x03R\x12recipientInbound1h\x122\n" +
+	"\x15recipient_inbound_24h\x18' \x01(\x03R\x13recipientInbound24h\x129\n" +
+	"\x19recipient_inbound_sum_24h\x18( \x01(\x01R\x16recipientInboundSum24h\x12L\n" +
+	"#recipient_blocked_inbound_share_30d\x18) \x01(\x01R\x1frecipientBlockedInboundShare30d\x12;\n" +
+	"\x1arecipient_account_age_days\x18* \x01(\x01R\x17recipientAccountAgeDays\x12F\n" +
+	" recipient_quick_forward_share_7d\x18+ \x01(\x01R\x1crecipientQuickForwardShare7d\x12A\n" +
+	"\x1dfailed_challenge_attempts_30d\x18, \x01(\x03R\x1afailedChallengeAttempts30d\"\xed\x01\n" +
+	"\x0fPredictResponse\x120\n" +
+	"\x11fraud_probability\x18\x01 \x01(\x01H\x00R\x10fraudProbability\x88\x01\x01\x120\n" +
+	"\x11block_transaction\x18\x02 \x01(\bH\x01R\x10blockTransaction\x88\x01\x01\x12J\n" +
+	"\ftop_features\x18\x03 \x03(\v2'.antifraud.model.v1.FeatureContributionR\vtopFeaturesB\x14\n" +
+	"\x12_fraud_probabilityB\x14\n" +
+	"\x12_block_transaction\"S\n" +
+	"\x13FeatureContribution\x12\x18\n" +
+	"\afeature\x18\x01 \x01(\tR\afeature\x12\"\n" +
+	"\fcontribution\x18\x02 \x01(\x01R\fcontribution2b\n" +
+	"\fModelService\x12R\n" +
+	"\aPredict\x12\".antifraud.model.v1.PredictRequest\x1a#.antifraud.model.v1.PredictResponseB)Z'antifraud-demo-backend/internal/modelpbb\x06proto3"
+
+var (
+	file_model_proto_rawDescOnce sync.Once
+	file_model_proto_rawDescData []byte
+)
+
+func file_model_proto_rawDescGZIP() []byte {
+	file_model_proto_rawDescOnce.Do(func() {
+		file_model_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_model_proto_rawDesc), len(file_model_proto_rawDesc)))
+	})
+	return file_model_proto_rawDescData
+}
+
+var file_model_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_model_proto_goTypes = []any{
+	(*PredictRequest)(nil),      // 0: antifraud.model.v1.PredictRequest
+	(*ModelFeatures)(nil),       // 1: antifraud.model.v1.ModelFeatures
+	(*PredictResponse)(nil),     // 2: antifraud.model.v1.PredictResponse
+	(*FeatureContribution)(nil), // 3: antifraud.model.v1.FeatureContribution
+}
+var file_model_proto_depIdxs = []int32{
+	1, // 0: antifraud.model.v1.PredictRequest.features:type_name -> antifraud.model.v1.ModelFeatures
+	3, // 1: antifraud.model.v1.PredictResponse.top_features:type_name -> antifraud.model.v1.FeatureContribution
+	0, // 2: antifraud.model.v1.ModelService.Predict:input_type -> antifraud.model.v1.PredictRequest
+	2, // 3: antifraud.model.v1.ModelService.Predict:output_type -> antifraud.model.v1.PredictResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_model_proto_init() }
+func file_model_proto_init() {
+	if File_model_proto != nil {
+		return
+	}
+	file_model_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_model_proto_rawDesc), len(file_model_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_model_proto_goTypes,
+		DependencyIndexes: file_model_proto_depIdxs,
+		MessageInfos:      file_model_proto_msgTypes,
+	}.Build()
+	File_model_proto = out.File
+	file_model_proto_goTypes = nil
+	file_model_proto_depIdxs = nil
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type UserStatus string
@@ -18,6 +19,10 @@ type User struct {
 	FirstName string     `json:"first_name" db:"first_name"`
 	LastName  string     `json:"last_name" db:"last_name"`
 	Status    UserStatus `json:"status" db:"status"`
+	// nil if unknown, for accounts loaded before it was recorded
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	// customer segment, selecting the segment's spending limits
+	Segment string `json:"segment" db:"segment"`
 }
 
 type CardStatus string
@@ -41,6 +46,51 @@ type LoginSession struct {
 	When       time.Time `json:"when" db:"when"`
 	PhoneModel string    `json:"phone_model" db:"phone_model"`
 	OS         string    `json:"os" db:"os"`
+	DeviceID   string    `json:"device_id" db:"device_id"`
+
+	ClientContext
+}
+
+// ClientContext describes where a request came from. Latitude and Longitude
+// are nil when the IP could not be geolocated.
+type ClientContext struct {
+	IP        string   `json:"ip" db:"ip"`
+	UserAgent string   `json:"user_agent" db:"user_agent"`
+	Country   string   `json:"country" db:"country"`
+	City      string   `json:"city" db:"city"`
+	Latitude  *float64 `json:"latitude" db:"latitude"`
+	Longitude *float64 `json:"longitude" db:"longitude"`
+}
+
+type Device struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	DeviceID   string     `json:"device_id" db:"device_id"`
+	Name       string     `json:"name" db:"name"`
+	PhoneModel string     `json:"phone_model" db:"phone_model"`
+	OS         string     `json:"os" db:"os"`
+	FirstSeen  time.Time  `json:"first_seen" db:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen" db:"last_seen"`
+	Trusted    bool       `json:"trusted" db:"trusted"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+type DeviceStatus string
+
+const (
+	DeviceActive  DeviceStatus = "active"
+	DeviceTrusted DeviceStatus = "trusted"
+	DeviceRevoked DeviceStatus = "revoked"
+)
+
+func (d *Device) Status() DeviceStatus {
+	if d.RevokedAt != nil {
+		return DeviceRevoked
+	}
+	if d.Trusted {
+		return DeviceTrusted
+	}
+	return DeviceActive
 }
 
 type Transfer struct {
@@ -52,6 +102,121 @@ type Transfer struct {
 	When       time.Time `json:"when" db:"when"`
 	FraudScore float64   `json:"fraud_score" db:"fraud_score"`
 	IsBlocked  bool      `json:"is_blocked" db:"is_blocked"`
+	Status     string    `json:"status" db:"status"`
+
+	DeviceID    string `json:"device_id" db:"device_id"`
+	IsNewDevice bool   `json:"is_new_device" db:"is_new_device"`
+
+	// name of the model whose decision was enforced
+	Model       string       `json:"model" db:"model"`
+	Explanation *Explanation `json:"explanation" db:"explanation"`
+	// block and allow list entries the transfer matched
+	ListHits ListHits `json:"list_hits" db:"list_hits"`
+	// analyst rules the transfer matched
+	RuleHits RuleHits `json:"rule_hits" db:"rule_hits"`
+	// the features the transfer was scored on; nil if it was decided
+	// without the model or scored before they were stored
+	Features *ModelFeatures `json:"features" db:"features"`
+
+	ClientContext
+}
+
+const (
+	// Accepted, waiting to be scored by a transfer worker.
+	TransferPending           = "pending"
+	TransferCompleted         = "completed"
+	TransferBlocked           = "blocked"
+	TransferChallengeRequired = "challenge_required"
+	TransferChallengeFailed   = "challenge_failed"
+	TransferChallengeExpired  = "challenge_expired"
+	// Scoring kept failing, the transfer was not executed.
+	TransferFailed = "failed"
+)
+
+// Executed reports whether the transfer went through. Blocked transfers and
+// transfers that were never confirmed did not.
+func (t *Transfer) Executed() bool {
+	return t.Status == TransferCompleted
+}
+
+// TransferChallenge is the one-time code a transfer with status
+// TransferChallengeRequired waits on.
+type TransferChallenge struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	TransferID     uuid.UUID  `json:"transfer_id" db:"transfer_id"`
+	UserID         string     `json:"user_id" db:"user_id"`
+	CodeHash       string     `json:"-" db:"code_hash"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	FailedAttempts int        `json:"failed_attempts" db:"failed_attempts"`
+	ConfirmedAt    *time.Time `json:"confirmed_at" db:"confirmed_at"`
+}
+
+// TransferJob is the scoring job of a pending transfer. Attempts is bumped
+// on every claim and fences off a worker whose lease ran out.
+type TransferJob struct {
+	TransferID  uuid.UUID  `db:"transfer_id"`
+	Status      string     `db:"status"`
+	Attempts    int        `db:"attempts"`
+	RunAt       time.Time  `db:"run_at"`
+	LockedUntil *time.Time `db:"locked_until"`
+	LastError   string     `db:"last_error"`
+}
+
+// ModelScore is one model's score of a transfer, either the enforced
+// decision or a shadow score. A failed call has no score and an Error.
+type ModelScore struct {
+	TransferID        uuid.UUID `json:"transfer_id" db:"transfer_id"`
+	Model             string    `json:"model" db:"model"`
+	FeatureSetVersion int       `json:"feature_set_version" db:"feature_set_version"`
+	Shadow            bool      `json:"shadow" db:"shadow"`
+	FraudScore        *float64  `json:"fraud_score" db:"fraud_score"`
+	IsBlocked         *bool     `json:"is_blocked" db:"is_blocked"`
+	LatencyMs         int64     `json:"latency_ms" db:"latency_ms"`
+	Error             string    `json:"error" db:"error"`
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID      `json:"id" db:"id"`
+	URL        string         `json:"url" db:"url"`
+	Secret     string         `json:"-" db:"secret"`
+	EventTypes pq.StringArray `json:"event_types" db:"event_types"`
+	Active     bool           `json:"active" db:"active"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	// retries exhausted, only a replay delivers it
+	WebhookDead = "dead"
+)
+
+type WebhookDelivery struct {
+	ID             int64      `json:"id" db:"id"`
+	SubscriptionID uuid.UUID  `json:"subscription_id" db:"subscription_id"`
+	EventID        int64      `json:"event_id" db:"event_id"`
+	EventUUID      uuid.UUID  `json:"event_uuid" db:"event_uuid"`
+	EventType      string     `json:"event_type" db:"event_type"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastStatusCode *int       `json:"last_status_code" db:"last_status_code"`
+	LastError      string     `json:"last_error" db:"last_error"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+}
+
+// WebhookAttempt is one try of a delivery. StatusCode is nil if the
+// receiver did not answer.
+type WebhookAttempt struct {
+	ID          int64     `json:"id" db:"id"`
+	DeliveryID  int64     `json:"delivery_id" db:"delivery_id"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+	StatusCode  *int      `json:"status_code" db:"status_code"`
+	Error       string    `json:"error" db:"error"`
+	DurationMs  int64     `json:"duration_ms" db:"duration_ms"`
 }
 
 type Superuser struct {
@@ -59,3 +224,145 @@ type Superuser struct {
 	Username     string    `json:"username" db:"username"`
 	PasswordHash string    `json:"-" db:"password_hash"`
 }
+
+const (
+	LimitScopeSegment = "segment"
+	LimitScopeUser    = "user"
+	LimitScopeCard    = "card"
+)
+
+// LimitOverride sets some of the spending limits of a segment, a user or a
+// card. Limits left nil are inherited; zero lifts a limit.
+type LimitOverride struct {
+	Scope              string    `json:"scope" db:"scope"`
+	ScopeID            string    `json:"scope_id" db:"scope_id"`
+	MaxAmount          *float64  `json:"max_amount" db:"max_amount"`
+	DailyAmount        *float64  `json:"daily_amount" db:"daily_amount"`
+	MonthlyAmount      *float64  `json:"monthly_amount" db:"monthly_amount"`
+	HourlyTransfers    *int      `json:"hourly_transfers" db:"hourly_transfers"`
+	DailyNewRecipients *int      `json:"daily_new_recipients" db:"daily_new_recipients"`
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ListEntry puts a card, user, device or IP range on the block or allow
+// list until it expires, if ever.
+type ListEntry struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	List      string     `json:"list" db:"list"`
+	Kind      string     `json:"kind" db:"kind"`
+	Value     string     `json:"value" db:"value"`
+	Reason    string     `json:"reason" db:"reason"`
+	ExpiresAt *time.Time `json:"expires_at" db:"expires_at"`
+	CreatedBy string     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	// who renewed the entry last and when, "" and nil if never renewed
+	UpdatedBy string     `json:"updated_by" db:"updated_by"`
+	UpdatedAt *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Rule is an analyst rule at its current version. A rule matching a scored
+// transfer blocks or challenges it, whatever the model decided.
+type Rule struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Version     int       `json:"version" db:"version"`
+	Expression  string    `json:"expression" db:"expression"`
+	Action      string    `json:"action" db:"action"`
+	Description string    `json:"description" db:"description"`
+	Enabled     bool      `json:"enabled" db:"enabled"`
+	CreatedBy   string    `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	expr *RuleExpr
+}
+
+// RuleVersion is a rule as it was saved at a version; a rule is never edited
+// in place.
+type RuleVersion struct {
+	RuleID      uuid.UUID `json:"rule_id" db:"rule_id"`
+	Version     int       `json:"version" db:"version"`
+	Expression  string    `json:"expression" db:"expression"`
+	Action      string    `json:"action" db:"action"`
+	Description string    `json:"description" db:"description"`
+	Enabled     bool      `json:"enabled" db:"enabled"`
+	CreatedBy   string    `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// TransferLabel is the fraud outcome of a transfer, once known.
+type TransferLabel struct {
+	TransferID uuid.UUID `json:"transfer_id" db:"transfer_id"`
+	Label      string    `json:"label" db:"label"`
+	Note       string    `json:"note" db:"note"`
+	LabeledBy  string    `json:"labeled_by" db:"labeled_by"`
+	LabeledAt  time.Time `json:"labeled_at" db:"labeled_at"`
+	// the case that set the label by closing, nil if set by an analyst
+	CaseID *uuid.UUID `json:"case_id" db:"case_id"`
+}
+
+// Simulation is a stored replay of past transfers through a candidate
+// policy. The report is computed once, when the simulation is run.
+type Simulation struct {
+	ID        uuid.UUID         `json:"id" db:"id"`
+	Policy    SimulationPolicy  `json:"policy" db:"policy"`
+	Start     time.Time         `json:"start" db:"start_ts"`
+	End       time.Time         `json:"end" db:"end_ts"`
+	Report    *SimulationReport `json:"report" db:"report"`
+	CreatedBy string            `json:"created_by" db:"created_by"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+}
+
+// Case groups the users, cards and transfers of a fraud investigation.
+type Case struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Title       string    `json:"title" db:"title"`
+	Description string    `json:"description" db:"description"`
+	Status      string    `json:"status" db:"status"`
+	// superuser ID, "" if unassigned
+	Assignee  string     `json:"assignee" db:"assignee"`
+	CreatedBy string     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at" db:"closed_at"`
+}
+
+// CaseEntity is a user, card or transfer linked to a case.
+type CaseEntity struct {
+	CaseID   uuid.UUID `json:"case_id" db:"case_id"`
+	Kind     string    `json:"kind" db:"kind"`
+	EntityID string    `json:"entity_id" db:"entity_id"`
+	AddedBy  string    `json:"added_by" db:"added_by"`
+	AddedAt  time.Time `json:"added_at" db:"added_at"`
+}
+
+type CaseNote struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	CaseID    uuid.UUID `json:"case_id" db:"case_id"`
+	Body      string    `json:"body" db:"body"`
+	Author    string    `json:"author" db:"author"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CaseAttachment is a file attached to a case. Its content is kept in the
+// attachment store under StorageKey.
+type CaseAttachment struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	CaseID      uuid.UUID `json:"case_id" db:"case_id"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	StorageKey  string    `json:"storage_key" db:"storage_key"`
+	UploadedBy  string    `json:"uploaded_by" db:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// CaseEvent is an entry of a case's timeline.
+type CaseEvent struct {
+	ID        int64         `json:"id" db:"id"`
+	CaseID    uuid.UUID     `json:"case_id" db:"case_id"`
+	Type      string        `json:"type" db:"type"`
+	Actor     string        `json:"actor" db:"actor"`
+	Data      CaseEventData `json:"data" db:"data"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+}
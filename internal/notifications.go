@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+var (
+	notificationPollInterval time.Duration
+	notificationHeartbeat    time.Duration
+	notificationBatchSize    int
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	// streams also poll, in case a notification was missed
+	viper.SetDefault("NOTIFICATION_POLL_INTERVAL", 5*time.Second)
+	viper.SetDefault("NOTIFICATION_HEARTBEAT", 15*time.Second)
+	viper.SetDefault("NOTIFICATION_BATCH_SIZE", 100)
+	notificationPollInterval = viper.GetDuration("NOTIFICATION_POLL_INTERVAL")
+	notificationHeartbeat = viper.GetDuration("NOTIFICATION_HEARTBEAT")
+	notificationBatchSize = viper.GetInt("NOTIFICATION_BATCH_SIZE")
+}
+
+// userEventTypes are the events sent to the user of their key.
+var userEventTypes = map[string]bool{
+	EventTransferCreated:    true,
+	EventTransferApproved:   true,
+	EventTransferBlocked:    true,
+	EventTransferChallenged: true,
+	EventTransferExpired:    true,
+	EventTransferFailed:     true,
+	EventDeviceNewLogin:     true,
+	EventDeviceRevoked:      true,
+	EventCardBlocked:        true,
+}
+
+// userEventStore is where a notification stream reads the user's events.
+type userEventStore interface {
+	GetDevice(userId, deviceId string) (*Device, error)
+	LastEventID(key string) (int64, error)
+	ListEventsForKey(key string, afterID int64, limit int) ([]*OutboxEvent, error)
+}
+
+// anyEventKey is watched to be woken by the events of every key.
+const anyEventKey = ""
+
+// eventNotifier wakes the streams of a key when events of the key are
+// written, by this or another instance.
+type eventNotifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+var userEvents = &eventNotifier{waiters: make(map[string]map[chan struct{}]struct{})}
+
+// Watch returns a channel that receives after events of key are written.
+// Wakeups are coalesced; the stream reads everything new on each.
+func (n *eventNotifier) Watch(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.waiters[key] == nil {
+		n.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[key][ch] = struct{}{}
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.waiters[key], ch)
+		if len(n.waiters[key]) == 0 {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// Notify wakes the watchers of key and of anyEventKey.
+func (n *eventNotifier) Notify(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.waiters[key] {
+		wake(ch)
+	}
+	for ch := range n.waiters[anyEventKey] {
+		wake(ch)
+	}
+}
+
+func (n *eventNotifier) NotifyAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, chans := range n.waiters {
+		for ch := range chans {
+			wake(ch)
+		}
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// StartEventListener listens on outboxChannel over its own connection to dsn
+// and wakes the streams of the keys notified, until ctx is done. Without it
+// streams still get their events, on their next poll.
+func StartEventListener(ctx context.Context, dsn string) error {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("event listener: %v", err)
+		}
+	})
+	if err := l.Listen(outboxChannel); err != nil {
+		l.Close()
+		return err
+	}
+
+	go func() {
+		defer l.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-l.Notify:
+				if n == nil {
+					// reconnected: notifications may have been lost
+					userEvents.NotifyAll()
+					continue
+				}
+				userEvents.Notify(n.Extra)
+			}
+		}
+	}()
+	return nil
+}
+
+// ListEventsForKey returns up to limit events of key after the event
+// afterID, oldest first. The ids of a key follow commit order, so nothing
+// committed later can show up before afterID.
+func (db *DB) ListEventsForKey(key string, afterID int64, limit int) ([]*OutboxEvent, error) {
+	out := make([]*OutboxEvent, 0)
+	err := db.conn.Select(&out, `SELECT id, event_id, type, key, payload, created_at FROM outbox_events
+		WHERE key=$1 AND id > $2 ORDER BY id LIMIT $3`, key, afterID, limit)
+	return out, err
+}
+
+// LastEventID returns the id of the last event of key, or 0 if there is none.
+func (db *DB) LastEventID(key string) (int64, error) {
+	var id int64
+	err := db.conn.Get(&id, `SELECT COALESCE(MAX(id), 0) FROM outbox_events WHERE key=$1`, key)
+	return id, err
+}
+
+// writeSSE writes a server-sent event. id is left out when empty.
+func writeSSE(w io.Writer, id, event string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"antifraud-demo-backend/internal/auth"
+)
+
+func TestEventNotifier(t *testing.T) {
+	n := &eventNotifier{waiters: make(map[string]map[chan struct{}]struct{})}
+	alice, stopAlice := n.Watch("alice")
+	bob, stopBob := n.Watch("bob")
+	defer stopBob()
+
+	// wakeups are coalesced
+	n.Notify("alice")
+	n.Notify("alice")
+	if len(alice) != 1 || len(bob) != 0 {
+		t.Fatalf("alice %d, bob %d wakeups", len(alice), len(bob))
+	}
+	<-alice
+
+	n.NotifyAll()
+	if len(alice) != 1 || len(bob) != 1 {
+		t.Fatalf("notify all: alice %d, bob %d wakeups", len(alice), len(bob))
+	}
+	<-alice
+
+	stopAlice()
+	n.Notify("alice")
+	if len(alice) != 0 {
+		t.Error("stopped watcher woken")
+	}
+	if _, ok := n.waiters["alice"]; ok {
+		t.Error("key without watchers kept")
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	var b strings.Builder
+	writeSSE(&b, "42", EventTransferApproved, []byte(`{"id":"t-1"}`))
+	writeSSE(&b, "", "close", []byte(`{"reason":"token_expired"}`))
+	want := "id: 42\nevent: transfer.approved\ndata: {\"id\":\"t-1\"}\n\n" +
+		"event: close\ndata: {\"reason\":\"token_expired\"}\n\n"
+	if b.String() != want {
+		t.Errorf("got %q", b.String())
+	}
+}
+
+// memUserEvents is a userEventStore of one user's events.
+type memUserEvents struct {
+	mu     sync.Mutex
+	device *Device
+	events []*OutboxEvent
+}
+
+func (s *memUserEvents) GetDevice(userId, deviceId string) (*Device, error) {
+	if s.device == nil || s.device.DeviceID != deviceId {
+		return nil, sql.ErrNoRows
+	}
+	return s.device, nil
+}
+
+func (s *memUserEvents) LastEventID(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.events)), nil
+}
+
+func (s *memUserEvents) ListEventsForKey(key string, afterID int64, limit int) ([]*OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*OutboxEvent, 0)
+	for _, e := range s.events {
+		if e.Key == key && e.ID > afterID && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s *memUserEvents) add(typ, key string, payload any) {
+	b, _ := json.Marshal(payload)
+	s.mu.Lock()
+	s.events = append(s.events, &OutboxEvent{ID: int64(len(s.events) + 1), Type: typ, Key: key, Payload: b})
+	s.mu.Unlock()
+	userEvents.Notify(key)
+}
+
+func notificationRequest(claims *auth.JwtClaims, lastEventID string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/notifications/stream", nil)
+	if lastEventID != "" {
+		r.Header.Set("Last-Event-ID", lastEventID)
+	}
+	return r.WithContext(context.WithValue(r.Context(), auth.CtxKeyClaims, claims))
+}
+
+// sseEvents returns the event lines of an event stream, in order.
+func sseEvents(body string) []string {
+	out := make([]string, 0)
+	for line := range strings.SplitSeq(body, "\n") {
+		if strings.HasPrefix(line, "event: ") || strings.HasPrefix(line, "data: {\"reason\"") {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func TestNotificationStreamClosesOnTokenExpiry(t *testing.T) {
+	store := &memUserEvents{}
+	store.add(EventCardBlocked, "alice", CardDTO{Status: string(CardBlocked)})
+
+	claims := &auth.JwtClaims{UserId: "alice", ExpiresAt: time.Now().Add(time.Second).Unix()}
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamNotifications(w, notificationRequest(claims, "0"), store)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed after the token expired")
+	}
+
+	want := []string{"event: card.blocked", "event: close", `data: {"reason":"token_expired"}`}
+	if got := sseEvents(w.Body.String()); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNotificationStreamClosesOnDeviceRevoked(t *testing.T) {
+	phone := &Device{DeviceID: "phone", UserID: "alice"}
+	tablet := &Device{DeviceID: "tablet", UserID: "alice"}
+	store := &memUserEvents{device: phone}
+	store.add(EventTransferApproved, "alice", TransferDTO{})
+	store.add(EventTransferApproved, "bob", TransferDTO{})
+
+	claims := &auth.JwtClaims{UserId: "alice", DeviceID: "phone", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamNotifications(w, notificationRequest(claims, ""), store)
+	}()
+
+	// revoking another device does not close the stream
+	time.Sleep(50 * time.Millisecond)
+	store.add(EventDeviceRevoked, "alice", deviceEventToDTO(tablet, nil))
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("stream closed when another device was revoked")
+	default:
+	}
+
+	now := time.Now()
+	phone.RevokedAt = &now
+	store.add(EventDeviceRevoked, "alice", deviceEventToDTO(phone, nil))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed after its device was revoked")
+	}
+
+	// the events before the stream opened are not sent
+	want := []string{"event: device.revoked", "event: device.revoked", "event: close", `data: {"reason":"device_revoked"}`}
+	if got := sseEvents(w.Body.String()); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// and it cannot be opened again
+	w = httptest.NewRecorder()
+	streamNotifications(w, notificationRequest(claims, "0"), store)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("revoked device: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
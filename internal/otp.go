@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// OTPMessage is a one-time code to deliver to a user.
+type OTPMessage struct {
+	UserID      string    `json:"user_id"`
+	TransferID  string    `json:"transfer_id"`
+	ChallengeID string    `json:"challenge_id"`
+	Code        string    `json:"code"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// OTPSender delivers one-time codes (SMS, push, ...).
+type OTPSender interface {
+	SendOTP(ctx context.Context, m OTPMessage) error
+}
+
+// LogOTPSender writes codes to the server log, for local development.
+type LogOTPSender struct{}
+
+func (LogOTPSender) SendOTP(_ context.Context, m OTPMessage) error {
+	log.Printf("OTP for user %s, transfer %s: %s (expires %s)", m.UserID, m.TransferID, m.Code, m.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// FileOTPSender appends codes as JSON lines to a file, so that tests and
+// demos can read them back.
+type FileOTPSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileOTPSender) SendOTP(_ context.Context, m OTPMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NewOTPSender returns the sender of the given kind: "log" or "file"
+// (writing to path).
+func NewOTPSender(kind, path string) (OTPSender, error) {
+	switch kind {
+	case "", "log":
+		return LogOTPSender{}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file OTP sender needs a path")
+		}
+		return &FileOTPSender{Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown OTP sender %q", kind)
+}
+
+var otpSender OTPSender = LogOTPSender{}
+
+func SetOTPSender(s OTPSender) { otpSender = s }
+
+// newOTPCode returns a random 6-digit code.
+func newOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileOTPSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "otp.jsonl")
+	s, err := NewOTPSender("file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Date(2025, 11, 24, 12, 5, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			m := OTPMessage{UserID: "alice", TransferID: fmt.Sprint(i), ChallengeID: "c", Code: fmt.Sprintf("%06d", i), ExpiresAt: expiresAt}
+			if err := s.SendOTP(context.Background(), m); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m OTPMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		i, err := strconv.Atoi(m.TransferID)
+		if err != nil || m.UserID != "alice" || !m.ExpiresAt.Equal(expiresAt) || m.Code != fmt.Sprintf("%06d", i) {
+			t.Errorf("got %+v", m)
+		}
+		seen[m.TransferID] = true
+	}
+	if len(seen) != 20 {
+		t.Errorf("got %d codes", len(seen))
+	}
+}
+
+func TestNewOTPSender(t *testing.T) {
+	for _, kind := range []string{"", "log"} {
+		if s, err := NewOTPSender(kind, ""); err != nil || s != (LogOTPSender{}) {
+			t.Errorf("%q: got %v, %v", kind, s, err)
+		}
+	}
+	if _, err := NewOTPSender("file", ""); err == nil {
+		t.Error("file sender without a path")
+	}
+	if _, err := NewOTPSender("sms", ""); err == nil {
+		t.Error("unknown sender")
+	}
+}
+
+func TestNewOTPCode(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9]{6}$`)
+	seen := make(map[string]bool)
+	for range 100 {
+		code, err := newOTPCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("got %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 90 {
+		t.Errorf("%d distinct codes of 100", len(seen))
+	}
+}
@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// Event types.
+const (
+	EventTransferCreated    = "transfer.created"
+	EventTransferApproved   = "transfer.approved"
+	EventTransferBlocked    = "transfer.blocked"
+	EventTransferChallenged = "transfer.challenged"
+	EventTransferExpired    = "transfer.expired"
+	EventTransferFailed     = "transfer.failed"
+
+	EventDeviceNewLogin = "device.new_login"
+	EventDeviceRevoked  = "device.revoked"
+
+	EventCardBlocked = "card.blocked"
+	EventUserBlocked = "user.blocked"
+)
+
+var eventTypes = []string{
+	EventTransferCreated,
+	EventTransferApproved,
+	EventTransferBlocked,
+	EventTransferChallenged,
+	EventTransferExpired,
+	EventTransferFailed,
+	EventDeviceNewLogin,
+	EventDeviceRevoked,
+	EventCardBlocked,
+	EventUserBlocked,
+}
+
+var (
+	outboxPollInterval time.Duration
+	outboxBatchSize    int
+	outboxClaimLease   time.Duration
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	viper.SetDefault("OUTBOX_POLL_INTERVAL", time.Second)
+	viper.SetDefault("OUTBOX_BATCH_SIZE", 100)
+	// must outlast the publication of a batch
+	viper.SetDefault("OUTBOX_CLAIM_LEASE", time.Minute)
+	outboxPollInterval = viper.GetDuration("OUTBOX_POLL_INTERVAL")
+	outboxBatchSize = viper.GetInt("OUTBOX_BATCH_SIZE")
+	outboxClaimLease = viper.GetDuration("OUTBOX_CLAIM_LEASE")
+}
+
+// Advisory lock keys. The relay lock serializes the claims of relays across
+// instances; the key locks (two-key form, a separate key space) serialize
+// the event writes of one key.
+const (
+	outboxRelayLock = 0x6f7574626f78
+	outboxKeyLock   = 0x6f7462
+)
+
+// outboxChannel is notified with the key of every event written, on commit.
+const outboxChannel = "outbox_events"
+
+type OutboxEvent struct {
+	ID        int64     `db:"id"`
+	EventID   uuid.UUID `db:"event_id"`
+	Type      string    `db:"type"`
+	Key       string    `db:"key"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// addEvent writes an event to the outbox within tx, along with its deliveries
+// to the webhooks subscribed to its type, and notifies outboxChannel. The key
+// lock is held until tx ends, so a later event of the key cannot get a
+// smaller id and still be committed after this one.
+func addEvent(tx *sqlx.Tx, typ, key string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, outboxKeyLock, key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, outboxChannel, key); err != nil {
+		return err
+	}
+	var id int64
+	if err := tx.Get(&id, `INSERT INTO outbox_events (type, key, payload) VALUES ($1, $2, $3) RETURNING id`, typ, key, b); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO webhook_deliveries (subscription_id, event_id)
+		SELECT id, $1 FROM webhook_subscriptions WHERE active AND $2 = ANY(event_types)`, id, typ)
+	return err
+}
+
+// transferEventType is the event of a transfer entering its status.
+func transferEventType(t *Transfer) string {
+	switch t.Status {
+	case TransferPending:
+		return EventTransferCreated
+	case TransferCompleted:
+		return EventTransferApproved
+	case TransferBlocked, TransferChallengeFailed:
+		return EventTransferBlocked
+	case TransferChallengeRequired:
+		return EventTransferChallenged
+	case TransferChallengeExpired:
+		return EventTransferExpired
+	}
+	return EventTransferFailed
+}
+
+// addTransferEvent writes the event of t's status, with t as its sender
+// sees it.
+func addTransferEvent(tx *sqlx.Tx, t *Transfer) error {
+	return addEvent(tx, transferEventType(t), t.FromUserID, transferToDTO(t))
+}
+
+// RelayOutbox publishes up to limit unpublished events to sink, oldest
+// first, and returns how many it claimed. The events are claimed for lease
+// and published outside of any transaction. An event is marked published
+// only after the sink has it; a crash in between publishes it again once
+// the claim runs out.
+func (db *DB) RelayOutbox(ctx context.Context, sink EventSink, limit int, lease time.Duration) (int, error) {
+	events, err := db.ClaimOutboxEvents(limit, lease)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	published, pubErr := publishEvents(ctx, sink, events)
+	if err := db.settleOutboxEvents(events, published); err != nil {
+		return 0, err
+	}
+	return len(events), pubErr
+}
+
+// ClaimOutboxEvents claims up to limit unpublished events for lease, oldest
+// first. An event is not claimed while an earlier event of its key is
+// claimed by another relay, so that relays running at once keep each key in
+// order.
+func (db *DB) ClaimOutboxEvents(limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// claims see each other's
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, outboxRelayLock); err != nil {
+		return nil, err
+	}
+	events := make([]*OutboxEvent, 0)
+	err = tx.Select(&events, `UPDATE outbox_events SET claimed_until = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT e.id FROM outbox_events e
+			WHERE e.published_at IS NULL AND (e.claimed_until IS NULL OR e.claimed_until <= now())
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox_events p
+					WHERE p.key = e.key AND p.id < e.id AND p.published_at IS NULL AND p.claimed_until > now()
+				)
+			ORDER BY e.id LIMIT $1
+		)
+		RETURNING id, event_id, type, key, payload, created_at`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// settleOutboxEvents marks the published events of a claim published and
+// releases the others, to be claimed again.
+func (db *DB) settleOutboxEvents(claimed []*OutboxEvent, published []int64) error {
+	ids := make([]int64, len(claimed))
+	for i, e := range claimed {
+		ids[i] = e.ID
+	}
+	_, err := db.conn.Exec(`UPDATE outbox_events
+		SET published_at = CASE WHEN id = ANY($2) THEN now() END, claimed_until = NULL
+		WHERE id = ANY($1)`, pq.Array(ids), pq.Array(published))
+	return err
+}
+
+// publishEvents publishes events in order and returns the ids of those
+// published. After a failure the later events of the same key are held
+// back, to keep each key in order; other keys go on.
+func publishEvents(ctx context.Context, sink EventSink, events []*OutboxEvent) ([]int64, error) {
+	published := make([]int64, 0, len(events))
+	failed := make(map[string]bool)
+	var firstErr error
+	for _, e := range events {
+		if failed[e.Key] {
+			continue
+		}
+		err := sink.Publish(ctx, Event{
+			ID:        e.EventID.String(),
+			Type:      e.Type,
+			Key:       e.Key,
+			Payload:   json.RawMessage(e.Payload),
+			CreatedAt: e.CreatedAt,
+		})
+		if err != nil {
+			failed[e.Key] = true
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		published = append(published, e.ID)
+	}
+	return published, firstErr
+}
+
+// StartOutboxRelay publishes outbox events to sink until ctx is done.
+func StartOutboxRelay(ctx context.Context, sink EventSink) {
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			// a full batch means there may be more
+			for ctx.Err() == nil {
+				n, err := dbClient.RelayOutbox(ctx, sink, outboxBatchSize, outboxClaimLease)
+				if err != nil {
+					log.Printf("failed to relay outbox events: %v", err)
+					break
+				}
+				if n < outboxBatchSize {
+					break
+				}
+			}
+			timer.Reset(outboxPollInterval)
+		}
+	}()
+}
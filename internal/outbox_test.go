@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func outboxEvents(keys ...string) []*OutboxEvent {
+	out := make([]*OutboxEvent, len(keys))
+	for i, k := range keys {
+		out[i] = &OutboxEvent{ID: int64(i + 1), EventID: uuid.New(), Type: EventTransferCreated, Key: k, Payload: []byte(`{}`)}
+	}
+	return out
+}
+
+func TestPublishEventsKeepsKeysInOrder(t *testing.T) {
+	events := outboxEvents("alice", "bob", "alice", "carol", "bob", "alice")
+
+	// the second event of alice fails once
+	failAlice := true
+	sink := &MemoryEventSink{Fail: func(e Event) error {
+		if e.ID == events[2].EventID.String() && failAlice {
+			failAlice = false
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+
+	published, err := publishEvents(context.Background(), sink, events)
+	if err == nil {
+		t.Fatal("expected the publication error")
+	}
+	// alice's later events are held back, bob and carol go on
+	if want := []int64{1, 2, 4, 5}; !equalIDs(published, want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+
+	// the next run starts from the first unpublished event
+	published, err = publishEvents(context.Background(), sink, []*OutboxEvent{events[2], events[5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{3, 6}; !equalIDs(published, want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+
+	var alice []string
+	for _, e := range sink.Events() {
+		if e.Key == "alice" {
+			alice = append(alice, e.ID)
+		}
+	}
+	want := []string{events[0].EventID.String(), events[2].EventID.String(), events[5].EventID.String()}
+	if strings.Join(alice, ",") != strings.Join(want, ",") {
+		t.Errorf("alice's events delivered as %v, want %v", alice, want)
+	}
+}
+
+func TestFileEventSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := NewEventSink("file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := publishEvents(context.Background(), sink, outboxEvents("alice", "bob")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"key":"alice"`) || !strings.Contains(lines[1], `"payload":{}`) {
+		t.Errorf("unexpected file content:\n%s", b)
+	}
+}
+
+func TestTransferEventType(t *testing.T) {
+	cases := map[string]string{
+		TransferPending:           EventTransferCreated,
+		TransferCompleted:         EventTransferApproved,
+		TransferBlocked:           EventTransferBlocked,
+		TransferChallengeRequired: EventTransferChallenged,
+		TransferChallengeFailed:   EventTransferBlocked,
+		TransferChallengeExpired:  EventTransferExpired,
+		TransferFailed:            EventTransferFailed,
+	}
+	for status, want := range cases {
+		if got := transferEventType(&Transfer{Status: status}); got != want {
+			t.Errorf("%s: got %s, want %s", status, got, want)
+		}
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func addTestEvents(t *testing.T, db *DB, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		tx, err := db.conn.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := addEvent(tx, EventTransferCreated, k, map[string]string{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func eventKeys(events []*OutboxEvent) string {
+	keys := make([]string, len(events))
+	for i, e := range events {
+		keys[i] = e.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestRelayOutboxClaims(t *testing.T) {
+	db := openTestDB(t)
+	addTestEvents(t, db, "alice", "bob", "alice")
+
+	first, err := db.ClaimOutboxEvents(1, time.Minute)
+	if err != nil || eventKeys(first) != "alice" {
+		t.Fatalf("got %v, %v", eventKeys(first), err)
+	}
+	// alice's second event waits for her first
+	second, err := db.ClaimOutboxEvents(10, time.Minute)
+	if err != nil || eventKeys(second) != "bob" {
+		t.Fatalf("got %v, %v", eventKeys(second), err)
+	}
+	sink := &MemoryEventSink{}
+	if n, err := db.RelayOutbox(context.Background(), sink, 10, time.Minute); err != nil || n != 0 {
+		t.Fatalf("relayed %d claimed events, %v", n, err)
+	}
+
+	// the first claim is published, the second given up
+	if err := db.settleOutboxEvents(first, []int64{first[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.settleOutboxEvents(second, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RelayOutbox(context.Background(), sink, 10, time.Minute); err != nil || n != 2 {
+		t.Fatalf("relayed %d, %v", n, err)
+	}
+	var keys []string
+	for _, e := range sink.Events() {
+		keys = append(keys, e.Key)
+	}
+	if strings.Join(keys, ",") != "bob,alice" {
+		t.Errorf("published %v", keys)
+	}
+
+	// a relay that died holds its claim until the lease runs out
+	addTestEvents(t, db, "carol")
+	if lost, err := db.ClaimOutboxEvents(10, 50*time.Millisecond); err != nil || eventKeys(lost) != "carol" {
+		t.Fatalf("got %v, %v", eventKeys(lost), err)
+	}
+	if n, _ := db.RelayOutbox(context.Background(), sink, 10, time.Minute); n != 0 {
+		t.Fatalf("relayed %d claimed events", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n, err := db.RelayOutbox(context.Background(), sink, 10, time.Minute); err != nil || n != 1 {
+		t.Fatalf("relayed %d, %v", n, err)
+	}
+	if n, _ := db.RelayOutbox(context.Background(), sink, 10, time.Minute); n != 0 {
+		t.Errorf("relayed %d published events again", n)
+	}
+}
+
+func TestUpdateUserBlockedEvent(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.conn.Exec(`INSERT INTO users (id, first_name, last_name, status) VALUES ('mallory', 'M', 'T', 'active')`); err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.GetUserByID("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Status = StatusBlocked
+	for range 2 {
+		if err := db.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := db.ListEventsForKey("mallory", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventUserBlocked {
+		t.Errorf("got %d events", len(events))
+	}
+	if err := db.UpdateUser(&User{ID: "nobody", Status: StatusBlocked}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown user: got %v", err)
+	}
+}
+
+func TestListStreamEventsHoldsBackRunningTransactions(t *testing.T) {
+	db := openTestDB(t)
+	head, err := db.TransferStreamHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := db.conn.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Rollback()
+	if err := addEvent(running, EventTransferBlocked, "first", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addEvent(tx, EventTransferBlocked, "second", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.ListStreamEvents(transferStreamEventTypes, head, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("got %d events while an older transaction is running", len(events))
+	}
+
+	if err := running.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.ListStreamEvents(transferStreamEventTypes, head, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, len(events))
+	for i, e := range events {
+		keys[i] = e.Key
+	}
+	if got := strings.Join(keys, ","); got != "first,second" {
+		t.Errorf("got %s", got)
+	}
+}
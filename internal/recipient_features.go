@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"time"
+)
+
+// Outbound transfers made within this window after an inbound one count as
+// forwarding the received funds.
+const quickForwardWindow = time.Hour
+
+type RecipientActivity struct {
+	// Transfers to and from the recipient card of at least the last 30 days.
+	Inbound  []*Transfer
+	Outbound []*Transfer
+	// Creation time of the card owner's account, nil if unknown.
+	AccountCreatedAt *time.Time
+}
+
+// ComputeRecipientFeatures describes the destination card of a transfer made
+// at transdate. The features target money mules: cards receiving from many
+// unrelated senders and passing the money on right away.
+func ComputeRecipientFeatures(a *RecipientActivity, transdate time.Time) RecipientFeatures {
+	var f RecipientFeatures
+
+	cutoff1h := transdate.Add(-time.Hour)
+	cutoff24h := transdate.Add(-24 * time.Hour)
+	cutoff7 := transdate.Add(-7 * 24 * time.Hour)
+	cutoff30 := transdate.Add(-30 * 24 * time.Hour)
+
+	senders24h := make(map[string]struct{})
+	senders7d := make(map[string]struct{})
+	var inbound30, blocked30 int
+	received7d := make([]time.Time, 0)
+	for _, t := range a.Inbound {
+		if t.When.After(transdate) || t.When.Before(cutoff30) {
+			continue
+		}
+
+		inbound30++
+		if t.IsBlocked {
+			blocked30++
+			continue
+		}
+		if !t.Executed() {
+			continue
+		}
+
+		if !t.When.Before(cutoff7) {
+			senders7d[t.FromUserID] = struct{}{}
+			received7d = append(received7d, t.When)
+		}
+		if !t.When.Before(cutoff24h) {
+			senders24h[t.FromUserID] = struct{}{}
+			f.RecipientInbound24h++
+			f.RecipientInboundSum24h += t.Amount
+		}
+		if !t.When.Before(cutoff1h) {
+			f.RecipientInbound1h++
+		}
+	}
+
+	f.RecipientDistinctSenders24h = len(senders24h)
+	f.RecipientDistinctSenders7d = len(senders7d)
+	if inbound30 > 0 {
+		f.RecipientBlockedInboundShare30d = float64(blocked30) / float64(inbound30)
+	}
+
+	// -1 means the account age is unknown
+	f.RecipientAccountAgeDays = -1
+	if a.AccountCreatedAt != nil {
+		f.RecipientAccountAgeDays = max(transdate.Sub(*a.AccountCreatedAt).Hours()/24, 0)
+	}
+
+	if len(received7d) > 0 {
+		forwarded := 0
+		for _, in := range received7d {
+			for _, out := range a.Outbound {
+				if !out.Executed() || out.When.After(transdate) {
+					continue
+				}
+				if !out.When.Before(in) && out.When.Sub(in) <= quickForwardWindow {
+					forwarded++
+					break
+				}
+			}
+		}
+		f.RecipientQuickForwardShare7d = float64(forwarded) / float64(len(received7d))
+	}
+
+	return f
+}
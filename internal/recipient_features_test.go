@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecipientAccountAge(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-36 * time.Hour)
+	later := now.Add(time.Hour)
+	cases := []struct {
+		createdAt *time.Time
+		want      float64
+	}{
+		{&created, 1.5},
+		{&later, 0},
+		// accounts loaded before creation times were recorded
+		{nil, -1},
+	}
+	for i, c := range cases {
+		f := ComputeRecipientFeatures(&RecipientActivity{AccountCreatedAt: c.createdAt}, now)
+		if f.RecipientAccountAgeDays != c.want {
+			t.Errorf("case %d: got %v, want %v", i, f.RecipientAccountAgeDays, c.want)
+		}
+	}
+}
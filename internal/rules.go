@@ -0,0 +1,452 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Rule actions. Rules are evaluated after the model and only ever tighten
+// its decision: a block hit blocks the transfer, a challenge hit challenges
+// a transfer the model approved.
+const (
+	RuleBlock     = "block"
+	RuleChallenge = "challenge"
+)
+
+var ErrRuleExists = errors.New("rule already exists")
+
+// ruleContextVars are the variables of a rule that are not model features.
+var ruleContextVars = map[string]ruleType{
+	// fraud probability of the model that decided
+	"score":            ruleNumber,
+	"direction_is_new": ruleBool,
+	// new and not trusted by the user
+	"device_is_new": ruleBool,
+	"country":       ruleString,
+	"city":          ruleString,
+	// UTC hour the transfer was submitted, 0 to 23
+	"hour": ruleNumber,
+}
+
+// ruleFeatures are the features rules can read: the latest version of every
+// registered feature, by its plain name.
+var ruleFeatures = sync.OnceValue(func() []FeatureDef {
+	out := make([]FeatureDef, 0, len(featureRegistry))
+	for _, versions := range featureRegistry {
+		var latest FeatureDef
+		for _, def := range versions {
+			if def.Version > latest.Version {
+				latest = def
+			}
+		}
+		out = append(out, latest)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+})
+
+// ruleVariables returns the variables of the rule language and their types.
+var ruleVariables = sync.OnceValue(func() map[string]ruleType {
+	vars := make(map[string]ruleType)
+	for _, def := range ruleFeatures() {
+		if _, ok := def.Extract(&ModelFeatures{}).(string); ok {
+			vars[def.Name] = ruleString
+		} else {
+			vars[def.Name] = ruleNumber
+		}
+	}
+	for name, t := range ruleContextVars {
+		vars[name] = t
+	}
+	return vars
+})
+
+// RuleVariable is a variable rules can read.
+type RuleVariable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// RuleVariables lists the variables of the rule language by name.
+func RuleVariables() []RuleVariable {
+	vars := ruleVariables()
+	out := make([]RuleVariable, 0, len(vars))
+	for name, t := range vars {
+		out = append(out, RuleVariable{Name: name, Type: t.String()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// RuleInput is what rules are evaluated against: a transfer, the features
+// it was scored on and the score of the model that decided it.
+type RuleInput struct {
+	Transfer *Transfer
+	Features *ModelFeatures
+	Score    float64
+}
+
+func (in *RuleInput) env() map[string]any {
+	env := make(map[string]any, len(ruleFeatures())+len(ruleContextVars))
+	for _, def := range ruleFeatures() {
+		switch v := def.Extract(in.Features).(type) {
+		case int:
+			env[def.Name] = float64(v)
+		case float64, string:
+			env[def.Name] = v
+		}
+	}
+	t := in.Transfer
+	env["score"] = in.Score
+	env["direction_is_new"] = in.Features.IsNewDestination == 1
+	env["device_is_new"] = t.IsNewDevice
+	env["country"] = t.Country
+	env["city"] = t.City
+	env["hour"] = float64(t.When.UTC().Hour())
+	return env
+}
+
+// CompileRuleExpression compiles a rule expression against the rule
+// variables.
+func CompileRuleExpression(src string) (*RuleExpr, error) {
+	return CompileRule(src, ruleVariables())
+}
+
+// validateRule checks r and trims its name.
+func validateRule(r *Rule) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("missing name")
+	}
+	if r.Action != RuleBlock && r.Action != RuleChallenge {
+		return errors.New("action must be block or challenge")
+	}
+	if _, err := CompileRuleExpression(r.Expression); err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+	return nil
+}
+
+// RuleHit is a rule a transfer matched, at the version it matched.
+type RuleHit struct {
+	RuleID  uuid.UUID `json:"rule_id"`
+	Name    string    `json:"name"`
+	Version int       `json:"version"`
+	Action  string    `json:"action"`
+}
+
+// RuleHits are stored with the transfer as JSON; NULL if there are none.
+type RuleHits []RuleHit
+
+func (h RuleHits) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(h)
+}
+
+func (h *RuleHits) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	}
+	return fmt.Errorf("RuleHits: unsupported type %T", src)
+}
+
+// Decision returns the strictest action of the hits, block before
+// challenge, and "" without hits.
+func (h RuleHits) Decision() string {
+	decision := ""
+	for _, hit := range h {
+		if hit.Action == RuleBlock {
+			return RuleBlock
+		}
+		decision = RuleChallenge
+	}
+	return decision
+}
+
+// applyRuleDecision tightens status, the model's decision, with a rule
+// decision.
+func applyRuleDecision(status, decision string) string {
+	switch {
+	case decision == RuleBlock:
+		return TransferBlocked
+	case decision == RuleChallenge && status == TransferCompleted:
+		return TransferChallengeRequired
+	}
+	return status
+}
+
+// compiledRule is the compiled expression of a rule at a version; a
+// version is never edited.
+type compiledRule struct {
+	version int
+	expr    *RuleExpr
+	err     error
+}
+
+// compiledRules caches the compiled expression of the current version of
+// every stored rule, by rule ID.
+var (
+	compiledRulesMu sync.Mutex
+	compiledRules   = make(map[uuid.UUID]compiledRule)
+)
+
+// compile returns the compiled expression of r. Stored rules are compiled
+// once per version, when saved or loaded, and a new version replaces the
+// previous one in the cache; a rule that is not stored yet is compiled once
+// per *Rule.
+func (r *Rule) compile() (*RuleExpr, error) {
+	if r.expr != nil {
+		return r.expr, nil
+	}
+	if r.ID == uuid.Nil {
+		expr, err := CompileRuleExpression(r.Expression)
+		r.expr = expr
+		return expr, err
+	}
+
+	compiledRulesMu.Lock()
+	c, ok := compiledRules[r.ID]
+	if !ok || c.version != r.Version {
+		cached := c
+		c = compiledRule{version: r.Version}
+		c.expr, c.err = CompileRuleExpression(r.Expression)
+		// a *Rule loaded before an update does not replace the newer version
+		if r.Version > cached.version {
+			compiledRules[r.ID] = c
+		}
+	}
+	compiledRulesMu.Unlock()
+	r.expr = c.expr
+	return c.expr, c.err
+}
+
+// MatchRules evaluates rules against in. A rule that does not compile or
+// fails on in is skipped, and its error returned along with the hits.
+func MatchRules(rules []*Rule, in *RuleInput) (RuleHits, error) {
+	env := in.env()
+	hits := make(RuleHits, 0)
+	var errs []error
+	for _, r := range rules {
+		expr, err := r.compile()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s v%d: %w", r.Name, r.Version, err))
+			continue
+		}
+		ok, err := expr.Eval(env)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s v%d: %w", r.Name, r.Version, err))
+			continue
+		}
+		if ok {
+			hits = append(hits, RuleHit{RuleID: r.ID, Name: r.Name, Version: r.Version, Action: r.Action})
+		}
+	}
+	return hits, errors.Join(errs...)
+}
+
+// ReplayTransfer is a past transfer replayed by a dry run or a simulation.
+type ReplayTransfer struct {
+	Transfer
+	// a challenge was sent for the transfer, whatever the user answered
+	Challenged bool `db:"challenged"`
+}
+
+// replayColumns selects a ReplayTransfer from transfers.
+const replayColumns = transferColumns + `,
+	EXISTS (SELECT 1 FROM transfer_challenges c WHERE c.transfer_id = transfers.id) AS challenged`
+
+// decidedStatus is the decision taken on a settled transfer, before the
+// user answered a challenge. A confirmed challenge completes the transfer,
+// so the status alone does not tell it from one approved outright.
+func decidedStatus(t *ReplayTransfer) string {
+	if t.Challenged {
+		return TransferChallengeRequired
+	}
+	return t.Status
+}
+
+// RuleDryRun is how a rule would have decided on past transfers.
+type RuleDryRun struct {
+	Evaluated int
+	// transfers scored before their features were stored, not evaluated
+	Skipped       int
+	Matched       int
+	MatchedAmount float64
+	// matched transfers whose decision the rule would have changed
+	Changed int
+	Errors  int
+	Samples []RuleDryRunSample
+}
+
+// RuleDryRunSample is a past transfer matched by a rule.
+type RuleDryRunSample struct {
+	Transfer *Transfer
+	Status   string
+	WouldBe  string
+}
+
+// maxRuleDryRunSamples bounds the matched transfers returned by a dry run.
+const maxRuleDryRunSamples = 50
+
+// DryRunRule evaluates expr with action against transfers, on the features
+// they were scored on. Nothing is stored.
+func DryRunRule(expr *RuleExpr, action string, transfers []*ReplayTransfer) *RuleDryRun {
+	out := &RuleDryRun{Samples: make([]RuleDryRunSample, 0)}
+	for _, rt := range transfers {
+		t := &rt.Transfer
+		if t.Features == nil {
+			out.Skipped++
+			continue
+		}
+		out.Evaluated++
+		in := &RuleInput{Transfer: t, Features: t.Features, Score: t.FraudScore}
+		ok, err := expr.Eval(in.env())
+		if err != nil {
+			out.Errors++
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		out.Matched++
+		out.MatchedAmount += t.Amount
+		status := decidedStatus(rt)
+		wouldBe := applyRuleDecision(status, action)
+		if wouldBe != status {
+			out.Changed++
+		}
+		if len(out.Samples) < maxRuleDryRunSamples {
+			out.Samples = append(out.Samples, RuleDryRunSample{Transfer: t, Status: status, WouldBe: wouldBe})
+		}
+	}
+	return out
+}
+
+const ruleColumns = `id, name, version, expression, action, description, enabled, created_by, created_at, updated_at`
+
+// CreateRule stores r as version 1 of a new rule and writes the stored row
+// back into r. It returns ErrRuleExists if the name is taken.
+func (db *DB) CreateRule(r *Rule) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(r, `INSERT INTO rules (name, version, expression, action, description, enabled, created_by)
+		VALUES ($1, 1, $2, $3, $4, $5, $6)
+		RETURNING `+ruleColumns, r.Name, r.Expression, r.Action, r.Description, r.Enabled, r.CreatedBy)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrRuleExists
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertRuleVersion(tx, r, r.CreatedBy); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.compile()
+	return nil
+}
+
+// UpdateRule saves r as the next version of its rule, by the superuser by,
+// and writes the stored row back into r. It returns sql.ErrNoRows if there
+// is no such rule.
+func (db *DB) UpdateRule(r *Rule, by string) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(r, `UPDATE rules
+		SET version = version + 1, expression = $2, action = $3, description = $4, enabled = $5, updated_at = now()
+		WHERE id = $1
+		RETURNING `+ruleColumns, r.ID, r.Expression, r.Action, r.Description, r.Enabled)
+	if err != nil {
+		return err
+	}
+	if err := insertRuleVersion(tx, r, by); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// the expression may have been compiled at the previous version
+	r.expr = nil
+	r.compile()
+	return nil
+}
+
+func insertRuleVersion(tx *sqlx.Tx, r *Rule, by string) error {
+	_, err := tx.Exec(`INSERT INTO rule_versions (rule_id, version, expression, action, description, enabled, created_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`, r.ID, r.Version, r.Expression, r.Action, r.Description, r.Enabled, by)
+	return err
+}
+
+func (db *DB) GetRule(id uuid.UUID) (*Rule, error) {
+	var r Rule
+	err := db.conn.Get(&r, `SELECT `+ruleColumns+` FROM rules WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListRules lists the rules by name, only the enabled ones with
+// enabledOnly.
+func (db *DB) ListRules(enabledOnly bool) ([]*Rule, error) {
+	out := make([]*Rule, 0)
+	err := db.conn.Select(&out, `SELECT `+ruleColumns+` FROM rules WHERE enabled OR NOT $1 ORDER BY name`, enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	// a rule that does not compile is reported when it is matched
+	for _, r := range out {
+		r.compile()
+	}
+	return out, nil
+}
+
+// ListRuleVersions lists the versions of a rule, newest first.
+func (db *DB) ListRuleVersions(ruleID uuid.UUID) ([]*RuleVersion, error) {
+	out := make([]*RuleVersion, 0)
+	err := db.conn.Select(&out, `SELECT rule_id, version, expression, action, description, enabled, created_by, created_at
+		FROM rule_versions WHERE rule_id=$1 ORDER BY version DESC`, ruleID)
+	return out, err
+}
+
+// ListScoredTransfers lists up to limit transfers submitted in [start, end)
+// that were decided by the model, newest first. Transfers with list hits
+// that did not decide them, such as an allowed recipient, are included.
+func (db *DB) ListScoredTransfers(start, end time.Time, limit int) ([]*ReplayTransfer, error) {
+	out := make([]*ReplayTransfer, 0)
+	err := db.conn.Select(&out, `SELECT `+replayColumns+` FROM transfers
+		WHERE when_ts >= $1 AND when_ts < $2 AND model IS NOT NULL AND status NOT IN ('pending', 'failed')
+		ORDER BY when_ts DESC
+		LIMIT $3`, start, end, limit)
+	return out, err
+}
@@ -0,0 +1,524 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The rule language is a typed expression over the variables of a transfer
+// (see ruleVariables), for example
+//
+//	amount > 500000 && logins_last_7_days == 0 && direction_is_new
+//
+// It has numbers, strings ("..."), true and false; the operators ||, &&, !,
+// == != < <= > >=, + - * / and `in` with a list of literals, as in
+// country in ["RU", "BY"]; and parentheses. There are no functions, loops
+// or assignments: a rule only reads its variables, and is type checked when
+// compiled so that it cannot fail at run time except on a division by zero.
+
+// Sandbox bounds of a rule.
+const (
+	maxRuleLength = 2000
+	maxRuleDepth  = 32
+)
+
+type ruleType int
+
+const (
+	ruleNumber ruleType = iota
+	ruleBool
+	ruleString
+)
+
+func (t ruleType) String() string {
+	switch t {
+	case ruleNumber:
+		return "number"
+	case ruleBool:
+		return "bool"
+	}
+	return "string"
+}
+
+// RuleExpr is a compiled rule expression.
+type RuleExpr struct {
+	root ruleNode
+	vars []string
+}
+
+// Vars returns the variables the expression reads.
+func (e *RuleExpr) Vars() []string { return e.vars }
+
+// Eval evaluates the expression. env must hold a value of the right type,
+// float64, bool or string, for every variable of the expression.
+func (e *RuleExpr) Eval(env map[string]any) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// CompileRule parses and type checks src against vars, the variables
+// available and their types.
+func CompileRule(src string, vars map[string]ruleType) (*RuleExpr, error) {
+	if len(src) > maxRuleLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxRuleLength)
+	}
+	toks, err := lexRule(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{toks: toks, vars: vars, used: make(map[string]bool)}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	if root.typ() != ruleBool {
+		return nil, fmt.Errorf("expression is a %s, not a bool", root.typ())
+	}
+
+	e := &RuleExpr{root: root, vars: make([]string, 0, len(p.used))}
+	for name := range p.used {
+		e.vars = append(e.vars, name)
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type ruleToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var ruleOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")", "[", "]", ","}
+
+func lexRule(src string) ([]ruleToken, error) {
+	var toks []ruleToken
+	i := 0
+next:
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == '_') {
+				j++
+			}
+			toks = append(toks, ruleToken{tokNumber, src[i:j], i})
+			i = j
+			continue
+		case isRuleLetter(src[i]):
+			j := i
+			for j < len(src) && (isRuleLetter(src[j]) || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, ruleToken{tokIdent, src[i:j], i})
+			i = j
+			continue
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("at %d: unterminated string", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("at %d: invalid string", i)
+			}
+			toks = append(toks, ruleToken{tokString, s, i})
+			i = j + 1
+			continue
+		}
+		for _, op := range ruleOps {
+			if strings.HasPrefix(src[i:], op) {
+				toks = append(toks, ruleToken{tokOp, op, i})
+				i += len(op)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("at %d: unexpected %q", i, c)
+	}
+	return append(toks, ruleToken{tokEOF, "end of expression", len(src)}), nil
+}
+
+func isRuleLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+type ruleParser struct {
+	toks []ruleToken
+	i    int
+	vars map[string]ruleType
+	used map[string]bool
+}
+
+func (p *ruleParser) peek() ruleToken { return p.toks[p.i] }
+
+func (p *ruleParser) next() ruleToken {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *ruleParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) errorf(tok ruleToken, format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *ruleParser) parseOr(depth int) (ruleNode, error) {
+	return p.parseLogical(depth, "||", p.parseAnd)
+}
+
+func (p *ruleParser) parseAnd(depth int) (ruleNode, error) {
+	return p.parseLogical(depth, "&&", p.parseComparison)
+}
+
+func (p *ruleParser) parseLogical(depth int, op string, operand func(int) (ruleNode, error)) (ruleNode, error) {
+	l, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if !p.accept(op) {
+			return l, nil
+		}
+		r, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != ruleBool || r.typ() != ruleBool {
+			return nil, p.errorf(tok, "%s needs bools, got %s and %s", op, l.typ(), r.typ())
+		}
+		l = &binaryNode{op: op, l: l, r: r, t: ruleBool}
+	}
+}
+
+func (p *ruleParser) parseComparison(depth int) (ruleNode, error) {
+	l, err := p.parseSum(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.kind == tokIdent && tok.text == "in" {
+		p.next()
+		return p.parseIn(l, tok)
+	}
+	if tok.kind != tokOp {
+		return l, nil
+	}
+	switch tok.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.parseSum(depth)
+	if err != nil {
+		return nil, err
+	}
+	if l.typ() != r.typ() {
+		return nil, p.errorf(tok, "cannot compare %s with %s", l.typ(), r.typ())
+	}
+	if tok.text != "==" && tok.text != "!=" && l.typ() != ruleNumber {
+		return nil, p.errorf(tok, "%s needs numbers, got %s", tok.text, l.typ())
+	}
+	return &binaryNode{op: tok.text, l: l, r: r, t: ruleBool}, nil
+}
+
+func (p *ruleParser) parseIn(x ruleNode, in ruleToken) (ruleNode, error) {
+	if x.typ() == ruleBool {
+		return nil, p.errorf(in, "in needs a number or a string, got bool")
+	}
+	if !p.accept("[") {
+		return nil, p.errorf(p.peek(), "expected [ after in")
+	}
+	n := &inNode{x: x}
+	for !p.accept("]") {
+		if len(n.set) > 0 && !p.accept(",") {
+			return nil, p.errorf(p.peek(), "expected , or ]")
+		}
+		tok := p.next()
+		neg := false
+		if tok.kind == tokOp && tok.text == "-" {
+			neg = true
+			tok = p.next()
+		}
+		v, t, err := p.literal(tok)
+		if err != nil {
+			return nil, err
+		}
+		if t != x.typ() || neg && t != ruleNumber {
+			return nil, p.errorf(tok, "list of %ss cannot hold %q", x.typ(), tok.text)
+		}
+		if neg {
+			v = -v.(float64)
+		}
+		n.set = append(n.set, v)
+	}
+	return n, nil
+}
+
+func (p *ruleParser) parseSum(depth int) (ruleNode, error) {
+	return p.parseArithmetic(depth, []string{"+", "-"}, p.parseProduct)
+}
+
+func (p *ruleParser) parseProduct(depth int) (ruleNode, error) {
+	return p.parseArithmetic(depth, []string{"*", "/"}, p.parseUnary)
+}
+
+func (p *ruleParser) parseArithmetic(depth int, ops []string, operand func(int) (ruleNode, error)) (ruleNode, error) {
+	l, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || (tok.text != ops[0] && tok.text != ops[1]) {
+			return l, nil
+		}
+		p.next()
+		r, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != ruleNumber || r.typ() != ruleNumber {
+			return nil, p.errorf(tok, "%s needs numbers, got %s and %s", tok.text, l.typ(), r.typ())
+		}
+		l = &binaryNode{op: tok.text, l: l, r: r, t: ruleNumber}
+	}
+}
+
+func (p *ruleParser) parseUnary(depth int) (ruleNode, error) {
+	if depth > maxRuleDepth {
+		return nil, fmt.Errorf("expression nested deeper than %d", maxRuleDepth)
+	}
+	tok := p.peek()
+	if tok.kind == tokOp && (tok.text == "!" || tok.text == "-") {
+		p.next()
+		x, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		want := ruleNumber
+		if tok.text == "!" {
+			want = ruleBool
+		}
+		if x.typ() != want {
+			return nil, p.errorf(tok, "%s needs a %s, got %s", tok.text, want, x.typ())
+		}
+		return &unaryNode{op: tok.text, x: x}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *ruleParser) parsePrimary(depth int) (ruleNode, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokOp && tok.text == "(":
+		x, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf(p.peek(), "expected )")
+		}
+		return x, nil
+	case tok.kind == tokIdent && tok.text != "true" && tok.text != "false":
+		t, ok := p.vars[tok.text]
+		if !ok {
+			return nil, p.errorf(tok, "unknown variable %s", tok.text)
+		}
+		p.used[tok.text] = true
+		return &varNode{name: tok.text, t: t}, nil
+	}
+	v, t, err := p.literal(tok)
+	if err != nil {
+		return nil, err
+	}
+	return &litNode{v: v, t: t}, nil
+}
+
+func (p *ruleParser) literal(tok ruleToken) (any, ruleType, error) {
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(tok.text, "_", ""), 64)
+		if err != nil {
+			return nil, 0, p.errorf(tok, "invalid number %q", tok.text)
+		}
+		return f, ruleNumber, nil
+	case tokString:
+		return tok.text, ruleString, nil
+	case tokIdent:
+		if tok.text == "true" || tok.text == "false" {
+			return tok.text == "true", ruleBool, nil
+		}
+	}
+	return nil, 0, p.errorf(tok, "unexpected %q", tok.text)
+}
+
+type ruleNode interface {
+	typ() ruleType
+	eval(env map[string]any) (any, error)
+}
+
+type litNode struct {
+	v any
+	t ruleType
+}
+
+func (n *litNode) typ() ruleType                    { return n.t }
+func (n *litNode) eval(map[string]any) (any, error) { return n.v, nil }
+
+type varNode struct {
+	name string
+	t    ruleType
+}
+
+func (n *varNode) typ() ruleType { return n.t }
+
+func (n *varNode) eval(env map[string]any) (any, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("variable %s not set", n.name)
+	}
+	return v, nil
+}
+
+type unaryNode struct {
+	op string
+	x  ruleNode
+}
+
+func (n *unaryNode) typ() ruleType { return n.x.typ() }
+
+func (n *unaryNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !v.(bool), nil
+	}
+	return -v.(float64), nil
+}
+
+type binaryNode struct {
+	op   string
+	l, r ruleNode
+	t    ruleType
+}
+
+func (n *binaryNode) typ() ruleType { return n.t }
+
+var errRuleDivisionByZero = errors.New("division by zero")
+
+func (n *binaryNode) eval(env map[string]any) (any, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit
+	switch n.op {
+	case "&&":
+		if !l.(bool) {
+			return false, nil
+		}
+	case "||":
+		if l.(bool) {
+			return true, nil
+		}
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return r.(bool), nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	}
+
+	a, b := l.(float64), r.(float64)
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	case ">=":
+		return a >= b, nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errRuleDivisionByZero
+		}
+		return a / b, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type inNode struct {
+	x   ruleNode
+	set []any
+}
+
+func (n *inNode) typ() ruleType { return ruleBool }
+
+func (n *inNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range n.set {
+		if v == s {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+var testRuleVars = map[string]ruleType{
+	"amount":             ruleNumber,
+	"logins_last_7_days": ruleNumber,
+	"direction_is_new":   ruleBool,
+	"country":            ruleString,
+}
+
+func TestCompileRuleEval(t *testing.T) {
+	env := map[string]any{
+		"amount":             600000.0,
+		"logins_last_7_days": 0.0,
+		"direction_is_new":   true,
+		"country":            "RU",
+	}
+	cases := map[string]bool{
+		`amount > 500000 && logins_last_7_days == 0 && direction_is_new`: true,
+		`amount > 500_000 && !direction_is_new`:                          false,
+		`country in ["RU", "BY"]`:                                        true,
+		`country in ["KZ"] || amount / 2 >= 300000`:                      true,
+		`-amount < -1 && (amount - 100000) * 2 == 1000000`:               true,
+		`logins_last_7_days in [-1, 1, 2]`:                               false,
+		`country != "RU" || false`:                                       false,
+	}
+	for src, want := range cases {
+		e, err := CompileRule(src, testRuleVars)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", src, got, err, want)
+		}
+	}
+}
+
+func TestCompileRuleErrors(t *testing.T) {
+	cases := map[string]string{
+		`amount`:                  "not a bool",
+		`amount > "x"`:            "cannot compare",
+		`direction_is_new > true`: "needs numbers",
+		`balance > 0`:             "unknown variable",
+		`amount > 1 &&`:           "unexpected",
+		`(amount > 1`:             "expected )",
+		`country in ["RU", 1]`:    "cannot hold",
+		`country in "RU"`:         "expected [",
+		`amount > 1 amount`:       "unexpected",
+		`country == "RU`:          "unterminated string",
+		`amount # 1`:              "unexpected",
+		`!amount`:                 "needs a bool",
+		strings.Repeat("(", 40) + "direction_is_new" + strings.Repeat(")", 40): "nested deeper",
+		strings.Repeat("direction_is_new || ", 200) + "true":                   "longer than",
+	}
+	for src, want := range cases {
+		_, err := CompileRule(src, testRuleVars)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%.40s: got %v, want an error containing %q", src, err, want)
+		}
+	}
+}
+
+func TestRuleEvalShortCircuit(t *testing.T) {
+	e, err := CompileRule(`logins_last_7_days > 0 && amount / logins_last_7_days > 1000`, testRuleVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Eval(map[string]any{"amount": 5000.0, "logins_last_7_days": 0.0})
+	if err != nil || got {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	e, err = CompileRule(`amount / logins_last_7_days > 1000`, testRuleVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(map[string]any{"amount": 5000.0, "logins_last_7_days": 0.0}); err != errRuleDivisionByZero {
+		t.Errorf("got %v, want division by zero", err)
+	}
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRuleVariables(t *testing.T) {
+	vars := ruleVariables()
+	want := map[string]ruleType{
+		"amount":             ruleNumber,
+		"logins_last_7_days": ruleNumber,
+		"direction":          ruleString,
+		"direction_is_new":   ruleBool,
+		"score":              ruleNumber,
+		"country":            ruleString,
+	}
+	for name, typ := range want {
+		if got, ok := vars[name]; !ok || got != typ {
+			t.Errorf("%s: got %v (%v), want %v", name, got, ok, typ)
+		}
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	tr := &Transfer{Amount: 600000, When: time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC), ClientContext: ClientContext{Country: "RU"}}
+	feats := &ModelFeatures{Amount: 600000, IsNewDestination: 1}
+	rules := []*Rule{
+		{ID: uuid.New(), Name: "big to new", Version: 3, Action: RuleBlock, Expression: `amount > 500000 && logins_last_7_days == 0 && direction_is_new`},
+		{ID: uuid.New(), Name: "night", Version: 1, Action: RuleChallenge, Expression: `hour >= 23 && score > 0.2`},
+		{ID: uuid.New(), Name: "broken", Version: 1, Action: RuleBlock, Expression: `amount / logins_last_7_days > 1`},
+		{ID: uuid.New(), Name: "abroad", Version: 2, Action: RuleBlock, Expression: `country != "RU"`},
+	}
+
+	hits, err := MatchRules(rules, &RuleInput{Transfer: tr, Features: feats, Score: 0.3})
+	if !errors.Is(err, errRuleDivisionByZero) {
+		t.Errorf("got error %v, want division by zero", err)
+	}
+	if len(hits) != 2 || hits[0].Name != "big to new" || hits[0].Version != 3 || hits[1].Name != "night" {
+		t.Fatalf("got hits %+v", hits)
+	}
+	if d := hits.Decision(); d != RuleBlock {
+		t.Errorf("got decision %q", d)
+	}
+}
+
+func TestRuleCompileCache(t *testing.T) {
+	r := &Rule{ID: uuid.New(), Version: 1, Expression: `amount > 100`}
+	expr, err := r.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same version loaded again is not recompiled
+	loaded := &Rule{ID: r.ID, Version: 1, Expression: `amount > 100`}
+	if got, err := loaded.compile(); err != nil || got != expr {
+		t.Errorf("got %p, %v, want %p", got, err, expr)
+	}
+	next := &Rule{ID: r.ID, Version: 2, Expression: `amount > 200`}
+	v2, err := next.compile()
+	if err != nil || v2 == expr {
+		t.Errorf("version 2: got %p, %v", v2, err)
+	}
+	// only the current version is kept
+	if c := compiledRules[r.ID]; c.version != 2 || c.expr != v2 {
+		t.Errorf("cached version %d", c.version)
+	}
+	stale := &Rule{ID: r.ID, Version: 1, Expression: `amount > 100`}
+	if got, err := stale.compile(); err != nil || got == v2 {
+		t.Errorf("stale version: got %p, %v", got, err)
+	}
+	if c := compiledRules[r.ID]; c.version != 2 {
+		t.Errorf("stale version cached over version %d", c.version)
+	}
+
+	broken := &Rule{ID: uuid.New(), Version: 1, Expression: `amount >`}
+	for range 2 {
+		if _, err := broken.compile(); err == nil {
+			t.Error("broken rule compiled")
+		}
+	}
+	candidate := &Rule{Expression: `amount > 100`}
+	if got, err := candidate.compile(); err != nil || got == expr {
+		t.Errorf("candidate: got %p, %v", got, err)
+	}
+}
+
+func TestApplyRuleDecision(t *testing.T) {
+	cases := []struct {
+		status, decision, want string
+	}{
+		{TransferCompleted, "", TransferCompleted},
+		{TransferCompleted, RuleChallenge, TransferChallengeRequired},
+		{TransferCompleted, RuleBlock, TransferBlocked},
+		{TransferChallengeRequired, RuleBlock, TransferBlocked},
+		// rules never loosen the model's decision
+		{TransferBlocked, RuleChallenge, TransferBlocked},
+	}
+	for _, c := range cases {
+		if got := applyRuleDecision(c.status, c.decision); got != c.want {
+			t.Errorf("%s + %q: got %s, want %s", c.status, c.decision, got, c.want)
+		}
+	}
+}
+
+func TestDryRunRule(t *testing.T) {
+	expr, err := CompileRuleExpression(`amount >= 1000 && direction_is_new`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := func(amount float64, status string, challenged bool, newDest int) *ReplayTransfer {
+		return &ReplayTransfer{
+			Transfer: Transfer{
+				ID: uuid.New(), Amount: amount, Status: status,
+				Features: &ModelFeatures{Amount: amount, IsNewDestination: newDest},
+			},
+			Challenged: challenged,
+		}
+	}
+	transfers := []*ReplayTransfer{
+		transfer(5000, TransferCompleted, false, 1),
+		transfer(2000, TransferChallengeExpired, true, 1),
+		transfer(3000, TransferBlocked, false, 1),
+		transfer(9000, TransferCompleted, false, 0),
+		transfer(10, TransferCompleted, false, 1),
+		// challenged, then confirmed by the user
+		transfer(4000, TransferCompleted, true, 1),
+		// scored before features were stored
+		{Transfer: Transfer{ID: uuid.New(), Amount: 8000, Status: TransferCompleted}},
+	}
+
+	res := DryRunRule(expr, RuleChallenge, transfers)
+	if res.Evaluated != 6 || res.Skipped != 1 || res.Matched != 4 || res.MatchedAmount != 14000 || res.Changed != 1 {
+		t.Errorf("got %+v", res)
+	}
+	if len(res.Samples) != 4 || res.Samples[0].WouldBe != TransferChallengeRequired || res.Samples[1].Status != TransferChallengeRequired ||
+		res.Samples[3].Status != TransferChallengeRequired {
+		t.Errorf("got samples %+v", res.Samples)
+	}
+
+	res = DryRunRule(expr, RuleBlock, transfers)
+	if res.Changed != 3 {
+		t.Errorf("block: got %d changed, want 3", res.Changed)
+	}
+}
+
+func TestRuleHitsValue(t *testing.T) {
+	if v, err := (RuleHits{}).Value(); v != nil || err != nil {
+		t.Errorf("no hits stored as %v, %v", v, err)
+	}
+
+	hits := RuleHits{{RuleID: uuid.New(), Name: "big to new", Version: 2, Action: RuleBlock}}
+	v, err := hits.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RuleHits
+	if err := got.Scan(v); err != nil || len(got) != 1 || got[0] != hits[0] {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+// settleTestTransfer settles a transfer of insertTestTransfer at when, as
+// decided by model ("" for a list) with hits.
+func settleTestTransfer(t *testing.T, db *DB, when time.Time, status, model string, hits ListHits) uuid.UUID {
+	t.Helper()
+	id := insertTestTransfer(t, db)
+	_, err := db.conn.Exec(`UPDATE transfers SET when_ts=$2, status=$3, model=NULLIF($4, ''), list_hits=$5 WHERE id=$1`,
+		id, when, status, model, hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestListScoredTransfersWithListHits(t *testing.T) {
+	db := openTestDB(t)
+	// a day of its own, so that other tests' transfers are not listed
+	day := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, rand.Intn(3650))
+
+	scored := settleTestTransfer(t, db, day.Add(time.Hour), TransferCompleted, "champion", nil)
+	allowedRecipient := settleTestTransfer(t, db, day.Add(2*time.Hour), TransferBlocked, "champion",
+		ListHits{{EntryID: uuid.New(), List: ListAllow, Kind: ListKindCard, Party: ListPartyRecipientCard}})
+	settleTestTransfer(t, db, day.Add(3*time.Hour), TransferBlocked, "",
+		ListHits{{EntryID: uuid.New(), List: ListBlock, Kind: ListKindUser, Party: ListPartySender}})
+
+	got, err := db.ListScoredTransfers(day, day.AddDate(0, 0, 1), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != allowedRecipient || got[1].ID != scored {
+		t.Errorf("got %d transfers", len(got))
+	}
+}
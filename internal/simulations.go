@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SimulationPolicy is a candidate decision policy: the score thresholds
+// applied to the model and the rules evaluated after it.
+type SimulationPolicy struct {
+	// Block transfers scored at least this; the model's own block decision
+	// if nil.
+	BlockMinScore *float64 `json:"block_min_score"`
+	// Challenge approved transfers scored at least this; STEP_UP_MIN_SCORE
+	// if nil.
+	StepUpMinScore *float64         `json:"step_up_min_score"`
+	Rules          []SimulationRule `json:"rules"`
+	// The rules enabled when the simulation is run are added to Rules.
+	IncludeEnabledRules bool `json:"include_enabled_rules"`
+}
+
+// SimulationRule is a candidate rule, evaluated as if it were enabled.
+type SimulationRule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	Action     string `json:"action"`
+}
+
+func (p SimulationPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+func (p *SimulationPolicy) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	}
+	return fmt.Errorf("SimulationPolicy: unsupported type %T", src)
+}
+
+// rules validates p and returns its rules.
+func (p *SimulationPolicy) rules() ([]*Rule, error) {
+	for name, v := range map[string]*float64{"block_min_score": p.BlockMinScore, "step_up_min_score": p.StepUpMinScore} {
+		if v != nil && (*v < 0 || *v > 1) {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+
+	out := make([]*Rule, len(p.Rules))
+	names := make(map[string]bool)
+	for i, sr := range p.Rules {
+		r := &Rule{Name: sr.Name, Expression: sr.Expression, Action: sr.Action}
+		if err := validateRule(r); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule %d: %s listed twice", i+1, r.Name)
+		}
+		names[r.Name] = true
+		out[i] = r
+	}
+	return out, nil
+}
+
+// addEnabledRules adds the enabled rules to the rules of p, except those
+// replaced by a candidate rule of the same name.
+func (p *SimulationPolicy) addEnabledRules(enabled []*Rule) {
+	candidates := make(map[string]bool)
+	for _, sr := range p.Rules {
+		candidates[strings.TrimSpace(sr.Name)] = true
+	}
+	for _, r := range enabled {
+		if !candidates[r.Name] {
+			p.Rules = append(p.Rules, SimulationRule{Name: r.Name, Expression: r.Expression, Action: r.Action})
+		}
+	}
+}
+
+// modelStatus is the decision of p on a transfer scored score, given the
+// block decision of the model.
+func (p *SimulationPolicy) modelStatus(score float64, modelBlocked bool) string {
+	blocked := modelBlocked
+	if p.BlockMinScore != nil {
+		blocked = score >= *p.BlockMinScore
+	}
+	stepUp := stepUpMinScore
+	if p.StepUpMinScore != nil {
+		stepUp = *p.StepUpMinScore
+	}
+	switch {
+	case blocked:
+		return TransferBlocked
+	case score >= stepUp:
+		return TransferChallengeRequired
+	}
+	return TransferCompleted
+}
+
+// SimulationTransfer is a past transfer replayed by a simulation.
+type SimulationTransfer struct {
+	ReplayTransfer
+	// block decision of the model that scored the transfer
+	ModelBlocked bool `db:"model_blocked"`
+	// fraud label, "" if the transfer is not labeled
+	Label string `db:"label"`
+}
+
+// SimulationOutcome sums up the decisions of a policy. A transfer is
+// stopped if it was blocked or challenged.
+type SimulationOutcome struct {
+	Completed          int     `json:"completed"`
+	Challenged         int     `json:"challenged"`
+	Blocked            int     `json:"blocked"`
+	BlockedAmount      float64 `json:"blocked_amount"`
+	FraudStopped       int     `json:"fraud_stopped"`
+	FraudStoppedAmount float64 `json:"fraud_stopped_amount"`
+	FraudMissed        int     `json:"fraud_missed"`
+	FraudMissedAmount  float64 `json:"fraud_missed_amount"`
+	LegitStopped       int     `json:"legit_stopped"`
+}
+
+func (o *SimulationOutcome) add(status, label string, amount float64) {
+	switch status {
+	case TransferBlocked:
+		o.Blocked++
+		o.BlockedAmount += amount
+	case TransferChallengeRequired:
+		o.Challenged++
+	default:
+		o.Completed++
+	}
+
+	stopped := status != TransferCompleted
+	switch {
+	case label == LabelFraud && stopped:
+		o.FraudStopped++
+		o.FraudStoppedAmount += amount
+	case label == LabelFraud:
+		o.FraudMissed++
+		o.FraudMissedAmount += amount
+	case label == LabelLegit && stopped:
+		o.LegitStopped++
+	}
+}
+
+// SimulationSample is a transfer whose decision the policy changed.
+type SimulationSample struct {
+	TransferID uuid.UUID `json:"transfer_id"`
+	FromUserID string    `json:"from_user_id"`
+	Amount     float64   `json:"amount"`
+	When       time.Time `json:"when"`
+	FraudScore float64   `json:"fraud_score"`
+	Label      string    `json:"label"`
+	Current    string    `json:"current"`
+	Candidate  string    `json:"candidate"`
+	RuleHits   []string  `json:"rule_hits"`
+}
+
+// SimulationReport compares the decisions taken on past transfers with
+// those of a candidate policy.
+type SimulationReport struct {
+	Evaluated int `json:"evaluated"`
+	// transfers scored before their features were stored, not replayed
+	Skipped int `json:"skipped"`
+	// the period had more transfers than were replayed
+	Truncated    bool `json:"truncated"`
+	FraudLabeled int  `json:"fraud_labeled"`
+	LegitLabeled int  `json:"legit_labeled"`
+	// transfers a candidate rule failed on; they are decided without it
+	Errors    int               `json:"errors"`
+	Current   SimulationOutcome `json:"current"`
+	Candidate SimulationOutcome `json:"candidate"`
+	Flipped   int               `json:"flipped"`
+	// flipped decisions by "current->candidate"
+	Flips       map[string]int     `json:"flips"`
+	RuleMatches map[string]int     `json:"rule_matches"`
+	Samples     []SimulationSample `json:"samples"`
+}
+
+func (r SimulationReport) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *SimulationReport) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("SimulationReport: unsupported type %T", src)
+}
+
+// maxSimulationSamples bounds the changed transfers kept in a report.
+const maxSimulationSamples = 50
+
+// Simulate replays transfers, on the features they were scored on, through
+// p and rules, the rules of p. The transfers are only read.
+func Simulate(p *SimulationPolicy, rules []*Rule, transfers []*SimulationTransfer) *SimulationReport {
+	rep := &SimulationReport{
+		Flips:       make(map[string]int),
+		RuleMatches: make(map[string]int),
+		Samples:     make([]SimulationSample, 0),
+	}
+	for _, r := range rules {
+		rep.RuleMatches[r.Name] = 0
+	}
+
+	for _, st := range transfers {
+		t := &st.Transfer
+		if t.Features == nil {
+			rep.Skipped++
+			continue
+		}
+		rep.Evaluated++
+		switch st.Label {
+		case LabelFraud:
+			rep.FraudLabeled++
+		case LabelLegit:
+			rep.LegitLabeled++
+		}
+
+		hits, err := MatchRules(rules, &RuleInput{Transfer: t, Features: t.Features, Score: t.FraudScore})
+		if err != nil {
+			rep.Errors++
+		}
+		names := make([]string, len(hits))
+		for i, hit := range hits {
+			rep.RuleMatches[hit.Name]++
+			names[i] = hit.Name
+		}
+
+		current := decidedStatus(&st.ReplayTransfer)
+		candidate := applyRuleDecision(p.modelStatus(t.FraudScore, st.ModelBlocked), hits.Decision())
+		rep.Current.add(current, st.Label, t.Amount)
+		rep.Candidate.add(candidate, st.Label, t.Amount)
+		if current == candidate {
+			continue
+		}
+
+		rep.Flipped++
+		rep.Flips[current+"->"+candidate]++
+		if len(rep.Samples) < maxSimulationSamples {
+			rep.Samples = append(rep.Samples, SimulationSample{
+				TransferID: t.ID,
+				FromUserID: t.FromUserID,
+				Amount:     t.Amount,
+				When:       t.When,
+				FraudScore: t.FraudScore,
+				Label:      st.Label,
+				Current:    current,
+				Candidate:  candidate,
+				RuleHits:   names,
+			})
+		}
+	}
+	return rep
+}
+
+// ListSimulationTransfers lists up to limit transfers submitted in
+// [start, end) that were decided by the model, newest first, with the
+// model's block decision and their fraud label. As in ListScoredTransfers,
+// list hits that did not decide a transfer do not exclude it.
+func (db *DB) ListSimulationTransfers(start, end time.Time, limit int) ([]*SimulationTransfer, error) {
+	out := make([]*SimulationTransfer, 0)
+	err := db.conn.Select(&out, `SELECT `+replayColumns+`,
+			COALESCE((SELECT s.is_blocked FROM model_scores s
+				WHERE s.transfer_id = transfers.id AND NOT s.shadow AND s.is_blocked IS NOT NULL
+				LIMIT 1), is_blocked) AS model_blocked,
+			COALESCE((SELECT l.label FROM transfer_labels l WHERE l.transfer_id = transfers.id), '') AS label
+		FROM transfers
+		WHERE when_ts >= $1 AND when_ts < $2 AND model IS NOT NULL AND status NOT IN ('pending', 'failed')
+		ORDER BY when_ts DESC
+		LIMIT $3`, start, end, limit)
+	return out, err
+}
+
+const simulationColumns = `id, policy, start_ts, end_ts, report, created_by, created_at`
+
+// SaveSimulation stores s and sets its ID and creation time.
+func (db *DB) SaveSimulation(s *Simulation) error {
+	return db.conn.Get(s, `INSERT INTO simulations (policy, start_ts, end_ts, report, created_by)
+		VALUES ($1,$2,$3,$4,$5)
+		RETURNING `+simulationColumns, s.Policy, s.Start, s.End, s.Report, s.CreatedBy)
+}
+
+func (db *DB) GetSimulation(id uuid.UUID) (*Simulation, error) {
+	var s Simulation
+	err := db.conn.Get(&s, `SELECT `+simulationColumns+` FROM simulations WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSimulations lists the most recent simulations, newest first, with
+// reports without their samples.
+func (db *DB) ListSimulations(limit int) ([]*Simulation, error) {
+	out := make([]*Simulation, 0)
+	err := db.conn.Select(&out, `SELECT id, policy, start_ts, end_ts, report - 'samples' AS report, created_by, created_at
+		FROM simulations ORDER BY created_at DESC LIMIT $1`, limit)
+	return out, err
+}
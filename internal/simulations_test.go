@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func setStepUpMinScore(t *testing.T, v float64) {
+	t.Helper()
+	saved := stepUpMinScore
+	t.Cleanup(func() { stepUpMinScore = saved })
+	stepUpMinScore = v
+}
+
+func TestSimulationPolicyModelStatus(t *testing.T) {
+	setStepUpMinScore(t, 0.5)
+	blockAt, stepUpAt := 0.8, 0.3
+	cases := []struct {
+		policy       SimulationPolicy
+		score        float64
+		modelBlocked bool
+		want         string
+	}{
+		{SimulationPolicy{}, 0.6, false, TransferChallengeRequired},
+		{SimulationPolicy{}, 0.9, true, TransferBlocked},
+		{SimulationPolicy{}, 0.4, false, TransferCompleted},
+		{SimulationPolicy{StepUpMinScore: &stepUpAt}, 0.4, false, TransferChallengeRequired},
+		{SimulationPolicy{BlockMinScore: &blockAt}, 0.85, false, TransferBlocked},
+		// a block threshold replaces the model's own decision
+		{SimulationPolicy{BlockMinScore: &blockAt}, 0.7, true, TransferChallengeRequired},
+	}
+	for i, c := range cases {
+		if got := c.policy.modelStatus(c.score, c.modelBlocked); got != c.want {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestSimulationPolicyRules(t *testing.T) {
+	bad := 1.5
+	invalid := []SimulationPolicy{
+		{BlockMinScore: &bad},
+		{Rules: []SimulationRule{{Name: "x", Expression: "amount", Action: RuleBlock}}},
+		{Rules: []SimulationRule{{Name: "x", Expression: "amount > 1", Action: "flag"}}},
+		{Rules: []SimulationRule{
+			{Name: "x", Expression: "amount > 1", Action: RuleBlock},
+			{Name: " x", Expression: "amount > 2", Action: RuleBlock},
+		}},
+	}
+	for i, p := range invalid {
+		if _, err := p.rules(); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+
+	p := SimulationPolicy{Rules: []SimulationRule{{Name: "big", Expression: "amount > 1000", Action: RuleChallenge}}}
+	p.addEnabledRules([]*Rule{
+		{Name: "big", Expression: "amount > 5000", Action: RuleBlock},
+		{Name: "night", Expression: "hour < 5", Action: RuleChallenge},
+	})
+	rules, err := p.rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the candidate replaces the enabled rule of the same name
+	if len(rules) != 2 || rules[0].Expression != "amount > 1000" || rules[1].Name != "night" {
+		t.Errorf("got rules %+v, %+v", rules[0], rules[1])
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	setStepUpMinScore(t, 0.5)
+	transfer := func(amount, score float64, status, label string, modelBlocked, challenged bool) *SimulationTransfer {
+		return &SimulationTransfer{
+			ReplayTransfer: ReplayTransfer{
+				Transfer: Transfer{
+					ID: uuid.New(), Amount: amount, FraudScore: score, Status: status,
+					Features: &ModelFeatures{Amount: amount},
+				},
+				Challenged: challenged,
+			},
+			ModelBlocked: modelBlocked,
+			Label:        label,
+		}
+	}
+	transfers := []*SimulationTransfer{
+		transfer(100, 0.1, TransferCompleted, LabelLegit, false, false),
+		transfer(9000, 0.2, TransferCompleted, LabelFraud, false, false),
+		transfer(200, 0.6, TransferChallengeFailed, LabelFraud, false, true),
+		transfer(300, 0.9, TransferBlocked, LabelLegit, true, false),
+		transfer(7000, 0.4, TransferCompleted, "", false, false),
+		// challenged, then confirmed by the user: challenged by both policies
+		transfer(400, 0.75, TransferCompleted, LabelFraud, false, true),
+		// scored before features were stored
+		{ReplayTransfer: ReplayTransfer{Transfer: Transfer{ID: uuid.New(), Amount: 8000, Status: TransferCompleted}}},
+	}
+	stepUpAt := 0.7
+	p := &SimulationPolicy{
+		StepUpMinScore: &stepUpAt,
+		Rules:          []SimulationRule{{Name: "big", Expression: "amount > 5000", Action: RuleBlock}},
+	}
+	rules, err := p.rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := Simulate(p, rules, transfers)
+	if rep.Evaluated != 6 || rep.Skipped != 1 || rep.FraudLabeled != 3 || rep.LegitLabeled != 2 {
+		t.Errorf("got %+v", rep)
+	}
+	// the two big transfers are blocked, the challenged one is let through
+	if rep.Flipped != 3 || rep.Flips["completed->blocked"] != 2 || rep.Flips["challenge_required->completed"] != 1 {
+		t.Errorf("got flips %v", rep.Flips)
+	}
+	if rep.RuleMatches["big"] != 2 || len(rep.Samples) != 3 || rep.Samples[0].RuleHits[0] != "big" {
+		t.Errorf("got matches %v, samples %+v", rep.RuleMatches, rep.Samples)
+	}
+
+	want := SimulationOutcome{Completed: 3, Challenged: 2, Blocked: 1, BlockedAmount: 300, FraudStopped: 2, FraudStoppedAmount: 600, FraudMissed: 1, FraudMissedAmount: 9000, LegitStopped: 1}
+	if rep.Current != want {
+		t.Errorf("current: got %+v, want %+v", rep.Current, want)
+	}
+	want = SimulationOutcome{Completed: 2, Challenged: 1, Blocked: 3, BlockedAmount: 16300, FraudStopped: 2, FraudStoppedAmount: 9400, FraudMissed: 1, FraudMissedAmount: 200, LegitStopped: 1}
+	if rep.Candidate != want {
+		t.Errorf("candidate: got %+v, want %+v", rep.Candidate, want)
+	}
+}
+
+func TestListSimulationsWithoutSamples(t *testing.T) {
+	db := openTestDB(t)
+	s := &Simulation{
+		Policy: SimulationPolicy{Rules: []SimulationRule{{Name: "big", Expression: "amount > 5000", Action: RuleBlock}}},
+		Start:  time.Now().Add(-24 * time.Hour), End: time.Now(),
+		Report: &SimulationReport{
+			Evaluated: 3, Flipped: 1,
+			Flips:       map[string]int{"completed->blocked": 1},
+			RuleMatches: map[string]int{"big": 1},
+			Samples:     []SimulationSample{{TransferID: uuid.New(), Amount: 9000, Current: TransferCompleted, Candidate: TransferBlocked}},
+		},
+		CreatedBy: "admin",
+	}
+	if err := db.SaveSimulation(s); err != nil {
+		t.Fatal(err)
+	}
+
+	sims, err := db.ListSimulations(maxListedSimulations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sims) == 0 || sims[0].ID != s.ID {
+		t.Fatalf("latest simulation not listed first")
+	}
+	rep := sims[0].Report
+	if rep.Evaluated != 3 || rep.Flips["completed->blocked"] != 1 || rep.Samples != nil || len(sims[0].Policy.Rules) != 1 {
+		t.Errorf("got report %+v", rep)
+	}
+
+	got, err := db.GetSimulation(s.ID)
+	if err != nil || len(got.Report.Samples) != 1 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
+
+func TestListSimulationTransfersWithListHits(t *testing.T) {
+	db := openTestDB(t)
+	day := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, rand.Intn(3650))
+
+	scored := settleTestTransfer(t, db, day.Add(time.Hour), TransferCompleted, "champion", nil)
+	allowedDevice := settleTestTransfer(t, db, day.Add(2*time.Hour), TransferCompleted, "champion",
+		ListHits{{EntryID: uuid.New(), List: ListAllow, Kind: ListKindDevice, Party: ListPartyDevice}})
+	settleTestTransfer(t, db, day.Add(3*time.Hour), TransferCompleted, "",
+		ListHits{{EntryID: uuid.New(), List: ListAllow, Kind: ListKindUser, Party: ListPartySender}})
+
+	got, err := db.ListSimulationTransfers(day, day.AddDate(0, 0, 1), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != allowedDevice || got[1].ID != scored {
+		t.Errorf("got %d transfers", len(got))
+	}
+}
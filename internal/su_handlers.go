@@ -1,9 +1,22 @@
 package internal
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"antifraud-demo-backend/internal/auth"
+
+	"github.com/google/uuid"
 )
 
 func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,12 +31,7 @@ func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 	userDTOs := make([]UserDTO, len(users))
 	for i, user := range users {
-		userDTOs[i] = UserDTO{
-			ID:        user.ID,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Status:    string(user.Status),
-		}
+		userDTOs[i] = userToDTO(user)
 	}
 
 	_ = json.NewEncoder(w).Encode(UserListResponse{Users: userDTOs})
@@ -48,18 +56,58 @@ func ListCardsByUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	cardDTOs := make([]CardDTO, len(cards))
 	for i, card := range cards {
-		cardDTOs[i] = CardDTO{
-			ID:      card.ID.String(),
-			UserID:  card.UserID,
-			Number:  card.Number,
-			Balance: card.Balance,
-			Status:  string(card.Status),
-		}
+		cardDTOs[i] = cardToDTO(card)
 	}
 
 	_ = json.NewEncoder(w).Encode(CardListResponse{Cards: cardDTOs})
 }
 
+// SetCardStatusHandler blocks or unblocks a card. The owner is notified
+// when their card is blocked.
+func SetCardStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid card id"})
+		return
+	}
+
+	var req SetCardStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	status := CardStatus(req.Status)
+	if status != CardActive && status != CardBlocked {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "status must be active or blocked"})
+		return
+	}
+
+	card, err := dbClient.GetCardByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "card not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get card"})
+		return
+	}
+
+	card.Status = status
+	if err := dbClient.SetCardStatus(card); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update card"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(cardToDTO(card))
+}
+
 func ListTransfersByUserHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -79,21 +127,208 @@ func ListTransfersByUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	transferDTOs := make([]TransferDTO, len(transfers))
 	for i, t := range transfers {
-		transferDTOs[i] = TransferDTO{
-			ID:         t.ID.String(),
-			FromUserID: t.FromUserID,
-			FromCardID: t.FromCardID.String(),
-			ToCardID:   t.ToCardID.String(),
-			Amount:     t.Amount,
-			When:       t.When.Format("2006-01-02T15:04:05Z07:00"),
-			FraudScore: t.FraudScore,
-			IsBlocked:  t.IsBlocked,
-		}
+		transferDTOs[i] = transferToDTO(t)
 	}
 
 	_ = json.NewEncoder(w).Encode(TransferListResponse{Transfers: transferDTOs})
 }
 
+func ListDevicesByUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "missing user id"})
+		return
+	}
+
+	devices, err := dbClient.ListDevicesForUser(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list devices"})
+		return
+	}
+
+	deviceDTOs := make([]DeviceDTO, len(devices))
+	for i, d := range devices {
+		deviceDTOs[i] = deviceToDTO(d)
+	}
+
+	_ = json.NewEncoder(w).Encode(DeviceListResponse{Devices: deviceDTOs})
+}
+
+// MuleReportHandler shows the recipient-side features of a card as the model
+// would see them for a transfer made now, plus who has been sending to it.
+func MuleReportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cardID, err := uuid.Parse(r.URL.Query().Get("cardId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid card id"})
+		return
+	}
+
+	card, err := dbClient.GetCardByID(cardID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "card not found"})
+		return
+	}
+
+	now := time.Now().UTC()
+	activity, err := dbClient.GetRecipientActivity(card, now.Add(-30*24*time.Hour))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get recipient activity"})
+		return
+	}
+
+	senders := make([]MuleReportSenderDTO, 0)
+	index := make(map[string]int)
+	for _, t := range activity.Inbound {
+		i, ok := index[t.FromUserID]
+		if !ok {
+			i = len(senders)
+			index[t.FromUserID] = i
+			senders = append(senders, MuleReportSenderDTO{UserID: t.FromUserID})
+		}
+		senders[i].Transfers++
+		senders[i].Amount += t.Amount
+		if t.IsBlocked {
+			senders[i].Blocked++
+		}
+	}
+	sort.Slice(senders, func(i, j int) bool { return senders[i].Amount > senders[j].Amount })
+
+	_ = json.NewEncoder(w).Encode(MuleReportResponse{
+		Card:     cardToDTO(card),
+		Features: ComputeRecipientFeatures(activity, now),
+		Senders:  senders,
+	})
+}
+
+func FeatureStoreCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "missing user id"})
+		return
+	}
+
+	diffs, err := dbClient.CheckLoginFeatures(userId, time.Now().UTC())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to check features"})
+		return
+	}
+
+	mismatches := make([]FeatureDiffDTO, len(diffs))
+	for i, d := range diffs {
+		mismatches[i] = FeatureDiffDTO{Feature: d.Feature, Store: d.Got, Full: d.Want}
+	}
+
+	_ = json.NewEncoder(w).Encode(FeatureStoreCheckResponse{
+		UserID:     userId,
+		Consistent: len(mismatches) == 0,
+		Mismatches: mismatches,
+	})
+}
+
+func FeatureStoreRebuildHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "missing user id"})
+		return
+	}
+
+	if err := dbClient.RebuildLoginFeatures(userId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to rebuild features"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetTransferHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid transfer id"})
+		return
+	}
+
+	t, err := dbClient.GetTransferByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "transfer not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get transfer"})
+		return
+	}
+
+	scores, err := dbClient.ListModelScores(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get model scores"})
+		return
+	}
+
+	label, err := dbClient.GetTransferLabel(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get transfer label"})
+		return
+	}
+
+	cases, err := dbClient.ListCasesForTransfer(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list cases"})
+		return
+	}
+
+	resp := AdminTransferResponse{
+		Transfer:    transferToDTO(t),
+		Model:       t.Model,
+		Explanation: t.Explanation,
+		ListHits:    append(make([]ListHit, 0), t.ListHits...),
+		RuleHits:    append(make([]RuleHit, 0), t.RuleHits...),
+		ModelScores: make([]ModelScoreDTO, len(scores)),
+		Cases:       caseRefsToDTO(cases),
+	}
+	if label != nil {
+		dto := transferLabelToDTO(label)
+		resp.Label = &dto
+	}
+	// admins see the reasons of every decision
+	resp.Transfer.ReasonCodes = t.Explanation.ReasonCodes()
+	for i, s := range scores {
+		resp.ModelScores[i] = ModelScoreDTO{
+			Model:             s.Model,
+			FeatureSetVersion: s.FeatureSetVersion,
+			Shadow:            s.Shadow,
+			FraudScore:        s.FraudScore,
+			IsBlocked:         s.IsBlocked,
+			LatencyMs:         s.LatencyMs,
+			Error:             s.Error,
+		}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
 // TODO: refactor
 func AnalyticsTransfersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -134,3 +369,1754 @@ func AnalyticsTransfersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	_ = json.NewEncoder(w).Encode(resp)
 }
+
+func AnalyticsModelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var startPtr, endPtr *time.Time
+	if startStr := r.URL.Query().Get("start"); startStr != "" {
+		if t, err := time.Parse("2006-01-02", startStr); err == nil {
+			startPtr = &t
+		}
+	}
+	if endStr := r.URL.Query().Get("end"); endStr != "" {
+		if t, err := time.Parse("2006-01-02", endStr); err == nil {
+			endPtr = &t
+		}
+	}
+
+	stats, err := dbClient.GetModelComparison(startPtr, endPtr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get analytics"})
+		return
+	}
+
+	resp := ModelComparisonResponse{Models: make([]ModelComparisonDTO, len(stats))}
+	for i, s := range stats {
+		resp.Models[i] = ModelComparisonDTO(*s)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func webhookToDTO(s *WebhookSubscription) WebhookDTO {
+	return WebhookDTO{
+		ID:         s.ID.String(),
+		URL:        s.URL,
+		EventTypes: s.EventTypes,
+		Active:     s.Active,
+		CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  s.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func webhookDeliveryToDTO(d *WebhookDelivery, attempts []WebhookAttemptDTO) WebhookDeliveryDTO {
+	dto := WebhookDeliveryDTO{
+		ID:             d.ID,
+		SubscriptionID: d.SubscriptionID.String(),
+		EventID:        d.EventUUID.String(),
+		EventType:      d.EventType,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt.Format(time.RFC3339),
+		Log:            attempts,
+	}
+	if d.Status == WebhookPending {
+		next := d.NextAttemptAt.Format(time.RFC3339)
+		dto.NextAttemptAt = &next
+	}
+	if d.DeliveredAt != nil {
+		delivered := d.DeliveredAt.Format(time.RFC3339)
+		dto.DeliveredAt = &delivered
+	}
+	if dto.Log == nil {
+		dto.Log = make([]WebhookAttemptDTO, 0)
+	}
+	return dto
+}
+
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	s := &WebhookSubscription{URL: req.URL, Secret: req.Secret, EventTypes: req.EventTypes, Active: true}
+	if s.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to generate secret"})
+			return
+		}
+		s.Secret = secret
+	}
+	if err := validateWebhook(s); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.CreateWebhook(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to create webhook"})
+		return
+	}
+
+	dto := webhookToDTO(s)
+	dto.Secret = s.Secret
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	list, err := dbClient.ListWebhooks()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list webhooks"})
+		return
+	}
+
+	resp := WebhookListResponse{Webhooks: make([]WebhookDTO, len(list))}
+	for i, s := range list {
+		resp.Webhooks[i] = webhookToDTO(s)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// getWebhook loads the webhook of the {id} path value, writing the error
+// response if it fails.
+func getWebhook(w http.ResponseWriter, r *http.Request) (*WebhookSubscription, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid webhook id"})
+		return nil, false
+	}
+
+	s, err := dbClient.GetWebhook(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "webhook not found"})
+			return nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get webhook"})
+		return nil, false
+	}
+	return s, true
+}
+
+func GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, ok := getWebhook(w, r)
+	if !ok {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(webhookToDTO(s))
+}
+
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, ok := getWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if req.URL != nil {
+		s.URL = *req.URL
+	}
+	if req.EventTypes != nil {
+		s.EventTypes = *req.EventTypes
+	}
+	if req.Secret != nil {
+		s.Secret = *req.Secret
+	}
+	if req.Active != nil {
+		s.Active = *req.Active
+	}
+	if err := validateWebhook(s); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.UpdateWebhook(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update webhook"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(webhookToDTO(s))
+}
+
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid webhook id"})
+		return
+	}
+
+	if err := dbClient.DeleteWebhook(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "webhook not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to delete webhook"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultDeliveryListLimit = 50
+	maxDeliveryListLimit     = 500
+)
+
+// ListWebhookDeliveriesHandler returns the latest deliveries of a webhook
+// with their attempts. Optional query: status, limit.
+func ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, ok := getWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", WebhookPending, WebhookDelivered, WebhookDead:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid status"})
+		return
+	}
+	limit := defaultDeliveryListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeliveryListLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := dbClient.ListWebhookDeliveries(s.ID, status, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list deliveries"})
+		return
+	}
+	ids := make([]int64, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+	attempts, err := dbClient.ListWebhookAttempts(ids)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list deliveries"})
+		return
+	}
+	logs := make(map[int64][]WebhookAttemptDTO)
+	for _, a := range attempts {
+		logs[a.DeliveryID] = append(logs[a.DeliveryID], WebhookAttemptDTO{
+			AttemptedAt: a.AttemptedAt.Format(time.RFC3339),
+			StatusCode:  a.StatusCode,
+			Error:       a.Error,
+			DurationMs:  a.DurationMs,
+		})
+	}
+
+	resp := WebhookDeliveryListResponse{Deliveries: make([]WebhookDeliveryDTO, len(deliveries))}
+	for i, d := range deliveries {
+		resp.Deliveries[i] = webhookDeliveryToDTO(d, logs[d.ID])
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ReplayWebhookDeliveryHandler sends a delivered or dead delivery again.
+func ReplayWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid delivery id"})
+		return
+	}
+
+	d, ok, err := dbClient.ReplayWebhookDelivery(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "delivery not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to replay delivery"})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "delivery is still pending"})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(webhookDeliveryToDTO(d, nil))
+}
+
+func limitOverrideToDTO(o *LimitOverride) LimitOverrideDTO {
+	return LimitOverrideDTO{
+		Scope:              o.Scope,
+		ScopeID:            o.ScopeID,
+		MaxAmount:          o.MaxAmount,
+		DailyAmount:        o.DailyAmount,
+		MonthlyAmount:      o.MonthlyAmount,
+		HourlyTransfers:    o.HourlyTransfers,
+		DailyNewRecipients: o.DailyNewRecipients,
+		UpdatedAt:          o.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// limitScope reads the scope and id of a limits path. Card ids are
+// normalized.
+func limitScope(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	scope, id := r.PathValue("scope"), r.PathValue("id")
+	switch scope {
+	case LimitScopeSegment, LimitScopeUser:
+	case LimitScopeCard:
+		cardId, err := uuid.Parse(id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid card id"})
+			return "", "", false
+		}
+		id = cardId.String()
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid scope"})
+		return "", "", false
+	}
+	return scope, id, true
+}
+
+func ListLimitOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := r.URL.Query().Get("scope")
+	switch scope {
+	case "", LimitScopeSegment, LimitScopeUser, LimitScopeCard:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid scope"})
+		return
+	}
+
+	list, err := dbClient.ListLimitOverrides(scope)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list limits"})
+		return
+	}
+
+	dtos := make([]LimitOverrideDTO, len(list))
+	for i, o := range list {
+		dtos[i] = limitOverrideToDTO(o)
+	}
+	_ = json.NewEncoder(w).Encode(LimitOverrideListResponse{Limits: dtos})
+}
+
+func GetLimitOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope, id, ok := limitScope(w, r)
+	if !ok {
+		return
+	}
+
+	o, err := dbClient.GetLimitOverride(scope, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "limits not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get limits"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(limitOverrideToDTO(o))
+}
+
+// SetLimitOverrideHandler creates or replaces the limits of a segment, user
+// or card. They apply to the transfers enqueued from then on.
+func SetLimitOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope, id, ok := limitScope(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	o := &LimitOverride{
+		Scope:              scope,
+		ScopeID:            id,
+		MaxAmount:          req.MaxAmount,
+		DailyAmount:        req.DailyAmount,
+		MonthlyAmount:      req.MonthlyAmount,
+		HourlyTransfers:    req.HourlyTransfers,
+		DailyNewRecipients: req.DailyNewRecipients,
+	}
+	if err := validateLimitOverride(o); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	switch scope {
+	case LimitScopeUser:
+		if _, err := dbClient.GetUserByID(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "user not found"})
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get user"})
+			return
+		}
+	case LimitScopeCard:
+		if _, err := dbClient.GetCardByID(uuid.MustParse(id)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "card not found"})
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get card"})
+			return
+		}
+	}
+
+	if err := dbClient.SetLimitOverride(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to set limits"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(limitOverrideToDTO(o))
+}
+
+func DeleteLimitOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scope, id, ok := limitScope(w, r)
+	if !ok {
+		return
+	}
+
+	if err := dbClient.DeleteLimitOverride(scope, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "limits not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to delete limits"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listEntryToDTO(e *ListEntry, now time.Time) ListEntryDTO {
+	dto := ListEntryDTO{
+		ID:        e.ID.String(),
+		List:      e.List,
+		Kind:      e.Kind,
+		Value:     e.Value,
+		Reason:    e.Reason,
+		CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt.Format(time.RFC3339),
+	}
+	if e.ExpiresAt != nil {
+		expiresAt := e.ExpiresAt.Format(time.RFC3339)
+		dto.ExpiresAt = &expiresAt
+		dto.Expired = !e.ExpiresAt.After(now)
+	}
+	if e.UpdatedAt != nil {
+		updatedAt := e.UpdatedAt.Format(time.RFC3339)
+		dto.UpdatedBy = e.UpdatedBy
+		dto.UpdatedAt = &updatedAt
+	}
+	return dto
+}
+
+// CreateListEntryHandler puts a card, user, device or IP range on the block
+// or allow list. It applies to the transfers scored from then on. Adding an
+// entry already listed renews it.
+func CreateListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req CreateListEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	now := time.Now().UTC()
+	e := &ListEntry{List: req.List, Kind: req.Kind, Value: req.Value, Reason: req.Reason, CreatedBy: claims.UserId}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(now) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid expires_at"})
+			return
+		}
+		e.ExpiresAt = &expiresAt
+	}
+	if err := normalizeListEntry(e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.AddListEntry(e); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to add list entry"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(listEntryToDTO(e, now))
+}
+
+func ListListEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query()
+	list, kind := q.Get("list"), q.Get("kind")
+	if list != "" && list != ListBlock && list != ListAllow {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid list"})
+		return
+	}
+	switch kind {
+	case "", ListKindCard, ListKindUser, ListKindDevice, ListKindIP:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid kind"})
+		return
+	}
+	includeExpired := false
+	if v := q.Get("include_expired"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid include_expired"})
+			return
+		}
+		includeExpired = b
+	}
+
+	entries, err := dbClient.ListListEntries(list, kind, includeExpired)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list entries"})
+		return
+	}
+
+	now := time.Now()
+	dtos := make([]ListEntryDTO, len(entries))
+	for i, e := range entries {
+		dtos[i] = listEntryToDTO(e, now)
+	}
+	_ = json.NewEncoder(w).Encode(ListEntryListResponse{Entries: dtos})
+}
+
+func GetListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid entry id"})
+		return
+	}
+
+	e, err := dbClient.GetListEntry(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "entry not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get entry"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(listEntryToDTO(e, time.Now()))
+}
+
+func DeleteListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid entry id"})
+		return
+	}
+
+	if err := dbClient.DeleteListEntry(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "entry not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to delete entry"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ruleToDTO(r *Rule) RuleDTO {
+	return RuleDTO{
+		ID:          r.ID.String(),
+		Name:        r.Name,
+		Version:     r.Version,
+		Expression:  r.Expression,
+		Action:      r.Action,
+		Description: r.Description,
+		Enabled:     r.Enabled,
+		CreatedBy:   r.CreatedBy,
+		CreatedAt:   r.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   r.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func ListRuleVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(RuleVariableListResponse{Variables: RuleVariables()})
+}
+
+// CreateRuleHandler adds a rule at version 1. It applies to the transfers
+// scored from then on if enabled.
+func CreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req CreateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	rule := &Rule{
+		Name:        req.Name,
+		Expression:  req.Expression,
+		Action:      req.Action,
+		Description: req.Description,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		CreatedBy:   claims.UserId,
+	}
+	if err := validateRule(rule); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.CreateRule(rule); err != nil {
+		if errors.Is(err, ErrRuleExists) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to create rule"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ruleToDTO(rule))
+}
+
+func ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rules, err := dbClient.ListRules(false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list rules"})
+		return
+	}
+
+	dtos := make([]RuleDTO, len(rules))
+	for i, rule := range rules {
+		dtos[i] = ruleToDTO(rule)
+	}
+	_ = json.NewEncoder(w).Encode(RuleListResponse{Rules: dtos})
+}
+
+func getRule(w http.ResponseWriter, r *http.Request) (*Rule, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid rule id"})
+		return nil, false
+	}
+
+	rule, err := dbClient.GetRule(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "rule not found"})
+			return nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get rule"})
+		return nil, false
+	}
+	return rule, true
+}
+
+// GetRuleHandler returns a rule with all its versions.
+func GetRuleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rule, ok := getRule(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := dbClient.ListRuleVersions(rule.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get rule versions"})
+		return
+	}
+
+	dto := ruleToDTO(rule)
+	dto.Versions = make([]RuleVersionDTO, len(versions))
+	for i, v := range versions {
+		dto.Versions[i] = RuleVersionDTO{
+			Version:     v.Version,
+			Expression:  v.Expression,
+			Action:      v.Action,
+			Description: v.Description,
+			Enabled:     v.Enabled,
+			CreatedBy:   v.CreatedBy,
+			CreatedAt:   v.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+// UpdateRuleHandler saves the changed fields as the next version of a rule,
+// enabling or disabling it included.
+func UpdateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	rule, ok := getRule(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if req.Expression != nil {
+		rule.Expression = *req.Expression
+	}
+	if req.Action != nil {
+		rule.Action = *req.Action
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if err := validateRule(rule); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.UpdateRule(rule, claims.UserId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "rule not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update rule"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(ruleToDTO(rule))
+}
+
+// Bounds of the transfers a rule dry run or a simulation goes through.
+const (
+	defaultReplayLimit = 10000
+	maxReplayLimit     = 50000
+)
+
+// dateRange parses the YYYY-MM-DD days start and end, both included, into
+// [from, to). Without start, the range covers the days days up to end;
+// without end, up to today.
+func dateRange(start, end string, days int) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if end != "" {
+		t, err := time.Parse("2006-01-02", end)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid end")
+		}
+		to = t.Add(24 * time.Hour)
+	}
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	if start != "" {
+		t, err := time.Parse("2006-01-02", start)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid start")
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("start is after end")
+	}
+	return from, to, nil
+}
+
+func replayLimit(limit int) int {
+	if limit <= 0 {
+		return defaultReplayLimit
+	}
+	return min(limit, maxReplayLimit)
+}
+
+// DryRunRuleHandler evaluates an expression, or a stored rule version, on
+// the transfers the model decided in a period and reports what the rule
+// would have changed. Nothing is stored.
+func DryRunRuleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RuleDryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if req.RuleID != "" {
+		id, err := uuid.Parse(req.RuleID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid rule_id"})
+			return
+		}
+		versions, err := dbClient.ListRuleVersions(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get rule versions"})
+			return
+		}
+		var v *RuleVersion
+		for _, x := range versions {
+			if req.Version == 0 || x.Version == req.Version {
+				v = x
+				break
+			}
+		}
+		if v == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "rule version not found"})
+			return
+		}
+		req.Expression, req.Action = v.Expression, v.Action
+	}
+	if req.Action != RuleBlock && req.Action != RuleChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "action must be block or challenge"})
+		return
+	}
+	expr, err := CompileRuleExpression(req.Expression)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid expression: " + err.Error()})
+		return
+	}
+
+	from, to, err := dateRange(req.Start, req.End, 30)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+	limit := replayLimit(req.Limit)
+
+	transfers, err := dbClient.ListScoredTransfers(from, to, limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list transfers"})
+		return
+	}
+	truncated := len(transfers) > limit
+	transfers = transfers[:min(len(transfers), limit)]
+
+	res := DryRunRule(expr, req.Action, transfers)
+
+	resp := RuleDryRunResponse{
+		Expression:    req.Expression,
+		Action:        req.Action,
+		Start:         from.Format("2006-01-02"),
+		End:           to.Add(-24 * time.Hour).Format("2006-01-02"),
+		Evaluated:     res.Evaluated,
+		Skipped:       res.Skipped,
+		Truncated:     truncated,
+		Matched:       res.Matched,
+		MatchedAmount: res.MatchedAmount,
+		Changed:       res.Changed,
+		Errors:        res.Errors,
+		Samples:       make([]RuleDryRunSampleDTO, len(res.Samples)),
+	}
+	for i, s := range res.Samples {
+		resp.Samples[i] = RuleDryRunSampleDTO{Transfer: transferToDTO(s.Transfer), Status: s.Status, WouldBe: s.WouldBe}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func transferLabelToDTO(l *TransferLabel) TransferLabelDTO {
+	dto := TransferLabelDTO{
+		Label:     l.Label,
+		Note:      l.Note,
+		LabeledBy: l.LabeledBy,
+		LabeledAt: l.LabeledAt.Format(time.RFC3339),
+	}
+	if l.CaseID != nil {
+		dto.CaseID = l.CaseID.String()
+	}
+	return dto
+}
+
+// SetTransferLabelHandler records whether a transfer turned out to be fraud,
+// replacing its label if it has one.
+func SetTransferLabelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid transfer id"})
+		return
+	}
+
+	var req SetTransferLabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	l := &TransferLabel{TransferID: id, Label: req.Label, Note: req.Note, LabeledBy: claims.UserId}
+	if err := validateTransferLabel(l); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if _, err := dbClient.GetTransferByID(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "transfer not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get transfer"})
+		return
+	}
+
+	if err := dbClient.SetTransferLabel(l); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to label transfer"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(transferLabelToDTO(l))
+}
+
+func DeleteTransferLabelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid transfer id"})
+		return
+	}
+
+	if err := dbClient.DeleteTransferLabel(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "label not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to delete label"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func simulationToDTO(s *Simulation) SimulationDTO {
+	return SimulationDTO{
+		ID:        s.ID.String(),
+		Start:     s.Start.Format("2006-01-02"),
+		End:       s.End.Add(-24 * time.Hour).Format("2006-01-02"),
+		Policy:    s.Policy,
+		Report:    s.Report,
+		CreatedBy: s.CreatedBy,
+		CreatedAt: s.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreateSimulationHandler replays the transfers the model decided in a
+// period through a candidate policy and stores the report. Transfers,
+// rules and labels are only read.
+func CreateSimulationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req CreateSimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	from, to, err := dateRange(req.Start, req.End, 30)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	policy := req.Policy
+	if policy.IncludeEnabledRules {
+		enabled, err := dbClient.ListRules(true)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list rules"})
+			return
+		}
+		policy.addEnabledRules(enabled)
+	}
+	rules, err := policy.rules()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	limit := replayLimit(req.Limit)
+	transfers, err := dbClient.ListSimulationTransfers(from, to, limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list transfers"})
+		return
+	}
+	truncated := len(transfers) > limit
+	transfers = transfers[:min(len(transfers), limit)]
+
+	report := Simulate(&policy, rules, transfers)
+	report.Truncated = truncated
+
+	s := &Simulation{Policy: policy, Start: from, End: to, Report: report, CreatedBy: claims.UserId}
+	if err := dbClient.SaveSimulation(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to save simulation"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(simulationToDTO(s))
+}
+
+// maxListedSimulations is how many of the latest simulations are listed.
+const maxListedSimulations = 100
+
+// ListSimulationsHandler lists the latest simulations without their
+// samples.
+func ListSimulationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sims, err := dbClient.ListSimulations(maxListedSimulations)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list simulations"})
+		return
+	}
+
+	dtos := make([]SimulationDTO, len(sims))
+	for i, s := range sims {
+		dtos[i] = simulationToDTO(s)
+	}
+	_ = json.NewEncoder(w).Encode(SimulationListResponse{Simulations: dtos})
+}
+
+func GetSimulationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid simulation id"})
+		return
+	}
+
+	s, err := dbClient.GetSimulation(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "simulation not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get simulation"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(simulationToDTO(s))
+}
+
+func caseToDTO(c *Case) CaseDTO {
+	dto := CaseDTO{
+		ID:          c.ID.String(),
+		Title:       c.Title,
+		Description: c.Description,
+		Status:      c.Status,
+		Assignee:    c.Assignee,
+		CreatedBy:   c.CreatedBy,
+		CreatedAt:   c.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   c.UpdatedAt.Format(time.RFC3339),
+	}
+	if c.ClosedAt != nil {
+		closedAt := c.ClosedAt.Format(time.RFC3339)
+		dto.ClosedAt = &closedAt
+	}
+	return dto
+}
+
+func caseRefsToDTO(cases []*Case) []CaseRefDTO {
+	out := make([]CaseRefDTO, len(cases))
+	for i, c := range cases {
+		out[i] = CaseRefDTO{ID: c.ID.String(), Title: c.Title, Status: c.Status, Assignee: c.Assignee}
+	}
+	return out
+}
+
+func caseAttachmentToDTO(a *CaseAttachment) CaseAttachmentDTO {
+	return CaseAttachmentDTO{
+		ID:          a.ID.String(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		UploadedBy:  a.UploadedBy,
+		CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// writeCaseError writes the response for an error of a case change that
+// the admin can fix, and reports whether err was one.
+func writeCaseError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrCaseTransition), errors.Is(err, ErrCaseLabelConflict):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, ErrUnknownCaseEntity), errors.Is(err, ErrUnknownSuperuser):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	default:
+		return false
+	}
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	return true
+}
+
+// CreateCaseHandler opens a case, optionally assigned and with linked
+// users, cards and transfers.
+func CreateCaseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req CreateCaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	c := &Case{
+		Title:       strings.TrimSpace(req.Title),
+		Description: req.Description,
+		Assignee:    req.Assignee,
+		CreatedBy:   claims.UserId,
+	}
+	if c.Title == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "missing title"})
+		return
+	}
+	entities := make([]*CaseEntity, len(req.Entities))
+	for i, e := range req.Entities {
+		entities[i] = &CaseEntity{Kind: e.Kind, EntityID: e.EntityID}
+		if err := normalizeCaseEntity(entities[i]); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	if err := dbClient.CreateCase(c, entities); err != nil {
+		if writeCaseError(w, err) {
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to create case"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(caseToDTO(c))
+}
+
+// ListCasesHandler lists cases, most recently updated first, optionally
+// filtered by status and assignee.
+func ListCasesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if _, ok := caseTransitions[status]; status != "" && !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid status"})
+		return
+	}
+
+	cases, err := dbClient.ListCases(status, r.URL.Query().Get("assignee"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list cases"})
+		return
+	}
+
+	dtos := make([]CaseDTO, len(cases))
+	for i, c := range cases {
+		dtos[i] = caseToDTO(c)
+	}
+	_ = json.NewEncoder(w).Encode(CaseListResponse{Cases: dtos})
+}
+
+func getCase(w http.ResponseWriter, r *http.Request) (*Case, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid case id"})
+		return nil, false
+	}
+
+	c, err := dbClient.GetCase(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "case not found"})
+			return nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get case"})
+		return nil, false
+	}
+	return c, true
+}
+
+// GetCaseHandler returns a case with its linked entities, notes,
+// attachments and timeline.
+func GetCaseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, ok := getCase(w, r)
+	if !ok {
+		return
+	}
+
+	entities, err := dbClient.ListCaseEntities(c.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list case entities"})
+		return
+	}
+	notes, err := dbClient.ListCaseNotes(c.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list case notes"})
+		return
+	}
+	attachments, err := dbClient.ListCaseAttachments(c.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list case attachments"})
+		return
+	}
+	events, err := dbClient.ListCaseEvents(c.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list case events"})
+		return
+	}
+
+	dto := caseToDTO(c)
+	dto.Entities = make([]CaseEntityDTO, len(entities))
+	for i, e := range entities {
+		dto.Entities[i] = CaseEntityDTO{Kind: e.Kind, EntityID: e.EntityID, AddedBy: e.AddedBy, AddedAt: e.AddedAt.Format(time.RFC3339)}
+	}
+	dto.Notes = make([]CaseNoteDTO, len(notes))
+	for i, n := range notes {
+		dto.Notes[i] = CaseNoteDTO{ID: n.ID.String(), Body: n.Body, Author: n.Author, CreatedAt: n.CreatedAt.Format(time.RFC3339)}
+	}
+	dto.Attachments = make([]CaseAttachmentDTO, len(attachments))
+	for i, a := range attachments {
+		dto.Attachments[i] = caseAttachmentToDTO(a)
+	}
+	dto.Timeline = make([]CaseEventDTO, len(events))
+	for i, e := range events {
+		dto.Timeline[i] = CaseEventDTO{Type: e.Type, Actor: e.Actor, Data: e.Data, CreatedAt: e.CreatedAt.Format(time.RFC3339)}
+	}
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+// UpdateCaseHandler edits, assigns or moves a case along its workflow.
+// Closing a case labels its linked transfers as fraud or legit.
+func UpdateCaseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid case id"})
+		return
+	}
+
+	var req UpdateCaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	u := CaseUpdate{Title: req.Title, Description: req.Description, Status: req.Status, Assignee: req.Assignee}
+	c, err := dbClient.UpdateCase(id, u, claims.UserId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "case not found"})
+			return
+		}
+		if writeCaseError(w, err) {
+			return
+		}
+		if errors.Is(err, errInvalidCaseUpdate) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update case"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(caseToDTO(c))
+}
+
+// AddCaseEntityHandler links a user, card or transfer to a case. Linking
+// it again does nothing.
+func AddCaseEntityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	c, ok := getCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req CaseEntityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	e := &CaseEntity{CaseID: c.ID, Kind: req.Kind, EntityID: req.EntityID, AddedBy: claims.UserId}
+	if err := normalizeCaseEntity(e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.AddCaseEntity(e); err != nil {
+		if writeCaseError(w, err) {
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to link entity"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func RemoveCaseEntityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	c, ok := getCase(w, r)
+	if !ok {
+		return
+	}
+
+	e := &CaseEntity{Kind: r.PathValue("kind"), EntityID: r.PathValue("entityId")}
+	if err := normalizeCaseEntity(e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := dbClient.RemoveCaseEntity(c.ID, e.Kind, e.EntityID, claims.UserId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "entity not linked to case"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to unlink entity"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func AddCaseNoteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	c, ok := getCase(w, r)
+	if !ok {
+		return
+	}
+
+	var req AddCaseNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "missing body"})
+		return
+	}
+
+	n := &CaseNote{CaseID: c.ID, Body: req.Body, Author: claims.UserId}
+	if err := dbClient.AddCaseNote(n); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to add note"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(CaseNoteDTO{ID: n.ID.String(), Body: n.Body, Author: n.Author, CreatedAt: n.CreatedAt.Format(time.RFC3339)})
+}
+
+// UploadCaseAttachmentHandler stores the "file" part of a multipart upload
+// in the attachment store and attaches it to a case.
+func UploadCaseAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, ok := auth.JwtClaimsFromContext(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	c, ok := getCase(w, r)
+	if !ok {
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "expected a multipart upload"})
+		return
+	}
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "missing file"})
+			return
+		}
+		if part.FormName() == "file" {
+			break
+		}
+	}
+	defer part.Close()
+
+	a := &CaseAttachment{
+		ID:          uuid.New(),
+		CaseID:      c.ID,
+		Filename:    filepath.Base(part.FileName()),
+		ContentType: part.Header.Get("Content-Type"),
+		UploadedBy:  claims.UserId,
+	}
+	if a.Filename == "." || a.Filename == string(filepath.Separator) {
+		a.Filename = "attachment"
+	}
+	if a.ContentType == "" {
+		a.ContentType = "application/octet-stream"
+	}
+	a.StorageKey = a.ID.String()
+
+	// one byte over the limit tells a file of the maximum size from a
+	// larger one
+	a.Size, err = attachmentStore.Put(a.StorageKey, io.LimitReader(part, caseAttachmentMaxBytes+1))
+	if err != nil {
+		attachmentStore.Delete(a.StorageKey)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to store attachment"})
+		return
+	}
+	if a.Size > caseAttachmentMaxBytes {
+		attachmentStore.Delete(a.StorageKey)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "attachment too large"})
+		return
+	}
+
+	if err := dbClient.AddCaseAttachment(a); err != nil {
+		attachmentStore.Delete(a.StorageKey)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to add attachment"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(caseAttachmentToDTO(a))
+}
+
+// DownloadCaseAttachmentHandler returns the content of a case attachment.
+func DownloadCaseAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	caseID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid case id"})
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("attachmentId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid attachment id"})
+		return
+	}
+
+	a, err := dbClient.GetCaseAttachment(caseID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "attachment not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get attachment"})
+		return
+	}
+
+	f, err := attachmentStore.Open(a.StorageKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to open attachment"})
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = io.Copy(w, f)
+}
+
+// GetUserHandler returns a user with the cases they are linked to.
+func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, err := dbClient.GetUserByID(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "user not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get user"})
+		return
+	}
+
+	cases, err := dbClient.ListCasesForUser(user.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list cases"})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(AdminUserResponse{
+		User:  userToDTO(user),
+		Cases: caseRefsToDTO(cases),
+	})
+}
+
+// UpdateUserHandler changes the status of a user. Blocking a user adds a
+// user.blocked event.
+func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if req.Status != nil && UserStatus(*req.Status) != StatusActive && UserStatus(*req.Status) != StatusBlocked {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "status must be active or blocked"})
+		return
+	}
+	if req.Segment != nil && strings.TrimSpace(*req.Segment) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "segment must not be empty"})
+		return
+	}
+
+	user, err := dbClient.GetUserByID(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "user not found"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get user"})
+		return
+	}
+
+	if req.Status != nil {
+		user.Status = UserStatus(*req.Status)
+	}
+	if req.Segment != nil {
+		user.Segment = strings.TrimSpace(*req.Segment)
+	}
+	if err := dbClient.UpdateUser(user); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update user"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(userToDTO(user))
+}
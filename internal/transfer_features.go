@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"math"
+	"time"
+)
+
+type TransferHistory struct {
+	// Sender's transfers of at least the last 30 days, blocked ones included.
+	Recent []*Transfer
+	// Whether the sender ever made an executed transfer to feats.Direction.
+	KnownDestination bool
+	// Current balance of the source card.
+	Balance float64
+	// Wrong one-time codes entered by the sender in the last 30 days.
+	FailedChallengeAttempts int
+}
+
+// ComputeTransferFeatures fills the transfer-history part of feats for a new
+// transfer of feats.Amount made at transdate.
+//
+// Velocity counts and sums include blocked and unconfirmed attempts, while
+// the amount z-score is computed against executed transfers only so that
+// rejected fraud attempts don't shift the customer's baseline.
+func ComputeTransferFeatures(feats *ModelFeatures, h *TransferHistory, transdate time.Time) *ModelFeatures {
+	if !h.KnownDestination {
+		feats.IsNewDestination = 1
+	}
+	if h.Balance > 0 {
+		feats.AmountToBalanceRatio = feats.Amount / h.Balance
+	}
+	feats.FailedChallengeAttempts30d = h.FailedChallengeAttempts
+
+	cutoff1h := transdate.Add(-time.Hour)
+	cutoff24h := transdate.Add(-24 * time.Hour)
+	cutoff7 := transdate.Add(-7 * 24 * time.Hour)
+	cutoff30 := transdate.Add(-30 * 24 * time.Hour)
+
+	// -1 means there is no previous transfer
+	feats.SecondsSinceLastTransfer = -1
+	var last time.Time
+
+	amounts := make([]float64, 0)
+	for _, t := range h.Recent {
+		if t.When.After(transdate) || t.When.Before(cutoff30) {
+			continue
+		}
+		if t.When.After(last) {
+			last = t.When
+		}
+
+		if !t.When.Before(cutoff7) {
+			feats.TransfersLast7d++
+			feats.AmountSumLast7d += t.Amount
+		}
+		if !t.When.Before(cutoff24h) {
+			feats.TransfersLast24h++
+			feats.AmountSumLast24h += t.Amount
+		}
+		if !t.When.Before(cutoff1h) {
+			feats.TransfersLast1h++
+			feats.AmountSumLast1h += t.Amount
+		}
+
+		if t.Executed() {
+			amounts = append(amounts, t.Amount)
+		}
+	}
+
+	if !last.IsZero() {
+		feats.SecondsSinceLastTransfer = transdate.Sub(last).Seconds()
+	}
+
+	if len(amounts) >= 2 {
+		sum := 0.0
+		for _, v := range amounts {
+			sum += v
+		}
+		mean := sum / float64(len(amounts))
+
+		varSum := 0.0
+		for _, v := range amounts {
+			varSum += (v - mean) * (v - mean)
+		}
+		std := math.Sqrt(varSum / float64(len(amounts)))
+		if std != 0 {
+			feats.AmountZscore30d = (feats.Amount - mean) / std
+		}
+	}
+
+	return feats
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EnqueueTransfer inserts t, which must be pending, together with its
+// scoring job. It returns a *LimitError, inserting nothing, if t exceeds the
+// spending limits of its sender; the transfers of a sender are enqueued one
+// at a time so that concurrent ones cannot both fit.
+func (db *DB) EnqueueTransfer(t *Transfer) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, limitsLock, t.FromUserID); err != nil {
+		return err
+	}
+	if err := checkTransferLimits(tx, t); err != nil {
+		return err
+	}
+	if err := insertTransfer(tx, t); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO transfer_jobs (transfer_id, run_at) VALUES ($1, $2)`, t.ID, t.When); err != nil {
+		return err
+	}
+	if err := addTransferEvent(tx, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimTransferJob locks the oldest runnable job for lease and returns it,
+// or nil if there is none. Jobs locked by other workers are skipped rather
+// than waited on; a job whose lease ran out is runnable again.
+func (db *DB) ClaimTransferJob(lease time.Duration) (*TransferJob, error) {
+	var j TransferJob
+	err := db.conn.Get(&j, `UPDATE transfer_jobs
+		SET attempts = attempts + 1, locked_until = now() + $1 * interval '1 millisecond', updated_at = now()
+		WHERE transfer_id = (
+			SELECT transfer_id FROM transfer_jobs
+			WHERE status = 'queued' AND run_at <= now() AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING transfer_id, status, attempts, run_at, locked_until, last_error`, lease.Milliseconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// SettleTransfer stores the decision on the job's pending transfer, with
+// the challenge of a challenged transfer, and completes the job. It returns
+// false without changing anything if the job was claimed again since, its
+// lease having run out.
+func (db *DB) SettleTransfer(j *TransferJob, t *Transfer, c *TransferChallenge) (bool, error) {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE transfer_jobs SET status = 'done', locked_until = NULL, updated_at = now()
+		WHERE transfer_id = $1 AND attempts = $2 AND status = 'queued'`, j.TransferID, j.Attempts)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	res, err = tx.NamedExec(`UPDATE transfers
+		SET fraud_score = :fraud_score, is_blocked = :is_blocked, status = :status, is_new_device = :is_new_device,
+			model = NULLIF(:model, ''), explanation = :explanation, list_hits = :list_hits,
+			rule_hits = :rule_hits, features = :features
+		WHERE id = :id AND status = 'pending'`, t)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		if err := addTransferEvent(tx, t); err != nil {
+			return false, err
+		}
+	}
+	if c != nil {
+		c.TransferID = t.ID
+		if _, err := tx.NamedExec(`INSERT INTO transfer_challenges (id, transfer_id, user_id, code_hash, created_at, expires_at)
+			VALUES (:id, :transfer_id, :user_id, :code_hash, :created_at, :expires_at)`, c); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RetryTransferJob releases the job to be claimed again after delay.
+func (db *DB) RetryTransferJob(j *TransferJob, delay time.Duration, cause string) error {
+	_, err := db.conn.Exec(`UPDATE transfer_jobs SET run_at = now() + $3 * interval '1 millisecond', locked_until = NULL, last_error = $4, updated_at = now()
+		WHERE transfer_id = $1 AND attempts = $2 AND status = 'queued'`, j.TransferID, j.Attempts, delay.Milliseconds(), cause)
+	return err
+}
+
+// FailTransferJob gives up on the job; its transfer is not executed.
+func (db *DB) FailTransferJob(j *TransferJob, cause string) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE transfer_jobs SET status = 'failed', locked_until = NULL, last_error = $3, updated_at = now()
+		WHERE transfer_id = $1 AND attempts = $2 AND status = 'queued'`, j.TransferID, j.Attempts, cause)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	var t Transfer
+	err = tx.Get(&t, `UPDATE transfers SET status = 'failed' WHERE id = $1 AND status = 'pending' RETURNING `+transferColumns, j.TransferID)
+	if err == nil {
+		err = addTransferEvent(tx, &t)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTransferForUser returns the user's transfer, sql.ErrNoRows if the user
+// made no such transfer.
+func (db *DB) GetTransferForUser(userId string, id uuid.UUID) (*Transfer, error) {
+	var t Transfer
+	err := db.conn.Get(&t, `SELECT `+transferColumns+` FROM transfers WHERE id=$1 AND from_user_id=$2`, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (db *DB) GetChallengeForTransfer(transferId uuid.UUID) (*TransferChallenge, error) {
+	var c TransferChallenge
+	err := db.conn.Get(&c, `SELECT id, transfer_id, user_id, code_hash, created_at, expires_at, failed_attempts, confirmed_at
+		FROM transfer_challenges WHERE transfer_id=$1`, transferId)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
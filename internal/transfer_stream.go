@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+var (
+	transferStreamPollInterval time.Duration
+	transferStreamHeartbeat    time.Duration
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	// streams also poll: an event is held back while an older transaction
+	// is still running
+	viper.SetDefault("TRANSFER_STREAM_POLL_INTERVAL", time.Second)
+	viper.SetDefault("TRANSFER_STREAM_HEARTBEAT", 15*time.Second)
+	transferStreamPollInterval = viper.GetDuration("TRANSFER_STREAM_POLL_INTERVAL")
+	transferStreamHeartbeat = viper.GetDuration("TRANSFER_STREAM_HEARTBEAT")
+}
+
+// transferStreamEventTypes are the events of GET /admin/stream/transfers:
+// a transfer decided, or its status changed by a challenge.
+var transferStreamEventTypes = []string{
+	EventTransferApproved,
+	EventTransferBlocked,
+	EventTransferChallenged,
+	EventTransferExpired,
+	EventTransferFailed,
+}
+
+// streamPosition is a position in the outbox events of every key, in the
+// order they become visible: by transaction, then id. It is sent as the id
+// of an event, "<txid>-<id>".
+type streamPosition struct {
+	TxID int64
+	ID   int64
+}
+
+func (p streamPosition) String() string {
+	return fmt.Sprintf("%d-%d", p.TxID, p.ID)
+}
+
+func parseStreamPosition(s string) (streamPosition, error) {
+	txid, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return streamPosition{}, errors.New("invalid stream position")
+	}
+	var p streamPosition
+	var err error
+	if p.TxID, err = strconv.ParseInt(txid, 10, 64); err != nil {
+		return streamPosition{}, err
+	}
+	if p.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return streamPosition{}, err
+	}
+	return p, nil
+}
+
+// StreamEvent is an outbox event with its position in the stream.
+type StreamEvent struct {
+	TxID int64 `db:"txid"`
+	OutboxEvent
+}
+
+func (e *StreamEvent) Position() streamPosition {
+	return streamPosition{TxID: e.TxID, ID: e.ID}
+}
+
+// TransferStreamHead returns the position of the stream now: the events
+// after it are those of the transactions still running or yet to start.
+func (db *DB) TransferStreamHead() (streamPosition, error) {
+	var txid int64
+	err := db.conn.Get(&txid, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`)
+	return streamPosition{TxID: txid}, err
+}
+
+// ListStreamEvents returns up to limit events of types after the position
+// after, in stream order. Events of transactions at or after the oldest one
+// running are left for later, since an event before them may still commit.
+func (db *DB) ListStreamEvents(types []string, after streamPosition, limit int) ([]*StreamEvent, error) {
+	out := make([]*StreamEvent, 0)
+	err := db.conn.Select(&out, `SELECT txid::text::bigint AS txid, id, event_id, type, key, payload, created_at
+		FROM outbox_events
+		WHERE (txid, id) > ($1::text::xid8, $2) AND txid < pg_snapshot_xmin(pg_current_snapshot())
+			AND type = ANY($3)
+		ORDER BY txid, id LIMIT $4`, strconv.FormatInt(after.TxID, 10), after.ID, pq.Array(types), limit)
+	return out, err
+}
+
+// transferEventStore is where the transfer stream reads its events.
+type transferEventStore interface {
+	TransferStreamHead() (streamPosition, error)
+	ListStreamEvents(types []string, after streamPosition, limit int) ([]*StreamEvent, error)
+}
+
+// transferStreamFilter selects the transfers a client is sent.
+type transferStreamFilter struct {
+	BlockedOnly bool
+	MinScore    float64
+}
+
+func (f transferStreamFilter) Match(t *TransferDTO) bool {
+	return (!f.BlockedOnly || t.IsBlocked) && t.FraudScore >= f.MinScore
+}
+
+// TransferStreamHandler streams transfers as they are decided or change
+// status, as server-sent events, from the outbox: every instance streams
+// every transfer. Optional query: blocked=true for blocked transfers only,
+// min_score. A client reconnecting with Last-Event-ID is first sent what it
+// missed.
+func TransferStreamHandler(w http.ResponseWriter, r *http.Request) {
+	streamTransfers(w, r, dbClient)
+}
+
+func streamTransfers(w http.ResponseWriter, r *http.Request, store transferEventStore) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var filter transferStreamFilter
+	q := r.URL.Query()
+	if v := q.Get("blocked"); v != "" {
+		blocked, err := strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid blocked"})
+			return
+		}
+		filter.BlockedOnly = blocked
+	}
+	if v := q.Get("min_score"); v != "" {
+		score, err := strconv.ParseFloat(v, 64)
+		if err != nil || score < 0 || score > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid min_score"})
+			return
+		}
+		filter.MinScore = score
+	}
+
+	// watch before reading the head, so that nothing falls in between
+	wakeup, stop := userEvents.Watch(anyEventKey)
+	defer stop()
+
+	var pos streamPosition
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		p, err := parseStreamPosition(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid Last-Event-ID"})
+			return
+		}
+		pos = p
+	} else {
+		p, err := store.TransferStreamHead()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get events"})
+			return
+		}
+		pos = p
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	poll := time.NewTicker(transferStreamPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(transferStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for {
+			events, err := store.ListStreamEvents(transferStreamEventTypes, pos, notificationBatchSize)
+			if err != nil {
+				// the next poll tries again
+				log.Printf("transfer stream: failed to list events: %v", err)
+				break
+			}
+			for _, e := range events {
+				pos = e.Position()
+				var t TransferDTO
+				if err := json.Unmarshal(e.Payload, &t); err != nil || !filter.Match(&t) {
+					continue
+				}
+				if err := writeSSE(w, pos.String(), "transfer", e.Payload); err != nil {
+					return
+				}
+			}
+			if len(events) < notificationBatchSize {
+				break
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wakeup:
+		case <-poll.C:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
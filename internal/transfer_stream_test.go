@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStreamEvents is a transferEventStore. Events up to horizon are
+// visible; the later ones are of transactions still running.
+type memStreamEvents struct {
+	mu      sync.Mutex
+	events  []*StreamEvent
+	horizon int64
+}
+
+func (s *memStreamEvents) TransferStreamHead() (streamPosition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return streamPosition{TxID: s.horizon + 1}, nil
+}
+
+func (s *memStreamEvents) ListStreamEvents(types []string, after streamPosition, limit int) ([]*StreamEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*StreamEvent, 0)
+	for _, e := range s.events {
+		p := e.Position()
+		newer := p.TxID > after.TxID || (p.TxID == after.TxID && p.ID > after.ID)
+		if newer && e.TxID <= s.horizon && slices.Contains(types, e.Type) && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// add appends an event of transaction txid, visible from then on if
+// visible is set.
+func (s *memStreamEvents) add(txid int64, typ string, t TransferDTO, visible bool) {
+	b, _ := json.Marshal(t)
+	s.mu.Lock()
+	e := &StreamEvent{TxID: txid, OutboxEvent: OutboxEvent{ID: int64(len(s.events) + 1), Type: typ, Key: t.FromUserID, Payload: b}}
+	s.events = append(s.events, e)
+	slices.SortFunc(s.events, func(a, b *StreamEvent) int {
+		if a.TxID != b.TxID {
+			return int(a.TxID - b.TxID)
+		}
+		return int(a.ID - b.ID)
+	})
+	if visible {
+		s.horizon = max(s.horizon, txid)
+	}
+	s.mu.Unlock()
+	userEvents.Notify(t.FromUserID)
+}
+
+func (s *memStreamEvents) setHorizon(txid int64) {
+	s.mu.Lock()
+	s.horizon = txid
+	s.mu.Unlock()
+	userEvents.Notify("alice")
+}
+
+// readStream returns the ids and amounts of the first n events of an
+// event stream.
+func readStream(t *testing.T, store transferEventStore, query, lastEventID string, n int, after func()) ([]string, []float64) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streamTransfers(w, r, store)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	after()
+
+	var ids []string
+	var amounts []float64
+	r := bufio.NewReader(resp.Body)
+	for len(amounts) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var dto TransferDTO
+			if err := json.Unmarshal([]byte(data), &dto); err != nil {
+				t.Fatal(err)
+			}
+			amounts = append(amounts, dto.Amount)
+		}
+	}
+	return ids, amounts
+}
+
+func TestTransferStreamResume(t *testing.T) {
+	store := &memStreamEvents{}
+	store.add(1, EventTransferBlocked, TransferDTO{FromUserID: "alice", Amount: 1, FraudScore: 0.9, IsBlocked: true}, true)
+	store.add(2, EventTransferCreated, TransferDTO{FromUserID: "alice", Amount: 2, FraudScore: 0.9, IsBlocked: true}, true)
+	store.add(2, EventTransferBlocked, TransferDTO{FromUserID: "bob", Amount: 3, FraudScore: 0.9, IsBlocked: true}, true)
+	store.add(3, EventTransferBlocked, TransferDTO{FromUserID: "alice", Amount: 4, FraudScore: 0.3, IsBlocked: true}, true)
+
+	ids, amounts := readStream(t, store, "?blocked=true&min_score=0.5", "1-1", 2, func() {
+		store.add(4, EventTransferApproved, TransferDTO{FromUserID: "bob", Amount: 5, FraudScore: 0.95}, true)
+		store.add(4, EventTransferBlocked, TransferDTO{FromUserID: "bob", Amount: 6, FraudScore: 0.7, IsBlocked: true}, true)
+	})
+	// created events are not streamed, the others are filtered out
+	if !slices.Equal(amounts, []float64{3, 6}) {
+		t.Errorf("got amounts %v", amounts)
+	}
+	if !slices.Equal(ids, []string{"2-3", "4-6"}) {
+		t.Errorf("got ids %v", ids)
+	}
+}
+
+func TestTransferStreamStatusChanges(t *testing.T) {
+	store := &memStreamEvents{horizon: 10}
+	_, amounts := readStream(t, store, "", "", 3, func() {
+		// a challenge confirmed, another expired, in transactions
+		// committing out of order: the later is held back until the
+		// earlier commits
+		store.add(11, EventTransferChallenged, TransferDTO{FromUserID: "alice", Amount: 1, Status: TransferChallengeRequired}, true)
+		store.add(13, EventTransferExpired, TransferDTO{FromUserID: "bob", Amount: 3, Status: TransferChallengeExpired}, false)
+		store.add(12, EventTransferApproved, TransferDTO{FromUserID: "alice", Amount: 2, Status: TransferCompleted}, false)
+		store.setHorizon(13)
+	})
+	if !slices.Equal(amounts, []float64{1, 2, 3}) {
+		t.Errorf("got amounts %v", amounts)
+	}
+}
+
+func TestParseStreamPosition(t *testing.T) {
+	p := streamPosition{TxID: 812, ID: 40961}
+	if got, err := parseStreamPosition(p.String()); err != nil || got != p {
+		t.Errorf("got %v, %v", got, err)
+	}
+	for _, s := range []string{"", "42", "a-1", "1-b"} {
+		if _, err := parseStreamPosition(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestTransferStreamHandlerInvalidQuery(t *testing.T) {
+	for _, q := range []string{"?blocked=maybe", "?min_score=2", "?min_score=high"} {
+		w := httptest.NewRecorder()
+		streamTransfers(w, httptest.NewRequest(http.MethodGet, "/admin/stream/transfers"+q, nil), &memStreamEvents{})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", q, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/stream/transfers", nil)
+	r.Header.Set("Last-Event-ID", "1764000000123")
+	streamTransfers(w, r, &memStreamEvents{})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("old Last-Event-ID: got %d", w.Code)
+	}
+}
@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	transferWorkers         int
+	transferJobLease        time.Duration
+	transferJobMaxAttempts  int
+	transferJobPollInterval time.Duration
+	transferJobBackoff      time.Duration
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	viper.SetDefault("TRANSFER_WORKERS", 4)
+	// must outlast a job: the model call with its retries plus the queries
+	viper.SetDefault("TRANSFER_JOB_LEASE", time.Minute)
+	viper.SetDefault("TRANSFER_JOB_MAX_ATTEMPTS", 5)
+	viper.SetDefault("TRANSFER_JOB_POLL_INTERVAL", time.Second)
+	viper.SetDefault("TRANSFER_JOB_BACKOFF", 2*time.Second)
+	transferWorkers = viper.GetInt("TRANSFER_WORKERS")
+	transferJobLease = viper.GetDuration("TRANSFER_JOB_LEASE")
+	transferJobMaxAttempts = viper.GetInt("TRANSFER_JOB_MAX_ATTEMPTS")
+	transferJobPollInterval = viper.GetDuration("TRANSFER_JOB_POLL_INTERVAL")
+	transferJobBackoff = viper.GetDuration("TRANSFER_JOB_BACKOFF")
+}
+
+// transferJobsReady wakes an idle worker when a transfer is enqueued so that
+// it does not wait for the next poll.
+var transferJobsReady = make(chan struct{}, 1)
+
+func notifyTransferWorkers() {
+	select {
+	case transferJobsReady <- struct{}{}:
+	default:
+	}
+}
+
+// transferJobQueue is the store of transfer jobs the workers run; the
+// database in production.
+type transferJobQueue interface {
+	ClaimTransferJob(lease time.Duration) (*TransferJob, error)
+	RetryTransferJob(j *TransferJob, delay time.Duration, cause string) error
+	FailTransferJob(j *TransferJob, cause string) error
+}
+
+// StartTransferWorkers starts TRANSFER_WORKERS workers scoring pending
+// transfers until ctx is done, and returns a channel closed once they have
+// all stopped. Jobs left behind by a crashed or stopped process are picked
+// up again once their lease has run out.
+func StartTransferWorkers(ctx context.Context) <-chan struct{} {
+	var wg sync.WaitGroup
+	for range max(transferWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTransferWorker(ctx, dbClient, processTransferJob)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func runTransferWorker(ctx context.Context, q transferJobQueue, process func(context.Context, *TransferJob) error) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-transferJobsReady:
+		}
+
+		for ctx.Err() == nil {
+			j, err := q.ClaimTransferJob(transferJobLease)
+			if err != nil {
+				log.Printf("failed to claim transfer job: %v", err)
+				break
+			}
+			if j == nil {
+				break
+			}
+			runTransferJob(ctx, q, j, process)
+		}
+		timer.Reset(transferJobPollInterval)
+	}
+}
+
+// transferJobRetryDelay is the backoff before the next attempt of a job
+// that failed attempts times.
+func transferJobRetryDelay(attempts int) time.Duration {
+	return transferJobBackoff << min(attempts-1, 10)
+}
+
+func runTransferJob(ctx context.Context, q transferJobQueue, j *TransferJob, process func(context.Context, *TransferJob) error) {
+	jobCtx, cancel := context.WithTimeout(ctx, transferJobLease)
+	defer cancel()
+
+	err := process(jobCtx, j)
+	if err == nil || ctx.Err() != nil {
+		// on shutdown the job is resumed when its lease runs out
+		return
+	}
+
+	if j.Attempts >= transferJobMaxAttempts {
+		log.Printf("transfer %s: giving up after %d attempts: %v", j.TransferID, j.Attempts, err)
+		if err := q.FailTransferJob(j, err.Error()); err != nil {
+			log.Printf("transfer %s: failed to fail job: %v", j.TransferID, err)
+		}
+		return
+	}
+	if err := q.RetryTransferJob(j, transferJobRetryDelay(j.Attempts), err.Error()); err != nil {
+		log.Printf("transfer %s: failed to reschedule job: %v", j.TransferID, err)
+	}
+}
+
+// processTransferJob decides on the job's transfer and settles it:
+// completed, blocked, or challenged with a one-time code sent to the user.
+// A transfer hitting the block or allow list is decided without the model;
+// otherwise the enabled rules can tighten the model's decision.
+func processTransferJob(ctx context.Context, j *TransferJob) error {
+	t, err := dbClient.GetTransferByID(j.TransferID)
+	if err != nil {
+		return err
+	}
+	fromCard, err := dbClient.GetCardByID(t.FromCardID)
+	if err != nil {
+		return err
+	}
+	toCard, err := dbClient.GetCardByID(t.ToCardID)
+	if err != nil {
+		return err
+	}
+
+	t.ListHits, err = dbClient.MatchLists(t, fromCard, toCard, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	var pr *Prediction
+	if decision := t.ListHits.Decision(); decision != "" {
+		decideByList(t, decision)
+	} else {
+		var feats *ModelFeatures
+		pr, feats, err = scoreTransfer(ctx, t, fromCard, toCard)
+		if err != nil {
+			return err
+		}
+		t.FraudScore = pr.FraudProbability
+		t.IsBlocked = pr.BlockTransaction
+		t.Status = transferStatus(pr.PredictResponse)
+		t.Model = pr.Model
+		t.Explanation = Explain(feats, pr.PredictResponse)
+		t.Features = feats
+		if err := applyRules(t, pr.FraudProbability); err != nil {
+			return err
+		}
+	}
+
+	var c *TransferChallenge
+	var code string
+	if t.Status == TransferChallengeRequired {
+		c, code, err = newChallenge(t, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	settled, err := dbClient.SettleTransfer(j, t, c)
+	if err != nil || !settled {
+		// not settled: the lease ran out and another worker owns the job
+		return err
+	}
+	if c != nil {
+		// the decision is stored; a code that is not delivered lets the
+		// challenge expire and the transfer is not executed
+		err := otpSender.SendOTP(ctx, OTPMessage{
+			UserID:      t.FromUserID,
+			TransferID:  t.ID.String(),
+			ChallengeID: c.ID.String(),
+			Code:        code,
+			ExpiresAt:   c.ExpiresAt,
+		})
+		if err != nil {
+			log.Printf("transfer %s: failed to send confirmation code: %v", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// decideByList blocks or approves t on its list hits.
+func decideByList(t *Transfer, decision string) {
+	t.IsBlocked = decision == ListBlock
+	t.FraudScore = 0
+	t.Status = TransferCompleted
+	if t.IsBlocked {
+		t.FraudScore = 1
+		t.Status = TransferBlocked
+	}
+	t.Model = ""
+	t.Explanation = &Explanation{Source: ExplanationList, Features: make([]FeatureContribution, 0)}
+}
+
+// applyRules matches t, scored score by the model, against the enabled
+// rules and tightens its decision with their hits. Rules failing on t are
+// logged and skipped.
+func applyRules(t *Transfer, score float64) error {
+	rules, err := dbClient.ListRules(true)
+	if err != nil {
+		return err
+	}
+	hits, err := MatchRules(rules, &RuleInput{Transfer: t, Features: t.Features, Score: score})
+	if err != nil {
+		log.Printf("transfer %s: %v", t.ID, err)
+	}
+	t.RuleHits = hits
+	t.Status = applyRuleDecision(t.Status, hits.Decision())
+	t.IsBlocked = t.Status == TransferBlocked
+	return nil
+}
+
+// scoreTransfer computes the features of t as of its creation and calls the
+// model. t.IsNewDevice is set along the way.
+func scoreTransfer(ctx context.Context, t *Transfer, fromCard, toCard *Card) (*Prediction, *ModelFeatures, error) {
+	var device *Device
+	var err error
+	if t.DeviceID != "" {
+		device, err = dbClient.GetDevice(t.FromUserID, t.DeviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, err
+		}
+	}
+
+	feats, err := computeFeatures(t, fromCard, toCard)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// devices the user explicitly trusted are not reported as new
+	t.IsNewDevice = feats.IsNewDevice == 1 && (device == nil || !device.Trusted)
+
+	pr, err := PredictFraud(ctx, t.ID, t.FromUserID, feats)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pr, feats, nil
+}
+
+// computeFeatures computes the model features of t as of its creation.
+// The history of t is taken up to t; the balance and whether the recipient
+// is known are as of now.
+func computeFeatures(t *Transfer, fromCard, toCard *Card) (*ModelFeatures, error) {
+	since := t.When.Add(-30 * 24 * time.Hour)
+	recent, err := dbClient.ListTransfersForUserSince(t.FromUserID, since)
+	if err != nil {
+		return nil, err
+	}
+	knownDestination, err := dbClient.HasTransferredTo(t.FromUserID, t.ToCardID)
+	if err != nil {
+		return nil, err
+	}
+	failedChallenges, err := dbClient.CountFailedChallengeAttempts(t.FromUserID, since)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := dbClient.GetRecipientActivity(toCard, since)
+	if err != nil {
+		return nil, err
+	}
+	// the transfer itself is already stored
+	recent = withoutTransfer(recent, t)
+	recipient.Inbound = withoutTransfer(recipient.Inbound, t)
+
+	feats := &ModelFeatures{
+		// CstDimID: t.FromUserID,
+		Amount: t.Amount,
+		// TODO: it's not encrypted
+		Direction: t.ToCardID.String(),
+	}
+	if err := dbClient.LoginFeatures(feats, t.FromUserID, t.DeviceID, t.When); err != nil {
+		return nil, err
+	}
+	ComputeTransferFeatures(feats, &TransferHistory{
+		Recent:           recent,
+		KnownDestination: knownDestination,
+		Balance:          fromCard.Balance,
+
+		FailedChallengeAttempts: failedChallenges,
+	}, t.When)
+	feats.RecipientFeatures = ComputeRecipientFeatures(recipient, t.When)
+	return feats, nil
+}
+
+func withoutTransfer(list []*Transfer, t *Transfer) []*Transfer {
+	out := make([]*Transfer, 0, len(list))
+	for _, x := range list {
+		if x.ID != t.ID {
+			out = append(out, x)
+		}
+	}
+	return out
+}
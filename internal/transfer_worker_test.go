@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memTransferJobs is a transferJobQueue with the lease semantics of the
+// transfer_jobs table.
+type memTransferJobs struct {
+	mu   sync.Mutex
+	jobs []*TransferJob
+}
+
+func (q *memTransferJobs) ClaimTransferJob(lease time.Duration) (*TransferJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, j := range q.jobs {
+		if j.Status != "queued" || j.RunAt.After(now) || (j.LockedUntil != nil && j.LockedUntil.After(now)) {
+			continue
+		}
+		j.Attempts++
+		until := now.Add(lease)
+		j.LockedUntil = &until
+		claimed := *j
+		return &claimed, nil
+	}
+	return nil, nil
+}
+
+// current returns the job claimed as j if it was not claimed again since.
+func (q *memTransferJobs) current(j *TransferJob) *TransferJob {
+	for _, x := range q.jobs {
+		if x.TransferID == j.TransferID && x.Attempts == j.Attempts && x.Status == "queued" {
+			return x
+		}
+	}
+	return nil
+}
+
+func (q *memTransferJobs) RetryTransferJob(j *TransferJob, delay time.Duration, cause string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if x := q.current(j); x != nil {
+		x.RunAt, x.LockedUntil, x.LastError = time.Now().Add(delay), nil, cause
+	}
+	return nil
+}
+
+func (q *memTransferJobs) FailTransferJob(j *TransferJob, cause string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if x := q.current(j); x != nil {
+		x.Status, x.LockedUntil, x.LastError = "failed", nil, cause
+	}
+	return nil
+}
+
+func (q *memTransferJobs) settle(j *TransferJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if x := q.current(j); x != nil {
+		x.Status, x.LockedUntil = "done", nil
+		return true
+	}
+	return false
+}
+
+func (q *memTransferJobs) get(id uuid.UUID) TransferJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, x := range q.jobs {
+		if x.TransferID == id {
+			return *x
+		}
+	}
+	return TransferJob{}
+}
+
+func setTransferJobConfig(t *testing.T, lease, poll, backoff time.Duration, maxAttempts int) {
+	oldLease, oldPoll, oldBackoff, oldMax := transferJobLease, transferJobPollInterval, transferJobBackoff, transferJobMaxAttempts
+	transferJobLease, transferJobPollInterval, transferJobBackoff, transferJobMaxAttempts = lease, poll, backoff, maxAttempts
+	t.Cleanup(func() {
+		transferJobLease, transferJobPollInterval, transferJobBackoff, transferJobMaxAttempts = oldLease, oldPoll, oldBackoff, oldMax
+	})
+}
+
+func TestTransferJobRetryDelay(t *testing.T) {
+	setTransferJobConfig(t, time.Minute, time.Second, 2*time.Second, 5)
+	for attempts, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second, 40: 2048 * time.Second} {
+		if got := transferJobRetryDelay(attempts); got != want {
+			t.Errorf("attempt %d: got %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRunTransferJobRetriesThenFails(t *testing.T) {
+	setTransferJobConfig(t, time.Minute, time.Second, time.Hour, 3)
+	id := uuid.New()
+	q := &memTransferJobs{jobs: []*TransferJob{{TransferID: id, Status: "queued"}}}
+	failing := func(context.Context, *TransferJob) error { return errors.New("model unavailable") }
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		j, _ := q.ClaimTransferJob(transferJobLease)
+		if j == nil || j.Attempts != attempt {
+			t.Fatalf("attempt %d: claimed %+v", attempt, j)
+		}
+		start := time.Now()
+		runTransferJob(context.Background(), q, j, failing)
+
+		got := q.get(id)
+		if attempt < 3 {
+			// released for the next attempt after its backoff
+			if got.Status != "queued" || got.LockedUntil != nil || got.LastError != "model unavailable" {
+				t.Fatalf("attempt %d: got %+v", attempt, got)
+			}
+			if delay := got.RunAt.Sub(start); delay < transferJobRetryDelay(attempt) || delay > transferJobRetryDelay(attempt)+time.Second {
+				t.Errorf("attempt %d: retried after %v, want %v", attempt, delay, transferJobRetryDelay(attempt))
+			}
+			if next, _ := q.ClaimTransferJob(transferJobLease); next != nil {
+				t.Fatalf("attempt %d: claimed during backoff", attempt)
+			}
+			q.mu.Lock()
+			q.jobs[0].RunAt = time.Now()
+			q.mu.Unlock()
+		} else if got.Status != "failed" {
+			t.Fatalf("last attempt: got %+v, want failed", got)
+		}
+	}
+}
+
+func TestTransferWorkerResumesCrashedJob(t *testing.T) {
+	const lease = 100 * time.Millisecond
+	setTransferJobConfig(t, lease, 10*time.Millisecond, time.Hour, 5)
+	id := uuid.New()
+	q := &memTransferJobs{jobs: []*TransferJob{{TransferID: id, Status: "queued"}}}
+
+	// the first worker dies while scoring the transfer
+	crashed := make(chan struct{})
+	ctx, crash := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runTransferWorker(ctx, q, func(ctx context.Context, j *TransferJob) error {
+			close(crashed)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-crashed
+	crashedAt := time.Now()
+	crash()
+	<-stopped
+	if got := q.get(id); got.Status != "queued" || got.Attempts != 1 || got.LockedUntil == nil {
+		t.Fatalf("crashed job: got %+v", got)
+	}
+
+	// another worker resumes it once the lease has run out
+	resumed := make(chan *TransferJob, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped = make(chan struct{})
+	defer func() { cancel(); <-stopped }()
+	go func() {
+		defer close(stopped)
+		runTransferWorker(ctx, q, func(ctx context.Context, j *TransferJob) error {
+			if q.settle(j) {
+				resumed <- j
+			}
+			return nil
+		})
+	}()
+	select {
+	case j := <-resumed:
+		if j.Attempts != 2 {
+			t.Errorf("resumed attempt %d, want 2", j.Attempts)
+		}
+		if elapsed := time.Since(crashedAt); elapsed < lease/2 {
+			t.Errorf("resumed after %v, before the lease ran out", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not resumed")
+	}
+	if got := q.get(id); got.Status != "done" {
+		t.Errorf("got %+v, want done", got)
+	}
+}
@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+var (
+	webhookWorkers      int
+	webhookTimeout      time.Duration
+	webhookMaxAttempts  int
+	webhookBackoff      time.Duration
+	webhookMaxBackoff   time.Duration
+	webhookPollInterval time.Duration
+)
+
+func init() {
+	viper.AutomaticEnv()
+
+	viper.SetDefault("WEBHOOK_WORKERS", 2)
+	viper.SetDefault("WEBHOOK_TIMEOUT", 10*time.Second)
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	viper.SetDefault("WEBHOOK_BACKOFF", 10*time.Second)
+	viper.SetDefault("WEBHOOK_MAX_BACKOFF", time.Hour)
+	viper.SetDefault("WEBHOOK_POLL_INTERVAL", time.Second)
+	webhookWorkers = viper.GetInt("WEBHOOK_WORKERS")
+	webhookTimeout = viper.GetDuration("WEBHOOK_TIMEOUT")
+	webhookMaxAttempts = viper.GetInt("WEBHOOK_MAX_ATTEMPTS")
+	webhookBackoff = viper.GetDuration("WEBHOOK_BACKOFF")
+	webhookMaxBackoff = viper.GetDuration("WEBHOOK_MAX_BACKOFF")
+	webhookPollInterval = viper.GetDuration("WEBHOOK_POLL_INTERVAL")
+}
+
+// Delivery headers. The signature is "sha256=" and the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret; receivers should
+// reject old timestamps to stop replays of captured requests.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// minWebhookSecretLen bounds secrets chosen by admins.
+const minWebhookSecretLen = 16
+
+// SignWebhook returns the signature header value of a delivery.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validateWebhook(s *WebhookSubscription) error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url")
+	}
+	if len(s.Secret) < minWebhookSecretLen {
+		return fmt.Errorf("secret must have at least %d characters", minWebhookSecretLen)
+	}
+	if len(s.EventTypes) == 0 {
+		return fmt.Errorf("no event types")
+	}
+	for _, typ := range s.EventTypes {
+		if !slices.Contains(eventTypes, typ) {
+			return fmt.Errorf("unknown event type %q", typ)
+		}
+	}
+	return nil
+}
+
+// WebhookStatusError is a non-2xx answer of the receiver.
+type WebhookStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WebhookStatusError) Error() string {
+	return fmt.Sprintf("receiver returned %d: %s", e.StatusCode, e.Body)
+}
+
+// sendWebhook posts e to the subscription and returns the receiver's status
+// code, 0 if it did not answer.
+func sendWebhook(ctx context.Context, client *http.Client, s *WebhookSubscription, deliveryID int64, e Event, now time.Time) (int, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, e.Type)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(s.Secret, now.Unix(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &WebhookStatusError{StatusCode: resp.StatusCode, Body: string(snippet)}
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookRetryDelay is the wait after the given failed attempt: doubling
+// from WEBHOOK_BACKOFF, capped at WEBHOOK_MAX_BACKOFF.
+func webhookRetryDelay(attempt int) time.Duration {
+	d := webhookBackoff
+	for i := 1; i < attempt && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, webhookMaxBackoff)
+}
+
+// StartWebhookWorkers starts WEBHOOK_WORKERS workers sending due
+// deliveries until ctx is done.
+func StartWebhookWorkers(ctx context.Context) {
+	client := &http.Client{Timeout: webhookTimeout}
+	for range max(webhookWorkers, 1) {
+		go runWebhookWorker(ctx, client)
+	}
+}
+
+func runWebhookWorker(ctx context.Context, client *http.Client) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		for ctx.Err() == nil {
+			// the lease outlasts the request, so a delivery is only sent
+			// twice if its worker died
+			d, err := dbClient.ClaimWebhookDelivery(2 * webhookTimeout)
+			if err != nil {
+				log.Printf("failed to claim webhook delivery: %v", err)
+				break
+			}
+			if d == nil {
+				break
+			}
+			if err := deliverWebhook(ctx, client, d); err != nil {
+				log.Printf("webhook delivery %d: %v", d.ID, err)
+			}
+		}
+		timer.Reset(webhookPollInterval)
+	}
+}
+
+// deliverWebhook makes one attempt of d and records it.
+func deliverWebhook(ctx context.Context, client *http.Client, d *WebhookDelivery) error {
+	s, err := dbClient.GetWebhook(d.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	e, err := dbClient.GetOutboxEvent(d.EventID)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	a := &WebhookAttempt{DeliveryID: d.ID, AttemptedAt: start.UTC()}
+	if !s.Active {
+		err = errors.New("subscription is disabled")
+	} else {
+		var code int
+		code, err = sendWebhook(ctx, client, s, d.ID, Event{
+			ID:        e.EventID.String(),
+			Type:      e.Type,
+			Key:       e.Key,
+			Payload:   json.RawMessage(e.Payload),
+			CreatedAt: e.CreatedAt,
+		}, start)
+		if code != 0 {
+			a.StatusCode = &code
+		}
+	}
+	if ctx.Err() != nil {
+		// shutting down: the delivery is retried once its lease runs out
+		return nil
+	}
+	a.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		a.Error = err.Error()
+	}
+
+	switch {
+	case err == nil:
+		d.Status = WebhookDelivered
+	case !s.Active || d.Attempts >= webhookMaxAttempts:
+		d.Status = WebhookDead
+	default:
+		d.Status = WebhookPending
+		d.NextAttemptAt = start.Add(webhookRetryDelay(d.Attempts))
+	}
+	return dbClient.RecordWebhookAttempt(d, a)
+}
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_id, e.event_id as event_uuid, e.type as event_type, d.status, d.attempts,
+	d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+const webhookColumns = `id, url, secret, event_types, active, created_at, updated_at`
+
+func (db *DB) CreateWebhook(s *WebhookSubscription) error {
+	return db.conn.Get(s, `INSERT INTO webhook_subscriptions (url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4) RETURNING `+webhookColumns, s.URL, s.Secret, s.EventTypes, s.Active)
+}
+
+func (db *DB) GetWebhook(id uuid.UUID) (*WebhookSubscription, error) {
+	var s WebhookSubscription
+	err := db.conn.Get(&s, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (db *DB) ListWebhooks() ([]*WebhookSubscription, error) {
+	out := make([]*WebhookSubscription, 0)
+	err := db.conn.Select(&out, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY created_at`)
+	return out, err
+}
+
+func (db *DB) UpdateWebhook(s *WebhookSubscription) error {
+	return db.conn.Get(s, `UPDATE webhook_subscriptions SET url=$2, secret=$3, event_types=$4, active=$5, updated_at=now()
+		WHERE id=$1 RETURNING `+webhookColumns, s.ID, s.URL, s.Secret, s.EventTypes, s.Active)
+}
+
+// DeleteWebhook deletes the subscription with its deliveries and their
+// logs. It returns sql.ErrNoRows if there is no such subscription.
+func (db *DB) DeleteWebhook(id uuid.UUID) error {
+	res, err := db.conn.Exec(`DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *DB) GetOutboxEvent(id int64) (*OutboxEvent, error) {
+	var e OutboxEvent
+	err := db.conn.Get(&e, `SELECT id, event_id, type, key, payload, created_at FROM outbox_events WHERE id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ClaimWebhookDelivery locks the most overdue pending delivery for lease
+// and returns it, or nil if none is due.
+func (db *DB) ClaimWebhookDelivery(lease time.Duration) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := db.conn.Get(&d, `WITH d AS (
+			UPDATE webhook_deliveries
+			SET attempts = attempts + 1, locked_until = now() + $1 * interval '1 millisecond'
+			WHERE id = (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= now() AND (locked_until IS NULL OR locked_until <= now())
+				ORDER BY next_attempt_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT `+webhookDeliveryColumns+` FROM d JOIN outbox_events e ON e.id = d.event_id`, lease.Milliseconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RecordWebhookAttempt logs a and moves d to d.Status. Nothing is recorded
+// if d was claimed again or replayed since.
+func (db *DB) RecordWebhookAttempt(d *WebhookDelivery, a *WebhookAttempt) error {
+	tx, err := db.conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE webhook_deliveries
+		SET status = $3, next_attempt_at = $4, locked_until = NULL, last_status_code = $5, last_error = $6,
+			delivered_at = CASE WHEN $3 = 'delivered' THEN now() END
+		WHERE id = $1 AND attempts = $2 AND status = 'pending'`,
+		d.ID, d.Attempts, d.Status, d.NextAttemptAt, a.StatusCode, a.Error)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if _, err := tx.NamedExec(`INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES (:delivery_id, :attempted_at, :status_code, :error, :duration_ms)`, a); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a subscription,
+// optionally in the given status, newest first.
+func (db *DB) ListWebhookDeliveries(subscriptionId uuid.UUID, status string, limit int) ([]*WebhookDelivery, error) {
+	out := make([]*WebhookDelivery, 0)
+	err := db.conn.Select(&out, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries d JOIN outbox_events e ON e.id = d.event_id
+		WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.id DESC LIMIT $3`, subscriptionId, status, limit)
+	return out, err
+}
+
+func (db *DB) ListWebhookAttempts(deliveryIds []int64) ([]*WebhookAttempt, error) {
+	out := make([]*WebhookAttempt, 0)
+	err := db.conn.Select(&out, `SELECT id, delivery_id, attempted_at, status_code, error, duration_ms FROM webhook_attempts
+		WHERE delivery_id = ANY($1) ORDER BY id`, pq.Array(deliveryIds))
+	return out, err
+}
+
+// ReplayWebhookDelivery queues a delivered or dead delivery again with a
+// fresh retry budget. It returns sql.ErrNoRows if there is no such
+// delivery and false if it is still pending.
+func (db *DB) ReplayWebhookDelivery(id int64) (*WebhookDelivery, bool, error) {
+	var d WebhookDelivery
+	err := db.conn.Get(&d, `WITH d AS (
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = 0, next_attempt_at = now(), locked_until = NULL, delivered_at = NULL
+			WHERE id = $1 AND status <> 'pending'
+			RETURNING *
+		)
+		SELECT `+webhookDeliveryColumns+` FROM d JOIN outbox_events e ON e.id = d.event_id`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		if err := db.conn.Get(&exists, `SELECT EXISTS (SELECT 1 FROM webhook_deliveries WHERE id=$1)`, id); err != nil {
+			return nil, false, err
+		}
+		if exists {
+			return nil, false, nil
+		}
+		return nil, false, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &d, true, nil
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef0123456789abcdef"
+
+func testWebhookEvent() Event {
+	return Event{
+		ID:        "6f1d2b9e-5a57-4c1e-9d0b-3f3c8f6f2a10",
+		Type:      EventTransferBlocked,
+		Key:       "user-1",
+		Payload:   json.RawMessage(`{"id":"t-1","is_blocked":true}`),
+		CreatedAt: time.Date(2025, 11, 24, 10, 30, 0, 0, time.UTC),
+	}
+}
+
+// The receiver checks the signature the way a partner would, without
+// SignWebhook.
+func TestSendWebhookSigned(t *testing.T) {
+	now := time.Unix(1764000000, 0)
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+		mac.Write([]byte(r.Header.Get(WebhookTimestampHeader) + "."))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(r.Header.Get(WebhookSignatureHeader)), []byte(want)) {
+			t.Errorf("signature %q, want %q", r.Header.Get(WebhookSignatureHeader), want)
+		}
+		if ts := r.Header.Get(WebhookTimestampHeader); ts != strconv.FormatInt(now.Unix(), 10) {
+			t.Errorf("timestamp %q", ts)
+		}
+		if r.Header.Get(WebhookEventHeader) != EventTransferBlocked || r.Header.Get(WebhookDeliveryHeader) != "42" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := &WebhookSubscription{URL: srv.URL, Secret: testWebhookSecret}
+	code, err := sendWebhook(context.Background(), srv.Client(), s, 42, testWebhookEvent(), now)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("got %d, %v", code, err)
+	}
+	if got.ID != testWebhookEvent().ID || string(got.Payload) != string(testWebhookEvent().Payload) {
+		t.Errorf("receiver got %+v", got)
+	}
+}
+
+func TestSendWebhookFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance"))
+	}))
+	defer srv.Close()
+
+	s := &WebhookSubscription{URL: srv.URL, Secret: testWebhookSecret}
+	code, err := sendWebhook(context.Background(), srv.Client(), s, 1, testWebhookEvent(), time.Now())
+	var statusErr *WebhookStatusError
+	if code != http.StatusServiceUnavailable || !errors.As(err, &statusErr) || statusErr.Body != "maintenance" {
+		t.Errorf("got %d, %v", code, err)
+	}
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	s.URL = srv.URL + "/slow"
+	code, err = sendWebhook(context.Background(), client, s, 1, testWebhookEvent(), time.Now())
+	if code != 0 || err == nil {
+		t.Errorf("timeout: got %d, %v", code, err)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	defer func(b, m time.Duration) { webhookBackoff, webhookMaxBackoff = b, m }(webhookBackoff, webhookMaxBackoff)
+	webhookBackoff, webhookMaxBackoff = 10*time.Second, time.Hour
+
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second}
+	for i, w := range want {
+		if got := webhookRetryDelay(i + 1); got != w {
+			t.Errorf("attempt %d: %v, want %v", i+1, got, w)
+		}
+	}
+	if got := webhookRetryDelay(50); got != time.Hour {
+		t.Errorf("attempt 50: %v, want the cap", got)
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	valid := WebhookSubscription{URL: "https://partner.example/hooks", Secret: testWebhookSecret, EventTypes: []string{EventTransferBlocked}}
+	if err := validateWebhook(&valid); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]func(s *WebhookSubscription){
+		"scheme":     func(s *WebhookSubscription) { s.URL = "ftp://partner.example" },
+		"host":       func(s *WebhookSubscription) { s.URL = "https://" },
+		"secret":     func(s *WebhookSubscription) { s.Secret = "short" },
+		"no events":  func(s *WebhookSubscription) { s.EventTypes = nil },
+		"bad events": func(s *WebhookSubscription) { s.EventTypes = []string{"transfer.deleted"} },
+	}
+	for name, change := range cases {
+		s := valid
+		change(&s)
+		if err := validateWebhook(&s); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- completed, blocked, challenge_required, challenge_failed, challenge_expired.
+-- Only completed transfers were executed.
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS status TEXT;
+UPDATE transfers SET status = CASE WHEN is_blocked THEN 'blocked' ELSE 'completed' END WHERE status IS NULL;
+ALTER TABLE transfers ALTER COLUMN status SET DEFAULT 'completed';
+ALTER TABLE transfers ALTER COLUMN status SET NOT NULL;
+
+-- One-time code a risky transfer waits on before it is executed.
+CREATE TABLE IF NOT EXISTS transfer_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transfer_id UUID NOT NULL UNIQUE REFERENCES transfers(id),
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id),
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    confirmed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS transfer_challenges_user_id_created_at_idx ON transfer_challenges (user_id, created_at);
@@ -0,0 +1,18 @@
+-- +goose Up
+
+-- Scoring job of a pending transfer. Workers claim runnable jobs with
+-- FOR UPDATE SKIP LOCKED and hold them for a lease; a job whose worker died
+-- becomes runnable again once locked_until has passed.
+CREATE TABLE IF NOT EXISTS transfer_jobs (
+    transfer_id UUID PRIMARY KEY REFERENCES transfers(id),
+    -- queued, done, failed
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS transfer_jobs_queued_run_at_idx ON transfer_jobs (run_at) WHERE status = 'queued';
@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- Domain events, written in the transaction of the change they describe and
+-- published by the outbox relay. Events of one key (the user) are inserted
+-- under a per-key advisory lock, so their ids follow commit order.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+    type TEXT NOT NULL,
+    key TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    published_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
@@ -0,0 +1,4 @@
+-- +goose Up
+
+-- Notification streams read the events of one user after the last one sent.
+CREATE INDEX IF NOT EXISTS outbox_events_key_idx ON outbox_events (key, id);
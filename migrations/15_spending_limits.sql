@@ -0,0 +1,17 @@
+-- +goose Up
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS segment TEXT NOT NULL DEFAULT 'standard';
+
+-- Overrides of the default spending limits (set by LIMIT_* variables) for a
+-- segment, a user or a card. NULL inherits, 0 lifts the limit.
+CREATE TABLE IF NOT EXISTS spending_limits (
+    scope TEXT NOT NULL CHECK (scope IN ('segment', 'user', 'card')),
+    scope_id TEXT NOT NULL,
+    max_amount DOUBLE PRECISION CHECK (max_amount >= 0),
+    daily_amount DOUBLE PRECISION CHECK (daily_amount >= 0),
+    monthly_amount DOUBLE PRECISION CHECK (monthly_amount >= 0),
+    hourly_transfers INTEGER CHECK (hourly_transfers >= 0),
+    daily_new_recipients INTEGER CHECK (daily_new_recipients >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (scope, scope_id)
+);
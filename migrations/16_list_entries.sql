@@ -0,0 +1,23 @@
+-- +goose Up
+
+-- Block and allow lists, checked before a transfer is scored.
+CREATE TABLE IF NOT EXISTS list_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    list TEXT NOT NULL CHECK (list IN ('block', 'allow')),
+    kind TEXT NOT NULL CHECK (kind IN ('card', 'user', 'device', 'ip')),
+    -- card ID or number, user ID, device ID, or CIDR range
+    value TEXT NOT NULL,
+    -- set for ip entries
+    ip_range CIDR,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    UNIQUE (list, kind, value)
+);
+
+CREATE INDEX IF NOT EXISTS list_entries_kind_value_idx ON list_entries (kind, value);
+CREATE INDEX IF NOT EXISTS list_entries_ip_range_idx ON list_entries USING gist (ip_range inet_ops);
+
+-- The list entries a transfer matched, NULL if none.
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS list_hits JSONB;
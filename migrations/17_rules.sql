@@ -0,0 +1,37 @@
+-- +goose Up
+
+-- Analyst rules at their current version, evaluated after the model.
+CREATE TABLE IF NOT EXISTS rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    version INT NOT NULL,
+    expression TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('block', 'challenge')),
+    description TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Every version a rule was saved at.
+CREATE TABLE IF NOT EXISTS rule_versions (
+    rule_id UUID NOT NULL REFERENCES rules(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    expression TEXT NOT NULL,
+    action TEXT NOT NULL,
+    description TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (rule_id, version)
+);
+
+-- The rules a transfer matched, NULL if none.
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS rule_hits JSONB;
+-- The features a transfer was scored on, NULL if it was decided without the
+-- model or before they were stored.
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS features JSONB;
+
+-- Dry runs go through the transfers of a period.
+CREATE INDEX IF NOT EXISTS transfers_when_ts_idx ON transfers (when_ts);
@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- Fraud outcome of a transfer, once known, set by admins.
+CREATE TABLE IF NOT EXISTS transfer_labels (
+    transfer_id UUID PRIMARY KEY REFERENCES transfers(id),
+    label TEXT NOT NULL CHECK (label IN ('fraud', 'legit')),
+    note TEXT NOT NULL DEFAULT '',
+    labeled_by TEXT NOT NULL,
+    labeled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Replays of past transfers through a candidate policy. They only read
+-- transfers; the report is stored here.
+CREATE TABLE IF NOT EXISTS simulations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    policy JSONB NOT NULL,
+    start_ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    report JSONB NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
@@ -0,0 +1,65 @@
+-- +goose Up
+
+-- Fraud investigations. The assignee is a superuser; closing a case as
+-- closed_fraud or closed_legit labels its linked transfers.
+CREATE TABLE IF NOT EXISTS cases (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL CHECK (status IN ('open', 'investigating', 'closed_fraud', 'closed_legit')),
+    assignee UUID REFERENCES superusers(id),
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    closed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS cases_status_idx ON cases(status, updated_at DESC);
+
+-- Users, cards and transfers linked to a case. entity_id holds the ID as
+-- text since user IDs are not UUIDs.
+CREATE TABLE IF NOT EXISTS case_entities (
+    case_id UUID NOT NULL REFERENCES cases(id),
+    kind TEXT NOT NULL CHECK (kind IN ('user', 'card', 'transfer')),
+    entity_id TEXT NOT NULL,
+    added_by TEXT NOT NULL,
+    added_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (case_id, kind, entity_id)
+);
+
+CREATE INDEX IF NOT EXISTS case_entities_entity_idx ON case_entities(kind, entity_id);
+
+CREATE TABLE IF NOT EXISTS case_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    case_id UUID NOT NULL REFERENCES cases(id),
+    body TEXT NOT NULL,
+    author TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS case_notes_case_idx ON case_notes(case_id, created_at);
+
+-- Files attached to a case; their content is in the attachment store.
+CREATE TABLE IF NOT EXISTS case_attachments (
+    id UUID PRIMARY KEY,
+    case_id UUID NOT NULL REFERENCES cases(id),
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    uploaded_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS case_attachments_case_idx ON case_attachments(case_id, created_at);
+
+CREATE TABLE IF NOT EXISTS case_events (
+    id BIGSERIAL PRIMARY KEY,
+    case_id UUID NOT NULL REFERENCES cases(id),
+    type TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS case_events_case_idx ON case_events(case_id, id);
@@ -0,0 +1,25 @@
+-- +goose Up
+
+-- Migration 6 gave every existing account the time it ran as creation time.
+-- Accounts are loaded from the dataset, so take the earliest known activity
+-- of the account instead: its first login, the first transfer it sent or
+-- the first one its cards received. Accounts without any activity keep no
+-- creation time, which the recipient features read as unknown.
+ALTER TABLE users ALTER COLUMN created_at DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN created_at DROP DEFAULT;
+
+UPDATE users u SET created_at = LEAST(u.created_at, a.first_seen)
+FROM (
+    SELECT user_id, MIN(when_ts) AS first_seen FROM (
+        SELECT user_id, when_ts FROM login_sessions
+        UNION ALL SELECT from_user_id, when_ts FROM transfers
+        UNION ALL SELECT c.user_id, t.when_ts FROM transfers t JOIN cards c ON c.id = t.to_card_id
+    ) activity
+    GROUP BY user_id
+) a
+WHERE a.user_id = u.id;
+
+UPDATE users u SET created_at = NULL
+WHERE NOT EXISTS (SELECT 1 FROM login_sessions s WHERE s.user_id = u.id)
+  AND NOT EXISTS (SELECT 1 FROM transfers t WHERE t.from_user_id = u.id)
+  AND NOT EXISTS (SELECT 1 FROM transfers t JOIN cards c ON c.id = t.to_card_id WHERE c.user_id = u.id);
@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- The case a label was set by closing, NULL if an analyst set it directly.
+-- A case only changes or removes its own labels.
+ALTER TABLE transfer_labels ADD COLUMN IF NOT EXISTS case_id UUID REFERENCES cases(id);
+
+CREATE INDEX IF NOT EXISTS transfer_labels_case_idx ON transfer_labels(case_id);
+
+-- Who closed the case, to attribute the labels it gives.
+ALTER TABLE cases ADD COLUMN IF NOT EXISTS closed_by TEXT;
+
+-- labels set by closed cases so far carry the case in their note
+UPDATE transfer_labels l SET case_id = c.id
+FROM cases c
+WHERE l.case_id IS NULL AND l.note = 'case ' || c.id || ': ' || c.title;
+
+UPDATE cases c SET closed_by = (
+    SELECT e.actor FROM case_events e
+    WHERE e.case_id = c.id AND e.type = 'status_changed'
+    ORDER BY e.id DESC LIMIT 1
+)
+WHERE c.status IN ('closed_fraud', 'closed_legit') AND c.closed_by IS NULL;
@@ -0,0 +1,5 @@
+-- +goose Up
+
+-- Renewing an entry keeps who added it and when, and records who renewed it.
+ALTER TABLE list_entries ADD COLUMN IF NOT EXISTS updated_by TEXT;
+ALTER TABLE list_entries ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
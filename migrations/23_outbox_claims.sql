@@ -0,0 +1,6 @@
+-- +goose Up
+
+-- A relay claims events until claimed_until, publishes them outside of any
+-- transaction and then marks them published. Events whose claim ran out
+-- are claimed again.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
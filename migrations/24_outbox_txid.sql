@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- The transaction that wrote the event. Streams reading the events of every
+-- key go in (txid, id) order and only up to the oldest transaction still
+-- running, so that an event committed late is not skipped.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS txid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS outbox_events_txid_idx ON outbox_events (txid, id);
@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- A transfer is scored again when its job is retried. The latest score of
+-- each model is kept.
+DELETE FROM model_scores s
+USING model_scores newer
+WHERE newer.transfer_id = s.transfer_id AND newer.model = s.model AND newer.id > s.id;
+
+DROP INDEX IF EXISTS model_scores_transfer_id_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS model_scores_transfer_id_model_idx ON model_scores (transfer_id, model);
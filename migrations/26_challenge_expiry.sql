@@ -0,0 +1,4 @@
+-- +goose Up
+
+-- The transfers still waiting on a challenge, for the expiry sweeper.
+CREATE INDEX IF NOT EXISTS transfers_challenge_required_idx ON transfers (id) WHERE status = 'challenge_required';
@@ -0,0 +1,21 @@
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS devices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id),
+    -- identifier generated and persisted by the client app (X-Device-ID)
+    device_id TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    phone_model TEXT NOT NULL DEFAULT '',
+    os TEXT NOT NULL DEFAULT '',
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    trusted BOOLEAN NOT NULL DEFAULT FALSE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (user_id, device_id)
+);
+
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS device_id TEXT;
+
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS device_id TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS is_new_device BOOLEAN DEFAULT FALSE;
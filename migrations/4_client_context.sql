@@ -0,0 +1,15 @@
+-- +goose Up
+
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS ip TEXT;
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS city TEXT;
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE login_sessions ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS ip TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS city TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
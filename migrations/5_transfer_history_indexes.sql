@@ -0,0 +1,4 @@
+-- +goose Up
+
+CREATE INDEX IF NOT EXISTS transfers_from_user_id_when_ts_idx ON transfers (from_user_id, when_ts);
+CREATE INDEX IF NOT EXISTS transfers_from_user_id_to_card_id_idx ON transfers (from_user_id, to_card_id);
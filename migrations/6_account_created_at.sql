@@ -0,0 +1,6 @@
+-- +goose Up
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS transfers_to_card_id_when_ts_idx ON transfers (to_card_id, when_ts);
+CREATE INDEX IF NOT EXISTS transfers_from_card_id_when_ts_idx ON transfers (from_card_id, when_ts);
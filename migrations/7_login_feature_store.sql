@@ -0,0 +1,28 @@
+-- +goose Up
+
+-- Daily login aggregates per user, maintained on each login. Intervals are
+-- attributed to the day of the login they start from.
+CREATE TABLE IF NOT EXISTS login_feature_buckets (
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id),
+    day DATE NOT NULL,
+    logins INTEGER NOT NULL DEFAULT 0,
+    intervals INTEGER NOT NULL DEFAULT 0,
+    interval_sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+    interval_sq_sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+    -- value -> number of logins, e.g. {"iPhone 14 Pro": 3}
+    phone_models JSONB NOT NULL DEFAULT '{}',
+    oses JSONB NOT NULL DEFAULT '{}',
+    countries JSONB NOT NULL DEFAULT '{}',
+    places JSONB NOT NULL DEFAULT '{}',
+    PRIMARY KEY (user_id, day)
+);
+
+CREATE TABLE IF NOT EXISTS login_feature_state (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id),
+    last_session_id UUID NOT NULL REFERENCES login_sessions(id),
+    prev_session_id UUID REFERENCES login_sessions(id),
+    last_device_is_new BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS login_sessions_user_id_when_ts_idx ON login_sessions (user_id, when_ts);
+CREATE INDEX IF NOT EXISTS login_sessions_user_id_device_id_idx ON login_sessions (user_id, device_id);
@@ -0,0 +1,21 @@
+-- +goose Up
+
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS model TEXT;
+
+-- Every model's score of a transfer: the enforced decision and the shadow
+-- scores of the other configured models.
+CREATE TABLE IF NOT EXISTS model_scores (
+    id BIGSERIAL PRIMARY KEY,
+    transfer_id UUID NOT NULL REFERENCES transfers(id),
+    model TEXT NOT NULL,
+    feature_set_version INT NOT NULL,
+    shadow BOOLEAN NOT NULL,
+    -- NULL when the call failed
+    fraud_score DOUBLE PRECISION,
+    is_blocked BOOLEAN,
+    latency_ms BIGINT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS model_scores_transfer_id_idx ON model_scores (transfer_id);
@@ -0,0 +1,5 @@
+-- +goose Up
+
+-- Features that explain the decision, from the model or the local policy
+-- checks. NULL for transfers scored before explanations were stored.
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS explanation JSONB;